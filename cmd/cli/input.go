@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// maxSecretInput is the largest secret a server can be configured to accept,
+// past which input isn't read any further.
+const maxSecretInput = 10 << 20
+
+// stdin is where values left out of the command line are read from.
+var stdin io.Reader = os.Stdin
+
+// promptHidden asks for a value on the terminal stdin is, if it is one,
+// with echo turned off so that it never shows on screen.
+func promptHidden(prompt string) (string, bool, error) {
+	f, ok := stdin.(*os.File)
+	if !ok || !term.IsTerminal(int(f.Fd())) {
+		return "", false, nil
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	value, err := term.ReadPassword(int(f.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", false, err
+	}
+	return string(value), true, nil
+}
+
+// secretInput returns the secret argument of a command. When it is "-" or
+// left out, the secret is prompted for, or read from stdin until EOF when
+// piped. This keeps it out of the shell history and process listings.
+func secretInput(arg string) (string, error) {
+	if arg != "" && arg != "-" {
+		return arg, nil
+	}
+	secret, ok, err := promptHidden("Secret: ")
+	if err != nil {
+		return "", fmt.Errorf("read secret: %w", err)
+	}
+	if !ok {
+		return readSecretFrom(stdin)
+	}
+	if err := checkSecret(secret); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// secretFileInput reads a secret from the file at path, like a piped one.
+func secretFileInput(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("read secret: %w", err)
+	}
+	defer f.Close()
+	return readSecretFrom(f)
+}
+
+// readSecretFrom reads a secret from r until EOF, less the final newline.
+func readSecretFrom(r io.Reader) (string, error) {
+	b, err := io.ReadAll(io.LimitReader(r, maxSecretInput+1))
+	if err != nil {
+		return "", fmt.Errorf("read secret: %w", err)
+	}
+	secret := trimNewline(string(b))
+	if err := checkSecret(secret); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// checkSecret rejects a secret no server would accept.
+func checkSecret(secret string) error {
+	switch {
+	case strings.TrimSpace(secret) == "":
+		return errors.New("the secret is empty")
+	case len(secret) > maxSecretInput:
+		return fmt.Errorf("the secret exceeds %dMB", maxSecretInput>>20)
+	}
+	return nil
+}
+
+// passcodeInput prompts for a passcode, or reads a line of stdin when piped.
+func passcodeInput() (string, error) {
+	passcode, err := hiddenInput("Passcode: ")
+	if err != nil {
+		return "", fmt.Errorf("read passcode: %w", err)
+	}
+	if passcode == "" {
+		return "", errors.New("a passcode is required unless the URL contains a #key")
+	}
+	return passcode, nil
+}
+
+// passphraseInput prompts for a passphrase, or reads a line of stdin when
+// piped.
+func passphraseInput() (string, error) {
+	passphrase, err := hiddenInput("Passphrase: ")
+	if err != nil {
+		return "", fmt.Errorf("read passphrase: %w", err)
+	}
+	if passphrase == "" {
+		return "", errors.New("the passphrase is empty")
+	}
+	return passphrase, nil
+}
+
+// hiddenInput prompts for a value, or reads a line of stdin when piped.
+func hiddenInput(prompt string) (string, error) {
+	value, ok, err := promptHidden(prompt)
+	if ok || err != nil {
+		return value, err
+	}
+	return readLine(stdin)
+}
+
+// readLine reads a line of r one byte at a time, so that what follows is
+// left for the next value read from it.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return trimNewline(string(line)), nil
+}
+
+// trimNewline removes the newline ending the input of most tools, such as
+// echo, so that it isn't taken as part of the value.
+func trimNewline(s string) string {
+	s = strings.TrimSuffix(s, "\n")
+	return strings.TrimSuffix(s, "\r")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setStdin replaces stdin with input for the duration of a test.
+func setStdin(t *testing.T, input string) {
+	t.Helper()
+	old := stdin
+	stdin = strings.NewReader(input)
+	t.Cleanup(func() { stdin = old })
+}
+
+func TestSecretInput(t *testing.T) {
+	testCases := []struct {
+		name    string
+		arg     string
+		stdin   string
+		want    string
+		wantErr bool
+	}{
+		{"argument", "hunter2", "ignored", "hunter2", false},
+		{"dash reads stdin", "-", "hunter2\n", "hunter2", false},
+		{"no argument reads stdin", "", "hunter2\r\n", "hunter2", false},
+		{"several lines", "", "line 1\nline 2\n\n", "line 1\nline 2\n", false},
+		{"empty stdin", "-", "\n", "", true},
+		{"too large", "-", strings.Repeat("a", maxSecretInput+1), "", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			setStdin(t, tc.stdin)
+			got, err := secretInput(tc.arg)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("secretInput(%q) error = %v, wantErr %v", tc.arg, err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestPasscodeInput(t *testing.T) {
+	setStdin(t, "lemon-nemesis-onshore\nsomething else\n")
+	passcode, err := passcodeInput()
+	if err != nil {
+		t.Fatalf("passcodeInput() error = %v", err)
+	}
+	if passcode != "lemon-nemesis-onshore" {
+		t.Errorf("expected the first line, got %q", passcode)
+	}
+
+	setStdin(t, "lemon-nemesis-onshore")
+	if passcode, err := passcodeInput(); err != nil || passcode != "lemon-nemesis-onshore" {
+		t.Errorf("expected a passcode without a newline, got %q, %v", passcode, err)
+	}
+
+	setStdin(t, "")
+	if _, err := passcodeInput(); err == nil {
+		t.Error("expected an error without a passcode")
+	}
+}
+
+func TestSecretFileInput(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	secret, err := secretFileInput(write("secret.txt", "line 1\nline 2\n"))
+	if err != nil {
+		t.Fatalf("secretFileInput() error = %v", err)
+	}
+	if secret != "line 1\nline 2" {
+		t.Errorf("expected the file less its final newline, got %q", secret)
+	}
+
+	for name, path := range map[string]string{
+		"empty":     write("empty.txt", "\n"),
+		"too large": write("large.txt", strings.Repeat("a", maxSecretInput+1)),
+		"missing":   filepath.Join(dir, "missing.txt"),
+	} {
+		if _, err := secretFileInput(path); err == nil {
+			t.Errorf("expected an error for a %s file", name)
+		}
+	}
+}
+
+func TestHiddenInput_Sequence(t *testing.T) {
+	// Piped values each take a line, and the secret the rest.
+	setStdin(t, "over the phone\nline 1\nline 2\n")
+	passphrase, err := passphraseInput()
+	if err != nil || passphrase != "over the phone" {
+		t.Fatalf("expected the first line, got %q, %v", passphrase, err)
+	}
+	secret, err := secretInput("-")
+	if err != nil || secret != "line 1\nline 2" {
+		t.Errorf("expected the remaining lines, got %q, %v", secret, err)
+	}
+
+	setStdin(t, "\n")
+	if _, err := passphraseInput(); err == nil {
+		t.Error("expected an error for an empty passphrase")
+	}
+}
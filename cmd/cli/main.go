@@ -1,28 +1,26 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/smallwat3r/secretapi/internal/domain"
+	"github.com/smallwat3r/secretapi/internal/utility"
+	"github.com/smallwat3r/secretapi/pkg/client"
 )
 
 const defaultBaseURL = "https://secret.smallwat3r.com"
 
-const (
-	maxRetries    = 5
-	retryDelay    = 1 * time.Second
-	clientTimeout = 30 * time.Second
-)
-
 func main() {
 	if len(os.Args) < 2 {
 		printUsage()
@@ -33,27 +31,152 @@ func main() {
 	if baseURL == "" {
 		baseURL = defaultBaseURL
 	}
+	c := newClient(baseURL)
 
 	switch os.Args[1] {
 	case "create":
-		if len(os.Args) != 3 && len(os.Args) != 4 {
-			fmt.Fprintf(os.Stderr, "Usage: %s create <secret> [expiry]\n", os.Args[0])
+		fs := flag.NewFlagSet("create", flag.ExitOnError)
+		zk := fs.Bool("zk", false, "encrypt locally and keep the key in the URL fragment")
+		passphrase := fs.String("passphrase", "", "also require this passphrase to read")
+		passphraseStdin := fs.Bool("passphrase-stdin", false,
+			"also require a passphrase to read, prompted for or read from stdin")
+		secretFile := fs.String("secret-file", "", "read the secret from this file")
+		file := fs.String("file", "", "share this file instead of a secret")
+		var fields fieldsFlag
+		fs.Var(&fields, "field", "add a `name=value` field instead of a secret, can be repeated")
+		_ = fs.Parse(os.Args[2:])
+
+		// A secret file, a shared file or fields take the place of the
+		// secret argument.
+		noSecret := *secretFile != "" || *file != "" || len(fields) > 0
+		if noSecret && fs.NArg() > 1 || fs.NArg() > 2 {
+			fmt.Fprintf(os.Stderr,
+				"Usage: %s create [--zk] [--passphrase-stdin] [secret|-] [expiry]\n"+
+					"       %s create [--zk] [--passphrase-stdin] --secret-file <path> [expiry]\n"+
+					"       %s create --file <path> [--passphrase-stdin] [expiry]\n"+
+					"       %s create --field <name=value>... [--passphrase-stdin] [expiry]\n",
+				os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 			os.Exit(1)
 		}
-		secret := os.Args[2]
-		expiry := ""
-		if len(os.Args) == 4 {
-			expiry = os.Args[3]
+		var conflict string
+		switch {
+		case *file != "" && len(fields) > 0:
+			conflict = "--file can't be used with --field"
+		case *secretFile != "" && (*file != "" || len(fields) > 0):
+			conflict = "--secret-file can't be used with --file or --field"
+		case *passphrase != "" && *passphraseStdin:
+			conflict = "--passphrase can't be used with --passphrase-stdin"
+		case *zk && (*passphrase != "" || *passphraseStdin):
+			conflict = "--passphrase can't be used with --zk"
+		case *zk && (*file != "" || len(fields) > 0):
+			conflict = "--zk can't be used with --file or --field"
+		}
+		if conflict != "" {
+			fmt.Fprintln(os.Stderr, conflict)
+			os.Exit(1)
+		}
+
+		// From stdin, the passphrase comes first, on its own line.
+		if *passphraseStdin {
+			var err error
+			if *passphrase, err = passphraseInput(); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		switch {
+		case *file != "":
+			createFile(c, *file, fs.Arg(0), *passphrase)
+		case len(fields) > 0:
+			createFields(c, client.Fields(fields), fs.Arg(0), *passphrase)
+		case *secretFile != "":
+			secret, err := secretFileInput(*secretFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			createSecret(c, secret, fs.Arg(0), *zk, *passphrase)
+		default:
+			secret, err := secretInput(fs.Arg(0))
+			if err != nil {
+				log.Fatal(err)
+			}
+			createSecret(c, secret, fs.Arg(1), *zk, *passphrase)
 		}
-		createSecret(baseURL, secret, expiry)
 	case "read":
+		fs := flag.NewFlagSet("read", flag.ExitOnError)
+		passphrase := fs.String("passphrase", "", "passphrase the sender shared separately")
+		passphraseStdin := fs.Bool("passphrase-stdin", false,
+			"prompt for the passphrase, or read it from stdin")
+		output := fs.String("output", "", "where to save a shared file, - for stdout")
+		format := fs.String("format", "plain", "how to print the secret: plain, env or json")
+		_ = fs.Parse(os.Args[2:])
+		if fs.NArg() != 1 && fs.NArg() != 2 {
+			fmt.Fprintf(os.Stderr,
+				"Usage: %s read [--passphrase-stdin] [--output <path>] "+
+					"[--format plain|env|json] <url>\n",
+				os.Args[0])
+			os.Exit(1)
+		}
+		if *format != "plain" && *format != "env" && *format != "json" {
+			fmt.Fprintln(os.Stderr, "--format must be plain, env or json")
+			os.Exit(1)
+		}
+		if *passphrase != "" && *passphraseStdin {
+			fmt.Fprintln(os.Stderr, "--passphrase can't be used with --passphrase-stdin")
+			os.Exit(1)
+		}
+
+		// The passcode is prompted for, then the passphrase. From stdin,
+		// each comes on its own line.
+		passcode := fs.Arg(1)
+		if passcode != "" {
+			fmt.Fprintln(os.Stderr, "warning: the passcode argument is deprecated, "+
+				"it stays in the shell history: leave it out to be prompted for it")
+		} else if !strings.Contains(fs.Arg(0), "#") {
+			var err error
+			if passcode, err = passcodeInput(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *passphraseStdin {
+			var err error
+			if *passphrase, err = passphraseInput(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		readSecret(c, fs.Arg(0), passcode, *passphrase, *output, *format)
+	case "request":
+		if len(os.Args) > 3 {
+			fmt.Fprintf(os.Stderr, "Usage: %s request [expiry]\n", os.Args[0])
+			os.Exit(1)
+		}
+		requestSecret(c, strings.Join(os.Args[2:], ""))
+	case "send":
+		fs := flag.NewFlagSet("send", flag.ExitOnError)
+		var fields fieldsFlag
+		fs.Var(&fields, "field", "send a `name=value` field instead of a secret, can be repeated")
+		_ = fs.Parse(os.Args[2:])
+		if len(fields) > 0 && fs.NArg() != 1 || fs.NArg() != 1 && fs.NArg() != 2 {
+			fmt.Fprintf(os.Stderr,
+				"Usage: %s send <url> [secret|-]\n"+
+					"       %s send --field <name=value>... <url>\n",
+				os.Args[0], os.Args[0])
+			os.Exit(1)
+		}
+		var secret string
+		if len(fields) == 0 {
+			var err error
+			if secret, err = secretInput(fs.Arg(1)); err != nil {
+				log.Fatal(err)
+			}
+		}
+		sendSecret(c, fs.Arg(0), secret, client.Fields(fields))
+	case "revoke":
 		if len(os.Args) != 4 {
-			fmt.Fprintf(os.Stderr, "Usage: %s read <url> <passcode>\n", os.Args[0])
+			fmt.Fprintf(os.Stderr, "Usage: %s revoke <url> <token>\n", os.Args[0])
 			os.Exit(1)
 		}
-		urlArg := os.Args[2]
-		passcode := os.Args[3]
-		readSecret(urlArg, passcode)
+		revokeSecret(c, os.Args[2], os.Args[3])
 	case "help":
 		printUsage()
 	default:
@@ -67,113 +190,354 @@ func printUsage() {
 	fmt.Printf("Usage: %s <command> [arguments]\n", os.Args[0])
 	fmt.Println("A simple CLI to create and read secrets.")
 	fmt.Println("\nCommands:")
-	fmt.Printf("  create <secret> [expiry] Create a new secret (expiry: %s)\n",
+	fmt.Println("  create [--zk] [secret] [expiry] Create a new secret (expiry: one of the server's")
+	fmt.Printf("                                  options, %s by default, a duration\n",
 		strings.Join(domain.ExpiryOptions, ", "))
-	fmt.Println("  read <url> <passcode>    Read a secret")
-	fmt.Println("  help                     Show this help message")
+	fmt.Println("                                  such as 30m or 2d12h within the server's range,")
+	fmt.Println("                                  or an RFC 3339 time such as 2030-01-02T15:04:05Z)")
+	fmt.Println("                                  Without a secret, or with -, it is read from")
+	fmt.Println("                                  stdin, or prompted for without echo")
+	fmt.Println("                                  --zk encrypts locally, the key stays in the URL")
+	fmt.Println("                                  --passphrase-stdin also requires a passphrase")
+	fmt.Println("                                  to read, prompted for without echo")
+	fmt.Println("                                  --secret-file <path> reads the secret from a")
+	fmt.Println("                                  file, without <secret>")
+	fmt.Println("                                  --file <path> shares a file, without <secret>")
+	fmt.Println("                                  --field <name=value> adds a field, without")
+	fmt.Println("                                  <secret>, and can be repeated")
+	fmt.Println("  read <url>                      Read a secret, prompting for its passcode")
+	fmt.Println("                                  without echo (none for --zk links)")
+	fmt.Println("                                  --passphrase-stdin if the sender set one")
+	fmt.Println("                                  --output <path> saves a file elsewhere than")
+	fmt.Println("                                  its name in the current directory")
+	fmt.Println("                                  --format env prints export statements, json")
+	fmt.Println("                                  prints JSON, plain (default) one field a line")
+	fmt.Println("  request [expiry]                Ask someone to send you a secret, through a")
+	fmt.Println("                                  one-time link only your token can read")
+	fmt.Println("  send <url> [secret]             Send a secret through a request link, read")
+	fmt.Println("                                  like create's without one")
+	fmt.Println("                                  --field <name=value> sends fields instead")
+	fmt.Println("  revoke <url> <token>            Delete a secret using its delete token")
+	fmt.Println("  help                            Show this help message")
 	fmt.Println("\nEnvironment variables:")
-	fmt.Println("  SECRET_API_URL           Set the base URL for the secret API")
-	fmt.Println("                           (default: https://secret.smallwat3r.com)")
+	fmt.Println("  SECRET_API_URL                  Set the base URL for the secret API")
+	fmt.Println("                                  (default: https://secret.smallwat3r.com)")
+	fmt.Println("  SECRET_API_KEY                  API key to create secrets with, on servers")
+	fmt.Println("                                  requiring one")
 }
 
-// doRequestWithRetry handles retries for serverless instances that may need to wake up.
-func doRequestWithRetry(req *http.Request) (*http.Response, error) {
-	client := &http.Client{Timeout: clientTimeout}
+// newClient returns a client of the server at baseURL, which creates
+// secrets with the API key in SECRET_API_KEY, if any.
+func newClient(baseURL string) *client.Client {
+	retry := client.DefaultRetryPolicy
+	// Serverless instances may need to wake up.
+	retry.OnRetry = func(n, status int) {
+		log.Printf("server returned %d, retrying in %v... (%d/%d)",
+			status, retry.Delay, n, retry.Attempts-1)
+	}
+	return client.New(baseURL,
+		client.WithRetryPolicy(retry),
+		client.WithAPIKey(os.Getenv("SECRET_API_KEY")))
+}
 
-	for i := 0; i < maxRetries; i++ {
-		if i > 0 {
-			log.Printf("server returned 502, retrying in %v... (%d/%d)",
-				retryDelay, i, maxRetries-1)
-			time.Sleep(retryDelay)
-		}
+// exitCodes are the exit statuses of the errors reported by the server, so
+// that scripts can tell them apart. Other failures exit with 1.
+var exitCodes = map[client.ErrorCode]int{
+	client.CodeInvalidRequest:      10,
+	client.CodeInvalidExpiry:       11,
+	client.CodeSecretTooLarge:      12,
+	client.CodeLengthRequired:      13,
+	client.CodePasscodeRequired:    20,
+	client.CodePassphraseRequired:  21,
+	client.CodePasscodeInvalid:     22,
+	client.CodeClientEncrypted:     23,
+	client.CodeAPIKeyRequired:      30,
+	client.CodeAPIKeyInvalid:       31,
+	client.CodeDeleteTokenRequired: 32,
+	client.CodeDeleteTokenInvalid:  33,
+	client.CodeSecretNotFound:      40,
+	client.CodeSecretNotSent:       41,
+	client.CodeRateLimited:         50,
+	client.CodeInternal:            60,
+}
 
-		resp, err := client.Do(req)
-		if err != nil {
-			return nil, err
-		}
+// exitStatus returns the exit status of an error reported with code.
+func exitStatus(code client.ErrorCode) int {
+	if status, ok := exitCodes[code]; ok {
+		return status
+	}
+	return 1
+}
 
-		if resp.StatusCode != http.StatusBadGateway {
-			return resp, nil
-		}
+// fail reports the error of a failed action, and exits with the status of
+// its code when the server reported one.
+func fail(action string, err error) {
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.Code == "" {
+		log.Fatalf("%s: %v", action, err)
+	}
+	log.Printf("%s: %s", action, describeError(apiErr))
+	os.Exit(exitStatus(apiErr.Code))
+}
 
-		resp.Body.Close()
+// describeError returns the message of an error reported by the server,
+// along with what can be done about it.
+func describeError(e *client.Error) string {
+	msg := e.Detail
+	switch {
+	case e.RemainingAttempts != nil:
+		msg += fmt.Sprintf(" (%d attempt(s) remaining)", *e.RemainingAttempts)
+	case e.RetryAfter > 0:
+		msg += fmt.Sprintf(" (retry in %s)", e.RetryAfter)
 	}
+	return msg
+}
 
-	return nil, fmt.Errorf("server unavailable after %d retries", maxRetries)
+// parseExpiryArg splits an expiry argument into the expiry or the absolute
+// expires_at time the server expects.
+func parseExpiryArg(expiry string) (string, time.Time) {
+	if t, err := time.Parse(time.RFC3339, expiry); err == nil {
+		return "", t
+	}
+	return expiry, time.Time{}
 }
 
-func createSecret(baseURL, secret, expiry string) {
-	reqBody, err := json.Marshal(domain.CreateReq{Secret: secret, Expiry: expiry})
+// checkExpiry reports an expiry the server won't accept, along with the
+// options and range it advertises, before the secret is sent. It gives up
+// quietly if the server's config can't be fetched.
+func checkExpiry(c *client.Client, expiry string) error {
+	cfg, err := c.Config(context.Background())
 	if err != nil {
-		log.Fatalf("failed to marshal request: %v", err)
+		return nil
+	}
+	minTTL, okMin := utility.ParseExpiry(cfg.MinExpiry)
+	maxTTL, okMax := utility.ParseExpiry(cfg.MaxExpiry)
+	if !okMin || !okMax || slices.Contains(cfg.ExpiryOptions, expiry) {
+		return nil
 	}
 
-	req, err := http.NewRequest("POST", baseURL+"/create", bytes.NewReader(reqBody))
-	if err != nil {
-		log.Fatalf("failed to create request: %v", err)
+	ttl, ok := utility.ParseExpiry(expiry)
+	if _, expiresAt := parseExpiryArg(expiry); !expiresAt.IsZero() {
+		ttl, ok = time.Until(expiresAt), true
 	}
-	req.GetBody = func() (io.ReadCloser, error) {
-		return io.NopCloser(bytes.NewReader(reqBody)), nil
+	if !ok || ttl < minTTL || ttl > maxTTL {
+		return fmt.Errorf("expiry must be one of %s, or between %s and %s",
+			strings.Join(cfg.ExpiryOptions, ", "), cfg.MinExpiry, cfg.MaxExpiry)
 	}
-	req.Header.Set("Content-Type", "application/json")
+	return nil
+}
 
-	resp, err := doRequestWithRetry(req)
+// expiryParams checks an expiry argument, and returns it as the expiry or
+// the absolute expires_at time the server expects.
+func expiryParams(c *client.Client, expiry string) (string, time.Time) {
+	if expiry != "" {
+		if err := checkExpiry(c, expiry); err != nil {
+			log.Fatalf("invalid expiry %q: %v", expiry, err)
+		}
+	}
+	return parseExpiryArg(expiry)
+}
+
+// createSecret creates a secret. In zero-knowledge mode it is encrypted here
+// with a random key which is only ever appended to the URL fragment.
+func createSecret(c *client.Client, secret, expiry string, zeroKnowledge bool, passphrase string) {
+	expiry, expiresAt := expiryParams(c, expiry)
+	created, err := c.Create(context.Background(), client.CreateParams{
+		Secret:        secret,
+		Expiry:        expiry,
+		ExpiresAt:     expiresAt,
+		Passphrase:    passphrase,
+		ZeroKnowledge: zeroKnowledge,
+	})
 	if err != nil {
-		log.Fatalf("failed to create secret: %v", err)
+		fail("failed to create secret", err)
 	}
-	defer resp.Body.Close()
+	printCreated(created, zeroKnowledge, passphrase)
+}
 
-	if resp.StatusCode != http.StatusCreated {
-		body, _ := io.ReadAll(resp.Body)
-		log.Fatalf("failed to create secret: status %d, body: %s", resp.StatusCode, body)
+// createFields creates a structured secret, made of named fields.
+func createFields(c *client.Client, fields client.Fields, expiry, passphrase string) {
+	expiry, expiresAt := expiryParams(c, expiry)
+	created, err := c.Create(context.Background(), client.CreateParams{
+		Fields:     fields,
+		Expiry:     expiry,
+		ExpiresAt:  expiresAt,
+		Passphrase: passphrase,
+	})
+	if err != nil {
+		fail("failed to create secret", err)
 	}
+	printCreated(created, false, passphrase)
+}
 
-	var createRes domain.CreateRes
-	if err := json.NewDecoder(resp.Body).Decode(&createRes); err != nil {
-		log.Fatalf("failed to decode response: %v", err)
+// requestSecret creates a one-time link through which someone else can send
+// a secret, which only the printed token can read.
+func requestSecret(c *client.Client, expiry string) {
+	expiry, expiresAt := expiryParams(c, expiry)
+	request, err := c.Request(context.Background(), client.RequestParams{
+		Expiry:    expiry,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		fail("failed to create request", err)
 	}
 
-	fmt.Println("Your secret is ready to share:")
-	fmt.Printf("URL: %s\n", createRes.ReadURL)
-	fmt.Printf("Passcode: %s\n", createRes.Passcode)
-	fmt.Printf("Expires: %s\n", createRes.ExpiresAt.Format(time.RFC1123))
+	fmt.Println("Send this link to whoever should send you the secret:")
+	fmt.Printf("URL: %s\n", request.UploadURL)
+	fmt.Printf("Expires: %s\n", request.ExpiresAt.Format(time.RFC1123))
+	fmt.Println("Once they have, read it with the read URL and your token:")
+	fmt.Printf("Read URL: %s\n", request.ReadURL)
+	fmt.Printf("Token (keep private): %s\n", request.Token)
+	fmt.Printf("Delete token (keep private): %s\n", request.DeleteToken)
 }
 
-func readSecret(rawURL, passcode string) {
-	rawURL = strings.TrimRight(rawURL, "/")
+// sendSecret fulfills a secret request with a secret, or with fields.
+func sendSecret(c *client.Client, rawURL, secret string, fields client.Fields) {
+	if err := c.Send(context.Background(), rawURL, secret, fields); err != nil {
+		fail("failed to send secret", err)
+	}
+	fmt.Println("Secret sent. Only the person who requested it can read it.")
+}
 
-	// Force https for the production domain to avoid redirects
-	parsedURL, err := url.Parse(rawURL)
+// createFile shares a file. It is streamed to the server, which encrypts it.
+func createFile(c *client.Client, filePath, expiry, passphrase string) {
+	expiry, expiresAt := expiryParams(c, expiry)
+
+	f, err := os.Open(filePath)
 	if err != nil {
-		log.Fatalf("failed to parse URL: %v", err)
-	}
-	if parsedURL.Scheme == "http" && strings.Contains(parsedURL.Host, "smallwat3r.com") {
-		parsedURL.Scheme = "https"
-		rawURL = parsedURL.String()
+		log.Fatalf("failed to open file: %v", err)
 	}
+	defer f.Close()
 
-	req, err := http.NewRequest("POST", rawURL, nil)
+	created, err := c.CreateFile(context.Background(), client.FileParams{
+		Name:       filepath.Base(filePath),
+		Content:    f,
+		Expiry:     expiry,
+		ExpiresAt:  expiresAt,
+		Passphrase: passphrase,
+	})
 	if err != nil {
-		log.Fatalf("failed to create request: %v", err)
+		fail("failed to create secret", err)
+	}
+	printCreated(created, false, passphrase)
+}
+
+// printCreated prints what the sender needs to share a secret. The link of a
+// zero-knowledge secret carries its key.
+func printCreated(created *client.Created, zeroKnowledge bool, passphrase string) {
+	fmt.Println("Your secret is ready to share:")
+	fmt.Printf("URL: %s\n", created.ReadURL)
+	if !zeroKnowledge {
+		fmt.Printf("Passcode: %s\n", created.Passcode)
+		if passphrase != "" {
+			fmt.Println("Share the passphrase over another channel, it isn't part of the link.")
+		}
 	}
-	req.Header.Set("X-Passcode", passcode)
-	req.Header.Set("Accept", "application/json")
+	fmt.Printf("Expires: %s\n", created.ExpiresAt.Format(time.RFC1123))
+	fmt.Printf("Delete token (keep private): %s\n", created.DeleteToken)
+}
 
-	resp, err := doRequestWithRetry(req)
+func readSecret(c *client.Client, rawURL, passcode, passphrase, output, format string) {
+	secret, err := c.Read(context.Background(), rawURL, passcode, passphrase)
 	if err != nil {
-		log.Fatalf("failed to read secret: %v", err)
+		fail("failed to read secret", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		log.Fatalf("failed to read secret: status %d, body: %s", resp.StatusCode, body)
+	if secret.RemainingViews > 0 {
+		fmt.Fprintf(os.Stderr, "This secret can be read %d more time(s).\n",
+			secret.RemainingViews)
 	}
+	if secret.File != nil {
+		defer secret.File.Close()
+		saveFile(secret.File, output)
+		return
+	}
+	printSecret(secret.Text, secret.Fields, format)
+}
 
-	var readRes domain.ReadRes
-	if err := json.NewDecoder(resp.Body).Decode(&readRes); err != nil {
-		log.Fatalf("failed to decode response: %v", err)
+// printSecret prints a secret, or each of its fields on its own line. The
+// env format prints export statements for a shell to evaluate, a secret
+// without fields being exported as SECRET.
+func printSecret(secret string, fields client.Fields, format string) {
+	switch format {
+	case "env":
+		if fields == nil {
+			fields = client.Fields{{Name: "secret", Value: secret}}
+		}
+		for _, field := range fields {
+			fmt.Printf("export %s=%s\n", domain.EnvName(field.Name), domain.ShellQuote(field.Value))
+		}
+	case "json":
+		var v any = secret
+		if fields != nil {
+			v = fields
+		}
+		out, err := json.Marshal(v)
+		if err != nil {
+			log.Fatalf("failed to encode secret: %v", err)
+		}
+		fmt.Println(string(out))
+	default:
+		if fields == nil {
+			fmt.Println(secret)
+			return
+		}
+		for _, field := range fields {
+			fmt.Printf("%s: %s\n", field.Name, field.Value)
+		}
 	}
+}
+
+// fieldsFlag collects the repeated --field flags of a structured secret.
+type fieldsFlag client.Fields
+
+func (f *fieldsFlag) String() string {
+	return ""
+}
 
-	fmt.Println(readRes.Secret)
+func (f *fieldsFlag) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return errors.New("expected name=value")
+	}
+	*f = append(*f, client.Field{Name: name, Value: value})
+	return nil
+}
+
+// saveFile writes a shared file to output, or under its own name in the
+// current directory, never overwriting an existing file.
+func saveFile(file *client.File, output string) {
+	if output == "-" {
+		if _, err := io.Copy(os.Stdout, file); err != nil {
+			log.Fatalf("failed to read file: %v", err)
+		}
+		return
+	}
+	if output == "" {
+		output = filepath.Base(file.Name)
+		if output == "." || output == ".." || output == string(filepath.Separator) {
+			log.Fatalf("the file has no usable name, choose one with --output")
+		}
+	}
+
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		log.Fatalf("failed to save file: %v", err)
+	}
+	if _, err := io.Copy(f, file); err != nil {
+		f.Close()
+		os.Remove(output)
+		log.Fatalf("failed to read file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("failed to save file: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Saved %s\n", output)
+}
+
+func revokeSecret(c *client.Client, rawURL, token string) {
+	if err := c.Revoke(context.Background(), rawURL, token); err != nil {
+		fail("failed to revoke secret", err)
+	}
+	fmt.Println("Secret revoked.")
 }
@@ -3,21 +3,26 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/smallwat3r/secretapi/internal/domain"
+	"github.com/smallwat3r/secretapi/internal/utility"
+	"github.com/smallwat3r/secretapi/pkg/client"
 )
 
 func TestCreateSecret(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path != "/create" {
-			t.Errorf("Expected to request '/create', got: %s", r.URL.Path)
+		if r.URL.Path != "/api/v1/create" {
+			t.Errorf("Expected to request '/api/v1/create', got: %s", r.URL.Path)
 		}
 		if r.Method != "POST" {
 			t.Errorf("Expected 'POST' method, got: %s", r.Method)
@@ -38,7 +43,7 @@ func TestCreateSecret(t *testing.T) {
 	r, w, _ := os.Pipe()
 	os.Stdout = w
 
-	createSecret(server.URL, "test-secret", "")
+	createSecret(newClient(server.URL), "test-secret", "", false, "")
 
 	w.Close()
 	var buf bytes.Buffer
@@ -56,10 +61,110 @@ func TestCreateSecret(t *testing.T) {
 	}
 }
 
+func TestCheckExpiry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/config" {
+			t.Errorf("Expected to request '/api/v1/config', got: %s", r.URL.Path)
+		}
+		_ = json.NewEncoder(w).Encode(domain.ConfigRes{
+			ExpiryOptions: []string{"1h", "1d", "30d"},
+			MinExpiry:     "5m",
+			MaxExpiry:     "7d",
+		})
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		expiry  string
+		wantErr bool
+	}{
+		{"1h", false},
+		{"30d", false}, // options are accepted outside the range
+		{"30m", false},
+		{"2d12h", false},
+		{"PT45M", false},
+		{time.Now().Add(time.Hour).Format(time.RFC3339), false},
+		{"1m", true},
+		{"8d", true},
+		{"soon", true},
+		{time.Now().Add(-time.Hour).Format(time.RFC3339), true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.expiry, func(t *testing.T) {
+			err := checkExpiry(newClient(server.URL), tc.expiry)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("checkExpiry(%q) error = %v, wantErr %v", tc.expiry, err, tc.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "between 5m and 7d") {
+				t.Errorf("expected the error to advertise the range, got %q", err)
+			}
+		})
+	}
+}
+
+func TestCreateSecret_ExpiresAt(t *testing.T) {
+	expiresAt := time.Now().Add(2 * time.Hour).UTC().Truncate(time.Second)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/config" {
+			_ = json.NewEncoder(w).Encode(domain.ConfigRes{MinExpiry: "5m", MaxExpiry: "7d"})
+			return
+		}
+		var req domain.CreateReq
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Expiry != "" || req.ExpiresAt == nil || !req.ExpiresAt.Equal(expiresAt) {
+			t.Errorf("expected expires_at %v and no expiry, got %q and %v",
+				expiresAt, req.Expiry, req.ExpiresAt)
+		}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(domain.CreateRes{
+			ID:        "test-id",
+			Passcode:  "test-passcode",
+			ExpiresAt: expiresAt,
+			ReadURL:   "http://localhost/read/test-id",
+		})
+	}))
+	defer server.Close()
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	createSecret(newClient(server.URL), "test-secret", expiresAt.Format(time.RFC3339), false, "")
+
+	w.Close()
+	var buf bytes.Buffer
+	_, _ = io.Copy(&buf, r)
+	os.Stdout = oldStdout
+
+	if !strings.Contains(buf.String(), expiresAt.Format(time.RFC1123)) {
+		t.Errorf("Expected output to contain the expiry, got '%s'", buf.String())
+	}
+}
+
+func TestCreateSecret_APIKey(t *testing.T) {
+	t.Setenv("SECRET_API_KEY", "0123456789abcdef.token")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer 0123456789abcdef.token" {
+			t.Errorf("expected the API key as a bearer token, got %q", got)
+		}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(domain.CreateRes{ReadURL: "http://localhost/read/test-id"})
+	}))
+	defer server.Close()
+
+	oldStdout := os.Stdout
+	_, w, _ := os.Pipe()
+	os.Stdout = w
+	defer func() { os.Stdout = oldStdout }()
+
+	createSecret(newClient(server.URL), "test-secret", "", false, "")
+	w.Close()
+}
+
 func TestReadSecret(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !strings.HasPrefix(r.URL.Path, "/read/") {
-			t.Errorf("Expected to request '/read/:key', got: %s", r.URL.Path)
+		if !strings.HasPrefix(r.URL.Path, "/api/v1/read/") {
+			t.Errorf("Expected to request '/api/v1/read/:key', got: %s", r.URL.Path)
 		}
 		if r.Method != "POST" {
 			t.Errorf("Expected 'POST' method, got: %s", r.Method)
@@ -93,7 +198,7 @@ func TestReadSecret(t *testing.T) {
 			r, w, _ := os.Pipe()
 			os.Stdout = w
 
-			readSecret(tc.url, "test-passcode")
+			readSecret(newClient(server.URL), tc.url, "test-passcode", "", "", "plain")
 
 			w.Close()
 			var buf bytes.Buffer
@@ -134,3 +239,360 @@ func TestPrintUsage(t *testing.T) {
 		t.Errorf("Expected output to contain 'help', got '%s'", buf.String())
 	}
 }
+
+func TestCreateAndReadSecret_ZeroKnowledge(t *testing.T) {
+	var stored string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/create":
+			var req domain.CreateReq
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Fatalf("failed to decode request: %v", err)
+			}
+			if req.Secret != "" {
+				t.Errorf("plaintext must not be sent to the server, got %q", req.Secret)
+			}
+			stored = req.Ciphertext
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(domain.CreateRes{
+				ID:        "test-id",
+				ExpiresAt: time.Now().Add(time.Hour),
+				ReadURL:   "http://" + r.Host + "/read/test-id",
+			})
+		case strings.HasPrefix(r.URL.Path, "/api/v1/read/"):
+			if r.Header.Get("X-Passcode") != "" {
+				t.Error("expected no passcode for a zero-knowledge read")
+			}
+			_ = json.NewEncoder(w).Encode(domain.ReadRes{Ciphertext: stored})
+		}
+	}))
+	defer server.Close()
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	createSecret(newClient(server.URL), "zk-secret", "", true, "")
+
+	w.Close()
+	var buf bytes.Buffer
+	_, _ = io.Copy(&buf, r)
+	os.Stdout = oldStdout
+
+	if strings.Contains(buf.String(), "Passcode:") {
+		t.Errorf("expected no passcode in output, got '%s'", buf.String())
+	}
+	var readURL string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, "URL: ") {
+			readURL = strings.TrimPrefix(line, "URL: ")
+		}
+	}
+	if !strings.Contains(readURL, "#") {
+		t.Fatalf("expected URL to carry the key in its fragment, got '%s'", readURL)
+	}
+
+	r, w, _ = os.Pipe()
+	os.Stdout = w
+
+	readSecret(newClient(server.URL), readURL, "", "", "", "plain")
+
+	w.Close()
+	buf.Reset()
+	_, _ = io.Copy(&buf, r)
+	os.Stdout = oldStdout
+
+	if buf.String() != "zk-secret\n" {
+		t.Errorf("expected output 'zk-secret\\n', got '%s'", buf.String())
+	}
+}
+
+func TestCreateAndReadSecret_Passphrase(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/create" {
+			var req domain.CreateReq
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			if req.Passphrase != "over the phone" {
+				t.Errorf("expected the passphrase in the request, got %q", req.Passphrase)
+			}
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(domain.CreateRes{
+				ID:       "test-id",
+				Passcode: "test-passcode",
+				ReadURL:  "http://localhost/read/test-id",
+			})
+			return
+		}
+		if r.Header.Get("X-Passphrase") != "over the phone" {
+			t.Errorf("expected 'X-Passphrase' header, got %q", r.Header.Get("X-Passphrase"))
+		}
+		_ = json.NewEncoder(w).Encode(domain.ReadRes{Secret: "test-secret"})
+	}))
+	defer server.Close()
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	createSecret(newClient(server.URL), "test-secret", "", false, "over the phone")
+	readSecret(newClient(server.URL), server.URL+"/read/test-id", "test-passcode",
+		"over the phone", "", "plain")
+
+	w.Close()
+	var buf bytes.Buffer
+	_, _ = io.Copy(&buf, r)
+	os.Stdout = oldStdout
+
+	if !strings.Contains(buf.String(), "passphrase") {
+		t.Errorf("expected a reminder to share the passphrase, got '%s'", buf.String())
+	}
+	if !strings.HasSuffix(buf.String(), "test-secret\n") {
+		t.Errorf("expected the secret to be printed, got '%s'", buf.String())
+	}
+}
+
+func TestCreateAndReadFile(t *testing.T) {
+	var stored []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/create/file" {
+			if got := r.URL.Query().Get("filename"); got != "notes.txt" {
+				t.Errorf("expected filename 'notes.txt', got %q", got)
+			}
+			if got := r.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+				t.Errorf("expected a text/plain Content-Type, got %q", got)
+			}
+			stored, _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(domain.CreateRes{
+				ID:       "test-id",
+				Passcode: "test-passcode",
+				ReadURL:  "http://localhost/read/test-id",
+			})
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Disposition", `attachment; filename="notes.txt"`)
+		_, _ = w.Write(stored)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(src, []byte("root password"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	createFile(newClient(server.URL), src, "", "")
+
+	w.Close()
+	var buf bytes.Buffer
+	_, _ = io.Copy(&buf, r)
+	os.Stdout = oldStdout
+
+	if string(stored) != "root password" {
+		t.Errorf("expected the file to be uploaded, got %q", stored)
+	}
+	if !strings.Contains(buf.String(), "Passcode: test-passcode") {
+		t.Errorf("expected the passcode in output, got '%s'", buf.String())
+	}
+
+	dst := filepath.Join(dir, "saved.txt")
+	readSecret(newClient(server.URL), server.URL+"/read/test-id", "test-passcode", "", dst, "plain")
+	got, err := os.ReadFile(dst)
+	if err != nil || string(got) != "root password" {
+		t.Errorf("expected the file to be saved, got %q, %v", got, err)
+	}
+}
+
+func TestCreateAndReadFields(t *testing.T) {
+	fields := client.Fields{{Name: "username", Value: "admin"}, {Name: "db password", Value: "it's"}}
+	// The fields are read back as the server received them.
+	var received domain.Fields
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/create" {
+			var req domain.CreateReq
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			received = req.Fields
+			if len(req.Fields) != 2 || req.Fields[1] != domain.Field(fields[1]) ||
+				req.Secret != "" {
+				t.Errorf("expected the fields in the request, got %+v", req)
+			}
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(domain.CreateRes{
+				ID:       "test-id",
+				Passcode: "test-passcode",
+				ReadURL:  "http://localhost/read/test-id",
+			})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(domain.ReadRes{Fields: received})
+	}))
+	defer server.Close()
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	createFields(newClient(server.URL), fields, "", "")
+	w.Close()
+	_, _ = io.Copy(io.Discard, r)
+
+	tests := []struct{ format, want string }{
+		{"plain", "username: admin\ndb password: it's\n"},
+		{"env", "export USERNAME='admin'\nexport DB_PASSWORD='it'\\''s'\n"},
+		{"json", `{"username":"admin","db password":"it's"}` + "\n"},
+	}
+	for _, tc := range tests {
+		r, w, _ = os.Pipe()
+		os.Stdout = w
+
+		readSecret(newClient(server.URL), server.URL+"/read/test-id", "test-passcode", "", "",
+			tc.format)
+
+		w.Close()
+		var buf bytes.Buffer
+		_, _ = io.Copy(&buf, r)
+		os.Stdout = oldStdout
+
+		if buf.String() != tc.want {
+			t.Errorf("%s: expected output %q, got %q", tc.format, tc.want, buf.String())
+		}
+	}
+}
+
+func TestRequestAndSendSecret(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/request":
+			var req domain.RequestReq
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			if req.Expiry != "6h" {
+				t.Errorf("expected expiry '6h', got %q", req.Expiry)
+			}
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(domain.RequestRes{
+				ID:          "test-id",
+				UploadURL:   "http://localhost/request/test-id",
+				ReadURL:     "http://localhost/read/test-id",
+				Token:       "test-token",
+				DeleteToken: "test-delete-token",
+			})
+		case "/api/v1/request/test-id":
+			var req domain.FulfillReq
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			if len(req.Fields) != 1 || req.Fields[0].Name != "api key" || req.Secret != "" {
+				t.Errorf("expected the fields in the request, got %+v", req)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	requestSecret(newClient(server.URL), "6h")
+	sendSecret(newClient(server.URL), server.URL+"/request/test-id", "",
+		client.Fields{{Name: "api key", Value: "k"}})
+
+	w.Close()
+	var buf bytes.Buffer
+	_, _ = io.Copy(&buf, r)
+	os.Stdout = oldStdout
+
+	for _, want := range []string{
+		"URL: http://localhost/request/test-id",
+		"Read URL: http://localhost/read/test-id",
+		"Token (keep private): test-token",
+		"Delete token (keep private): test-delete-token",
+		"Secret sent.",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in output, got '%s'", want, buf.String())
+		}
+	}
+}
+
+func TestRevokeSecret(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" {
+			t.Errorf("Expected 'DELETE' method, got: %s", r.Method)
+		}
+		if r.URL.Path != "/api/v1/secret/test-id" {
+			t.Errorf("Expected to request '/api/v1/secret/test-id', got: %s", r.URL.Path)
+		}
+		if r.Header.Get("X-Delete-Token") != "test-token" {
+			t.Errorf("Expected 'X-Delete-Token' header to be 'test-token', got: %s",
+				r.Header.Get("X-Delete-Token"))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	revokeSecret(newClient(server.URL), server.URL+"/read/test-id#some-key", "test-token")
+
+	w.Close()
+	var buf bytes.Buffer
+	_, _ = io.Copy(&buf, r)
+	os.Stdout = oldStdout
+
+	if buf.String() != "Secret revoked.\n" {
+		t.Errorf("expected output 'Secret revoked.\\n', got '%s'", buf.String())
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	seen := map[int]domain.ErrorCode{}
+	for _, code := range domain.ErrorCodes() {
+		status := exitStatus(client.ErrorCode(code))
+		if status == 1 {
+			t.Errorf("error code %s has no exit status", code)
+			continue
+		}
+		if other, ok := seen[status]; ok {
+			t.Errorf("error codes %s and %s share exit status %d", code, other, status)
+		}
+		seen[status] = code
+	}
+	if status := exitStatus("unknown_code"); status != 1 {
+		t.Errorf("expected exit status 1 for an unknown code, got %d", status)
+	}
+}
+
+func TestFail(t *testing.T) {
+	// fail exits, so it runs in a child process.
+	if os.Getenv("CLI_TEST_FAIL") == "1" {
+		fail("failed to read secret", &client.Error{
+			StatusCode:        http.StatusUnauthorized,
+			Code:              client.CodePasscodeInvalid,
+			Detail:            "invalid passcode or passphrase",
+			RemainingAttempts: utility.IntPtr(2),
+		})
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFail$")
+	cmd.Env = append(os.Environ(), "CLI_TEST_FAIL=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitStatus(client.CodePasscodeInvalid) {
+		t.Fatalf("expected exit status %d, got %v", exitStatus(client.CodePasscodeInvalid), err)
+	}
+	want := "failed to read secret: invalid passcode or passphrase (2 attempt(s) remaining)"
+	if !strings.Contains(stderr.String(), want) {
+		t.Errorf("expected stderr to contain %q, got %q", want, stderr.String())
+	}
+}
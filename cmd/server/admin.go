@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/smallwat3r/secretapi/internal/apikey"
+	"github.com/smallwat3r/secretapi/internal/audit"
+	"github.com/smallwat3r/secretapi/internal/config"
+	"github.com/smallwat3r/secretapi/internal/utility"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const adminUsage = `Usage: secret-api [command]
+
+Without a command, runs the server.
+
+Commands:
+  rewrap          wrap every stored secret with the current master key
+  audit verify    check the audit log wasn't altered
+  apikey issue [--rate-limit n] [--max-expiry d] [--max-size bytes] <name>
+                  issue an API key and print its token
+  apikey revoke <id>
+                  revoke an API key
+  apikey list     list the API keys`
+
+// runAdmin runs an admin command against the configured storage instead of
+// serving requests.
+func runAdmin(cfg config.Config, args []string) error {
+	switch args[0] {
+	case "rewrap":
+		return rewrap(cfg)
+	case "audit":
+		if len(args) < 2 || args[1] != "verify" {
+			return fmt.Errorf("usage: audit verify\n\n%s", adminUsage)
+		}
+		return verifyAudit(cfg)
+	case "apikey":
+		return runAPIKey(cfg, args[1:])
+	case "help", "-h", "--help":
+		fmt.Println(adminUsage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", args[0], adminUsage)
+	}
+}
+
+// rewrap wraps the secrets stored with an older master key, or with none,
+// with the current one, so the older keys can be retired. With the bolt
+// backend the server must be stopped first, as it holds the database open.
+func rewrap(cfg config.Config) error {
+	if len(cfg.MasterKeys) == 0 {
+		return errors.New("MASTER_KEYS or MASTER_KEYS_FILE must be set")
+	}
+	if cfg.StorageBackend == "memory" {
+		return errors.New("in-memory secrets can't be rewrapped from another process")
+	}
+
+	store, err := openStorage(cfg)
+	if err != nil {
+		return fmt.Errorf("open %s storage: %w", cfg.StorageBackend, err)
+	}
+	defer store.close()
+
+	n, err := store.encrypted.Rewrap(context.Background())
+	slog.Info("rewrapped secrets", slog.Int("blobs", n),
+		slog.String("master_key", cfg.MasterKeys[0].ID))
+	return err
+}
+
+// verifyAudit checks every entry of the audit log chains to the one before
+// it, and prints the number of entries and the hash of the last one.
+func verifyAudit(cfg config.Config) error {
+	if cfg.AuditLog == "" {
+		return errors.New("AUDIT_LOG must be set")
+	}
+
+	var rdb redis.UniversalClient
+	if cfg.AuditLog == "redis" {
+		var err error
+		if rdb, err = newRedisClient(cfg); err != nil {
+			return err
+		}
+		defer rdb.Close()
+	} else if _, err := os.Stat(cfg.AuditLogFile); err != nil {
+		return err
+	}
+	sink, err := openAuditSink(cfg, rdb)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+
+	res, err := audit.Verify(context.Background(), sink)
+	if err != nil {
+		return fmt.Errorf("audit log invalid after %d entries: %w", res.Entries, err)
+	}
+	fmt.Printf("audit log ok: %d entries, head %s\n", res.Entries, res.Head)
+	return nil
+}
+
+// runAPIKey issues, revokes or lists the API keys of the configured store.
+// Servers using a file store see changes to it without a restart.
+func runAPIKey(cfg config.Config, args []string) error {
+	if cfg.APIKeys == "" {
+		return errors.New("API_KEYS must be set")
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("usage: apikey issue|revoke|list\n\n%s", adminUsage)
+	}
+
+	var rdb redis.UniversalClient
+	if cfg.APIKeys == "redis" {
+		var err error
+		if rdb, err = newRedisClient(cfg); err != nil {
+			return err
+		}
+		defer rdb.Close()
+	}
+	store := openAPIKeys(cfg, rdb)
+	ctx := context.Background()
+
+	switch args[0] {
+	case "issue":
+		return issueAPIKey(ctx, store, args[1:])
+	case "revoke":
+		if len(args) != 2 || !apikey.ValidID(args[1]) {
+			return fmt.Errorf("usage: apikey revoke <id>\n\n%s", adminUsage)
+		}
+		if err := store.Revoke(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("revoked api key %s\n", args[1])
+		return nil
+	case "list":
+		keys, err := store.List(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tRATE LIMIT\tMAX EXPIRY\tMAX SIZE\tCREATED")
+		for _, k := range keys {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", k.ID, k.Name,
+				quota(k.RateLimit > 0, fmt.Sprint(k.RateLimit)),
+				quota(k.MaxExpiry > 0, utility.FormatExpiry(k.MaxExpiry)),
+				quota(k.MaxSize > 0, fmt.Sprint(k.MaxSize)),
+				k.CreatedAt.Format(time.DateOnly))
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown apikey command %q\n\n%s", args[0], adminUsage)
+	}
+}
+
+// issueAPIKey adds a key named after the last argument to store, with the
+// quotas set by the flags before it, and prints its token.
+func issueAPIKey(ctx context.Context, store apikey.Store, args []string) error {
+	fs := flag.NewFlagSet("apikey issue", flag.ContinueOnError)
+	rateLimit := fs.Int("rate-limit", 0, "requests per minute, instead of the per-IP limit")
+	maxExpiry := fs.String("max-expiry", "", "longest expiry of its secrets, e.g. 1d")
+	maxSize := fs.Int64("max-size", 0, "bytes of its largest secret or file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || fs.Arg(0) == "" {
+		return fmt.Errorf("usage: apikey issue [flags] <name>\n\n%s", adminUsage)
+	}
+	if *rateLimit < 0 || *maxSize < 0 {
+		return errors.New("quotas can't be negative")
+	}
+
+	k := apikey.Key{Name: fs.Arg(0), RateLimit: *rateLimit, MaxSize: *maxSize}
+	if *maxExpiry != "" {
+		ttl, ok := utility.ParseExpiry(*maxExpiry)
+		if !ok {
+			return fmt.Errorf("invalid max expiry %q", *maxExpiry)
+		}
+		k.MaxExpiry = ttl
+	}
+
+	k, token, err := apikey.Issue(k)
+	if err != nil {
+		return err
+	}
+	if err := store.Add(ctx, k); err != nil {
+		return err
+	}
+	fmt.Printf("issued api key %s (%s), its token won't be shown again:\n%s\n",
+		k.ID, k.Name, token)
+	return nil
+}
+
+// quota formats a quota for display, "-" if it isn't set.
+func quota(set bool, s string) string {
+	if !set {
+		return "-"
+	}
+	return s
+}
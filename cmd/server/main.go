@@ -2,46 +2,108 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/smallwat3r/secretapi/internal/app"
+	"github.com/smallwat3r/secretapi/internal/audit"
 	"github.com/smallwat3r/secretapi/internal/config"
-	"github.com/smallwat3r/secretapi/internal/domain"
-
-	"github.com/redis/go-redis/v9"
+	"github.com/smallwat3r/secretapi/internal/logging"
+	"github.com/smallwat3r/secretapi/internal/metrics"
+	"github.com/smallwat3r/secretapi/internal/tracing"
 )
 
 func main() {
 	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("failed to load config: %v", err)
+		fatal("failed to load config", logging.Err(err))
+	}
+	slog.SetDefault(logging.New(os.Stderr, logging.Options{
+		Format:    cfg.LogFormat,
+		Level:     cfg.LogLevel,
+		SecretIDs: cfg.LogSecretIDs,
+	}))
+
+	if len(os.Args) > 1 {
+		if err := runAdmin(cfg, os.Args[1:]); err != nil {
+			fatal("command failed", slog.String("command", os.Args[1]), logging.Err(err))
+		}
+		return
 	}
 
-	opt, err := redis.ParseURL(cfg.RedisURL)
-	if err != nil {
-		log.Fatalf("failed to parse redis url: %v", err)
+	shutdownTracing := func(context.Context) error { return nil }
+	if cfg.TracingEndpoint != "" {
+		shutdownTracing, err = tracing.Setup(context.Background(), tracing.Options{
+			Endpoint:    cfg.TracingEndpoint,
+			SampleRatio: cfg.TraceSampleRatio,
+		})
+		if err != nil {
+			fatal("failed to set up tracing", logging.Err(err))
+		}
+		slog.Info("exporting traces", slog.String("endpoint", cfg.TracingEndpoint))
 	}
 
-	// Configure connection pool
-	opt.PoolSize = cfg.RedisPoolSize
-	opt.MinIdleConns = cfg.RedisMinIdle
-	opt.DialTimeout = cfg.RedisDialTimeout
-	opt.ReadTimeout = cfg.RedisReadTimeout
-	opt.WriteTimeout = cfg.RedisWriteTimeout
-	opt.PoolTimeout = cfg.RedisPoolTimeout
+	store, err := openStorage(cfg)
+	if err != nil {
+		fatal("failed to open storage", slog.String("backend", cfg.StorageBackend), logging.Err(err))
+	}
 
-	rdb := redis.NewClient(opt)
+	repo := store.repo
+	handler := app.NewHandler(repo, app.HandlerConfig{
+		DefaultTheme:    cfg.DefaultTheme,
+		ExpiryOptions:   cfg.ExpiryOptions,
+		DefaultExpiry:   cfg.DefaultExpiry,
+		MinExpiry:       cfg.MinExpiry,
+		MaxExpiry:       cfg.MaxExpiry,
+		MaxSecretSize:   cfg.MaxSecretSize,
+		MaxFileSize:     cfg.MaxFileSize,
+		MaxReadAttempts: cfg.MaxReadAttempts,
+	})
+
+	if cfg.APIKeys != "" {
+		handler.SetAPIKeys(openAPIKeys(cfg, store.rdb))
+		slog.Info("requiring api keys to create secrets", slog.String("api_keys", cfg.APIKeys))
+	}
 
-	if err := rdb.Ping(context.Background()).Err(); err != nil {
-		log.Fatalf("failed to connect to redis: %v", err)
+	auditCtx, stopAudit := context.WithCancel(context.Background())
+	auditDone := make(chan struct{})
+	var auditLog *audit.Log
+	if cfg.AuditLog != "" {
+		sink, err := openAuditSink(cfg, store.rdb)
+		if err == nil {
+			auditLog, err = audit.Open(auditCtx, sink)
+		}
+		if err != nil {
+			fatal("failed to open audit log", slog.String("audit_log", cfg.AuditLog),
+				logging.Err(err))
+		}
+		handler.SetAuditLog(auditLog)
+		slog.Info("recording audit log", slog.String("audit_log", cfg.AuditLog))
+		go func() {
+			auditLog.Run(auditCtx)
+			close(auditDone)
+		}()
+	} else {
+		close(auditDone)
 	}
 
-	repo := domain.NewRedisRepository(rdb)
-	handler := app.NewHandler(repo, cfg.DefaultTheme)
+	notifierCtx, stopNotifier := context.WithCancel(context.Background())
+	notifierDone := make(chan struct{})
+	if cfg.WebhooksEnabled {
+		whCfg := app.DefaultWebhookConfig()
+		whCfg.AllowPrivate = cfg.WebhookAllowPrivate
+		notifier := app.NewWebhookNotifier(repo, whCfg)
+		handler.SetNotifier(notifier)
+		go func() {
+			notifier.Run(notifierCtx)
+			close(notifierDone)
+		}()
+	} else {
+		close(notifierDone)
+	}
 
 	secCfg := app.SecurityHeadersConfig{
 		RequireHTTPS:  cfg.RequireHTTPS,
@@ -51,7 +113,25 @@ func main() {
 	rlCfg := app.DefaultRateLimitConfig()
 	rlCfg.TrustedProxyCIDR = cfg.TrustedProxyCIDR
 
-	router := app.NewRouter(handler, rdb, secCfg, rlCfg)
+	router := app.NewRouter(handler, store.rlStore, secCfg, rlCfg)
+
+	// Metrics are served on their own listener when one is configured, so
+	// they can be kept off the public network, or else next to the API.
+	var metricsSrv *http.Server
+	if cfg.MetricsEnabled {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", metrics.Handler())
+		if cfg.MetricsAddr != "" {
+			metricsSrv = &http.Server{
+				Addr:              cfg.MetricsAddr,
+				Handler:           mux,
+				ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			}
+		} else {
+			mux.Handle("/", router)
+			router = mux
+		}
+	}
 
 	srv := &http.Server{
 		Addr:              cfg.ListenAddr(),
@@ -64,27 +144,64 @@ func main() {
 	}
 
 	go func() {
-		log.Printf("listening on %s", cfg.ListenAddr())
+		slog.Info("listening", slog.String("addr", cfg.ListenAddr()))
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("listen: %s\n", err)
+			fatal("listen failed", logging.Err(err))
 		}
 	}()
 
+	if metricsSrv != nil {
+		go func() {
+			slog.Info("serving metrics", slog.String("addr", cfg.MetricsAddr))
+			if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fatal("metrics listen failed", logging.Err(err))
+			}
+		}()
+	}
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	log.Println("shutting down server...")
+	slog.Info("shutting down server")
 
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Printf("server forced to shutdown: %v", err)
+		slog.Error("server forced to shutdown", logging.Err(err))
+	}
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(ctx); err != nil {
+			slog.Error("metrics server forced to shutdown", logging.Err(err))
+		}
+	}
+
+	// Stop webhook delivery once no more events can be produced.
+	stopNotifier()
+	<-notifierDone
+	stopAudit()
+	<-auditDone
+	if auditLog != nil {
+		if err := auditLog.Close(); err != nil {
+			slog.Error("failed to close audit log", logging.Err(err))
+		}
+	}
+
+	if err := store.close(); err != nil {
+		slog.Error("failed to close storage", slog.String("backend", cfg.StorageBackend),
+			logging.Err(err))
 	}
 
-	if err := rdb.Close(); err != nil {
-		log.Printf("failed to close redis connection: %v", err)
+	// Flushed last, so the spans of the requests drained above are sent.
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("failed to flush traces", logging.Err(err))
 	}
 
-	log.Println("server exiting")
+	slog.Info("server exiting")
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, attrs ...any) {
+	slog.Error(msg, attrs...)
+	os.Exit(1)
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/smallwat3r/secretapi/internal/apikey"
+	"github.com/smallwat3r/secretapi/internal/app"
+	"github.com/smallwat3r/secretapi/internal/audit"
+	"github.com/smallwat3r/secretapi/internal/config"
+	"github.com/smallwat3r/secretapi/internal/domain"
+	"github.com/smallwat3r/secretapi/internal/metrics"
+	"github.com/smallwat3r/secretapi/internal/tracing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// storage is the secret repository and rate limit store of the configured
+// backend, along with a function releasing them on shutdown. With master
+// keys, repo wraps the backend's blobs and encrypted is the same repository.
+// rdb is the Redis client of the redis backend.
+type storage struct {
+	repo      domain.SecretRepository
+	encrypted *domain.EncryptedRepository
+	rlStore   app.RateLimitStore
+	rdb       redis.UniversalClient
+	close     func() error
+}
+
+func openStorage(cfg config.Config) (storage, error) {
+	store, err := openBackend(cfg)
+	if err != nil || len(cfg.MasterKeys) == 0 {
+		return store, err
+	}
+
+	store.encrypted, err = domain.NewEncryptedRepository(store.repo, cfg.MasterKeys)
+	if err != nil {
+		_ = store.close()
+		return storage{}, err
+	}
+	store.repo = store.encrypted
+	slog.Info("encrypting secrets at rest", slog.String("master_key", cfg.MasterKeys[0].ID))
+	return store, nil
+}
+
+func openBackend(cfg config.Config) (storage, error) {
+	switch cfg.StorageBackend {
+	case "memory":
+		slog.Info("using in-memory storage, secrets are lost on restart")
+		repo := domain.NewMemoryRepository()
+		return storage{repo: repo, rlStore: app.NewMemoryRateLimitStore(), close: repo.Close}, nil
+
+	case "bolt":
+		repo, err := domain.NewBoltRepository(cfg.BoltPath)
+		if err != nil {
+			return storage{}, err
+		}
+		slog.Info("using bolt storage", slog.String("path", cfg.BoltPath))
+		return storage{repo: repo, rlStore: app.NewMemoryRateLimitStore(), close: repo.Close}, nil
+
+	default:
+		rdb, err := newRedisClient(cfg)
+		if err != nil {
+			return storage{}, err
+		}
+
+		rdb.AddHook(metrics.RedisHook{})
+		rdb.AddHook(tracing.RedisHook{})
+
+		ctx := context.Background()
+		if err := rdb.Ping(ctx).Err(); err != nil {
+			_ = rdb.Close()
+			return storage{}, fmt.Errorf("connect to redis: %w", err)
+		}
+
+		// Keys written before they carried hash tags would otherwise be
+		// unreadable after an upgrade.
+		migrated, err := domain.MigrateLegacyKeys(ctx, rdb)
+		if err != nil {
+			_ = rdb.Close()
+			return storage{}, fmt.Errorf("migrate redis keys: %w", err)
+		}
+		if migrated > 0 {
+			slog.Info("migrated redis keys to hash-tagged names", slog.Int("keys", migrated))
+		}
+
+		return storage{
+			repo:    domain.NewRedisRepository(rdb),
+			rlStore: app.NewRedisRateLimitStore(rdb),
+			rdb:     rdb,
+			close:   rdb.Close,
+		}, nil
+	}
+}
+
+// newRedisClient returns a client for a single Redis, or for a Sentinel or
+// Cluster deployment when one is configured. REDIS_URL still supplies the
+// credentials, database and TLS settings in the latter cases.
+func newRedisClient(cfg config.Config) (redis.UniversalClient, error) {
+	opt, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse redis url: %w", err)
+	}
+
+	// Configure connection pool
+	opt.PoolSize = cfg.RedisPoolSize
+	opt.MinIdleConns = cfg.RedisMinIdle
+	opt.DialTimeout = cfg.RedisDialTimeout
+	opt.ReadTimeout = cfg.RedisReadTimeout
+	opt.WriteTimeout = cfg.RedisWriteTimeout
+	opt.PoolTimeout = cfg.RedisPoolTimeout
+
+	universal := &redis.UniversalOptions{
+		Username:     opt.Username,
+		Password:     opt.Password,
+		DB:           opt.DB,
+		TLSConfig:    opt.TLSConfig,
+		PoolSize:     opt.PoolSize,
+		MinIdleConns: opt.MinIdleConns,
+		DialTimeout:  opt.DialTimeout,
+		ReadTimeout:  opt.ReadTimeout,
+		WriteTimeout: opt.WriteTimeout,
+		PoolTimeout:  opt.PoolTimeout,
+	}
+
+	switch {
+	case cfg.RedisSentinelMaster != "":
+		universal.MasterName = cfg.RedisSentinelMaster
+		universal.Addrs = cfg.RedisSentinelAddrs
+		slog.Info("using redis sentinel", slog.String("master", cfg.RedisSentinelMaster))
+		return redis.NewFailoverClient(universal.Failover()), nil
+
+	case len(cfg.RedisClusterAddrs) > 0:
+		if opt.DB != 0 {
+			return nil, fmt.Errorf("redis cluster only supports database 0, got %d", opt.DB)
+		}
+		universal.Addrs = cfg.RedisClusterAddrs
+		slog.Info("using redis cluster", slog.Int("seed_nodes", len(cfg.RedisClusterAddrs)))
+		return redis.NewClusterClient(universal.Cluster()), nil
+
+	default:
+		return redis.NewClient(opt), nil
+	}
+}
+
+// openAuditSink returns the sink of the configured audit log. The redis sink
+// appends with rdb.
+func openAuditSink(cfg config.Config, rdb redis.UniversalClient) (audit.Sink, error) {
+	if cfg.AuditLog == "redis" {
+		return audit.NewRedisSink(rdb), nil
+	}
+	return audit.OpenFileSink(cfg.AuditLogFile)
+}
+
+// openAPIKeys returns the configured API key store. The redis store reads
+// keys with rdb.
+func openAPIKeys(cfg config.Config, rdb redis.UniversalClient) apikey.Store {
+	if cfg.APIKeys == "redis" {
+		return apikey.NewRedisStore(rdb)
+	}
+	return apikey.NewFileStore(cfg.APIKeysFile)
+}
@@ -0,0 +1,163 @@
+// Package apikey issues and checks the API keys that may be required to
+// create secrets, each with its own quotas.
+//
+// A key is presented as a bearer token made of its ID and a random secret,
+// "<id>.<secret>". Stores only keep the hash of the token, so a copy of the
+// store doesn't grant access.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/smallwat3r/secretapi/internal/utility"
+)
+
+// idLen is the length in bytes of a key ID before hex encoding.
+const idLen = 8
+
+var (
+	// ErrNotFound is returned by a Store for a key it doesn't hold.
+	ErrNotFound = errors.New("api key not found")
+	// ErrInvalid is returned by Authenticate for a token that isn't a valid
+	// key, whether malformed, unknown or revoked.
+	ErrInvalid = errors.New("invalid api key")
+)
+
+// Key is an API key, along with its quotas. A zero quota means the server's
+// own limit applies.
+type Key struct {
+	ID        string
+	Name      string
+	Hash      string        // hex encoded SHA-256 of the token
+	RateLimit int           // requests per rate limit window
+	MaxExpiry time.Duration // longest expiry of its secrets
+	MaxSize   int64         // bytes of its largest secret or file
+	CreatedAt time.Time
+}
+
+// keyJSON is how a key is stored, with its maximum expiry written like the
+// expiries of secrets, e.g. "7d".
+type keyJSON struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	RateLimit int       `json:"rate_limit,omitempty"`
+	MaxExpiry string    `json:"max_expiry,omitempty"`
+	MaxSize   int64     `json:"max_size,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (k Key) MarshalJSON() ([]byte, error) {
+	j := keyJSON{
+		ID:        k.ID,
+		Name:      k.Name,
+		Hash:      k.Hash,
+		RateLimit: k.RateLimit,
+		MaxSize:   k.MaxSize,
+		CreatedAt: k.CreatedAt,
+	}
+	if k.MaxExpiry > 0 {
+		j.MaxExpiry = utility.FormatExpiry(k.MaxExpiry)
+	}
+	return json.Marshal(j)
+}
+
+func (k *Key) UnmarshalJSON(b []byte) error {
+	var j keyJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*k = Key{
+		ID:        j.ID,
+		Name:      j.Name,
+		Hash:      j.Hash,
+		RateLimit: j.RateLimit,
+		MaxSize:   j.MaxSize,
+		CreatedAt: j.CreatedAt,
+	}
+	if j.MaxExpiry != "" {
+		ttl, ok := utility.ParseExpiry(j.MaxExpiry)
+		if !ok {
+			return fmt.Errorf("api key %s: invalid max_expiry %q", j.ID, j.MaxExpiry)
+		}
+		k.MaxExpiry = ttl
+	}
+	return nil
+}
+
+// Store holds API keys.
+type Store interface {
+	// Get returns the key with the given ID, or ErrNotFound.
+	Get(ctx context.Context, id string) (Key, error)
+	// List returns every key, oldest first.
+	List(ctx context.Context) ([]Key, error)
+	Add(ctx context.Context, k Key) error
+	// Revoke deletes the key with the given ID, or returns ErrNotFound.
+	Revoke(ctx context.Context, id string) error
+}
+
+// Issue returns a new key with the name and quotas of k, and the token
+// presenting it. The token can't be recovered from the key.
+func Issue(k Key) (Key, string, error) {
+	id := make([]byte, idLen)
+	if _, err := rand.Read(id); err != nil {
+		return Key{}, "", fmt.Errorf("api key: %w", err)
+	}
+	secret, err := utility.GenerateToken()
+	if err != nil {
+		return Key{}, "", err
+	}
+	k.ID = hex.EncodeToString(id)
+	k.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	token := k.ID + "." + secret
+	k.Hash = hex.EncodeToString(utility.HashToken(token))
+	return k, token, nil
+}
+
+// ValidID reports whether id could be the ID of a key.
+func ValidID(id string) bool {
+	b, err := hex.DecodeString(id)
+	return err == nil && len(b) == idLen
+}
+
+// Authenticate returns the key a token presents, or ErrInvalid.
+func Authenticate(ctx context.Context, store Store, token string) (Key, error) {
+	id, _, ok := strings.Cut(token, ".")
+	if !ok || !ValidID(id) {
+		return Key{}, ErrInvalid
+	}
+	k, err := store.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return Key{}, ErrInvalid
+	}
+	if err != nil {
+		return Key{}, err
+	}
+	hash := hex.EncodeToString(utility.HashToken(token))
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(k.Hash)) != 1 {
+		return Key{}, ErrInvalid
+	}
+	return k, nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the key a request was
+// authenticated with.
+func NewContext(ctx context.Context, k Key) context.Context {
+	return context.WithValue(ctx, contextKey{}, k)
+}
+
+// FromContext returns the key a request was authenticated with, if any.
+func FromContext(ctx context.Context) (Key, bool) {
+	k, ok := ctx.Value(contextKey{}).(Key)
+	return k, ok
+}
@@ -0,0 +1,130 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// forEachStore runs fn against an empty store of each kind.
+func forEachStore(t *testing.T, fn func(t *testing.T, store Store)) {
+	t.Run("file", func(t *testing.T) {
+		fn(t, NewFileStore(filepath.Join(t.TempDir(), "api_keys.json")))
+	})
+	t.Run("redis", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { _ = rdb.Close() })
+		fn(t, NewRedisStore(rdb))
+	})
+}
+
+func TestStore(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		issued, token, err := Issue(Key{Name: "ci", RateLimit: 100, MaxExpiry: 24 * time.Hour})
+		if err != nil {
+			t.Fatalf("Issue() error = %v", err)
+		}
+		if strings.Contains(issued.Hash, token) || !strings.HasPrefix(token, issued.ID+".") {
+			t.Fatalf("unexpected token %q for key %+v", token, issued)
+		}
+		if err := store.Add(ctx, issued); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+		other, _, _ := Issue(Key{Name: "other"})
+		// Keys issued within the same second would have no order.
+		other.CreatedAt = issued.CreatedAt.Add(time.Second)
+		if err := store.Add(ctx, other); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+
+		k, err := Authenticate(ctx, store, token)
+		if err != nil {
+			t.Fatalf("Authenticate() error = %v", err)
+		}
+		if k != issued {
+			t.Errorf("expected key %+v, got %+v", issued, k)
+		}
+
+		for _, bad := range []string{
+			"",
+			token[:len(token)-1] + "x",
+			other.ID + token[len(issued.ID):],
+			"not-a-key." + token[len(issued.ID)+1:],
+		} {
+			if _, err := Authenticate(ctx, store, bad); !errors.Is(err, ErrInvalid) {
+				t.Errorf("Authenticate(%q) error = %v, want ErrInvalid", bad, err)
+			}
+		}
+
+		keys, err := store.List(ctx)
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		if len(keys) != 2 || keys[0].ID != issued.ID || keys[1].ID != other.ID {
+			t.Errorf("unexpected keys %+v", keys)
+		}
+
+		if err := store.Revoke(ctx, issued.ID); err != nil {
+			t.Fatalf("Revoke() error = %v", err)
+		}
+		if _, err := Authenticate(ctx, store, token); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected a revoked key to be invalid, got %v", err)
+		}
+		if err := store.Revoke(ctx, issued.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Revoke() of a revoked key error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestFileStore_Reload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "api_keys.json")
+	server, admin := NewFileStore(path), NewFileStore(path)
+
+	issued, token, _ := Issue(Key{Name: "ci"})
+	if err := admin.Add(ctx, issued); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if _, err := Authenticate(ctx, server, token); err != nil {
+		t.Fatalf("expected a key added by another store to apply, got %v", err)
+	}
+	if err := admin.Revoke(ctx, issued.ID); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if _, err := Authenticate(ctx, server, token); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected a key revoked by another store to be invalid, got %v", err)
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the file to be private, got %v %v", info.Mode(), err)
+	}
+
+	// Keys can be written by hand, with their expiry like a secret's.
+	written := `[{"id":"0123456789abcdef","name":"hand","hash":"h","max_expiry":"2d12h"}]`
+	if err := os.WriteFile(path, []byte(written), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	k, err := server.Get(ctx, "0123456789abcdef")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if k.MaxExpiry != 60*time.Hour {
+		t.Errorf("expected a max expiry of 60h, got %s", k.MaxExpiry)
+	}
+
+	if err := os.WriteFile(path, []byte(`[{"id":"x","max_expiry":"soon"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Get(ctx, "x"); err == nil {
+		t.Error("expected an error for an invalid max_expiry")
+	}
+}
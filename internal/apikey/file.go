@@ -0,0 +1,129 @@
+package apikey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// FileStore keeps API keys in a JSON file, as an array of keys. The file is
+// read again whenever it changes, so keys issued or revoked by another
+// process, or edited by hand, apply without a restart. A missing file holds
+// no keys.
+type FileStore struct {
+	path string
+
+	mu      sync.Mutex
+	keys    []Key
+	modTime time.Time
+	size    int64
+}
+
+// NewFileStore returns a store keeping keys in the file at path.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+func (s *FileStore) Get(ctx context.Context, id string) (Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return Key{}, err
+	}
+	for _, k := range s.keys {
+		if k.ID == id {
+			return k, nil
+		}
+	}
+	return Key{}, ErrNotFound
+}
+
+func (s *FileStore) List(ctx context.Context) ([]Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return slices.Clone(s.keys), nil
+}
+
+func (s *FileStore) Add(ctx context.Context, k Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	return s.save(append(slices.Clone(s.keys), k))
+}
+
+func (s *FileStore) Revoke(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	keys := slices.DeleteFunc(slices.Clone(s.keys), func(k Key) bool { return k.ID == id })
+	if len(keys) == len(s.keys) {
+		return ErrNotFound
+	}
+	return s.save(keys)
+}
+
+// load reads the file again if it changed since it was last read. The
+// caller must hold s.mu.
+func (s *FileStore) load() error {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.keys, s.modTime, s.size = nil, time.Time{}, 0
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil
+	}
+
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var keys []Key
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return err
+	}
+	s.keys, s.modTime, s.size = keys, info.ModTime(), info.Size()
+	return nil
+}
+
+// save replaces the file with keys, through a temporary file renamed over
+// it so that readers never see it half written. The caller must hold s.mu.
+func (s *FileStore) save(keys []Key) error {
+	b, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), s.path); err != nil {
+		return err
+	}
+	s.modTime = time.Time{}
+	return s.load()
+}
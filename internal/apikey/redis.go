@@ -0,0 +1,82 @@
+package apikey
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisHashKey is the hash holding the API keys, by ID.
+const redisHashKey = "apikeys"
+
+// RedisStore keeps API keys in a Redis hash, shared by every server using
+// the same Redis.
+type RedisStore struct {
+	rdb redis.UniversalClient
+}
+
+// NewRedisStore returns a store keeping keys in rdb.
+func NewRedisStore(rdb redis.UniversalClient) *RedisStore {
+	return &RedisStore{rdb: rdb}
+}
+
+func (s *RedisStore) Get(ctx context.Context, id string) (Key, error) {
+	b, err := s.rdb.HGet(ctx, redisHashKey, id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Key{}, ErrNotFound
+	}
+	if err != nil {
+		return Key{}, err
+	}
+	var k Key
+	if err := json.Unmarshal(b, &k); err != nil {
+		return Key{}, fmt.Errorf("api key %s: %w", id, err)
+	}
+	return k, nil
+}
+
+func (s *RedisStore) List(ctx context.Context) ([]Key, error) {
+	all, err := s.rdb.HGetAll(ctx, redisHashKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]Key, 0, len(all))
+	for id, v := range all {
+		var k Key
+		if err := json.Unmarshal([]byte(v), &k); err != nil {
+			return nil, fmt.Errorf("api key %s: %w", id, err)
+		}
+		keys = append(keys, k)
+	}
+	// Creation times are kept to the second, the ID breaks ties so that the
+	// order is at least stable.
+	slices.SortFunc(keys, func(a, b Key) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), strings.Compare(a.ID, b.ID))
+	})
+	return keys, nil
+}
+
+func (s *RedisStore) Add(ctx context.Context, k Key) error {
+	b, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+	return s.rdb.HSet(ctx, redisHashKey, k.ID, b).Err()
+}
+
+func (s *RedisStore) Revoke(ctx context.Context, id string) error {
+	n, err := s.rdb.HDel(ctx, redisHashKey, id).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
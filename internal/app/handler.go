@@ -4,34 +4,107 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
+	"mime"
 	"net/http"
 	"net/url"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 
+	"github.com/smallwat3r/secretapi/internal/apikey"
+	"github.com/smallwat3r/secretapi/internal/audit"
 	"github.com/smallwat3r/secretapi/internal/domain"
+	"github.com/smallwat3r/secretapi/internal/logging"
+	"github.com/smallwat3r/secretapi/internal/metrics"
+	"github.com/smallwat3r/secretapi/internal/tracing"
 	"github.com/smallwat3r/secretapi/internal/utility"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/google/uuid"
-	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// HandlerConfig holds the limits applied to secrets, which operators can
+// change, and the UI defaults.
+type HandlerConfig struct {
+	DefaultTheme    string        // "" | "light" | "dark"
+	ExpiryOptions   []string      // accepted expiries, e.g. "15m", "1h" or "7d"
+	DefaultExpiry   string        // one of ExpiryOptions
+	MinExpiry       time.Duration // shortest expiry that isn't an option
+	MaxExpiry       time.Duration // longest expiry that isn't an option
+	MaxSecretSize   int           // bytes of plaintext
+	MaxFileSize     int64         // bytes of a file secret, 0 disables them
+	MaxReadAttempts int           // wrong passcodes before a secret is deleted
+}
+
+// maxFilenameSize is the longest file name most file systems accept.
+const maxFilenameSize = 255
+
+// DefaultHandlerConfig returns the limits secretapi has always applied.
+func DefaultHandlerConfig() HandlerConfig {
+	return HandlerConfig{
+		ExpiryOptions:   domain.ExpiryOptions,
+		DefaultExpiry:   domain.DefaultExpiry,
+		MinExpiry:       domain.MinExpiry,
+		MaxExpiry:       domain.MaxExpiry,
+		MaxSecretSize:   domain.MaxSecretSize,
+		MaxFileSize:     domain.MaxFileSize,
+		MaxReadAttempts: domain.MaxReadAttempts,
+	}
+}
+
 type Handler struct {
-	repo         domain.SecretRepository
-	defaultTheme string
+	repo     domain.SecretRepository
+	cfg      HandlerConfig
+	expiries map[string]time.Duration // parsed ExpiryOptions
+	notifier Notifier                 // nil if webhooks are disabled
+	auditLog *audit.Log               // nil if auditing is disabled
+	apiKeys  apikey.Store             // nil if anyone may create secrets
+
+	openAPISpec func() ([]byte, error) // encoded once, see HandleOpenAPI
+}
+
+// NewHandler returns a handler applying cfg. Expiry options that can't be
+// parsed are ignored, config.Load rejects them up front.
+func NewHandler(repo domain.SecretRepository, cfg HandlerConfig) *Handler {
+	expiries := make(map[string]time.Duration, len(cfg.ExpiryOptions))
+	for _, opt := range cfg.ExpiryOptions {
+		if ttl, ok := utility.ParseExpiry(opt); ok {
+			expiries[opt] = ttl
+		}
+	}
+	h := &Handler{repo: repo, cfg: cfg, expiries: expiries}
+	h.openAPISpec = sync.OnceValues(h.encodeOpenAPISpec)
+	return h
+}
+
+// SetNotifier enables webhooks for secrets created with a notify_url.
+func (h *Handler) SetNotifier(n Notifier) {
+	h.notifier = n
+}
+
+// SetAuditLog records the lifecycle events of secrets in l.
+func (h *Handler) SetAuditLog(l *audit.Log) {
+	h.auditLog = l
 }
 
-func NewHandler(repo domain.SecretRepository, defaultTheme string) *Handler {
-	return &Handler{repo: repo, defaultTheme: defaultTheme}
+// SetAPIKeys requires an API key from store to create secrets and secret
+// requests. Reading them stays anonymous.
+func (h *Handler) SetAPIKeys(store apikey.Store) {
+	h.apiKeys = store
 }
 
 func (h *Handler) HandleHealth(w http.ResponseWriter, r *http.Request) {
-	// Check Redis if ?redis=true is passed
+	// Check the storage backend if ?redis=true is passed
 	if r.URL.Query().Get("redis") == "true" {
 		if err := h.repo.Ping(r.Context()); err != nil {
-			log.Printf("health check failed: %v", err)
+			slog.ErrorContext(r.Context(), "health check failed", logging.Err(err))
 			w.WriteHeader(http.StatusServiceUnavailable)
 			_, _ = w.Write([]byte("redis unavailable"))
 			return
@@ -43,153 +116,940 @@ func (h *Handler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) HandleConfig(w http.ResponseWriter, r *http.Request) {
 	utility.WriteJSON(w, http.StatusOK, domain.ConfigRes{
-		MaxSecretSize: domain.MaxSecretSize,
-		ExpiryOptions: domain.ExpiryOptions,
-		DefaultTheme:  h.defaultTheme,
+		MaxSecretSize:   h.cfg.MaxSecretSize,
+		MaxFileSize:     h.cfg.MaxFileSize,
+		ExpiryOptions:   h.cfg.ExpiryOptions,
+		DefaultExpiry:   h.cfg.DefaultExpiry,
+		MinExpiry:       utility.FormatExpiry(h.cfg.MinExpiry),
+		MaxExpiry:       utility.FormatExpiry(h.cfg.MaxExpiry),
+		MaxViews:        domain.MaxViews,
+		MaxReadAttempts: h.cfg.MaxReadAttempts,
+		DefaultTheme:    h.cfg.DefaultTheme,
+		APIKeyRequired:  h.apiKeys != nil,
 	})
 }
 
 func (h *Handler) HandleCreate(w http.ResponseWriter, r *http.Request) {
-	r.Body = http.MaxBytesReader(w, r.Body, domain.MaxRequestBodySize)
+	ctx, span := tracing.Tracer().Start(r.Context(), "HandleCreate")
+	defer span.End()
+	r = r.WithContext(ctx)
 
 	var req domain.CreateReq
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if !h.decodeBody(w, r, &req) {
+		return
+	}
+
+	req.Secret = strings.TrimSpace(req.Secret)
+	req.Ciphertext = strings.TrimSpace(req.Ciphertext)
+	if req.Ciphertext != "" && (req.Secret != "" || req.Fields != nil) {
+		utility.HttpError(w, domain.CodeInvalidRequest,
+			"secret, ciphertext and fields are mutually exclusive")
+		return
+	}
+	maxSize := int(keyLimit(ctx, int64(h.cfg.MaxSecretSize)))
+	var plaintext []byte
+	if req.Ciphertext == "" {
+		var err error
+		if plaintext, err = secretContent(req.Secret, req.Fields, maxSize); err != nil {
+			utility.WriteError(w, err, domain.CodeInvalidRequest)
+			return
+		}
+	} else if len(req.Ciphertext) > domain.MaxCiphertextSize(maxSize) {
+		utility.HttpError(w, domain.CodeSecretTooLarge,
+			fmt.Sprintf("secret exceeds %s limit", formatSize(maxSize)))
+		return
+	}
+	if req.Passphrase != "" && req.Ciphertext != "" {
+		utility.HttpError(w, domain.CodeInvalidRequest,
+			"passphrase can't be used with a client-side encrypted secret")
+		return
+	}
+
+	ttl, err := h.checkOptions(ctx, &req)
+	if err != nil {
+		utility.WriteError(w, err, domain.CodeInvalidRequest)
+		return
+	}
+
+	span.SetAttributes(
+		attribute.String("secret.expiry", h.expiryBucket(ttl)),
+		attribute.Bool("secret.client_encrypted", req.Ciphertext != ""),
+	)
+
+	id := uuid.NewString()
+
+	var (
+		blob     []byte
+		passcode string
+	)
+	if req.Ciphertext != "" {
+		// Zero-knowledge mode: the client already encrypted the secret and
+		// kept the key, so the blob is stored as-is.
+		blob = []byte(req.Ciphertext)
+		if err := utility.ValidateClientBlob(blob, maxSize); err != nil {
+			utility.HttpError(w, domain.CodeInvalidRequest, "ciphertext must be a valid v2 blob")
+			return
+		}
+	} else {
+		var err error
+		passcode, err = utility.GeneratePasscode()
+		if err != nil {
+			utility.HttpError(w, domain.CodeInternal, "passcode generation failed")
+			return
+		}
+		if req.Fields != nil {
+			blob, err = utility.EncryptFields(ctx, plaintext, passcode, req.Passphrase, id)
+		} else {
+			blob, err = utility.Encrypt(ctx, plaintext, passcode, req.Passphrase, id)
+		}
+		if err != nil {
+			utility.HttpError(w, domain.CodeInternal, "encryption failed")
+			return
+		}
+	}
+
+	h.storeSecret(w, r, id, req, ttl, blob, passcode)
+}
+
+// decodeBody decodes the JSON request body into v, and reports whether it
+// could. The body is limited to the size of the largest secret.
+func (h *Handler) decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxRequestBodySize())
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
 		var maxBytesErr *http.MaxBytesError
 		if errors.As(err, &maxBytesErr) {
-			utility.HttpError(w, http.StatusRequestEntityTooLarge, "request body too large")
-			return
+			utility.HttpError(w, domain.CodeSecretTooLarge, "request body too large")
+			return false
+		}
+		utility.HttpError(w, domain.CodeInvalidRequest, "invalid JSON body")
+		return false
+	}
+	return true
+}
+
+// secretContent validates a secret sent either as text or as the fields of a
+// structured secret, and returns the plaintext to encrypt, or the error to
+// reply with. The fields are encrypted together, as JSON.
+func secretContent(secret string, fields domain.Fields, maxSize int) ([]byte, error) {
+	var plaintext []byte
+	switch {
+	case secret != "" && fields != nil:
+		return nil, domain.Errorf(domain.CodeInvalidRequest,
+			"secret and fields are mutually exclusive")
+	case len(fields) > 0:
+		if err := fields.Validate(); err != nil {
+			return nil, domain.Errorf(domain.CodeInvalidRequest, "%v", err)
 		}
-		utility.HttpError(w, http.StatusBadRequest, "invalid JSON body")
+		var err error
+		if plaintext, err = json.Marshal(fields); err != nil {
+			return nil, domain.Errorf(domain.CodeInvalidRequest, "invalid fields")
+		}
+	case secret == "":
+		return nil, domain.Errorf(domain.CodeInvalidRequest, "secret is required")
+	default:
+		plaintext = []byte(secret)
+	}
+	if len(plaintext) > maxSize {
+		return nil, domain.Errorf(domain.CodeSecretTooLarge,
+			"secret exceeds %s limit", formatSize(maxSize))
+	}
+	return plaintext, nil
+}
+
+// keyLimit returns limit, or the size quota of the request's API key if it
+// is lower.
+func keyLimit(ctx context.Context, limit int64) int64 {
+	if k, ok := apikey.FromContext(ctx); ok && k.MaxSize > 0 && k.MaxSize < limit {
+		return k.MaxSize
+	}
+	return limit
+}
+
+// checkKeyExpiry rejects a TTL longer than the request's API key allows.
+func checkKeyExpiry(ctx context.Context, ttl time.Duration) error {
+	if k, ok := apikey.FromContext(ctx); ok && k.MaxExpiry > 0 && ttl > k.MaxExpiry {
+		return domain.Errorf(domain.CodeInvalidExpiry,
+			"expiry exceeds the %s limit of this API key", utility.FormatExpiry(k.MaxExpiry))
+	}
+	return nil
+}
+
+// HandleCreateFile creates a secret from a file sent as the raw request
+// body. The options of HandleCreate are passed in the query string, along
+// with the file name, and the passphrase in the X-Passphrase header. The file
+// is encrypted and stored chunk by chunk as it is received.
+func (h *Handler) HandleCreateFile(w http.ResponseWriter, r *http.Request) {
+	maxSize := keyLimit(r.Context(), h.cfg.MaxFileSize)
+	if r.ContentLength > maxSize {
+		utility.HttpError(w, domain.CodeSecretTooLarge,
+			fmt.Sprintf("file exceeds %s limit", formatSize(maxSize)))
 		return
 	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	// A file takes longer to send than the server's read timeout allows for
+	// other requests, the handler timeout bounds it instead.
+	_ = http.NewResponseController(w).SetReadDeadline(time.Now().Add(handlerTimeout))
 
-	req.Secret = strings.TrimSpace(req.Secret)
-	if req.Secret == "" {
-		utility.HttpError(w, http.StatusBadRequest, "secret is required")
+	q := r.URL.Query()
+	req := domain.CreateReq{
+		Expiry:     q.Get("expiry"),
+		NotifyURL:  q.Get("notify_url"),
+		Passphrase: r.Header.Get("X-Passphrase"),
+	}
+	if v := q.Get("max_views"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			utility.HttpError(w, domain.CodeInvalidRequest, "max_views must be a number")
+			return
+		}
+		req.MaxViews = n
+	}
+	if v := q.Get("expires_at"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			utility.HttpError(w, domain.CodeInvalidExpiry, "expires_at must be an RFC 3339 time")
+			return
+		}
+		req.ExpiresAt = &t
+	}
+	name := cleanFilename(q.Get("filename"))
+	if name == "" {
+		utility.HttpError(w, domain.CodeInvalidRequest, "filename is required")
 		return
 	}
-	if len(req.Secret) > domain.MaxSecretSize {
-		utility.HttpError(w, http.StatusRequestEntityTooLarge, "secret exceeds 64KB limit")
+	ttl, err := h.checkOptions(r.Context(), &req)
+	if err != nil {
+		utility.WriteError(w, err, domain.CodeInvalidRequest)
 		return
 	}
 
+	id := uuid.NewString()
 	passcode, err := utility.GeneratePasscode()
 	if err != nil {
-		utility.HttpError(w, http.StatusInternalServerError, "passcode generation failed")
+		utility.HttpError(w, domain.CodeInternal, "passcode generation failed")
+		return
+	}
+	c, err := utility.NewFileCipher(r.Context(), passcode, req.Passphrase, id)
+	if err != nil {
+		utility.HttpError(w, domain.CodeInternal, "encryption failed")
 		return
 	}
 
-	var ttl time.Duration
-	if req.Expiry == "" {
-		ttl = domain.DefaultExpiry
-	} else {
-		var ok bool
-		ttl, ok = utility.ParseExpiry(req.Expiry)
-		if !ok {
-			utility.HttpError(w, http.StatusBadRequest, "expiry must be one of: 1h, 6h, 1d, 3d")
+	var storeErr error
+	size, chunks, err := c.SealStream(r.Body, func(chunk []byte) error {
+		storeErr = h.repo.AppendChunk(r.Context(), id, chunk, ttl)
+		return storeErr
+	})
+	if err != nil {
+		// Chunks stored so far would expire on their own, but there's no
+		// point keeping them until then.
+		if err := h.repo.DeleteChunks(context.WithoutCancel(r.Context()), id); err != nil {
+			slog.ErrorContext(r.Context(), "failed to delete chunks of failed upload",
+				logging.ID(id), logging.Err(err))
+		}
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			utility.HttpError(w, domain.CodeSecretTooLarge,
+				fmt.Sprintf("file exceeds %s limit", formatSize(maxSize)))
+		case storeErr != nil:
+			utility.HttpError(w, domain.CodeInternal, "failed to store secret")
+		default:
+			utility.HttpError(w, domain.CodeInvalidRequest, "failed to read file")
+		}
+		return
+	}
+
+	blob, err := c.Blob(utility.FileMeta{
+		Name:   name,
+		Type:   fileType(r.Header.Get("Content-Type")),
+		Size:   size,
+		Chunks: chunks,
+	})
+	if err != nil {
+		utility.HttpError(w, domain.CodeInternal, "encryption failed")
+		return
+	}
+
+	h.storeSecret(w, r, id, req, ttl, blob, passcode)
+}
+
+// checkOptions validates the options of a new secret that don't depend on
+// how its content is sent, filling in their defaults, and returns its TTL.
+func (h *Handler) checkOptions(
+	ctx context.Context, req *domain.CreateReq,
+) (time.Duration, error) {
+	if len(req.Passphrase) > domain.MaxPassphraseSize {
+		return 0, domain.Errorf(domain.CodeInvalidRequest,
+			"passphrase exceeds %d bytes", domain.MaxPassphraseSize)
+	}
+
+	ttl, err := h.expiryTTL(*req, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	if err := checkKeyExpiry(ctx, ttl); err != nil {
+		return 0, err
+	}
+
+	if req.MaxViews == 0 {
+		req.MaxViews = 1
+	}
+	if req.MaxViews < 1 || req.MaxViews > domain.MaxViews {
+		return 0, domain.Errorf(domain.CodeInvalidRequest,
+			"max_views must be between 1 and %d", domain.MaxViews)
+	}
+
+	req.NotifyURL = strings.TrimSpace(req.NotifyURL)
+	if req.NotifyURL != "" {
+		if h.notifier == nil {
+			return 0, domain.Errorf(domain.CodeInvalidRequest,
+				"webhooks are not enabled on this server")
+		}
+		if err := h.notifier.ValidateURL(req.NotifyURL); err != nil {
+			return 0, err
+		}
+	}
+	return ttl, nil
+}
+
+// storeSecret stores the blob of a new secret along with its management
+// token and webhook, and sends the sender what they need to share it.
+func (h *Handler) storeSecret(
+	w http.ResponseWriter, r *http.Request, id string, req domain.CreateReq,
+	ttl time.Duration, blob []byte, passcode string,
+) {
+	deleteToken, err := utility.GenerateToken()
+	if err != nil {
+		utility.HttpError(w, domain.CodeInternal, "token generation failed")
+		return
+	}
+
+	secret := domain.Secret{
+		Blob:      blob,
+		MaxViews:  req.MaxViews,
+		TokenHash: utility.HashToken(deleteToken),
+	}
+
+	var notifySecret string
+	if req.NotifyURL != "" {
+		notifySecret, err = utility.GenerateToken()
+		if err != nil {
+			utility.HttpError(w, domain.CodeInternal, "token generation failed")
+			return
+		}
+		secret.Webhook = &domain.Webhook{URL: req.NotifyURL, Key: []byte(notifySecret)}
+	}
+
+	// An absolute expiry is measured again right before storing, so the time
+	// spent encrypting doesn't push it back. Either way the response reports
+	// exactly the TTL handed to the store.
+	now := time.Now()
+	if req.ExpiresAt != nil {
+		ttl = req.ExpiresAt.Sub(now).Truncate(time.Millisecond)
+		if ttl <= 0 {
+			utility.HttpError(w, domain.CodeInvalidExpiry, "expires_at must be in the future")
 			return
 		}
 	}
+	if err := h.repo.StoreSecret(r.Context(), id, secret, ttl); err != nil {
+		utility.HttpError(w, domain.CodeInternal, "failed to store secret")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "secret created", logging.ID(id),
+		slog.Duration("expiry", ttl),
+		slog.Int("max_views", req.MaxViews),
+		slog.Bool("zero_knowledge", passcode == ""),
+		slog.Bool("passphrase", req.Passphrase != ""),
+		slog.Bool("file", utility.IsFile(blob)),
+		slog.Int("fields", len(req.Fields)),
+		slog.Bool("webhook", secret.Webhook != nil))
+	metrics.SecretsCreated.WithLabelValues(h.expiryBucket(ttl)).Inc()
+
+	expiresAt := now.Add(ttl).UTC()
+	h.audit(r, audit.Event{Type: audit.EventCreated, ID: id, ExpiresAt: expiresAt})
+
+	utility.WriteJSON(w, http.StatusCreated, domain.CreateRes{
+		ID:           id,
+		Passcode:     passcode,
+		ExpiresAt:    expiresAt,
+		ReadURL:      publicURL(r, "/read/"+id),
+		DeleteToken:  deleteToken,
+		NotifySecret: notifySecret,
+	})
+}
+
+// HandleCreateRequest creates a secret request: a one-time link through
+// which someone else sends the requester a secret. The request is stored as a
+// secret holding the public key the secret will be sealed to. The requester
+// gets the private key as their token, which like a passcode is never
+// stored, so the server keeps nothing readable. A separate delete token
+// manages the request, so checking its status doesn't allow reading it.
+func (h *Handler) HandleCreateRequest(w http.ResponseWriter, r *http.Request) {
+	// The body is optional, all its options have defaults.
+	var req domain.RequestReq
+	if r.ContentLength != 0 && !h.decodeBody(w, r, &req) {
+		return
+	}
 
-	blob, err := utility.Encrypt([]byte(req.Secret), passcode)
+	ttl, err := h.expiryTTL(domain.CreateReq{Expiry: req.Expiry, ExpiresAt: req.ExpiresAt},
+		time.Now())
+	if err == nil {
+		err = checkKeyExpiry(r.Context(), ttl)
+	}
 	if err != nil {
-		utility.HttpError(w, http.StatusInternalServerError, "encryption failed")
+		utility.WriteError(w, err, domain.CodeInvalidRequest)
+		return
+	}
+
+	token, blob, err := utility.NewSecretRequest()
+	if err != nil {
+		utility.HttpError(w, domain.CodeInternal, "token generation failed")
+		return
+	}
+	deleteToken, err := utility.GenerateToken()
+	if err != nil {
+		utility.HttpError(w, domain.CodeInternal, "token generation failed")
 		return
 	}
 
 	id := uuid.NewString()
+	secret := domain.Secret{
+		Blob:      blob,
+		MaxViews:  1,
+		TokenHash: utility.HashToken(deleteToken),
+	}
+	now := time.Now()
+	if err := h.repo.StoreSecret(r.Context(), id, secret, ttl); err != nil {
+		utility.HttpError(w, domain.CodeInternal, "failed to store secret")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "secret request created", logging.ID(id),
+		slog.Duration("expiry", ttl))
+	h.audit(r, audit.Event{Type: audit.EventCreated, ID: id, ExpiresAt: now.Add(ttl)})
+
+	utility.WriteJSON(w, http.StatusCreated, domain.RequestRes{
+		ID:          id,
+		UploadURL:   publicURL(r, "/request/"+id),
+		ReadURL:     publicURL(r, "/read/"+id),
+		Token:       token,
+		DeleteToken: deleteToken,
+		ExpiresAt:   now.Add(ttl).UTC(),
+	})
+}
+
+// HandleFulfillRequest seals a secret to a secret request. It only succeeds
+// once: the request is swapped for the sealed secret atomically, and the
+// secret then lives out the rest of the request's expiry.
+func (h *Handler) HandleFulfillRequest(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		utility.HttpError(w, domain.CodeInvalidRequest, "missing id")
+		return
+	}
+
+	var req domain.FulfillReq
+	if !h.decodeBody(w, r, &req) {
+		return
+	}
+	plaintext, err := secretContent(strings.TrimSpace(req.Secret), req.Fields,
+		h.cfg.MaxSecretSize)
+	if err != nil {
+		utility.WriteError(w, err, domain.CodeInvalidRequest)
+		return
+	}
 
-	if err := h.repo.StoreSecret(r.Context(), id, blob, ttl); err != nil {
-		utility.HttpError(w, http.StatusInternalServerError, "failed to store secret")
+	request, err := h.repo.GetSecret(r.Context(), id)
+	if errors.Is(err, domain.ErrNotFound) || err == nil && !utility.IsRequest(request) {
+		utility.HttpError(w, domain.CodeSecretNotFound, "not found, already used or expired")
+		return
+	}
+	if err != nil {
+		utility.HttpError(w, domain.CodeInternal, "failed to fetch secret")
 		return
 	}
 
-	log.Printf("secret created: id=%s expiry=%s", id, ttl)
+	blob, err := utility.Seal(request, plaintext, req.Fields != nil, id)
+	if err != nil {
+		utility.HttpError(w, domain.CodeInternal, "encryption failed")
+		return
+	}
+	if err := h.repo.ReplaceSecret(r.Context(), id, request, blob); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			utility.HttpError(w, domain.CodeSecretNotFound, "not found, already used or expired")
+			return
+		}
+		utility.HttpError(w, domain.CodeInternal, "failed to store secret")
+		return
+	}
 
-	expiresAt := time.Now().Add(ttl).UTC()
+	slog.InfoContext(r.Context(), "secret request fulfilled", logging.ID(id),
+		slog.Int("fields", len(req.Fields)))
+	w.WriteHeader(http.StatusNoContent)
+}
 
+// publicURL returns the absolute URL of path on this server, as the client
+// reached it.
+func publicURL(r *http.Request, path string) string {
 	scheme := "http"
 	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
 		scheme = "https"
 	}
-	readURL := &url.URL{
+	u := &url.URL{
 		Scheme: scheme,
 		Host:   r.Host,
-		Path:   "/read/" + id,
+		Path:   path,
 	}
+	return u.String()
+}
 
-	utility.WriteJSON(w, http.StatusCreated, domain.CreateRes{
-		ID:        id,
-		Passcode:  passcode,
-		ExpiresAt: expiresAt,
-		ReadURL:   readURL.String(),
-	})
+// expiryTTL returns how long a secret requested at now should live. Expiry
+// options are always accepted, any other duration or an absolute expires_at
+// must fall between the configured minimum and maximum.
+func (h *Handler) expiryTTL(req domain.CreateReq, now time.Time) (time.Duration, error) {
+	var ttl time.Duration
+	switch {
+	case req.ExpiresAt != nil && req.Expiry != "":
+		return 0, domain.Errorf(domain.CodeInvalidExpiry,
+			"expiry and expires_at are mutually exclusive")
+	case req.ExpiresAt != nil:
+		ttl = req.ExpiresAt.Sub(now).Truncate(time.Millisecond)
+		if ttl <= 0 {
+			return 0, domain.Errorf(domain.CodeInvalidExpiry, "expires_at must be in the future")
+		}
+	case req.Expiry == "":
+		return h.expiries[h.cfg.DefaultExpiry], nil
+	default:
+		if ttl, ok := h.expiries[req.Expiry]; ok {
+			return ttl, nil
+		}
+		var ok bool
+		if ttl, ok = utility.ParseExpiry(req.Expiry); !ok {
+			return 0, domain.Errorf(domain.CodeInvalidExpiry,
+				"expiry must be one of %s, or a duration such as 30m, 2d12h or PT30M",
+				strings.Join(h.cfg.ExpiryOptions, ", "))
+		}
+	}
+	if ttl < h.cfg.MinExpiry || ttl > h.cfg.MaxExpiry {
+		return 0, domain.Errorf(domain.CodeInvalidExpiry, "expiry must be between %s and %s",
+			utility.FormatExpiry(h.cfg.MinExpiry), utility.FormatExpiry(h.cfg.MaxExpiry))
+	}
+	return ttl, nil
+}
+
+// expiryBucket returns the shortest expiry option at least as long as ttl, or
+// "longer", which keeps the expiries reported in metrics to a few values.
+func (h *Handler) expiryBucket(ttl time.Duration) string {
+	bucket, shortest := "longer", time.Duration(0)
+	for opt, d := range h.expiries {
+		if d >= ttl && (shortest == 0 || d < shortest) {
+			bucket, shortest = opt, d
+		}
+	}
+	return bucket
 }
 
 func (h *Handler) HandleRead(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Tracer().Start(r.Context(), "HandleRead")
+	defer span.End()
+	r = r.WithContext(ctx)
+
 	// Reject any request body - passcode is sent via header
 	r.Body = http.MaxBytesReader(w, r.Body, 0)
 
 	id := chi.URLParam(r, "id")
 	if id == "" {
-		utility.HttpError(w, http.StatusBadRequest, "missing id")
+		utility.HttpError(w, domain.CodeInvalidRequest, "missing id")
 		return
 	}
 
-	passcode := r.Header.Get("X-Passcode")
-	if passcode == "" {
-		utility.HttpError(w, http.StatusBadRequest, "passcode is required")
+	// Checked before anything else, so a typo doesn't cost a view.
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "plain" && format != "env" && format != "json" {
+		utility.HttpError(w, domain.CodeInvalidRequest, "format must be plain, env or json")
 		return
 	}
 
 	blob, err := h.repo.GetSecret(r.Context(), id)
 	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			utility.HttpError(w, http.StatusNotFound, "not found or expired")
+		if errors.Is(err, domain.ErrNotFound) {
+			utility.HttpError(w, domain.CodeSecretNotFound, "not found or expired")
 			return
 		}
-		utility.HttpError(w, http.StatusInternalServerError, "failed to fetch secret")
+		utility.HttpError(w, domain.CodeInternal, "failed to fetch secret")
 		return
 	}
 
-	plaintext, err := utility.Decrypt(blob, passcode)
-	if err != nil {
-		log.Printf("invalid passcode for secret: id=%s", id)
-		attempts, _ := h.repo.IncrFailAndMaybeDelete(r.Context(), id)
-		utility.WriteJSON(w, http.StatusUnauthorized, domain.ReadRes{
-			RemainingAttempts: utility.IntPtr(domain.MaxReadAttempts - int(attempts)),
+	passcode := r.Header.Get("X-Passcode")
+
+	// Reading a request before it was fulfilled costs neither an attempt
+	// nor a view.
+	if utility.IsRequest(blob) {
+		utility.HttpError(w, domain.CodeSecretNotSent, "the secret hasn't been sent yet")
+		return
+	}
+
+	if utility.IsClientEncrypted(blob) {
+		// The server cannot verify the key of a client-side encrypted secret.
+		// A passcode means the client doesn't know that, so refuse rather
+		// than burn a secret it won't be able to decrypt.
+		if passcode != "" {
+			utility.HttpError(w, domain.CodeClientEncrypted,
+				"secret is encrypted client-side, use the full link including its #fragment")
+			return
+		}
+		remaining, ok := h.consumeView(w, r, id, blob)
+		if !ok {
+			return
+		}
+		utility.WriteJSON(w, http.StatusOK, domain.ReadRes{
+			Ciphertext:     string(blob),
+			RemainingViews: utility.IntPtr(remaining),
 		})
 		return
 	}
 
-	log.Printf("secret successfully read: id=%s", id)
-	if err := h.repo.DelIfMatch(r.Context(), id, blob); err != nil {
-		log.Printf("failed to delete secret after read: id=%s err=%v", id, err)
+	if passcode == "" {
+		utility.HttpError(w, domain.CodePasscodeRequired, "passcode is required")
+		return
+	}
+	passphrase := r.Header.Get("X-Passphrase")
+	if passphrase == "" && utility.RequiresPassphrase(blob) {
+		utility.HttpError(w, domain.CodePassphraseRequired, "passphrase is required")
+		return
+	}
+
+	if utility.IsFile(blob) {
+		h.readFile(w, r, id, blob, passcode, passphrase)
+		return
+	}
+
+	plaintext, err := utility.Decrypt(ctx, blob, passcode, passphrase, id)
+	if err != nil {
+		h.rejectPasscode(w, r, id)
+		return
+	}
+
+	var fields domain.Fields
+	if utility.HasFields(blob) {
+		if err := json.Unmarshal(plaintext, &fields); err != nil {
+			utility.HttpError(w, domain.CodeInternal, "failed to decode secret")
+			return
+		}
 	}
 
-	// Tidy up attempts counter in background with timeout
-	go func() {
+	remaining, ok := h.consumeView(w, r, id, blob)
+	if !ok {
+		return
+	}
+
+	writeSecret(w, format, string(plaintext), fields, remaining)
+}
+
+// writeSecret writes a decrypted secret, either its text or its fields, in
+// the requested format. Only the default JSON response has room for the
+// remaining views, the other formats send them in a header.
+func writeSecret(
+	w http.ResponseWriter, format, secret string, fields domain.Fields, remaining int,
+) {
+	if format == "" {
+		res := domain.ReadRes{Secret: secret, RemainingViews: utility.IntPtr(remaining)}
+		if fields != nil {
+			res = domain.ReadRes{Fields: fields, RemainingViews: utility.IntPtr(remaining)}
+		}
+		utility.WriteJSON(w, http.StatusOK, res)
+		return
+	}
+
+	w.Header().Set("X-Remaining-Views", strconv.Itoa(remaining))
+	switch format {
+	case "json":
+		if fields != nil {
+			utility.WriteJSON(w, http.StatusOK, fields)
+		} else {
+			utility.WriteJSON(w, http.StatusOK, secret)
+		}
+		return
+	case "env":
+		if fields != nil {
+			secret = fields.Env()
+		} else {
+			secret = "SECRET=" + domain.ShellQuote(secret) + "\n"
+		}
+	default:
+		if fields != nil {
+			secret = fields.Plain()
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(secret))
+}
+
+// readFile streams the decrypted content of a file secret as an attachment.
+func (h *Handler) readFile(
+	w http.ResponseWriter, r *http.Request, id string, blob []byte, passcode, passphrase string,
+) {
+	c, meta, err := utility.OpenFile(r.Context(), blob, passcode, passphrase, id)
+	if err != nil {
+		h.rejectPasscode(w, r, id)
+		return
+	}
+
+	remaining, ok := h.consumeView(w, r, id, blob)
+	if !ok {
+		return
+	}
+	// The chunks outlive the last view so they can be streamed, and are
+	// deleted once sent. If the reader goes away first they expire with the
+	// secret, and can't be decrypted anymore as the salt of their key was in
+	// the blob.
+	if remaining == 0 {
 		defer func() {
-			if r := recover(); r != nil {
-				log.Printf("panic in DeleteAttempts goroutine: id=%s err=%v", id, r)
+			if err := h.repo.DeleteChunks(context.WithoutCancel(r.Context()), id); err != nil {
+				slog.ErrorContext(r.Context(), "failed to delete file chunks",
+					logging.ID(id), logging.Err(err))
 			}
 		}()
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if err := h.repo.DeleteAttempts(ctx, id); err != nil {
-			log.Printf("failed to delete attempts counter: id=%s err=%v", id, err)
+	}
+
+	w.Header().Set("Content-Type", meta.Type)
+	w.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": meta.Name}))
+	w.Header().Set("Content-Length", strconv.FormatInt(meta.Size, 10))
+	w.Header().Set("X-Remaining-Views", strconv.Itoa(remaining))
+	w.WriteHeader(http.StatusOK)
+
+	for i := range meta.Chunks {
+		chunk, err := h.repo.GetChunk(r.Context(), id, i)
+		if err == nil {
+			chunk, err = c.OpenChunk(i, chunk, i == meta.Chunks-1)
+		}
+		if err != nil {
+			// The status is already sent, the reader sees a short body.
+			slog.ErrorContext(r.Context(), "failed to stream file", logging.ID(id),
+				slog.Int("chunk", i), logging.Err(err))
+			return
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return
 		}
-	}()
+	}
+}
 
-	format := r.URL.Query().Get("format")
-	if format == "plain" {
-		w.Header().Set("Content-Type", "text/plain")
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write(plaintext)
+// rejectPasscode counts a failed read attempt, which burns the secret once
+// too many were made.
+func (h *Handler) rejectPasscode(w http.ResponseWriter, r *http.Request, id string) {
+	slog.WarnContext(r.Context(), "invalid passcode for secret", logging.ID(id))
+	metrics.FailedAttempts.Inc()
+	attempts, _ := h.repo.IncrFailAndMaybeDelete(r.Context(), id, h.cfg.MaxReadAttempts)
+	if attempts >= int64(h.cfg.MaxReadAttempts) {
+		metrics.SecretsBurned.Inc()
+		h.audit(r, audit.Event{Type: audit.EventBurned, ID: id})
+		h.notify(r.Context(), id, EventBurned, nil)
+	}
+	problem := domain.NewProblem(domain.CodePasscodeInvalid, "invalid passcode or passphrase")
+	problem.RemainingAttempts = utility.IntPtr(h.cfg.MaxReadAttempts - int(attempts))
+	utility.WriteProblem(w, problem)
+}
+
+func (h *Handler) HandleRevoke(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		utility.HttpError(w, domain.CodeInvalidRequest, "missing id")
+		return
+	}
+
+	token := r.Header.Get("X-Delete-Token")
+	if token == "" {
+		utility.HttpError(w, domain.CodeDeleteTokenRequired, "delete token is required")
+		return
+	}
+
+	err := h.repo.DeleteSecret(r.Context(), id, utility.HashToken(token))
+	switch {
+	case errors.Is(err, domain.ErrNotFound):
+		utility.HttpError(w, domain.CodeSecretNotFound, "not found or expired")
+		return
+	case errors.Is(err, domain.ErrInvalidToken):
+		slog.WarnContext(r.Context(), "invalid delete token for secret", logging.ID(id))
+		utility.HttpError(w, domain.CodeDeleteTokenInvalid, "invalid delete token")
+		return
+	case err != nil:
+		utility.HttpError(w, domain.CodeInternal, "failed to revoke secret")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "secret revoked", logging.ID(id))
+	h.audit(r, audit.Event{Type: audit.EventRevoked, ID: id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		utility.HttpError(w, domain.CodeInvalidRequest, "missing id")
+		return
+	}
+
+	token := r.Header.Get("X-Delete-Token")
+	if token == "" {
+		utility.HttpError(w, domain.CodeDeleteTokenRequired, "delete token is required")
+		return
+	}
+
+	status, err := h.repo.GetStatus(r.Context(), id, utility.HashToken(token))
+	switch {
+	case errors.Is(err, domain.ErrNotFound):
+		// Read, revoked or expired: the token can't be checked anymore, and
+		// existence is no more than what /read already discloses.
+		utility.WriteJSON(w, http.StatusOK, domain.StatusRes{Exists: false})
+		return
+	case errors.Is(err, domain.ErrInvalidToken):
+		utility.HttpError(w, domain.CodeDeleteTokenInvalid, "invalid delete token")
+		return
+	case err != nil:
+		utility.HttpError(w, domain.CodeInternal, "failed to fetch status")
 		return
 	}
 
-	utility.WriteJSON(w, http.StatusOK, domain.ReadRes{Secret: string(plaintext)})
+	res := domain.StatusRes{
+		Exists:         true,
+		RemainingViews: utility.IntPtr(int(status.RemainingViews)),
+		FailedAttempts: utility.IntPtr(int(status.FailedAttempts)),
+	}
+	// A negative TTL means the secret has no expiry, which never happens for
+	// secrets created through the API.
+	if status.TTL > 0 {
+		expiresAt := time.Now().Add(status.TTL).UTC()
+		expiresIn := int64(status.TTL / time.Second)
+		res.ExpiresAt = &expiresAt
+		res.ExpiresIn = &expiresIn
+	}
+	utility.WriteJSON(w, http.StatusOK, res)
+}
+
+// consumeView uses up one view of a secret that has just been read and
+// returns the number of views left. Once none remain the secret is deleted
+// along with its counters. It writes an error response and returns false if
+// another reader consumed the last view first, or if the view couldn't be
+// consumed, so a secret is never revealed more times than it allows.
+func (h *Handler) consumeView(
+	w http.ResponseWriter, r *http.Request, id string, blob []byte,
+) (int, bool) {
+	remaining, err := h.repo.DecrViewAndMaybeDelete(r.Context(), id, blob)
+	if errors.Is(err, domain.ErrNotFound) {
+		utility.HttpError(w, domain.CodeSecretNotFound, "not found or expired")
+		return 0, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to consume view after read",
+			logging.ID(id), logging.Err(err))
+		utility.HttpError(w, domain.CodeInternal, "failed to read secret")
+		return 0, false
+	}
+	slog.InfoContext(r.Context(), "secret successfully read", logging.ID(id),
+		slog.Int64("remaining_views", remaining))
+	metrics.SecretsRead.Inc()
+	h.audit(r, audit.Event{Type: audit.EventRead, ID: id, RemainingViews: remaining})
+	h.notify(r.Context(), id, EventRead, utility.IntPtr(int(remaining)))
+	return int(remaining), true
+}
+
+// notify queues an event for the webhook of a secret, if it has one. The
+// webhook is removed along with the last event the secret can produce, so
+// the expiry poller doesn't report it again.
+func (h *Handler) notify(
+	ctx context.Context, id string, event WebhookEvent, remainingViews *int,
+) {
+	if h.notifier == nil {
+		return
+	}
+
+	var (
+		hook *domain.Webhook
+		err  error
+	)
+	if event == EventRead && *remainingViews > 0 {
+		hook, err = h.repo.GetWebhook(ctx, id)
+	} else {
+		hook, err = h.repo.TakeWebhook(ctx, id)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to fetch webhook", logging.ID(id), logging.Err(err))
+		return
+	}
+	if hook == nil {
+		return
+	}
+
+	h.notifier.Notify(hook, WebhookPayload{
+		Event:          event,
+		ID:             id,
+		RemainingViews: remainingViews,
+		OccurredAt:     time.Now().UTC(),
+	})
+}
+
+// audit records an event of a secret in the audit log, if there is one. The
+// event is recorded even if the client has gone away meanwhile. A failure is
+// only logged, it doesn't fail the request.
+func (h *Handler) audit(r *http.Request, ev audit.Event) {
+	if h.auditLog == nil {
+		return
+	}
+	ev.ClientIP = stripPort(r.RemoteAddr)
+	ev.RequestID = middleware.GetReqID(r.Context())
+	if err := h.auditLog.Record(context.WithoutCancel(r.Context()), ev); err != nil {
+		slog.ErrorContext(r.Context(), "failed to record audit event",
+			slog.String("event", string(ev.Type)), logging.ID(ev.ID), logging.Err(err))
+	}
+}
+
+// maxRequestBodySize is the largest request body accepted, enough for a
+// client-side encrypted secret of MaxSecretSize.
+func (h *Handler) maxRequestBodySize() int64 {
+	return domain.MaxRequestBodySize(h.cfg.MaxSecretSize)
+}
+
+// cleanFilename strips the directories and control characters a sender may
+// have left in a file name, and returns "" if nothing usable remains.
+func cleanFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(path.Base(strings.ReplaceAll(name, "\\", "/")))
+	if name == "." || name == ".." || name == "/" || len(name) > maxFilenameSize {
+		return ""
+	}
+	return name
+}
+
+// fileType returns the MIME type of an uploaded file from its Content-Type,
+// or a generic one if it has none or it can't be parsed.
+func fileType(contentType string) string {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return mime.FormatMediaType(mediaType, params)
+}
+
+// formatSize formats a size in bytes for error messages, e.g. "64KB".
+func formatSize[T int | int64](n T) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dMB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dKB", n>>10)
+	default:
+		return fmt.Sprintf("%d bytes", n)
+	}
 }
 
 func (h *Handler) HandleIndexHTML(w http.ResponseWriter, r *http.Request) {
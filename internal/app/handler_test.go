@@ -1,35 +1,52 @@
 package app
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/smallwat3r/secretapi/internal/domain"
+	"github.com/smallwat3r/secretapi/internal/metrics"
 	"github.com/smallwat3r/secretapi/internal/utility"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/redis/go-redis/v9"
 )
 
 type mockSecretRepository struct {
-	StoreSecretFunc func(ctx context.Context, id string, secret []byte,
+	StoreSecretFunc func(ctx context.Context, id string, secret domain.Secret,
 		ttl time.Duration) error
 	GetSecretFunc              func(ctx context.Context, id string) ([]byte, error)
-	DelIfMatchFunc             func(ctx context.Context, id string, old []byte) error
-	IncrFailAndMaybeDeleteFunc func(ctx context.Context, id string) (int64, error)
-	DeleteAttemptsFunc         func(ctx context.Context, id string) error
+	ReplaceSecretFunc          func(ctx context.Context, id string, old, blob []byte) error
+	DecrViewAndMaybeDeleteFunc func(ctx context.Context, id string, old []byte) (int64, error)
+	IncrFailAndMaybeDeleteFunc func(ctx context.Context, id string, maxAttempts int) (int64, error)
+	DeleteSecretFunc           func(ctx context.Context, id string, tokenHash []byte) error
+	GetStatusFunc              func(ctx context.Context, id string, tokenHash []byte) (domain.SecretStatus, error)
+	GetWebhookFunc             func(ctx context.Context, id string) (*domain.Webhook, error)
+	TakeWebhookFunc            func(ctx context.Context, id string) (*domain.Webhook, error)
+	DueWebhooksFunc            func(ctx context.Context, before time.Time, limit int64) ([]string, error)
+	AppendChunkFunc            func(ctx context.Context, id string, chunk []byte, ttl time.Duration) error
+	GetChunkFunc               func(ctx context.Context, id string, index int) ([]byte, error)
+	DeleteChunksFunc           func(ctx context.Context, id string) error
+	RewriteBlobsFunc           func(ctx context.Context, rewrite domain.BlobRewriter) (int, error)
 	PingFunc                   func(ctx context.Context) error
 }
 
 func (m *mockSecretRepository) StoreSecret(
-	ctx context.Context, id string, secret []byte, ttl time.Duration,
+	ctx context.Context, id string, secret domain.Secret, ttl time.Duration,
 ) error {
 	if m.StoreSecretFunc != nil {
 		return m.StoreSecretFunc(ctx, id, secret, ttl)
@@ -44,29 +61,110 @@ func (m *mockSecretRepository) GetSecret(ctx context.Context, id string) ([]byte
 	return nil, nil
 }
 
-func (m *mockSecretRepository) DelIfMatch(ctx context.Context, id string, old []byte) error {
-	if m.DelIfMatchFunc != nil {
-		return m.DelIfMatchFunc(ctx, id, old)
+func (m *mockSecretRepository) ReplaceSecret(
+	ctx context.Context, id string, old, blob []byte,
+) error {
+	if m.ReplaceSecretFunc != nil {
+		return m.ReplaceSecretFunc(ctx, id, old, blob)
 	}
 	return nil
 }
 
+func (m *mockSecretRepository) DecrViewAndMaybeDelete(
+	ctx context.Context, id string, old []byte,
+) (int64, error) {
+	if m.DecrViewAndMaybeDeleteFunc != nil {
+		return m.DecrViewAndMaybeDeleteFunc(ctx, id, old)
+	}
+	return 0, nil
+}
+
 func (m *mockSecretRepository) IncrFailAndMaybeDelete(
-	ctx context.Context, id string,
+	ctx context.Context, id string, maxAttempts int,
 ) (int64, error) {
 	if m.IncrFailAndMaybeDeleteFunc != nil {
-		return m.IncrFailAndMaybeDeleteFunc(ctx, id)
+		return m.IncrFailAndMaybeDeleteFunc(ctx, id, maxAttempts)
 	}
 	return 0, nil
 }
 
-func (m *mockSecretRepository) DeleteAttempts(ctx context.Context, id string) error {
-	if m.DeleteAttemptsFunc != nil {
-		return m.DeleteAttemptsFunc(ctx, id)
+func (m *mockSecretRepository) DeleteSecret(
+	ctx context.Context, id string, tokenHash []byte,
+) error {
+	if m.DeleteSecretFunc != nil {
+		return m.DeleteSecretFunc(ctx, id, tokenHash)
+	}
+	return nil
+}
+
+func (m *mockSecretRepository) GetStatus(
+	ctx context.Context, id string, tokenHash []byte,
+) (domain.SecretStatus, error) {
+	if m.GetStatusFunc != nil {
+		return m.GetStatusFunc(ctx, id, tokenHash)
+	}
+	return domain.SecretStatus{}, nil
+}
+
+func (m *mockSecretRepository) GetWebhook(
+	ctx context.Context, id string,
+) (*domain.Webhook, error) {
+	if m.GetWebhookFunc != nil {
+		return m.GetWebhookFunc(ctx, id)
+	}
+	return nil, nil
+}
+
+func (m *mockSecretRepository) TakeWebhook(
+	ctx context.Context, id string,
+) (*domain.Webhook, error) {
+	if m.TakeWebhookFunc != nil {
+		return m.TakeWebhookFunc(ctx, id)
+	}
+	return nil, nil
+}
+
+func (m *mockSecretRepository) DueWebhooks(
+	ctx context.Context, before time.Time, limit int64,
+) ([]string, error) {
+	if m.DueWebhooksFunc != nil {
+		return m.DueWebhooksFunc(ctx, before, limit)
+	}
+	return nil, nil
+}
+
+func (m *mockSecretRepository) AppendChunk(
+	ctx context.Context, id string, chunk []byte, ttl time.Duration,
+) error {
+	if m.AppendChunkFunc != nil {
+		return m.AppendChunkFunc(ctx, id, chunk, ttl)
+	}
+	return nil
+}
+
+func (m *mockSecretRepository) GetChunk(ctx context.Context, id string, index int) ([]byte, error) {
+	if m.GetChunkFunc != nil {
+		return m.GetChunkFunc(ctx, id, index)
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (m *mockSecretRepository) DeleteChunks(ctx context.Context, id string) error {
+	if m.DeleteChunksFunc != nil {
+		return m.DeleteChunksFunc(ctx, id)
 	}
 	return nil
 }
 
+func (m *mockSecretRepository) RewriteBlobs(
+	ctx context.Context, rewrite domain.BlobRewriter,
+) (int, error) {
+	if m.RewriteBlobsFunc != nil {
+		return m.RewriteBlobsFunc(ctx, rewrite)
+	}
+	return 0, nil
+}
+
 func (m *mockSecretRepository) Ping(ctx context.Context) error {
 	if m.PingFunc != nil {
 		return m.PingFunc(ctx)
@@ -76,7 +174,7 @@ func (m *mockSecretRepository) Ping(ctx context.Context) error {
 
 func TestHandler_HandleHealth(t *testing.T) {
 	t.Run("returns ok without redis check", func(t *testing.T) {
-		handler := NewHandler(nil, "")
+		handler := NewHandler(nil, DefaultHandlerConfig())
 		req := httptest.NewRequest(http.MethodGet, "/health", nil)
 		rr := httptest.NewRecorder()
 
@@ -96,7 +194,7 @@ func TestHandler_HandleHealth(t *testing.T) {
 				return nil
 			},
 		}
-		handler := NewHandler(mockRepo, "")
+		handler := NewHandler(mockRepo, DefaultHandlerConfig())
 		req := httptest.NewRequest(http.MethodGet, "/health?redis=true", nil)
 		rr := httptest.NewRecorder()
 
@@ -116,7 +214,7 @@ func TestHandler_HandleHealth(t *testing.T) {
 				return errors.New("connection refused")
 			},
 		}
-		handler := NewHandler(mockRepo, "")
+		handler := NewHandler(mockRepo, DefaultHandlerConfig())
 		req := httptest.NewRequest(http.MethodGet, "/health?redis=true", nil)
 		rr := httptest.NewRecorder()
 
@@ -134,7 +232,7 @@ func TestHandler_HandleHealth(t *testing.T) {
 }
 
 func TestHandler_HandleConfig(t *testing.T) {
-	handler := NewHandler(nil, "")
+	handler := NewHandler(nil, DefaultHandlerConfig())
 	req := httptest.NewRequest(http.MethodGet, "/config", nil)
 	rr := httptest.NewRecorder()
 
@@ -170,7 +268,9 @@ func TestHandler_HandleConfig_DefaultTheme(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run("default_theme="+tc.defaultTheme, func(t *testing.T) {
-			handler := NewHandler(nil, tc.defaultTheme)
+			cfg := DefaultHandlerConfig()
+			cfg.DefaultTheme = tc.defaultTheme
+			handler := NewHandler(nil, cfg)
 			req := httptest.NewRequest(http.MethodGet, "/config", nil)
 			rr := httptest.NewRecorder()
 
@@ -194,11 +294,11 @@ func TestHandler_HandleCreate(t *testing.T) {
 	utility.LowerCryptoParamsForTest(t)
 
 	mockRepo := &mockSecretRepository{}
-	handler := NewHandler(mockRepo, "")
+	handler := NewHandler(mockRepo, DefaultHandlerConfig())
 
 	t.Run("successful creation", func(t *testing.T) {
 		mockRepo.StoreSecretFunc = func(
-			ctx context.Context, id string, secret []byte, ttl time.Duration,
+			ctx context.Context, id string, secret domain.Secret, ttl time.Duration,
 		) error {
 			return nil
 		}
@@ -232,7 +332,7 @@ func TestHandler_HandleCreate(t *testing.T) {
 	t.Run("successful creation with default expiry", func(t *testing.T) {
 		var capturedTTL time.Duration
 		mockRepo.StoreSecretFunc = func(
-			ctx context.Context, id string, secret []byte, ttl time.Duration,
+			ctx context.Context, id string, secret domain.Secret, ttl time.Duration,
 		) error {
 			capturedTTL = ttl
 			return nil
@@ -295,7 +395,7 @@ func TestHandler_HandleCreate(t *testing.T) {
 
 	t.Run("internal server error - store secret fails", func(t *testing.T) {
 		mockRepo.StoreSecretFunc = func(
-			ctx context.Context, id string, secret []byte, ttl time.Duration,
+			ctx context.Context, id string, secret domain.Secret, ttl time.Duration,
 		) error {
 			return errors.New("db error")
 		}
@@ -314,29 +414,27 @@ func TestHandler_HandleRead(t *testing.T) {
 	utility.LowerCryptoParamsForTest(t)
 
 	mockRepo := &mockSecretRepository{}
-	handler := NewHandler(mockRepo, "")
+	handler := NewHandler(mockRepo, DefaultHandlerConfig())
 	secretID := "test-id"
 	passcode, err := utility.GeneratePasscode()
 	if err != nil {
 		t.Fatalf("failed to generate passcode: %v", err)
 	}
 	secretText := "my-secret"
-	encryptedSecret, _ := utility.Encrypt([]byte(secretText), passcode)
+	encryptedSecret, _ := utility.Encrypt(
+		context.Background(), []byte(secretText), passcode, "", secretID)
 
 	t.Run("successful read", func(t *testing.T) {
 		mockRepo.GetSecretFunc = func(ctx context.Context, id string) ([]byte, error) {
 			if id == secretID {
 				return encryptedSecret, nil
 			}
-			return nil, redis.Nil
+			return nil, domain.ErrNotFound
 		}
-		mockRepo.DelIfMatchFunc = func(
+		mockRepo.DecrViewAndMaybeDeleteFunc = func(
 			ctx context.Context, id string, old []byte,
-		) error {
-			return nil
-		}
-		mockRepo.DeleteAttemptsFunc = func(ctx context.Context, id string) error {
-			return nil
+		) (int64, error) {
+			return 0, nil
 		}
 
 		req := httptest.NewRequest(http.MethodPost, "/read/"+secretID, nil)
@@ -367,15 +465,12 @@ func TestHandler_HandleRead(t *testing.T) {
 			if id == secretID {
 				return encryptedSecret, nil
 			}
-			return nil, redis.Nil
+			return nil, domain.ErrNotFound
 		}
-		mockRepo.DelIfMatchFunc = func(
+		mockRepo.DecrViewAndMaybeDeleteFunc = func(
 			ctx context.Context, id string, old []byte,
-		) error {
-			return nil
-		}
-		mockRepo.DeleteAttemptsFunc = func(ctx context.Context, id string) error {
-			return nil
+		) (int64, error) {
+			return 0, nil
 		}
 
 		target := &url.URL{
@@ -407,7 +502,7 @@ func TestHandler_HandleRead(t *testing.T) {
 
 	t.Run("not found", func(t *testing.T) {
 		mockRepo.GetSecretFunc = func(ctx context.Context, id string) ([]byte, error) {
-			return nil, redis.Nil
+			return nil, domain.ErrNotFound
 		}
 		req := httptest.NewRequest(http.MethodPost, "/read/wrong-id", nil)
 		req.Header.Set("X-Passcode", passcode)
@@ -427,7 +522,7 @@ func TestHandler_HandleRead(t *testing.T) {
 			return encryptedSecret, nil
 		}
 		mockRepo.IncrFailAndMaybeDeleteFunc = func(
-			ctx context.Context, id string,
+			ctx context.Context, id string, maxAttempts int,
 		) (int64, error) {
 			return 1, nil
 		}
@@ -442,10 +537,13 @@ func TestHandler_HandleRead(t *testing.T) {
 			t.Errorf("wrong status: got %v want %v", status, http.StatusUnauthorized)
 		}
 
-		var res domain.ReadRes
+		var res domain.Problem
 		if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
 			t.Fatalf("could not decode response: %v", err)
 		}
+		if res.Code != domain.CodePasscodeInvalid {
+			t.Errorf("expected code %s, got %s", domain.CodePasscodeInvalid, res.Code)
+		}
 		if res.RemainingAttempts == nil {
 			t.Fatal("expected remaining_attempts in response")
 		}
@@ -454,6 +552,27 @@ func TestHandler_HandleRead(t *testing.T) {
 		}
 	})
 
+	t.Run("unauthorized - blob copied to another id", func(t *testing.T) {
+		mockRepo.GetSecretFunc = func(ctx context.Context, id string) ([]byte, error) {
+			return encryptedSecret, nil
+		}
+		mockRepo.IncrFailAndMaybeDeleteFunc = func(
+			ctx context.Context, id string, maxAttempts int,
+		) (int64, error) {
+			return 1, nil
+		}
+		req := httptest.NewRequest(http.MethodPost, "/read/other-id", nil)
+		req.Header.Set("X-Passcode", passcode)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "other-id")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		handler.HandleRead(rr, req)
+		if status := rr.Code; status != http.StatusUnauthorized {
+			t.Errorf("wrong status: got %v want %v", status, http.StatusUnauthorized)
+		}
+	})
+
 	t.Run("bad request - missing passcode header", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodPost, "/read/"+secretID, nil)
 		rctx := chi.NewRouteContext()
@@ -514,14 +633,14 @@ func TestHandler_HandleCreate_ExpiryOptions(t *testing.T) {
 			var capturedTTL time.Duration
 			mockRepo := &mockSecretRepository{
 				StoreSecretFunc: func(
-					ctx context.Context, id string, secret []byte,
+					ctx context.Context, id string, secret domain.Secret,
 					ttl time.Duration,
 				) error {
 					capturedTTL = ttl
 					return nil
 				},
 			}
-			handler := NewHandler(mockRepo, "")
+			handler := NewHandler(mockRepo, DefaultHandlerConfig())
 
 			reqBody := `{"secret":"test","expiry":"` + tc.expiry + `"}`
 			req := httptest.NewRequest(
@@ -540,17 +659,198 @@ func TestHandler_HandleCreate_ExpiryOptions(t *testing.T) {
 	}
 }
 
+func TestHandler_HandleCreate_CustomExpiry(t *testing.T) {
+	utility.LowerCryptoParamsForTest(t)
+
+	inOneHour := time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano)
+	inTenDays := time.Now().Add(10 * 24 * time.Hour).UTC().Format(time.RFC3339)
+	testCases := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantTTL    time.Duration // 0 for expires_at, checked against the response
+	}{
+		{"go duration", `{"secret":"s","expiry":"30m"}`, http.StatusCreated, 30 * time.Minute},
+		{"days and hours", `{"secret":"s","expiry":"2d12h"}`, http.StatusCreated, 60 * time.Hour},
+		{"iso 8601", `{"secret":"s","expiry":"PT45M"}`, http.StatusCreated, 45 * time.Minute},
+		{"expires_at", `{"secret":"s","expires_at":"` + inOneHour + `"}`, http.StatusCreated, 0},
+		{"below minimum", `{"secret":"s","expiry":"1m"}`, http.StatusBadRequest, 0},
+		{"above maximum", `{"secret":"s","expiry":"P2W"}`, http.StatusBadRequest, 0},
+		{"expires_at too late", `{"secret":"s","expires_at":"` + inTenDays + `"}`,
+			http.StatusBadRequest, 0},
+		{"expires_at in the past", `{"secret":"s","expires_at":"2020-01-01T00:00:00Z"}`,
+			http.StatusBadRequest, 0},
+		{"expiry and expires_at", `{"secret":"s","expiry":"1h","expires_at":"` + inOneHour + `"}`,
+			http.StatusBadRequest, 0},
+		{"unparsable", `{"secret":"s","expiry":"tomorrow"}`, http.StatusBadRequest, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var capturedTTL time.Duration
+			mockRepo := &mockSecretRepository{
+				StoreSecretFunc: func(
+					ctx context.Context, id string, secret domain.Secret, ttl time.Duration,
+				) error {
+					capturedTTL = ttl
+					return nil
+				},
+			}
+			req := httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(tc.body))
+			rr := httptest.NewRecorder()
+			before := time.Now()
+			NewHandler(mockRepo, DefaultHandlerConfig()).HandleCreate(rr, req)
+			after := time.Now()
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("wrong status code: got %v want %v: %s", rr.Code, tc.wantStatus, rr.Body)
+			}
+			if rr.Code != http.StatusCreated {
+				return
+			}
+			if tc.wantTTL != 0 && capturedTTL != tc.wantTTL {
+				t.Errorf("expected TTL %v, got %v", tc.wantTTL, capturedTTL)
+			}
+
+			// The reported expiry is the stored TTL counted from the moment
+			// the secret was stored.
+			var res domain.CreateRes
+			if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+				t.Fatalf("could not decode response: %v", err)
+			}
+			if res.ExpiresAt.Before(before.Add(capturedTTL)) || res.ExpiresAt.After(after.Add(capturedTTL)) {
+				t.Errorf("expires_at %v doesn't match the stored TTL %v", res.ExpiresAt, capturedTTL)
+			}
+			if tc.wantTTL == 0 {
+				want, _ := time.Parse(time.RFC3339Nano, inOneHour)
+				if d := res.ExpiresAt.Sub(want); d < -time.Millisecond || d > 0 {
+					t.Errorf("expected expires_at %v, got %v", want, res.ExpiresAt)
+				}
+			}
+		})
+	}
+
+	t.Run("config reports the range", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		NewHandler(nil, DefaultHandlerConfig()).HandleConfig(
+			rr, httptest.NewRequest(http.MethodGet, "/config", nil))
+		var res domain.ConfigRes
+		if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if res.MinExpiry != "5m" || res.MaxExpiry != "7d" {
+			t.Errorf("expected a 5m to 7d range, got %q to %q", res.MinExpiry, res.MaxExpiry)
+		}
+	})
+}
+
+func TestHandler_CustomLimits(t *testing.T) {
+	utility.LowerCryptoParamsForTest(t)
+
+	cfg := HandlerConfig{
+		ExpiryOptions:   []string{"15m", "1h", "7d"},
+		DefaultExpiry:   "1h",
+		MaxSecretSize:   16,
+		MaxReadAttempts: 5,
+	}
+
+	t.Run("config reports the limits", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		NewHandler(nil, cfg).HandleConfig(rr, httptest.NewRequest(http.MethodGet, "/config", nil))
+		var res domain.ConfigRes
+		if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if res.MaxSecretSize != 16 || res.MaxReadAttempts != 5 || res.DefaultExpiry != "1h" ||
+			!slices.Equal(res.ExpiryOptions, cfg.ExpiryOptions) {
+			t.Errorf("config doesn't match the limits: %+v", res)
+		}
+	})
+
+	createCases := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantTTL    time.Duration
+	}{
+		{"default expiry", `{"secret":"s"}`, http.StatusCreated, time.Hour},
+		{"short expiry", `{"secret":"s","expiry":"15m"}`, http.StatusCreated, 15 * time.Minute},
+		{"long expiry", `{"secret":"s","expiry":"7d"}`, http.StatusCreated, 7 * 24 * time.Hour},
+		{"expiry not offered", `{"secret":"s","expiry":"1d"}`, http.StatusBadRequest, 0},
+		{"secret too large", `{"secret":"` + strings.Repeat("a", 17) + `"}`,
+			http.StatusRequestEntityTooLarge, 0},
+	}
+	for _, tc := range createCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var capturedTTL time.Duration
+			mockRepo := &mockSecretRepository{
+				StoreSecretFunc: func(
+					ctx context.Context, id string, secret domain.Secret, ttl time.Duration,
+				) error {
+					capturedTTL = ttl
+					return nil
+				},
+			}
+			req := httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(tc.body))
+			rr := httptest.NewRecorder()
+			NewHandler(mockRepo, cfg).HandleCreate(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("wrong status code: got %v want %v: %s", rr.Code, tc.wantStatus, rr.Body)
+			}
+			if capturedTTL != tc.wantTTL {
+				t.Errorf("expected TTL %v, got %v", tc.wantTTL, capturedTTL)
+			}
+		})
+	}
+
+	t.Run("read attempts", func(t *testing.T) {
+		blob, _ := utility.Encrypt(
+			context.Background(), []byte("s"), "abacus-abdomen-abdominal", "", "test-id")
+		var gotMax int
+		mockRepo := &mockSecretRepository{
+			GetSecretFunc: func(ctx context.Context, id string) ([]byte, error) {
+				return blob, nil
+			},
+			IncrFailAndMaybeDeleteFunc: func(
+				ctx context.Context, id string, maxAttempts int,
+			) (int64, error) {
+				gotMax = maxAttempts
+				return 1, nil
+			},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/read/test-id", nil)
+		req.Header.Set("X-Passcode", "wrong-pass")
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "test-id")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		NewHandler(mockRepo, cfg).HandleRead(rr, req)
+
+		var res domain.Problem
+		if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if gotMax != 5 {
+			t.Errorf("expected the repository to delete after 5 attempts, got %d", gotMax)
+		}
+		if res.RemainingAttempts == nil || *res.RemainingAttempts != 4 {
+			t.Errorf("expected 4 remaining attempts, got %v", res.RemainingAttempts)
+		}
+	})
+}
+
 func TestHandler_HandleCreate_HTTPSDetection(t *testing.T) {
 	utility.LowerCryptoParamsForTest(t)
 
 	mockRepo := &mockSecretRepository{
 		StoreSecretFunc: func(
-			ctx context.Context, id string, secret []byte, ttl time.Duration,
+			ctx context.Context, id string, secret domain.Secret, ttl time.Duration,
 		) error {
 			return nil
 		},
 	}
-	handler := NewHandler(mockRepo, "")
+	handler := NewHandler(mockRepo, DefaultHandlerConfig())
 
 	t.Run("detects HTTPS from X-Forwarded-Proto header", func(t *testing.T) {
 		reqBody := `{"secret":"test"}`
@@ -592,7 +892,7 @@ func TestHandler_HandleCreate_WhitespaceSecret(t *testing.T) {
 	utility.LowerCryptoParamsForTest(t)
 
 	mockRepo := &mockSecretRepository{}
-	handler := NewHandler(mockRepo, "")
+	handler := NewHandler(mockRepo, DefaultHandlerConfig())
 
 	testCases := []struct {
 		name   string
@@ -620,3 +920,1272 @@ func TestHandler_HandleCreate_WhitespaceSecret(t *testing.T) {
 		})
 	}
 }
+
+func TestHandler_ZeroKnowledge(t *testing.T) {
+	key, _ := utility.GenerateClientKey()
+	blob, err := utility.EncryptWithKey([]byte("my-secret"), key)
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+
+	t.Run("create stores the ciphertext as-is", func(t *testing.T) {
+		var stored []byte
+		mockRepo := &mockSecretRepository{
+			StoreSecretFunc: func(
+				ctx context.Context, id string, secret domain.Secret, ttl time.Duration,
+			) error {
+				stored = secret.Blob
+				return nil
+			},
+		}
+		handler := NewHandler(mockRepo, DefaultHandlerConfig())
+
+		reqBody := `{"ciphertext":"` + string(blob) + `","expiry":"1h"}`
+		req := httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(reqBody))
+		rr := httptest.NewRecorder()
+		handler.HandleCreate(rr, req)
+
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+		}
+		var res domain.CreateRes
+		if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if res.Passcode != "" {
+			t.Errorf("expected no passcode, got %q", res.Passcode)
+		}
+		if string(stored) != string(blob) {
+			t.Errorf("expected ciphertext to be stored unchanged, got %s", stored)
+		}
+	})
+
+	t.Run("create rejects secret and ciphertext together", func(t *testing.T) {
+		handler := NewHandler(&mockSecretRepository{}, DefaultHandlerConfig())
+		reqBody := `{"secret":"x","ciphertext":"` + string(blob) + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(reqBody))
+		rr := httptest.NewRecorder()
+		handler.HandleCreate(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("wrong status: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("create rejects malformed ciphertext", func(t *testing.T) {
+		handler := NewHandler(&mockSecretRepository{}, DefaultHandlerConfig())
+		reqBody := `{"ciphertext":"v1:not-client-side"}`
+		req := httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(reqBody))
+		rr := httptest.NewRecorder()
+		handler.HandleCreate(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("wrong status: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	newReadRequest := func(passcode string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/read/test-id", nil)
+		if passcode != "" {
+			req.Header.Set("X-Passcode", passcode)
+		}
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "test-id")
+		return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	}
+
+	t.Run("read returns the ciphertext and deletes it", func(t *testing.T) {
+		deleted := false
+		mockRepo := &mockSecretRepository{
+			GetSecretFunc: func(ctx context.Context, id string) ([]byte, error) {
+				return blob, nil
+			},
+			DecrViewAndMaybeDeleteFunc: func(
+				ctx context.Context, id string, old []byte,
+			) (int64, error) {
+				deleted = true
+				return 0, nil
+			},
+		}
+		handler := NewHandler(mockRepo, DefaultHandlerConfig())
+		rr := httptest.NewRecorder()
+		handler.HandleRead(rr, newReadRequest(""))
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		var res domain.ReadRes
+		if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if res.Ciphertext != string(blob) || res.Secret != "" {
+			t.Errorf("expected only the ciphertext, got %+v", res)
+		}
+		if !deleted {
+			t.Error("expected secret to be deleted after read")
+		}
+	})
+
+	t.Run("read with a passcode does not burn the secret", func(t *testing.T) {
+		mockRepo := &mockSecretRepository{
+			GetSecretFunc: func(ctx context.Context, id string) ([]byte, error) {
+				return blob, nil
+			},
+			DecrViewAndMaybeDeleteFunc: func(
+				ctx context.Context, id string, old []byte,
+			) (int64, error) {
+				t.Error("secret must not be deleted")
+				return 0, nil
+			},
+		}
+		handler := NewHandler(mockRepo, DefaultHandlerConfig())
+		rr := httptest.NewRecorder()
+		handler.HandleRead(rr, newReadRequest("some-passcode"))
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("wrong status: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestHandler_MaxViews(t *testing.T) {
+	utility.LowerCryptoParamsForTest(t)
+
+	t.Run("create stores the requested view count", func(t *testing.T) {
+		testCases := []struct {
+			body      string
+			wantViews int
+		}{
+			{`{"secret":"s"}`, 1},
+			{`{"secret":"s","max_views":3}`, 3},
+			{`{"secret":"s","max_views":10}`, 10},
+		}
+		for _, tc := range testCases {
+			var capturedViews int
+			mockRepo := &mockSecretRepository{
+				StoreSecretFunc: func(
+					ctx context.Context, id string, secret domain.Secret,
+					ttl time.Duration,
+				) error {
+					capturedViews = secret.MaxViews
+					return nil
+				},
+			}
+			handler := NewHandler(mockRepo, DefaultHandlerConfig())
+			req := httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(tc.body))
+			rr := httptest.NewRecorder()
+			handler.HandleCreate(rr, req)
+
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("%s: wrong status code: got %v want %v",
+					tc.body, rr.Code, http.StatusCreated)
+			}
+			if capturedViews != tc.wantViews {
+				t.Errorf("%s: expected %d views, got %d", tc.body, tc.wantViews, capturedViews)
+			}
+		}
+	})
+
+	t.Run("create rejects out of range view counts", func(t *testing.T) {
+		for _, views := range []string{"-1", "11"} {
+			handler := NewHandler(&mockSecretRepository{}, DefaultHandlerConfig())
+			reqBody := `{"secret":"s","max_views":` + views + `}`
+			req := httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(reqBody))
+			rr := httptest.NewRecorder()
+			handler.HandleCreate(rr, req)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("max_views=%s: wrong status: got %v want %v",
+					views, rr.Code, http.StatusBadRequest)
+			}
+		}
+	})
+
+	passcode := "abacus-abdomen-abdominal"
+	blob, _ := utility.Encrypt(context.Background(), []byte("my-secret"), passcode, "", "test-id")
+	newReadRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/read/test-id", nil)
+		req.Header.Set("X-Passcode", passcode)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "test-id")
+		return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	}
+
+	t.Run("read reports remaining views", func(t *testing.T) {
+		mockRepo := &mockSecretRepository{
+			GetSecretFunc: func(ctx context.Context, id string) ([]byte, error) {
+				return blob, nil
+			},
+			DecrViewAndMaybeDeleteFunc: func(
+				ctx context.Context, id string, old []byte,
+			) (int64, error) {
+				return 2, nil
+			},
+		}
+		handler := NewHandler(mockRepo, DefaultHandlerConfig())
+		rr := httptest.NewRecorder()
+		handler.HandleRead(rr, newReadRequest())
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		var res domain.ReadRes
+		if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if res.RemainingViews == nil || *res.RemainingViews != 2 {
+			t.Errorf("expected 2 remaining views, got %v", res.RemainingViews)
+		}
+	})
+
+	t.Run("read doesn't reveal a secret whose view wasn't consumed", func(t *testing.T) {
+		mockRepo := &mockSecretRepository{
+			GetSecretFunc: func(ctx context.Context, id string) ([]byte, error) {
+				return blob, nil
+			},
+			DecrViewAndMaybeDeleteFunc: func(
+				ctx context.Context, id string, old []byte,
+			) (int64, error) {
+				return 0, errors.New("connection reset")
+			},
+		}
+		handler := NewHandler(mockRepo, DefaultHandlerConfig())
+		rr := httptest.NewRecorder()
+		handler.HandleRead(rr, newReadRequest())
+
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("wrong status: got %v want %v", rr.Code, http.StatusInternalServerError)
+		}
+		if strings.Contains(rr.Body.String(), "my-secret") {
+			t.Error("plaintext must not be returned when the view was not consumed")
+		}
+	})
+
+	t.Run("read loses the race for the last view", func(t *testing.T) {
+		mockRepo := &mockSecretRepository{
+			GetSecretFunc: func(ctx context.Context, id string) ([]byte, error) {
+				return blob, nil
+			},
+			DecrViewAndMaybeDeleteFunc: func(
+				ctx context.Context, id string, old []byte,
+			) (int64, error) {
+				return 0, domain.ErrNotFound
+			},
+		}
+		handler := NewHandler(mockRepo, DefaultHandlerConfig())
+		rr := httptest.NewRecorder()
+		handler.HandleRead(rr, newReadRequest())
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("wrong status: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+		if strings.Contains(rr.Body.String(), "my-secret") {
+			t.Error("plaintext must not be returned when the view was not consumed")
+		}
+	})
+}
+
+func TestHandler_HandleRevoke(t *testing.T) {
+	newRevokeRequest := func(token string) *http.Request {
+		req := httptest.NewRequest(http.MethodDelete, "/secret/test-id", nil)
+		if token != "" {
+			req.Header.Set("X-Delete-Token", token)
+		}
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "test-id")
+		return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	}
+
+	t.Run("create returns a token whose hash is stored", func(t *testing.T) {
+		utility.LowerCryptoParamsForTest(t)
+
+		var stored domain.Secret
+		mockRepo := &mockSecretRepository{
+			StoreSecretFunc: func(
+				ctx context.Context, id string, secret domain.Secret, ttl time.Duration,
+			) error {
+				stored = secret
+				return nil
+			},
+		}
+		handler := NewHandler(mockRepo, DefaultHandlerConfig())
+		req := httptest.NewRequest(http.MethodPost, "/create",
+			strings.NewReader(`{"secret":"s"}`))
+		rr := httptest.NewRecorder()
+		handler.HandleCreate(rr, req)
+
+		var res domain.CreateRes
+		if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if res.DeleteToken == "" {
+			t.Fatal("expected non-empty delete_token in response")
+		}
+		if string(stored.TokenHash) != string(utility.HashToken(res.DeleteToken)) {
+			t.Error("expected the token hash to be stored, not the token")
+		}
+	})
+
+	testCases := []struct {
+		name       string
+		token      string
+		repoErr    error
+		wantStatus int
+	}{
+		{"revoked", "token", nil, http.StatusNoContent},
+		{"missing token", "", nil, http.StatusBadRequest},
+		{"invalid token", "token", domain.ErrInvalidToken, http.StatusForbidden},
+		{"not found", "token", domain.ErrNotFound, http.StatusNotFound},
+		{"repository error", "token", errors.New("redis down"), http.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotHash []byte
+			mockRepo := &mockSecretRepository{
+				DeleteSecretFunc: func(ctx context.Context, id string, tokenHash []byte) error {
+					gotHash = tokenHash
+					return tc.repoErr
+				},
+			}
+			handler := NewHandler(mockRepo, DefaultHandlerConfig())
+			rr := httptest.NewRecorder()
+			handler.HandleRevoke(rr, newRevokeRequest(tc.token))
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("wrong status: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if tc.token != "" && string(gotHash) != string(utility.HashToken(tc.token)) {
+				t.Error("expected the repository to receive the token hash")
+			}
+		})
+	}
+}
+
+func TestHandler_HandleStatus(t *testing.T) {
+	newStatusRequest := func(token string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/secret/test-id/status", nil)
+		if token != "" {
+			req.Header.Set("X-Delete-Token", token)
+		}
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "test-id")
+		return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	}
+
+	t.Run("reports a live secret", func(t *testing.T) {
+		mockRepo := &mockSecretRepository{
+			GetStatusFunc: func(
+				ctx context.Context, id string, tokenHash []byte,
+			) (domain.SecretStatus, error) {
+				return domain.SecretStatus{
+					TTL: time.Hour, RemainingViews: 2, FailedAttempts: 1,
+				}, nil
+			},
+		}
+		handler := NewHandler(mockRepo, DefaultHandlerConfig())
+		rr := httptest.NewRecorder()
+		handler.HandleStatus(rr, newStatusRequest("token"))
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		if strings.Contains(rr.Body.String(), "secret\"") ||
+			strings.Contains(rr.Body.String(), "ciphertext") {
+			t.Errorf("status must not reveal the secret, got %s", rr.Body.String())
+		}
+		var res domain.StatusRes
+		if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if !res.Exists {
+			t.Error("expected exists to be true")
+		}
+		if res.ExpiresIn == nil || *res.ExpiresIn != 3600 {
+			t.Errorf("expected expires_in of 3600, got %v", res.ExpiresIn)
+		}
+		if res.RemainingViews == nil || *res.RemainingViews != 2 {
+			t.Errorf("expected 2 remaining views, got %v", res.RemainingViews)
+		}
+		if res.FailedAttempts == nil || *res.FailedAttempts != 1 {
+			t.Errorf("expected 1 failed attempt, got %v", res.FailedAttempts)
+		}
+	})
+
+	testCases := []struct {
+		name       string
+		token      string
+		repoErr    error
+		wantStatus int
+		wantBody   string
+	}{
+		{"gone", "token", domain.ErrNotFound, http.StatusOK, `{"exists":false}`},
+		{"missing token", "", nil, http.StatusBadRequest, ""},
+		{"invalid token", "token", domain.ErrInvalidToken, http.StatusForbidden, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &mockSecretRepository{
+				GetStatusFunc: func(
+					ctx context.Context, id string, tokenHash []byte,
+				) (domain.SecretStatus, error) {
+					return domain.SecretStatus{}, tc.repoErr
+				},
+			}
+			handler := NewHandler(mockRepo, DefaultHandlerConfig())
+			rr := httptest.NewRecorder()
+			handler.HandleStatus(rr, newStatusRequest(tc.token))
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("wrong status: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if tc.wantBody != "" && strings.TrimSpace(rr.Body.String()) != tc.wantBody {
+				t.Errorf("wrong body: got %s want %s", rr.Body.String(), tc.wantBody)
+			}
+		})
+	}
+}
+
+type recordingNotifier struct {
+	events []WebhookPayload
+}
+
+func (n *recordingNotifier) ValidateURL(raw string) error {
+	return NewWebhookNotifier(nil, DefaultWebhookConfig()).ValidateURL(raw)
+}
+
+func (n *recordingNotifier) Notify(hook *domain.Webhook, payload WebhookPayload) {
+	n.events = append(n.events, payload)
+}
+
+func TestHandler_Webhooks(t *testing.T) {
+	utility.LowerCryptoParamsForTest(t)
+
+	t.Run("create refuses notify_url when webhooks are disabled", func(t *testing.T) {
+		handler := NewHandler(&mockSecretRepository{}, DefaultHandlerConfig())
+		reqBody := `{"secret":"s","notify_url":"https://hooks.example.com"}`
+		req := httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(reqBody))
+		rr := httptest.NewRecorder()
+		handler.HandleCreate(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("wrong status: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("create refuses an invalid notify_url", func(t *testing.T) {
+		handler := NewHandler(&mockSecretRepository{}, DefaultHandlerConfig())
+		handler.SetNotifier(&recordingNotifier{})
+		reqBody := `{"secret":"s","notify_url":"http://169.254.169.254/"}`
+		req := httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(reqBody))
+		rr := httptest.NewRecorder()
+		handler.HandleCreate(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("wrong status: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("create stores the webhook and returns its signing key", func(t *testing.T) {
+		var captured *domain.Webhook
+		mockRepo := &mockSecretRepository{
+			StoreSecretFunc: func(
+				ctx context.Context, id string, secret domain.Secret, ttl time.Duration,
+			) error {
+				captured = secret.Webhook
+				return nil
+			},
+		}
+		handler := NewHandler(mockRepo, DefaultHandlerConfig())
+		handler.SetNotifier(&recordingNotifier{})
+		reqBody := `{"secret":"s","notify_url":"https://hooks.example.com/secretapi"}`
+		req := httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(reqBody))
+		rr := httptest.NewRecorder()
+		handler.HandleCreate(rr, req)
+
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+		}
+		var res domain.CreateRes
+		if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if res.NotifySecret == "" {
+			t.Fatal("expected a notify_secret in the response")
+		}
+		if captured == nil || captured.URL != "https://hooks.example.com/secretapi" {
+			t.Fatalf("expected the webhook to be stored, got %+v", captured)
+		}
+		if string(captured.Key) != res.NotifySecret {
+			t.Error("expected the stored signing key to match notify_secret")
+		}
+	})
+
+	passcode := "abacus-abdomen-abdominal"
+	blob, _ := utility.Encrypt(context.Background(), []byte("my-secret"), passcode, "", "test-id")
+	newReadRequest := func(passcode string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/read/test-id", nil)
+		req.Header.Set("X-Passcode", passcode)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "test-id")
+		return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	}
+	hook := &domain.Webhook{URL: "https://hooks.example.com", Key: []byte("key")}
+
+	readCases := []struct {
+		name      string
+		remaining int64
+		wantTaken bool
+	}{
+		{"read keeps the webhook while views remain", 1, false},
+		{"last read takes the webhook", 0, true},
+	}
+	for _, tc := range readCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var taken bool
+			mockRepo := &mockSecretRepository{
+				GetSecretFunc: func(ctx context.Context, id string) ([]byte, error) {
+					return blob, nil
+				},
+				DecrViewAndMaybeDeleteFunc: func(
+					ctx context.Context, id string, old []byte,
+				) (int64, error) {
+					return tc.remaining, nil
+				},
+				GetWebhookFunc: func(ctx context.Context, id string) (*domain.Webhook, error) {
+					return hook, nil
+				},
+				TakeWebhookFunc: func(ctx context.Context, id string) (*domain.Webhook, error) {
+					taken = true
+					return hook, nil
+				},
+			}
+			notifier := &recordingNotifier{}
+			handler := NewHandler(mockRepo, DefaultHandlerConfig())
+			handler.SetNotifier(notifier)
+			rr := httptest.NewRecorder()
+			handler.HandleRead(rr, newReadRequest(passcode))
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusOK)
+			}
+			if taken != tc.wantTaken {
+				t.Errorf("expected webhook taken to be %t", tc.wantTaken)
+			}
+			if len(notifier.events) != 1 || notifier.events[0].Event != EventRead {
+				t.Fatalf("expected one read event, got %+v", notifier.events)
+			}
+			views := notifier.events[0].RemainingViews
+			if views == nil || int64(*views) != tc.remaining {
+				t.Errorf("expected %d remaining views in the event, got %v", tc.remaining, views)
+			}
+		})
+	}
+
+	t.Run("burning the secret sends a burned event", func(t *testing.T) {
+		attempts := int64(0)
+		mockRepo := &mockSecretRepository{
+			GetSecretFunc: func(ctx context.Context, id string) ([]byte, error) {
+				return blob, nil
+			},
+			IncrFailAndMaybeDeleteFunc: func(
+				ctx context.Context, id string, maxAttempts int,
+			) (int64, error) {
+				attempts++
+				return attempts, nil
+			},
+			TakeWebhookFunc: func(ctx context.Context, id string) (*domain.Webhook, error) {
+				return hook, nil
+			},
+		}
+		notifier := &recordingNotifier{}
+		handler := NewHandler(mockRepo, DefaultHandlerConfig())
+		handler.SetNotifier(notifier)
+
+		for i := 0; i < domain.MaxReadAttempts; i++ {
+			rr := httptest.NewRecorder()
+			handler.HandleRead(rr, newReadRequest("wrong-wrong-wrong"))
+			if rr.Code != http.StatusUnauthorized {
+				t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+			}
+			if i < domain.MaxReadAttempts-1 && len(notifier.events) != 0 {
+				t.Fatalf("expected no event before the secret is burned, got %+v",
+					notifier.events)
+			}
+		}
+
+		if len(notifier.events) != 1 || notifier.events[0].Event != EventBurned {
+			t.Errorf("expected one burned event, got %+v", notifier.events)
+		}
+	})
+}
+
+func TestHandler_Passphrase(t *testing.T) {
+	utility.LowerCryptoParamsForTest(t)
+
+	repo := domain.NewMemoryRepository()
+	t.Cleanup(func() { _ = repo.Close() })
+	handler := NewHandler(repo, DefaultHandlerConfig())
+
+	req := httptest.NewRequest(http.MethodPost, "/create",
+		strings.NewReader(`{"secret":"my-secret","max_views":2,"passphrase":"over the phone"}`))
+	rr := httptest.NewRecorder()
+	handler.HandleCreate(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	var created domain.CreateRes
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatalf("could not decode create response: %v", err)
+	}
+
+	read := func(passphrase string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/read/"+created.ID, nil)
+		req.Header.Set("X-Passcode", created.Passcode)
+		if passphrase != "" {
+			req.Header.Set("X-Passphrase", passphrase)
+		}
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", created.ID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		handler.HandleRead(rr, req)
+		return rr
+	}
+
+	t.Run("missing passphrase doesn't count as an attempt", func(t *testing.T) {
+		for range domain.MaxReadAttempts {
+			if rr := read(""); rr.Code != http.StatusBadRequest {
+				t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+			}
+		}
+	})
+
+	t.Run("wrong passphrase is a failed attempt", func(t *testing.T) {
+		rr := read("over the fence")
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("passcode and passphrase reveal the secret", func(t *testing.T) {
+		rr := read("over the phone")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		var res domain.ReadRes
+		if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if res.Secret != "my-secret" {
+			t.Errorf("wrong secret: got %q", res.Secret)
+		}
+	})
+
+	t.Run("rejected with a client-side encrypted secret", func(t *testing.T) {
+		key, _ := utility.GenerateClientKey()
+		blob, _ := utility.EncryptWithKey([]byte("s"), key)
+		body, _ := json.Marshal(domain.CreateReq{Ciphertext: string(blob), Passphrase: "p"})
+		req := httptest.NewRequest(http.MethodPost, "/create", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		handler.HandleCreate(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("rejected when too long", func(t *testing.T) {
+		body, _ := json.Marshal(domain.CreateReq{
+			Secret:     "s",
+			Passphrase: strings.Repeat("a", domain.MaxPassphraseSize+1),
+		})
+		req := httptest.NewRequest(http.MethodPost, "/create", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		handler.HandleCreate(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestHandler_Fields(t *testing.T) {
+	utility.LowerCryptoParamsForTest(t)
+
+	repo := domain.NewMemoryRepository()
+	t.Cleanup(func() { _ = repo.Close() })
+	handler := NewHandler(repo, DefaultHandlerConfig())
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		handler.HandleCreate(rr, req)
+		return rr
+	}
+	rr := create(`{"fields":{"username":"admin","password":"it's secret","api key":"k"},` +
+		`"max_views":5}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	var created domain.CreateRes
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatalf("could not decode create response: %v", err)
+	}
+
+	read := func(format string) *httptest.ResponseRecorder {
+		target := &url.URL{Path: "/read/" + created.ID}
+		if format != "" {
+			target.RawQuery = "format=" + format
+		}
+		req := httptest.NewRequest(http.MethodPost, target.String(), nil)
+		req.Header.Set("X-Passcode", created.Passcode)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", created.ID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		handler.HandleRead(rr, req)
+		return rr
+	}
+
+	t.Run("unknown format doesn't cost a view", func(t *testing.T) {
+		if rr := read("yaml"); rr.Code != http.StatusBadRequest {
+			t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+		status, _ := repo.GetStatus(context.Background(), created.ID,
+			utility.HashToken(created.DeleteToken))
+		if status.RemainingViews != 5 {
+			t.Errorf("expected 5 remaining views, got %d", status.RemainingViews)
+		}
+	})
+
+	t.Run("json response keeps the order", func(t *testing.T) {
+		rr := read("")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		want := `{"fields":{"username":"admin","password":"it's secret","api key":"k"},` +
+			`"remaining_views":4}` + "\n"
+		if rr.Body.String() != want {
+			t.Errorf("wrong body: got %s want %s", rr.Body.String(), want)
+		}
+	})
+
+	for _, tc := range []struct {
+		format, contentType, body string
+		remaining                 string
+	}{
+		{"plain", "text/plain", "username: admin\npassword: it's secret\napi key: k\n", "3"},
+		{"env", "text/plain", "USERNAME='admin'\nPASSWORD='it'\\''s secret'\nAPI_KEY='k'\n", "2"},
+		{"json", "application/json",
+			`{"username":"admin","password":"it's secret","api key":"k"}` + "\n", "1"},
+	} {
+		t.Run(tc.format, func(t *testing.T) {
+			rr := read(tc.format)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusOK)
+			}
+			if got := rr.Header().Get("Content-Type"); got != tc.contentType {
+				t.Errorf("wrong content type: got %v want %v", got, tc.contentType)
+			}
+			if got := rr.Header().Get("X-Remaining-Views"); got != tc.remaining {
+				t.Errorf("wrong remaining views: got %v want %v", got, tc.remaining)
+			}
+			if rr.Body.String() != tc.body {
+				t.Errorf("wrong body: got %q want %q", rr.Body.String(), tc.body)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		tests := map[string]string{
+			"with a secret":   `{"secret":"s","fields":{"a":"b"}}`,
+			"empty":           `{"fields":{}}`,
+			"not strings":     `{"fields":{"port":5432}}`,
+			"not an object":   `{"fields":["a","b"]}`,
+			"empty name":      `{"fields":{" ":"b"}}`,
+			"duplicate names": `{"fields":{"a":"b","a":"c"}}`,
+			"name too long":   `{"fields":{"` + strings.Repeat("a", domain.MaxFieldNameSize+1) + `":"b"}}`,
+		}
+		for name, body := range tests {
+			if rr := create(body); rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: wrong status code: got %v want %v", name, rr.Code, http.StatusBadRequest)
+			}
+		}
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		fields := domain.Fields{{Name: "key", Value: strings.Repeat("a", domain.MaxSecretSize)}}
+		body, _ := json.Marshal(domain.CreateReq{Fields: fields})
+		if rr := create(string(body)); rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("wrong status code: got %v want %v", rr.Code, http.StatusRequestEntityTooLarge)
+		}
+	})
+}
+
+func TestHandler_HandleRead_EnvFormat(t *testing.T) {
+	utility.LowerCryptoParamsForTest(t)
+
+	blob, _ := utility.Encrypt(
+		context.Background(), []byte("p@ss'word"), "test-passcode", "", "test-id")
+	mockRepo := &mockSecretRepository{
+		GetSecretFunc: func(ctx context.Context, id string) ([]byte, error) {
+			return blob, nil
+		},
+		DecrViewAndMaybeDeleteFunc: func(ctx context.Context, id string, old []byte) (int64, error) {
+			return 0, nil
+		},
+	}
+	handler := NewHandler(mockRepo, DefaultHandlerConfig())
+
+	req := httptest.NewRequest(http.MethodPost, "/read/test-id?format=env", nil)
+	req.Header.Set("X-Passcode", "test-passcode")
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "test-id")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+	handler.HandleRead(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if want := "SECRET='p@ss'\\''word'\n"; rr.Body.String() != want {
+		t.Errorf("wrong body: got %q want %q", rr.Body.String(), want)
+	}
+}
+
+func TestHandler_SecretRequests(t *testing.T) {
+	utility.LowerCryptoParamsForTest(t)
+
+	repo := domain.NewMemoryRepository()
+	t.Cleanup(func() { _ = repo.Close() })
+	handler := NewHandler(repo, DefaultHandlerConfig())
+
+	withID := func(req *http.Request, id string) *http.Request {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	}
+	newRequest := func(t *testing.T) domain.RequestRes {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/request", strings.NewReader(`{"expiry":"1h"}`))
+		rr := httptest.NewRecorder()
+		handler.HandleCreateRequest(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+		}
+		var res domain.RequestRes
+		if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		return res
+	}
+	fulfill := func(id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/request/"+id, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		handler.HandleFulfillRequest(rr, withID(req, id))
+		return rr
+	}
+	read := func(id, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/read/"+id, nil)
+		req.Header.Set("X-Passcode", token)
+		rr := httptest.NewRecorder()
+		handler.HandleRead(rr, withID(req, id))
+		return rr
+	}
+
+	t.Run("send and read", func(t *testing.T) {
+		created := newRequest(t)
+		if created.Token == "" || !strings.HasSuffix(created.UploadURL, "/request/"+created.ID) ||
+			!strings.HasSuffix(created.ReadURL, "/read/"+created.ID) {
+			t.Fatalf("unexpected response %+v", created)
+		}
+		if until := time.Until(created.ExpiresAt); until <= 59*time.Minute || until > time.Hour {
+			t.Errorf("expected the request to expire in an hour, got %v", until)
+		}
+
+		if rr := read(created.ID, created.Token); rr.Code != http.StatusConflict {
+			t.Errorf("reading before the secret is sent: got %v want %v",
+				rr.Code, http.StatusConflict)
+		}
+		if rr := fulfill(created.ID, `{"secret":"sk_live_123"}`); rr.Code != http.StatusNoContent {
+			t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+		}
+		if rr := fulfill(created.ID, `{"secret":"overwrite"}`); rr.Code != http.StatusNotFound {
+			t.Errorf("the upload link must die after one use: got %v want %v",
+				rr.Code, http.StatusNotFound)
+		}
+
+		blob, _ := repo.GetSecret(context.Background(), created.ID)
+		if strings.Contains(string(blob), "sk_live_123") {
+			t.Error("the secret must be stored encrypted")
+		}
+
+		otherToken, _, _ := utility.NewSecretRequest()
+		if rr := read(created.ID, otherToken); rr.Code != http.StatusUnauthorized {
+			t.Errorf("reading with another token: got %v want %v",
+				rr.Code, http.StatusUnauthorized)
+		}
+		rr := read(created.ID, created.Token)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		var res domain.ReadRes
+		if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if res.Secret != "sk_live_123" {
+			t.Errorf("wrong secret: got %q", res.Secret)
+		}
+		if rr := read(created.ID, created.Token); rr.Code != http.StatusNotFound {
+			t.Errorf("expected the secret to be deleted once read, got %v", rr.Code)
+		}
+	})
+
+	t.Run("fields", func(t *testing.T) {
+		created := newRequest(t)
+		if rr := fulfill(created.ID, `{"fields":{"username":"vendor","api key":"k"}}`); rr.Code != http.StatusNoContent {
+			t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+		}
+		rr := read(created.ID, created.Token)
+		want := `{"fields":{"username":"vendor","api key":"k"},"remaining_views":0}` + "\n"
+		if rr.Body.String() != want {
+			t.Errorf("wrong body: got %s want %s", rr.Body.String(), want)
+		}
+	})
+
+	t.Run("delete token revokes the request", func(t *testing.T) {
+		created := newRequest(t)
+		if created.DeleteToken == "" || created.DeleteToken == created.Token {
+			t.Fatalf("expected a delete token apart from the token, got %+v", created)
+		}
+		revoke := func(token string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodDelete, "/secret/"+created.ID, nil)
+			req.Header.Set("X-Delete-Token", token)
+			rr := httptest.NewRecorder()
+			handler.HandleRevoke(rr, withID(req, created.ID))
+			return rr
+		}
+		if rr := revoke(created.Token); rr.Code != http.StatusForbidden {
+			t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+		}
+		if rr := revoke(created.DeleteToken); rr.Code != http.StatusNoContent {
+			t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+		}
+		if rr := fulfill(created.ID, `{"secret":"s"}`); rr.Code != http.StatusNotFound {
+			t.Errorf("wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("an ordinary secret can't be overwritten", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(`{"secret":"s"}`))
+		rr := httptest.NewRecorder()
+		handler.HandleCreate(rr, req)
+		var created domain.CreateRes
+		_ = json.NewDecoder(rr.Body).Decode(&created)
+		if rr := fulfill(created.ID, `{"secret":"overwrite"}`); rr.Code != http.StatusNotFound {
+			t.Errorf("wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		created := newRequest(t)
+		for _, body := range []string{`{}`, `{"secret":"  "}`, `{"secret":"s","fields":{"a":"b"}}`, `nope`} {
+			if rr := fulfill(created.ID, body); rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: wrong status code: got %v want %v", body, rr.Code, http.StatusBadRequest)
+			}
+		}
+		req := httptest.NewRequest(http.MethodPost, "/request", strings.NewReader(`{"expiry":"1y"}`))
+		rr := httptest.NewRecorder()
+		handler.HandleCreateRequest(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("body is optional", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/request", nil)
+		rr := httptest.NewRecorder()
+		handler.HandleCreateRequest(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Errorf("wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+		}
+	})
+}
+
+func TestHandler_ConcurrentReads(t *testing.T) {
+	utility.LowerCryptoParamsForTest(t)
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	handler := NewHandler(domain.NewRedisRepository(rdb), DefaultHandlerConfig())
+
+	req := httptest.NewRequest(http.MethodPost, "/create",
+		strings.NewReader(`{"secret":"my-secret"}`))
+	rr := httptest.NewRecorder()
+	handler.HandleCreate(rr, req)
+	var created domain.CreateRes
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatalf("could not decode create response: %v", err)
+	}
+
+	const readers = 20
+	var (
+		wg       sync.WaitGroup
+		start    = make(chan struct{})
+		revealed atomic.Int32
+	)
+	for range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/read/"+created.ID, nil)
+			req.Header.Set("X-Passcode", created.Passcode)
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", created.ID)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+			<-start
+			rr := httptest.NewRecorder()
+			handler.HandleRead(rr, req)
+			if strings.Contains(rr.Body.String(), "my-secret") {
+				revealed.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if got := revealed.Load(); got != 1 {
+		t.Errorf("expected exactly one of %d readers to get the secret, got %d", readers, got)
+	}
+}
+
+func TestHandler_Metrics(t *testing.T) {
+	utility.LowerCryptoParamsForTest(t)
+
+	repo := domain.NewMemoryRepository()
+	t.Cleanup(func() { _ = repo.Close() })
+	handler := NewHandler(repo, DefaultHandlerConfig())
+
+	create := func(body string) domain.CreateRes {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handler.HandleCreate(rr, httptest.NewRequest(http.MethodPost, "/create",
+			strings.NewReader(body)))
+		var created domain.CreateRes
+		if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+			t.Fatalf("could not decode create response: %v", err)
+		}
+		return created
+	}
+	read := func(id, passcode string) int {
+		req := httptest.NewRequest(http.MethodPost, "/read/"+id, nil)
+		req.Header.Set("X-Passcode", passcode)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		handler.HandleRead(rr, req)
+		return rr.Code
+	}
+	value := func(name string) float64 {
+		switch name {
+		case "read":
+			return testutil.ToFloat64(metrics.SecretsRead)
+		case "failed":
+			return testutil.ToFloat64(metrics.FailedAttempts)
+		case "burned":
+			return testutil.ToFloat64(metrics.SecretsBurned)
+		default:
+			return testutil.ToFloat64(metrics.SecretsCreated.WithLabelValues(name))
+		}
+	}
+	before := make(map[string]float64)
+	for _, name := range []string{"read", "failed", "burned", "1h", "1d", "longer"} {
+		before[name] = value(name)
+	}
+
+	secret := create(`{"secret":"s","expiry":"30m"}`)
+	create(`{"secret":"s","expiry":"1d"}`)
+	create(`{"secret":"s","expiry":"5d"}`)
+	if code := read(secret.ID, secret.Passcode); code != http.StatusOK {
+		t.Fatalf("expected %d reading the secret, got %d", http.StatusOK, code)
+	}
+	burned := create(`{"secret":"s"}`)
+	for range domain.MaxReadAttempts {
+		read(burned.ID, "wrong-passcode")
+	}
+
+	want := map[string]float64{
+		"1h": 1, "1d": 2, "longer": 1, "read": 1,
+		"failed": domain.MaxReadAttempts, "burned": 1,
+	}
+	for name, n := range want {
+		if got := value(name) - before[name]; got != n {
+			t.Errorf("%s: expected %v more, got %v", name, n, got)
+		}
+	}
+}
+
+func TestHandler_Files(t *testing.T) {
+	utility.LowerCryptoParamsForTest(t)
+
+	repo := domain.NewMemoryRepository()
+	t.Cleanup(func() { _ = repo.Close() })
+	handler := NewHandler(repo, DefaultHandlerConfig())
+
+	upload := func(query string, body []byte, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/create/file?"+query, bytes.NewReader(body))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		handler.HandleCreateFile(rr, req)
+		return rr
+	}
+	read := func(id, passcode, passphrase string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/read/"+id, nil)
+		req.Header.Set("X-Passcode", passcode)
+		if passphrase != "" {
+			req.Header.Set("X-Passphrase", passphrase)
+		}
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		handler.HandleRead(rr, req)
+		return rr
+	}
+	created := func(t *testing.T, rr *httptest.ResponseRecorder) domain.CreateRes {
+		t.Helper()
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("wrong status code: got %v want %v: %s", rr.Code, http.StatusCreated, rr.Body)
+		}
+		var res domain.CreateRes
+		if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+			t.Fatalf("could not decode create response: %v", err)
+		}
+		return res
+	}
+
+	// Larger than a secret, and spanning several chunks.
+	kubeconfig := bytes.Repeat([]byte("apiVersion: v1\n"), 3*utility.FileChunkSize/15+1)
+
+	t.Run("streamed back as an attachment", func(t *testing.T) {
+		res := created(t, upload("filename=../.kube/config&max_views=2&expiry=1h", kubeconfig,
+			http.Header{"Content-Type": {"application/yaml"}}))
+
+		for remaining := 1; remaining >= 0; remaining-- {
+			rr := read(res.ID, res.Passcode, "")
+			if rr.Code != http.StatusOK {
+				t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusOK)
+			}
+			if !bytes.Equal(rr.Body.Bytes(), kubeconfig) {
+				t.Error("downloaded file doesn't match")
+			}
+			if got := rr.Header().Get("Content-Disposition"); got != `attachment; filename=config` {
+				t.Errorf("unexpected Content-Disposition %q", got)
+			}
+			if got := rr.Header().Get("Content-Type"); got != "application/yaml" {
+				t.Errorf("unexpected Content-Type %q", got)
+			}
+			if got := rr.Header().Get("Content-Length"); got != strconv.Itoa(len(kubeconfig)) {
+				t.Errorf("unexpected Content-Length %q", got)
+			}
+			if got := rr.Header().Get("X-Remaining-Views"); got != strconv.Itoa(remaining) {
+				t.Errorf("expected %d remaining views, got %q", remaining, got)
+			}
+		}
+
+		if _, err := repo.GetChunk(context.Background(), res.ID, 0); !errors.Is(err, domain.ErrNotFound) {
+			t.Errorf("expected chunks to be deleted after the last view, got %v", err)
+		}
+		if rr := read(res.ID, res.Passcode, ""); rr.Code != http.StatusNotFound {
+			t.Errorf("wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("passphrase", func(t *testing.T) {
+		res := created(t, upload("filename=id_ed25519", []byte("key"),
+			http.Header{"X-Passphrase": {"over the phone"}}))
+
+		if rr := read(res.ID, res.Passcode, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+		rr := read(res.ID, res.Passcode, "over the phone")
+		if rr.Code != http.StatusOK || rr.Body.String() != "key" {
+			t.Errorf("unexpected response %v: %q", rr.Code, rr.Body)
+		}
+		if got := rr.Header().Get("Content-Type"); got != "application/octet-stream" {
+			t.Errorf("unexpected Content-Type %q", got)
+		}
+	})
+
+	t.Run("burned with its chunks", func(t *testing.T) {
+		res := created(t, upload("filename=cert.p12", kubeconfig, nil))
+		for i := 1; i <= domain.MaxReadAttempts; i++ {
+			rr := read(res.ID, "wrong-pass", "")
+			if rr.Code != http.StatusUnauthorized {
+				t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+			}
+		}
+		if _, err := repo.GetChunk(context.Background(), res.ID, 0); !errors.Is(err, domain.ErrNotFound) {
+			t.Errorf("expected chunks to be burned with the secret, got %v", err)
+		}
+	})
+
+	t.Run("invalid uploads", func(t *testing.T) {
+		testCases := []struct {
+			name       string
+			query      string
+			wantStatus int
+		}{
+			{"missing filename", "", http.StatusBadRequest},
+			{"directory only", "filename=../", http.StatusBadRequest},
+			{"invalid expiry", "filename=a&expiry=1y", http.StatusBadRequest},
+			{"invalid expires_at", "filename=a&expires_at=tomorrow", http.StatusBadRequest},
+			{"invalid max_views", "filename=a&max_views=many", http.StatusBadRequest},
+			{"too many views", "filename=a&max_views=100", http.StatusBadRequest},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				if rr := upload(tc.query, []byte("data"), nil); rr.Code != tc.wantStatus {
+					t.Errorf("wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+				}
+			})
+		}
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		var appended int
+		mockRepo := &mockSecretRepository{
+			AppendChunkFunc: func(ctx context.Context, id string, chunk []byte, ttl time.Duration) error {
+				appended++
+				return nil
+			},
+		}
+		cfg := DefaultHandlerConfig()
+		cfg.MaxFileSize = utility.FileChunkSize
+		req := httptest.NewRequest(http.MethodPost, "/create/file?filename=a",
+			bytes.NewReader(make([]byte, 2*utility.FileChunkSize)))
+		rr := httptest.NewRecorder()
+		NewHandler(mockRepo, cfg).HandleCreateFile(rr, req)
+
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("wrong status code: got %v want %v", rr.Code, http.StatusRequestEntityTooLarge)
+		}
+		if !strings.Contains(rr.Body.String(), "64KB") {
+			t.Errorf("expected the limit in the error, got %s", rr.Body)
+		}
+	})
+
+	t.Run("config reports the limit", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.HandleConfig(rr, httptest.NewRequest(http.MethodGet, "/config", nil))
+		var res domain.ConfigRes
+		if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if res.MaxFileSize != domain.MaxFileSize {
+			t.Errorf("expected max_file_size %d, got %d", domain.MaxFileSize, res.MaxFileSize)
+		}
+	})
+}
@@ -1,18 +1,115 @@
 package app
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/smallwat3r/secretapi/internal/apikey"
+	"github.com/smallwat3r/secretapi/internal/domain"
+	"github.com/smallwat3r/secretapi/internal/logging"
+	"github.com/smallwat3r/secretapi/internal/metrics"
+	"github.com/smallwat3r/secretapi/internal/tracing"
 	"github.com/smallwat3r/secretapi/internal/utility"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Tracing starts a server span for each request, continuing the trace of the
+// caller if it sent one. The span is named after the route the request
+// matched rather than its path, which may hold a secret ID.
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(),
+			propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attribute.String("http.request.method", r.Method)))
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		route := routePattern{chi.RouteContext(ctx)}.LogValue().String()
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes(
+			attribute.String("http.route", route),
+			attribute.Int("http.response.status_code", status),
+		)
+		if status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// RequestLogger attaches the request ID and route, and the trace ID of traced
+// requests, to the context of each request, so every record logged while
+// serving it carries them, then logs the request with its status once served.
+// The path itself isn't logged, as it may hold a secret ID, only the route
+// pattern it matched.
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx := logging.With(r.Context(),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+			slog.Any("route", routePattern{chi.RouteContext(r.Context())}))
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			ctx = logging.With(ctx, slog.String("trace_id", sc.TraceID().String()))
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case r.URL.Path == "/health" || strings.HasPrefix(r.URL.Path, "/static/"):
+			level = slog.LevelDebug
+		}
+		slog.LogAttrs(ctx, level, "request served",
+			slog.String("method", r.Method),
+			slog.Int("status", status),
+			slog.Int("bytes", ww.BytesWritten()),
+			slog.Duration("duration", time.Since(start)))
+	})
+}
+
+// routePattern logs the route a request matched. It is resolved when a
+// record is logged, as routing happens after the middleware runs.
+type routePattern struct {
+	rctx *chi.Context
+}
+
+func (p routePattern) LogValue() slog.Value {
+	if p.rctx == nil || p.rctx.RoutePattern() == "" {
+		return slog.StringValue("unmatched")
+	}
+	return slog.StringValue(p.rctx.RoutePattern())
+}
+
 // ContentLengthValidator validates Content-Length header for requests with bodies.
 // It rejects requests without Content-Length or with excessive Content-Length.
 func ContentLengthValidator(maxSize int64) func(http.Handler) http.Handler {
@@ -24,13 +121,13 @@ func ContentLengthValidator(maxSize int64) func(http.Handler) http.Handler {
 				// Check if Content-Length header is present
 				// r.ContentLength is -1 if not specified or chunked encoding
 				if r.ContentLength < 0 {
-					utility.HttpError(w, http.StatusLengthRequired,
+					utility.HttpError(w, domain.CodeLengthRequired,
 						"Content-Length header is required")
 					return
 				}
 				// Reject if Content-Length exceeds maximum
 				if r.ContentLength > maxSize {
-					utility.HttpError(w, http.StatusRequestEntityTooLarge,
+					utility.HttpError(w, domain.CodeSecretTooLarge,
 						"Content-Length exceeds maximum allowed size")
 					return
 				}
@@ -94,9 +191,56 @@ func SecurityHeaders(cfg SecurityHeadersConfig) func(http.Handler) http.Handler
 	}
 }
 
+// legacyDeprecation is when the unversioned API paths were deprecated, as a
+// Deprecation header value (RFC 9745).
+const legacyDeprecation = "@1792108800" // 2026-10-16
+
+// Deprecated marks the responses of an API route served at the path it had
+// before the API was versioned, and links to its successor under /api/v1.
+func Deprecated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", legacyDeprecation)
+		w.Header().Set("Link", fmt.Sprintf(`<%s%s>; rel="successor-version"`,
+			apiPrefix, r.URL.Path))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireAPIKey rejects requests without a valid API key as a bearer token,
+// and adds the key to the context of the others, where the rate limiter and
+// handlers find its quotas.
+func RequireAPIKey(store apikey.Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !strings.EqualFold(scheme, "Bearer") || token == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="secretapi"`)
+				utility.HttpError(w, domain.CodeAPIKeyRequired, "an API key is required")
+				return
+			}
+			key, err := apikey.Authenticate(r.Context(), store, strings.TrimSpace(token))
+			if errors.Is(err, apikey.ErrInvalid) {
+				w.Header().Set("WWW-Authenticate",
+					`Bearer realm="secretapi", error="invalid_token"`)
+				utility.HttpError(w, domain.CodeAPIKeyInvalid, "invalid API key")
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "failed to check api key", logging.Err(err))
+				utility.HttpError(w, domain.CodeInternal, "failed to check API key")
+				return
+			}
+
+			ctx := logging.With(apikey.NewContext(r.Context(), key),
+				slog.String("api_key", key.ID))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
 // RateLimitConfig holds configuration for rate limiting.
 type RateLimitConfig struct {
-	PostLimit        int           // max POST requests per window
+	PostLimit        int           // max POST and DELETE requests per window
 	GetLimit         int           // max GET requests per window
 	Window           time.Duration // time window for rate limiting
 	TrustedProxyCIDR string        // CIDR from which X-Real-IP/X-Forwarded-For are trusted
@@ -132,19 +276,121 @@ func ipInCIDR(addr, cidr string) bool {
 	return network.Contains(ip)
 }
 
-// RateLimiterMiddleware uses Redis for distributed rate limiting.
+// RateLimitStore counts requests per client over a time window.
+type RateLimitStore interface {
+	// Incr increments the counter for key, restarting its window, and
+	// returns the new count.
+	Incr(ctx context.Context, key string, window time.Duration) (int64, error)
+	// Count returns the counter for key, or 0 once its window has passed,
+	// without incrementing it.
+	Count(ctx context.Context, key string) (int64, error)
+}
+
+type redisRateLimitStore struct {
+	rdb redis.UniversalClient
+}
+
+// NewRedisRateLimitStore returns a store that shares counters between all
+// servers using the same Redis, Sentinel or Cluster deployment.
+func NewRedisRateLimitStore(rdb redis.UniversalClient) RateLimitStore {
+	return &redisRateLimitStore{rdb: rdb}
+}
+
+func (s *redisRateLimitStore) Incr(
+	ctx context.Context, key string, window time.Duration,
+) (int64, error) {
+	// Use a pipeline to atomically increment and set expiry.
+	// This avoids a race condition where the process could crash between
+	// INCR and EXPIRE, leaving a key without TTL.
+	pipe := s.rdb.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+func (s *redisRateLimitStore) Count(ctx context.Context, key string) (int64, error) {
+	count, err := s.rdb.Get(ctx, key).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return count, err
+}
+
+type memoryRateLimitCounter struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// MemoryRateLimitStore keeps counters in process memory, for a single server
+// running without Redis.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	counters  map[string]*memoryRateLimitCounter
+	lastSweep time.Time
+}
+
+// NewMemoryRateLimitStore returns an empty in-memory store.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{counters: make(map[string]*memoryRateLimitCounter)}
+}
+
+func (s *MemoryRateLimitStore) Incr(
+	ctx context.Context, key string, window time.Duration,
+) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	// Drop idle clients once per window so the map doesn't grow forever.
+	if now.Sub(s.lastSweep) >= window {
+		for k, c := range s.counters {
+			if now.After(c.expiresAt) {
+				delete(s.counters, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	c, ok := s.counters[key]
+	if !ok || now.After(c.expiresAt) {
+		c = &memoryRateLimitCounter{}
+		s.counters[key] = c
+	}
+	c.count++
+	c.expiresAt = now.Add(window)
+	return c.count, nil
+}
+
+func (s *MemoryRateLimitStore) Count(ctx context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.counters[key]
+	if !ok || time.Now().After(c.expiresAt) {
+		return 0, nil
+	}
+	return c.count, nil
+}
+
+// RateLimiterMiddleware limits requests per client IP, or per API key for
+// requests authenticated with one. Counters live in a RateLimitStore, in
+// Redis by default so that limits hold across servers.
 type RateLimiterMiddleware struct {
-	rdb              *redis.Client
+	store            RateLimitStore
 	postLimit        int
 	getLimit         int
 	window           time.Duration
 	trustedProxyCIDR string
 }
 
-// NewRateLimiter creates a new Redis-based rate limiter middleware.
-func NewRateLimiter(rdb *redis.Client, cfg RateLimitConfig) *RateLimiterMiddleware {
+// NewRateLimiter creates a new rate limiter middleware. A nil store disables
+// rate limiting.
+func NewRateLimiter(store RateLimitStore, cfg RateLimitConfig) *RateLimiterMiddleware {
 	return &RateLimiterMiddleware{
-		rdb:              rdb,
+		store:            store,
 		postLimit:        cfg.PostLimit,
 		getLimit:         cfg.GetLimit,
 		window:           cfg.Window,
@@ -155,32 +401,15 @@ func NewRateLimiter(rdb *redis.Client, cfg RateLimitConfig) *RateLimiterMiddlewa
 // Handler returns the HTTP middleware handler.
 func (m *RateLimiterMiddleware) Handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Skip rate limiting if Redis is not configured (e.g., in tests)
-		if m.rdb == nil {
+		// Skip rate limiting if no store is configured (e.g., in tests)
+		if m.store == nil {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Only trust proxy headers when the request originates from within the
-		// configured trusted CIDR. Without this guard a client that bypasses
-		// the reverse proxy can spoof X-Real-IP / X-Forwarded-For and rotate
-		// IPs freely to defeat rate limiting.
-		ip := stripPort(r.RemoteAddr)
-		if m.trustedProxyCIDR != "" && ipInCIDR(r.RemoteAddr, m.trustedProxyCIDR) {
-			if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
-				ip = realIP
-			} else if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
-				if idx := strings.Index(forwardedFor, ","); idx != -1 {
-					ip = strings.TrimSpace(forwardedFor[:idx])
-				} else {
-					ip = strings.TrimSpace(forwardedFor)
-				}
-			}
-		}
-
 		var limit int
 		switch r.Method {
-		case http.MethodPost:
+		case http.MethodPost, http.MethodDelete:
 			limit = m.postLimit
 		case http.MethodGet:
 			limit = m.getLimit
@@ -189,26 +418,95 @@ func (m *RateLimiterMiddleware) Handler(next http.Handler) http.Handler {
 			return
 		}
 
-		key := fmt.Sprintf("ratelimit:%s:%s", ip, r.Method)
+		key := fmt.Sprintf("ratelimit:%s:%s", m.clientIP(r), r.Method)
+		// A key's requests count against its own limit, wherever they come
+		// from, so that one client doesn't hold back the others behind a NAT.
+		if k, ok := apikey.FromContext(r.Context()); ok {
+			key = fmt.Sprintf("ratelimit:apikey:%s:%s", k.ID, r.Method)
+			if k.RateLimit > 0 {
+				limit = k.RateLimit
+			}
+		}
 
-		// Use a pipeline to atomically increment and set expiry.
-		// This avoids a race condition where the process could crash between
-		// INCR and EXPIRE, leaving a key without TTL.
-		pipe := m.rdb.TxPipeline()
-		incr := pipe.Incr(r.Context(), key)
-		pipe.Expire(r.Context(), key, m.window)
-		_, err := pipe.Exec(r.Context())
+		count, err := m.store.Incr(r.Context(), key, m.window)
 		if err != nil {
-			log.Printf("rate limit redis error: %v", err)
+			slog.ErrorContext(r.Context(), "rate limit store error", logging.Err(err))
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		if int(incr.Val()) > limit {
-			utility.HttpError(w, http.StatusTooManyRequests, "rate limit exceeded")
+		if int(count) > limit {
+			// Every request restarts the window, this one included.
+			m.reject(w, r)
 			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// LimitAuthFailures limits the requests refused by RequireAPIKey per client
+// IP, so that keys can't be guessed. It runs before RequireAPIKey, and once
+// a client has failed as many times in a window as it may make POST
+// requests, refuses its requests before their key is looked up. The
+// requests of valid keys aren't counted, and stay limited per key.
+func (m *RateLimiterMiddleware) LimitAuthFailures(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.store == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := fmt.Sprintf("ratelimit:authfail:%s", m.clientIP(r))
+		count, err := m.store.Count(r.Context(), key)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "rate limit store error", logging.Err(err))
+		} else if int(count) >= m.postLimit {
+			// Refused attempts restart the window too.
+			if _, err := m.store.Incr(r.Context(), key, m.window); err != nil {
+				slog.ErrorContext(r.Context(), "rate limit store error", logging.Err(err))
+			}
+			m.reject(w, r)
+			return
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		if ww.Status() == http.StatusUnauthorized {
+			if _, err := m.store.Incr(r.Context(), key, m.window); err != nil {
+				slog.ErrorContext(r.Context(), "rate limit store error", logging.Err(err))
+			}
+		}
+	})
+}
+
+// clientIP returns the IP a request came from.
+func (m *RateLimiterMiddleware) clientIP(r *http.Request) string {
+	// Only trust proxy headers when the request originates from within the
+	// configured trusted CIDR. Without this guard a client that bypasses
+	// the reverse proxy can spoof X-Real-IP / X-Forwarded-For and rotate
+	// IPs freely to defeat rate limiting.
+	ip := stripPort(r.RemoteAddr)
+	if m.trustedProxyCIDR != "" && ipInCIDR(r.RemoteAddr, m.trustedProxyCIDR) {
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			ip = realIP
+		} else if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+			if idx := strings.Index(forwardedFor, ","); idx != -1 {
+				ip = strings.TrimSpace(forwardedFor[:idx])
+			} else {
+				ip = strings.TrimSpace(forwardedFor)
+			}
+		}
+	}
+	return ip
+}
+
+// reject responds that the client has made too many requests.
+func (m *RateLimiterMiddleware) reject(w http.ResponseWriter, r *http.Request) {
+	metrics.RateLimited.WithLabelValues(r.Method).Inc()
+	problem := domain.NewProblem(domain.CodeRateLimited, "rate limit exceeded")
+	problem.RetryAfter = int(m.window.Seconds())
+	w.Header().Set("Retry-After", strconv.Itoa(problem.RetryAfter))
+	utility.WriteProblem(w, problem)
+}
@@ -1,11 +1,24 @@
 package app
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/smallwat3r/secretapi/internal/domain"
+	"github.com/smallwat3r/secretapi/internal/logging"
+	"github.com/smallwat3r/secretapi/internal/metrics"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestSecurityHeaders(t *testing.T) {
@@ -190,7 +203,7 @@ func TestRateLimiter(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	t.Run("passes through when redis is nil", func(t *testing.T) {
+	t.Run("passes through when the store is nil", func(t *testing.T) {
 		rl := NewRateLimiter(nil, DefaultRateLimitConfig())
 		wrapped := rl.Handler(handler)
 
@@ -206,6 +219,73 @@ func TestRateLimiter(t *testing.T) {
 		}
 	})
 
+	t.Run("limits requests with the memory store", func(t *testing.T) {
+		cfg := RateLimitConfig{PostLimit: 3, GetLimit: 5, Window: time.Minute}
+		rl := NewRateLimiter(NewMemoryRateLimitStore(), cfg)
+		wrapped := rl.Handler(handler)
+		rejected := testutil.ToFloat64(metrics.RateLimited.WithLabelValues(http.MethodPost))
+
+		for i := range 4 {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.RemoteAddr = "192.168.1.1:12345"
+			rr := httptest.NewRecorder()
+			wrapped.ServeHTTP(rr, req)
+
+			want := http.StatusOK
+			if i == 3 {
+				want = http.StatusTooManyRequests
+			}
+			if rr.Code != want {
+				t.Errorf("request %d: expected %d, got %d", i+1, want, rr.Code)
+			}
+			if i == 3 {
+				var p domain.Problem
+				if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+					t.Fatalf("could not decode problem: %v", err)
+				}
+				if p.Code != domain.CodeRateLimited || p.RetryAfter != 60 ||
+					rr.Header().Get("Retry-After") != "60" {
+					t.Errorf("expected to retry after 60s, got %+v and Retry-After %q",
+						p, rr.Header().Get("Retry-After"))
+				}
+			}
+		}
+		got := testutil.ToFloat64(metrics.RateLimited.WithLabelValues(http.MethodPost))
+		if got != rejected+1 {
+			t.Errorf("expected 1 more rate limited request in metrics, got %v", got-rejected)
+		}
+
+		// Other clients and methods have their own counters.
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.RemoteAddr = "192.168.1.2:12345"
+		rr := httptest.NewRecorder()
+		wrapped.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("other client: expected %d, got %d", http.StatusOK, rr.Code)
+		}
+	})
+
+	t.Run("memory store restarts the count after the window", func(t *testing.T) {
+		store := NewMemoryRateLimitStore()
+		ctx := context.Background()
+		for range 3 {
+			if _, err := store.Incr(ctx, "key", 10*time.Millisecond); err != nil {
+				t.Fatalf("Incr() error = %v", err)
+			}
+		}
+		if count, _ := store.Count(ctx, "key"); count != 3 {
+			t.Errorf("expected a count of 3, got %d", count)
+		}
+		time.Sleep(20 * time.Millisecond)
+		if count, _ := store.Count(ctx, "key"); count != 0 {
+			t.Errorf("expected the count to have expired, got %d", count)
+		}
+		count, _ := store.Incr(ctx, "key", 10*time.Millisecond)
+		if count != 1 {
+			t.Errorf("expected count to restart at 1, got %d", count)
+		}
+	})
+
 	t.Run("default config has sensible values", func(t *testing.T) {
 		cfg := DefaultRateLimitConfig()
 		if cfg.PostLimit <= 0 {
@@ -220,6 +300,56 @@ func TestRateLimiter(t *testing.T) {
 	})
 }
 
+func TestRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(logging.New(&buf, logging.Options{Format: "json", Level: slog.LevelDebug}))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	const id = "d47ef7c1-4a3b-412f-b6ab-5c25b2b68d33"
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(RequestLogger)
+	r.Get("/read/{id}", func(w http.ResponseWriter, r *http.Request) {
+		slog.InfoContext(r.Context(), "secret read", logging.ID(chi.URLParam(r, "id")))
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/read/"+id, nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if strings.Contains(buf.String(), id) {
+		t.Errorf("expected the secret id not to be logged, got %s", buf.String())
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, got %d: %s", len(lines), buf.String())
+	}
+	var records []map[string]any
+	for _, line := range lines {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid JSON record %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	for _, record := range records {
+		if record["request_id"] == nil || record["request_id"] == "" {
+			t.Errorf("expected a request_id on %v", record)
+		}
+		if record["route"] != "/read/{id}" {
+			t.Errorf("expected the route pattern on %v", record)
+		}
+	}
+	if records[0]["request_id"] != records[1]["request_id"] {
+		t.Error("expected both records to share the request_id")
+	}
+	served := records[1]
+	if served["msg"] != "request served" || served["status"] != float64(http.StatusNotFound) {
+		t.Errorf("unexpected request record %v", served)
+	}
+}
+
 func TestStripPort(t *testing.T) {
 	cases := []struct{ input, want string }{
 		{"192.168.1.1:12345", "192.168.1.1"},
@@ -0,0 +1,393 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/smallwat3r/secretapi/internal/domain"
+	"github.com/smallwat3r/secretapi/internal/utility"
+)
+
+// apiPrefix is the path the current version of the API is served under.
+const apiPrefix = "/api/v1"
+
+// param is a header, query or path parameter of an operation.
+type param struct {
+	name, in, description string
+	required              bool
+	schema                map[string]any
+}
+
+// response is a response of an operation. Its body is described by the Go
+// type written as JSON, or by the content types of a raw body.
+type response struct {
+	description string
+	body        reflect.Type
+	problem     bool // body is written as application/problem+json
+	raw         []string
+}
+
+// operation describes a route of the API for the OpenAPI document.
+type operation struct {
+	method, path string // path relative to apiPrefix
+	id, summary  string
+	params       []param
+	body         reflect.Type // JSON request body
+	rawBody      string       // content type of a raw request body, instead
+	optionalBody bool
+	apiKey       bool // requires an API key on servers with API_KEYS set
+	responses    map[int]response
+}
+
+var (
+	idParam = param{name: "id", in: "path", required: true,
+		schema: map[string]any{"type": "string", "format": "uuid"}}
+	passphraseParam = param{name: "X-Passphrase", in: "header",
+		description: "Passphrase set by the sender, shared separately",
+		schema:      map[string]any{"type": "string"}}
+	deleteTokenParam = param{name: "X-Delete-Token", in: "header", required: true,
+		description: "Delete token of the secret, or the token of a secret request",
+		schema:      map[string]any{"type": "string"}}
+)
+
+func errorResponse(description string) response {
+	return response{description: description, body: reflect.TypeFor[domain.Problem](),
+		problem: true}
+}
+
+// apiOperations are the routes registered by apiRoutes, which
+// TestOpenAPISpec checks against the router and the handlers' responses.
+var apiOperations = []operation{
+	{
+		method: http.MethodGet, path: "/config", id: "getConfig",
+		summary: "Get the limits and options of the server",
+		responses: map[int]response{
+			http.StatusOK: {
+				description: "Server configuration",
+				body:        reflect.TypeFor[domain.ConfigRes](),
+			},
+		},
+	},
+	{
+		method: http.MethodPost, path: "/create", id: "createSecret",
+		summary: "Create a secret",
+		body:    reflect.TypeFor[domain.CreateReq](),
+		apiKey:  true,
+		responses: map[int]response{
+			http.StatusCreated: {
+				description: "Secret created",
+				body:        reflect.TypeFor[domain.CreateRes](),
+			},
+			http.StatusBadRequest:            errorResponse("Invalid secret or options"),
+			http.StatusRequestEntityTooLarge: errorResponse("Secret too large"),
+		},
+	},
+	{
+		method: http.MethodPost, path: "/create/file", id: "createFileSecret",
+		summary: "Create a secret from a file",
+		params: []param{
+			{name: "filename", in: "query", required: true,
+				schema: map[string]any{"type": "string"}},
+			{name: "expiry", in: "query", schema: map[string]any{"type": "string"}},
+			{name: "expires_at", in: "query",
+				schema: map[string]any{"type": "string", "format": "date-time"}},
+			{name: "max_views", in: "query", schema: map[string]any{"type": "integer"}},
+			{name: "notify_url", in: "query", schema: map[string]any{"type": "string"}},
+			passphraseParam,
+		},
+		rawBody: "application/octet-stream",
+		apiKey:  true,
+		responses: map[int]response{
+			http.StatusCreated: {
+				description: "Secret created",
+				body:        reflect.TypeFor[domain.CreateRes](),
+			},
+			http.StatusBadRequest:            errorResponse("Invalid options"),
+			http.StatusLengthRequired:        errorResponse("Content-Length missing"),
+			http.StatusRequestEntityTooLarge: errorResponse("File too large"),
+		},
+	},
+	{
+		method: http.MethodPost, path: "/request", id: "createSecretRequest",
+		summary:      "Create a link through which someone else sends a secret",
+		body:         reflect.TypeFor[domain.RequestReq](),
+		optionalBody: true,
+		apiKey:       true,
+		responses: map[int]response{
+			http.StatusCreated: {
+				description: "Secret request created",
+				body:        reflect.TypeFor[domain.RequestRes](),
+			},
+			http.StatusBadRequest: errorResponse("Invalid options"),
+		},
+	},
+	{
+		method: http.MethodPost, path: "/request/{id}", id: "fulfillSecretRequest",
+		summary: "Send a secret through a secret request",
+		params:  []param{idParam},
+		body:    reflect.TypeFor[domain.FulfillReq](),
+		responses: map[int]response{
+			http.StatusNoContent:             {description: "Secret sent"},
+			http.StatusBadRequest:            errorResponse("Invalid secret"),
+			http.StatusNotFound:              errorResponse("Not found, already used or expired"),
+			http.StatusRequestEntityTooLarge: errorResponse("Secret too large"),
+		},
+	},
+	{
+		method: http.MethodPost, path: "/read/{id}", id: "readSecret",
+		summary: "Read a secret, using up one of its views",
+		params: []param{
+			idParam,
+			{name: "X-Passcode", in: "header",
+				description: "Passcode of the secret, omitted for client-side encrypted secrets",
+				schema:      map[string]any{"type": "string"}},
+			passphraseParam,
+			{name: "format", in: "query",
+				description: "Returns the secret as text or JSON rather than a ReadRes",
+				schema: map[string]any{
+					"type": "string", "enum": []string{"plain", "env", "json"},
+				}},
+		},
+		responses: map[int]response{
+			http.StatusOK: {
+				description: "The secret, or the content of a file secret",
+				body:        reflect.TypeFor[domain.ReadRes](),
+				raw:         []string{"text/plain", "application/octet-stream"},
+			},
+			http.StatusBadRequest:   errorResponse("Missing passcode or passphrase"),
+			http.StatusUnauthorized: errorResponse("Wrong passcode or passphrase"),
+			http.StatusNotFound:     errorResponse("Not found or expired"),
+			http.StatusConflict:     errorResponse("Secret request not fulfilled yet"),
+		},
+	},
+	{
+		method: http.MethodDelete, path: "/secret/{id}", id: "revokeSecret",
+		summary: "Delete a secret before it is read",
+		params:  []param{idParam, deleteTokenParam},
+		responses: map[int]response{
+			http.StatusNoContent:  {description: "Secret deleted"},
+			http.StatusBadRequest: errorResponse("Missing delete token"),
+			http.StatusForbidden:  errorResponse("Invalid delete token"),
+			http.StatusNotFound:   errorResponse("Not found or expired"),
+		},
+	},
+	{
+		method: http.MethodGet, path: "/secret/{id}/status", id: "getSecretStatus",
+		summary: "Check whether a secret was read",
+		params:  []param{idParam, deleteTokenParam},
+		responses: map[int]response{
+			http.StatusOK: {
+				description: "Status of the secret",
+				body:        reflect.TypeFor[domain.StatusRes](),
+			},
+			http.StatusBadRequest: errorResponse("Missing delete token"),
+			http.StatusForbidden:  errorResponse("Invalid delete token"),
+		},
+	},
+}
+
+// enabledOperations returns the operations of apiOperations that h's config
+// enables, as apiRoutes only registers those.
+func (h *Handler) enabledOperations() []operation {
+	return slices.DeleteFunc(slices.Clone(apiOperations), func(op operation) bool {
+		return op.path == "/create/file" && h.cfg.MaxFileSize <= 0
+	})
+}
+
+// encodeOpenAPISpec returns the OpenAPI document of the operations h enables.
+func (h *Handler) encodeOpenAPISpec() ([]byte, error) {
+	return json.Marshal(newOpenAPISpec(h.enabledOperations()))
+}
+
+// HandleOpenAPI serves the OpenAPI document of the API the handler serves.
+func (h *Handler) HandleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	spec, err := h.openAPISpec()
+	if err != nil {
+		utility.HttpError(w, domain.CodeInternal, "failed to encode spec")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	_, _ = w.Write(spec)
+}
+
+// newOpenAPISpec returns the OpenAPI 3 document describing ops. The schemas
+// of request and response bodies are derived from their Go types.
+func newOpenAPISpec(ops []operation) map[string]any {
+	b := schemaBuilder{components: map[string]any{}}
+	paths := map[string]any{}
+	for _, op := range ops {
+		o := map[string]any{
+			"operationId": op.id,
+			"summary":     op.summary,
+		}
+
+		if len(op.params) > 0 {
+			params := make([]any, 0, len(op.params))
+			for _, p := range op.params {
+				param := map[string]any{"name": p.name, "in": p.in, "schema": p.schema}
+				if p.required {
+					param["required"] = true
+				}
+				if p.description != "" {
+					param["description"] = p.description
+				}
+				params = append(params, param)
+			}
+			o["parameters"] = params
+		}
+
+		switch {
+		case op.body != nil:
+			o["requestBody"] = map[string]any{
+				"required": !op.optionalBody,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": b.schema(op.body)},
+				},
+			}
+		case op.rawBody != "":
+			o["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					op.rawBody: map[string]any{
+						"schema": map[string]any{"type": "string", "format": "binary"},
+					},
+				},
+			}
+		}
+
+		// Any route may be rate limited, or fail.
+		responses := map[string]any{
+			"429":     b.response(errorResponse("Rate limit exceeded")),
+			"default": b.response(errorResponse("Unexpected error")),
+		}
+		if op.apiKey {
+			o["security"] = []any{map[string]any{"apiKey": []string{}}, map[string]any{}}
+			responses["401"] = b.response(errorResponse("Missing or invalid API key"))
+		}
+		for status, res := range op.responses {
+			responses[fmt.Sprint(status)] = b.response(res)
+		}
+		o["responses"] = responses
+
+		path := apiPrefix + op.path
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path].(map[string]any)[strings.ToLower(op.method)] = o
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "SecretAPI",
+			"version":     "1",
+			"description": "Share short-lived secrets through one-time links.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": b.components,
+			"securitySchemes": map[string]any{
+				"apiKey": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Required to create secrets by servers with API keys enabled",
+				},
+			},
+		},
+	}
+}
+
+// schemaBuilder derives JSON schemas from Go types through their json tags,
+// so that the document follows the types the handlers encode. Named structs
+// are added to the components and referenced.
+type schemaBuilder struct {
+	components map[string]any
+}
+
+func (b *schemaBuilder) response(res response) map[string]any {
+	r := map[string]any{"description": res.description}
+	content := map[string]any{}
+	if res.body != nil {
+		mediaType := "application/json"
+		if res.problem {
+			mediaType = "application/problem+json"
+		}
+		content[mediaType] = map[string]any{"schema": b.schema(res.body)}
+	}
+	for _, typ := range res.raw {
+		content[typ] = map[string]any{"schema": map[string]any{"type": "string"}}
+	}
+	if len(content) > 0 {
+		r["content"] = content
+	}
+	return r
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]any {
+	switch t {
+	case reflect.TypeFor[time.Time]():
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.TypeFor[domain.ErrorCode]():
+		return map[string]any{"type": "string", "enum": domain.ErrorCodes()}
+	case reflect.TypeFor[domain.Fields]():
+		// Fields encode as an object, in order.
+		return map[string]any{
+			"type":                 "object",
+			"additionalProperties": map[string]any{"type": "string"},
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.schema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := b.components[name]; !ok {
+			b.components[name] = b.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	panic(fmt.Sprintf("openapi: no schema for %s", t))
+}
+
+// object returns the schema of a struct. Fields without omitempty are
+// always encoded, so they are required.
+func (b *schemaBuilder) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	required := []string{}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = b.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	s := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
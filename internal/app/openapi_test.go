@@ -0,0 +1,334 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/smallwat3r/secretapi/internal/domain"
+	"github.com/smallwat3r/secretapi/internal/utility"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// loadOpenAPISpec fetches the document served by router, decoded as plain
+// JSON values.
+func loadOpenAPISpec(t *testing.T, router http.Handler) map[string]any {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, apiPrefix+"/openapi.json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	var spec map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&spec); err != nil {
+		t.Fatalf("could not decode spec: %v", err)
+	}
+	return spec
+}
+
+// routeParam matches the patterns of chi route parameters.
+var routeParam = regexp.MustCompile(`\{(\w+):[^/]*\}`)
+
+func TestOpenAPISpec_Routes(t *testing.T) {
+	noFiles := DefaultHandlerConfig()
+	noFiles.MaxFileSize = 0
+	testCases := []struct {
+		name string
+		cfg  HandlerConfig
+	}{
+		{"default", DefaultHandlerConfig()},
+		{"files disabled", noFiles},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := NewHandler(domain.NewMemoryRepository(), tc.cfg)
+			router := NewRouter(handler, nil, SecurityHeadersConfig{}, DefaultRateLimitConfig())
+			checkOpenAPIRoutes(t, router)
+		})
+	}
+}
+
+// checkOpenAPIRoutes checks that the spec served by router documents the
+// API routes it serves, no more and no fewer.
+func checkOpenAPIRoutes(t *testing.T, router http.Handler) {
+	t.Helper()
+	spec := loadOpenAPISpec(t, router)
+
+	var documented []string
+	for path, item := range spec["paths"].(map[string]any) {
+		for method := range item.(map[string]any) {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+
+	var routed, legacy []string
+	err := chi.Walk(router.(chi.Routes),
+		func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+			route = routeParam.ReplaceAllString(route, "{$1}")
+			switch {
+			case route == apiPrefix+"/openapi.json", strings.HasPrefix(route, "/static/"):
+			case strings.HasPrefix(route, apiPrefix+"/"):
+				routed = append(routed, method+" "+route)
+			case method != http.MethodGet || route == "/config" ||
+				strings.HasPrefix(route, "/secret/"):
+				// Page routes only answer GET.
+				legacy = append(legacy, method+" "+apiPrefix+route)
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+
+	slices.Sort(documented)
+	slices.Sort(routed)
+	slices.Sort(legacy)
+	if !slices.Equal(documented, routed) {
+		t.Errorf("documented routes %v don't match the API routes %v", documented, routed)
+	}
+	if !slices.Equal(legacy, routed) {
+		t.Errorf("deprecated routes %v don't match the API routes %v", legacy, routed)
+	}
+}
+
+func TestOpenAPISpec_Responses(t *testing.T) {
+	utility.LowerCryptoParamsForTest(t)
+
+	repo := domain.NewMemoryRepository()
+	t.Cleanup(func() { _ = repo.Close() })
+	router := NewRouter(NewHandler(repo, DefaultHandlerConfig()), nil,
+		SecurityHeadersConfig{}, DefaultRateLimitConfig())
+	spec := loadOpenAPISpec(t, router)
+
+	exercised := map[string]bool{}
+	// call sends a request to an operation, checks the response is one the
+	// spec describes and returns its body.
+	call := func(method, route, path, body string, header map[string]string, want int) []byte {
+		t.Helper()
+		req := httptest.NewRequest(method, apiPrefix+path, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Fatalf("%s %s: expected status %d, got %d: %s", method, path, want, rr.Code, rr.Body)
+		}
+
+		op, ok := specOperation(spec, method, apiPrefix+route)
+		if !ok {
+			t.Fatalf("%s %s isn't documented", method, route)
+		}
+		res, ok := op["responses"].(map[string]any)[strconv.Itoa(rr.Code)].(map[string]any)
+		if !ok {
+			t.Fatalf("%s %s: status %d isn't documented", method, route, rr.Code)
+		}
+		exercised[op["operationId"].(string)] = true
+
+		content, _ := res["content"].(map[string]any)
+		if rr.Body.Len() == 0 {
+			return nil
+		}
+		mediaType, _, _ := mime.ParseMediaType(rr.Header().Get("Content-Type"))
+		media, ok := content[mediaType].(map[string]any)
+		if !ok {
+			t.Fatalf("%s %s: %s response isn't documented for status %d",
+				method, route, mediaType, rr.Code)
+		}
+		if mediaType == "application/json" || mediaType == "application/problem+json" {
+			var v any
+			if err := json.Unmarshal(rr.Body.Bytes(), &v); err != nil {
+				t.Fatalf("%s %s: invalid JSON response: %v", method, route, err)
+			}
+			for _, err := range validateSchema(spec, media["schema"].(map[string]any), v, "body") {
+				t.Errorf("%s %s: %v", method, route, err)
+			}
+		}
+		if mediaType == "application/problem+json" {
+			var p domain.Problem
+			if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil {
+				t.Fatalf("%s %s: invalid problem: %v", method, route, err)
+			}
+			if p.Status != rr.Code || p.Code.Status() != rr.Code {
+				t.Errorf("%s %s: code %s doesn't match status %d", method, route, p.Code, rr.Code)
+			}
+		}
+		return rr.Body.Bytes()
+	}
+	decode := func(b []byte, v any) {
+		t.Helper()
+		if err := json.Unmarshal(b, v); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+	}
+
+	call("GET", "/config", "/config", "", nil, http.StatusOK)
+
+	var secret domain.CreateRes
+	decode(call("POST", "/create", "/create", `{"secret":"s","max_views":2}`, nil,
+		http.StatusCreated), &secret)
+	call("POST", "/create", "/create", `{}`, nil, http.StatusBadRequest)
+	call("POST", "/create", "/create", `{"secret":"`+strings.Repeat("a", 70*1024)+`"}`, nil,
+		http.StatusRequestEntityTooLarge)
+
+	read := "/read/" + secret.ID
+	call("POST", "/read/{id}", read, "", nil, http.StatusBadRequest)
+	call("POST", "/read/{id}", read, "", map[string]string{"X-Passcode": "wrong"},
+		http.StatusUnauthorized)
+	call("POST", "/read/{id}", read, "", map[string]string{"X-Passcode": secret.Passcode},
+		http.StatusOK)
+	call("POST", "/read/{id}", read+"?format=env", "",
+		map[string]string{"X-Passcode": secret.Passcode}, http.StatusOK)
+	call("POST", "/read/{id}", read, "", map[string]string{"X-Passcode": secret.Passcode},
+		http.StatusNotFound)
+
+	var file domain.CreateRes
+	decode(call("POST", "/create/file", "/create/file?filename=a.txt", "hello", nil,
+		http.StatusCreated), &file)
+	call("POST", "/create/file", "/create/file", "hello", nil, http.StatusBadRequest)
+	call("POST", "/read/{id}", "/read/"+file.ID, "",
+		map[string]string{"X-Passcode": file.Passcode}, http.StatusOK)
+
+	var request domain.RequestRes
+	decode(call("POST", "/request", "/request", "", nil, http.StatusCreated), &request)
+	call("POST", "/read/{id}", "/read/"+request.ID, "",
+		map[string]string{"X-Passcode": request.Token}, http.StatusConflict)
+	call("POST", "/request/{id}", "/request/"+request.ID, `{}`, nil, http.StatusBadRequest)
+	call("POST", "/request/{id}", "/request/"+request.ID, `{"secret":"s"}`, nil,
+		http.StatusNoContent)
+	call("POST", "/request/{id}", "/request/"+request.ID, `{"secret":"s"}`, nil,
+		http.StatusNotFound)
+
+	var revoked domain.CreateRes
+	decode(call("POST", "/create", "/create", `{"secret":"s"}`, nil, http.StatusCreated),
+		&revoked)
+	status := "/secret/" + revoked.ID + "/status"
+	token := map[string]string{"X-Delete-Token": revoked.DeleteToken}
+	wrongToken := map[string]string{"X-Delete-Token": "wrong"}
+	call("GET", "/secret/{id}/status", status, "", nil, http.StatusBadRequest)
+	call("GET", "/secret/{id}/status", status, "", wrongToken, http.StatusForbidden)
+	call("GET", "/secret/{id}/status", status, "", token, http.StatusOK)
+	call("DELETE", "/secret/{id}", "/secret/"+revoked.ID, "", nil, http.StatusBadRequest)
+	call("DELETE", "/secret/{id}", "/secret/"+revoked.ID, "", wrongToken, http.StatusForbidden)
+	call("DELETE", "/secret/{id}", "/secret/"+revoked.ID, "", token, http.StatusNoContent)
+	call("DELETE", "/secret/{id}", "/secret/"+revoked.ID, "", token, http.StatusNotFound)
+	call("GET", "/secret/{id}/status", status, "", token, http.StatusOK)
+
+	for _, op := range apiOperations {
+		if !exercised[op.id] {
+			t.Errorf("operation %s wasn't exercised", op.id)
+		}
+	}
+}
+
+func TestDeprecated(t *testing.T) {
+	handler := NewHandler(domain.NewMemoryRepository(), DefaultHandlerConfig())
+	router := NewRouter(handler, nil, SecurityHeadersConfig{}, DefaultRateLimitConfig())
+
+	for path, deprecated := range map[string]bool{
+		"/config":                   true,
+		apiPrefix + "/config":       false,
+		"/":                         false,
+		apiPrefix + "/openapi.json": false,
+	} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusOK && path != "/" {
+			t.Errorf("GET %s: expected status %d, got %d", path, http.StatusOK, rr.Code)
+		}
+		got := rr.Header().Get("Deprecation") != ""
+		if got != deprecated {
+			t.Errorf("GET %s: expected deprecated %v, got %v", path, deprecated, got)
+		}
+		if deprecated && rr.Header().Get("Link") != `</api/v1/config>; rel="successor-version"` {
+			t.Errorf("GET %s: unexpected Link header %q", path, rr.Header().Get("Link"))
+		}
+	}
+}
+
+// specOperation returns the operation of the spec serving method at path.
+func specOperation(spec map[string]any, method, path string) (map[string]any, bool) {
+	item, ok := spec["paths"].(map[string]any)[path].(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	op, ok := item[strings.ToLower(method)].(map[string]any)
+	return op, ok
+}
+
+// validateSchema returns how v doesn't match schema. Properties a schema
+// doesn't declare are reported, so that the spec can't fall behind the
+// handlers.
+func validateSchema(spec, schema map[string]any, v any, at string) []error {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		components := spec["components"].(map[string]any)["schemas"].(map[string]any)
+		schema = components[name].(map[string]any)
+	}
+
+	var errs []error
+	mismatch := func() []error {
+		return []error{fmt.Errorf("%s: expected %s, got %T", at, schema["type"], v)}
+	}
+	switch schema["type"] {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return mismatch()
+		}
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				errs = append(errs, fmt.Errorf("%s: missing %s", at, name))
+			}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		extra, _ := schema["additionalProperties"].(map[string]any)
+		for name, value := range obj {
+			prop, ok := props[name].(map[string]any)
+			if !ok {
+				prop = extra
+			}
+			if prop == nil {
+				errs = append(errs, fmt.Errorf("%s: undocumented property %s", at, name))
+				continue
+			}
+			errs = append(errs, validateSchema(spec, prop, value, at+"."+name)...)
+		}
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			return mismatch()
+		}
+		for i, item := range arr {
+			errs = append(errs, validateSchema(spec, schema["items"].(map[string]any), item,
+				fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return mismatch()
+		}
+		if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, any(s)) {
+			errs = append(errs, fmt.Errorf("%s: %q isn't one of %v", at, s, enum))
+		}
+	case "integer":
+		if n, ok := v.(float64); !ok || n != float64(int64(n)) {
+			return mismatch()
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return mismatch()
+		}
+	}
+	return errs
+}
@@ -5,11 +5,8 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/smallwat3r/secretapi/internal/domain"
-
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/redis/go-redis/v9"
 )
 
 // cacheControl wraps an http.Handler to add Cache-Control headers.
@@ -21,17 +18,21 @@ func cacheControl(h http.Handler, maxAge time.Duration) http.Handler {
 	})
 }
 
-func NewRouter(h *Handler, rdb *redis.Client, secCfg SecurityHeadersConfig, rlCfg RateLimitConfig) http.Handler {
+// handlerTimeout bounds the time spent handling a request.
+const handlerTimeout = 60 * time.Second
+
+func NewRouter(h *Handler, rlStore RateLimitStore, secCfg SecurityHeadersConfig, rlCfg RateLimitConfig) http.Handler {
 	r := chi.NewRouter()
-	rl := NewRateLimiter(rdb, rlCfg)
+	rl := NewRateLimiter(rlStore, rlCfg)
 
 	r.Use(middleware.RequestID)
+	r.Use(Tracing)
+	r.Use(RequestLogger)
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RedirectSlashes)
-	r.Use(middleware.Timeout(60 * time.Second))
+	r.Use(middleware.Timeout(handlerTimeout))
 	r.Use(SecurityHeaders(secCfg))
-	r.Use(ContentLengthValidator(domain.MaxRequestBodySize))
 
 	r.Get("/robots.txt", h.HandleRobotsTXT)
 	r.Get("/health", h.HandleHealth)
@@ -44,14 +45,57 @@ func NewRouter(h *Handler, rdb *redis.Client, secCfg SecurityHeadersConfig, rlCf
 	r.Get("/", h.HandleIndexHTML)
 	r.Get("/about", h.HandleIndexHTML)
 	r.Get("/read/{id:[0-9a-fA-F-]{36}}", h.HandleIndexHTML)
+	r.Get("/request/{id:[0-9a-fA-F-]{36}}", h.HandleIndexHTML)
+
+	// API routes, served under /api/v1 and, deprecated, at the paths they
+	// had before the API was versioned.
+	r.Route(apiPrefix, func(r chi.Router) {
+		apiRoutes(r, h, rl)
+		r.With(rl.Handler).Get("/openapi.json", h.HandleOpenAPI)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(Deprecated)
+		apiRoutes(r, h, rl)
+	})
+
+	return r
+}
 
-	// API routes (rate limited)
+// apiRoutes registers the routes of the API, which apiOperations describes.
+func apiRoutes(r chi.Router, h *Handler, rl *RateLimiterMiddleware) {
+	// Rate limited
 	r.Group(func(r chi.Router) {
 		r.Use(rl.Handler)
 		r.Get("/config", h.HandleConfig)
-		r.Post("/create", h.HandleCreate)
-		r.Post("/read/{id:[0-9a-fA-F-]{36}}", h.HandleRead)
+		r.Delete("/secret/{id:[0-9a-fA-F-]{36}}", h.HandleRevoke)
+		r.Get("/secret/{id:[0-9a-fA-F-]{36}}/status", h.HandleStatus)
+		r.Group(func(r chi.Router) {
+			r.Use(ContentLengthValidator(h.maxRequestBodySize()))
+			r.Post("/request/{id:[0-9a-fA-F-]{36}}", h.HandleFulfillRequest)
+			r.Post("/read/{id:[0-9a-fA-F-]{36}}", h.HandleRead)
+		})
 	})
 
-	return r
+	// Routes creating secrets, which may require an API key. The key is
+	// checked before the rate limiter, so its own limit applies, and failed
+	// checks are limited per IP.
+	r.Group(func(r chi.Router) {
+		if h.apiKeys != nil {
+			r.Use(rl.LimitAuthFailures)
+			r.Use(RequireAPIKey(h.apiKeys))
+		}
+		r.Use(rl.Handler)
+
+		// Files are much larger than other request bodies, so they get a
+		// ceiling of their own.
+		if h.cfg.MaxFileSize > 0 {
+			r.With(ContentLengthValidator(h.cfg.MaxFileSize)).
+				Post("/create/file", h.HandleCreateFile)
+		}
+		r.Group(func(r chi.Router) {
+			r.Use(ContentLengthValidator(h.maxRequestBodySize()))
+			r.Post("/create", h.HandleCreate)
+			r.Post("/request", h.HandleCreateRequest)
+		})
+	})
 }
@@ -2,14 +2,23 @@ package app
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
-	"github.com/redis/go-redis/v9"
+	"github.com/smallwat3r/secretapi/internal/apikey"
+	"github.com/smallwat3r/secretapi/internal/audit"
+	"github.com/smallwat3r/secretapi/internal/domain"
+	"github.com/smallwat3r/secretapi/internal/tracing"
 	"github.com/smallwat3r/secretapi/internal/utility"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func TestNewRouter_Routes(t *testing.T) {
@@ -17,12 +26,12 @@ func TestNewRouter_Routes(t *testing.T) {
 
 	mockRepo := &mockSecretRepository{
 		StoreSecretFunc: func(
-			ctx context.Context, id string, secret []byte, ttl time.Duration,
+			ctx context.Context, id string, secret domain.Secret, ttl time.Duration,
 		) error {
 			return nil
 		},
 	}
-	handler := NewHandler(mockRepo, "")
+	handler := NewHandler(mockRepo, DefaultHandlerConfig())
 	router := NewRouter(handler, nil, SecurityHeadersConfig{}, DefaultRateLimitConfig())
 
 	testCases := []struct {
@@ -56,12 +65,12 @@ func TestNewRouter_CreateEndpoint(t *testing.T) {
 
 	mockRepo := &mockSecretRepository{
 		StoreSecretFunc: func(
-			ctx context.Context, id string, secret []byte, ttl time.Duration,
+			ctx context.Context, id string, secret domain.Secret, ttl time.Duration,
 		) error {
 			return nil
 		},
 	}
-	handler := NewHandler(mockRepo, "")
+	handler := NewHandler(mockRepo, DefaultHandlerConfig())
 	router := NewRouter(handler, nil, SecurityHeadersConfig{}, DefaultRateLimitConfig())
 
 	reqBody := `{"secret":"test-secret"}`
@@ -81,10 +90,10 @@ func TestNewRouter_ReadEndpoint_ValidUUID(t *testing.T) {
 
 	mockRepo := &mockSecretRepository{
 		GetSecretFunc: func(ctx context.Context, id string) ([]byte, error) {
-			return nil, redis.Nil
+			return nil, domain.ErrNotFound
 		},
 	}
-	handler := NewHandler(mockRepo, "")
+	handler := NewHandler(mockRepo, DefaultHandlerConfig())
 	router := NewRouter(handler, nil, SecurityHeadersConfig{}, DefaultRateLimitConfig())
 
 	// Valid UUID format
@@ -105,7 +114,7 @@ func TestNewRouter_ReadEndpoint_InvalidUUID(t *testing.T) {
 	utility.LowerCryptoParamsForTest(t)
 
 	mockRepo := &mockSecretRepository{}
-	handler := NewHandler(mockRepo, "")
+	handler := NewHandler(mockRepo, DefaultHandlerConfig())
 	router := NewRouter(handler, nil, SecurityHeadersConfig{}, DefaultRateLimitConfig())
 
 	// Invalid UUID format - should not match route
@@ -126,7 +135,7 @@ func TestNewRouter_SecurityHeaders(t *testing.T) {
 	utility.LowerCryptoParamsForTest(t)
 
 	mockRepo := &mockSecretRepository{}
-	handler := NewHandler(mockRepo, "")
+	handler := NewHandler(mockRepo, DefaultHandlerConfig())
 	router := NewRouter(handler, nil, SecurityHeadersConfig{}, DefaultRateLimitConfig())
 
 	req := httptest.NewRequest(http.MethodGet, "/health", nil)
@@ -147,7 +156,7 @@ func TestNewRouter_RedirectSlashes(t *testing.T) {
 	utility.LowerCryptoParamsForTest(t)
 
 	mockRepo := &mockSecretRepository{}
-	handler := NewHandler(mockRepo, "")
+	handler := NewHandler(mockRepo, DefaultHandlerConfig())
 	router := NewRouter(handler, nil, SecurityHeadersConfig{}, DefaultRateLimitConfig())
 
 	// Request with trailing slash should redirect
@@ -162,3 +171,388 @@ func TestNewRouter_RedirectSlashes(t *testing.T) {
 			http.StatusMovedPermanently, rr.Code)
 	}
 }
+
+func TestNewRouter_RevokeEndpoint(t *testing.T) {
+	mockRepo := &mockSecretRepository{}
+	handler := NewHandler(mockRepo, DefaultHandlerConfig())
+	router := NewRouter(handler, nil, SecurityHeadersConfig{}, DefaultRateLimitConfig())
+
+	uuid := "550e8400-e29b-41d4-a716-446655440000"
+	req := httptest.NewRequest(http.MethodDelete, "/secret/"+uuid, nil)
+	req.Header.Set("X-Delete-Token", "token")
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d", http.StatusNoContent, rr.Code)
+	}
+}
+
+func TestNewRouter_RequestEndpoints(t *testing.T) {
+	_, request, _ := utility.NewSecretRequest()
+	mockRepo := &mockSecretRepository{
+		GetSecretFunc: func(ctx context.Context, id string) ([]byte, error) {
+			return request, nil
+		},
+	}
+	handler := NewHandler(mockRepo, DefaultHandlerConfig())
+	router := NewRouter(handler, nil, SecurityHeadersConfig{}, DefaultRateLimitConfig())
+
+	req := httptest.NewRequest(http.MethodPost, "/request", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Errorf("expected status %d, got %d", http.StatusCreated, rr.Code)
+	}
+
+	uuid := "550e8400-e29b-41d4-a716-446655440000"
+	req = httptest.NewRequest(http.MethodPost, "/request/"+uuid,
+		strings.NewReader(`{"secret":"s"}`))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d", http.StatusNoContent, rr.Code)
+	}
+}
+
+func TestNewRouter_FileEndpoint(t *testing.T) {
+	utility.LowerCryptoParamsForTest(t)
+
+	repo := domain.NewMemoryRepository()
+	t.Cleanup(func() { _ = repo.Close() })
+	cfg := DefaultHandlerConfig()
+	cfg.MaxFileSize = 256 * 1024
+	router := NewRouter(NewHandler(repo, cfg), nil, SecurityHeadersConfig{}, DefaultRateLimitConfig())
+
+	testCases := []struct {
+		name           string
+		path           string
+		size           int
+		expectedStatus int
+	}{
+		{"file larger than a secret", "/create/file?filename=a.bin", 128 * 1024, http.StatusCreated},
+		{"file too large", "/create/file?filename=a.bin", 257 * 1024, http.StatusRequestEntityTooLarge},
+		{"secret body keeps its ceiling", "/create", 128 * 1024, http.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tc.path,
+				strings.NewReader(strings.Repeat("a", tc.size)))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d: %s", tc.expectedStatus, rr.Code, rr.Body)
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg.MaxFileSize = 0
+		router := NewRouter(NewHandler(repo, cfg), nil, SecurityHeadersConfig{}, DefaultRateLimitConfig())
+		req := httptest.NewRequest(http.MethodPost, "/create/file?filename=a.bin",
+			strings.NewReader("a"))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code == http.StatusCreated {
+			t.Error("expected file uploads to be refused")
+		}
+	})
+}
+
+func TestNewRouter_APIKeys(t *testing.T) {
+	utility.LowerCryptoParamsForTest(t)
+
+	repo := domain.NewMemoryRepository()
+	t.Cleanup(func() { _ = repo.Close() })
+	cfg := DefaultHandlerConfig()
+	cfg.MaxFileSize = 1024
+	handler := NewHandler(repo, cfg)
+	keys := apikey.NewFileStore(filepath.Join(t.TempDir(), "api_keys.json"))
+	handler.SetAPIKeys(keys)
+	rlCfg := DefaultRateLimitConfig()
+	// Enough for the refused requests below, and fewer than the busy key's.
+	rlCfg.PostLimit = 5
+	router := NewRouter(handler, NewMemoryRateLimitStore(), SecurityHeadersConfig{}, rlCfg)
+
+	issue := func(k apikey.Key) string {
+		t.Helper()
+		k, token, err := apikey.Issue(k)
+		if err != nil {
+			t.Fatalf("Issue() error = %v", err)
+		}
+		if err := keys.Add(context.Background(), k); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+		return token
+	}
+	limited := issue(apikey.Key{
+		Name: "limited", RateLimit: 100, MaxExpiry: time.Hour, MaxSize: 10,
+	})
+	busy := issue(apikey.Key{Name: "busy", RateLimit: 6})
+
+	serve := func(method, path, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	testCases := []struct {
+		name           string
+		path           string
+		body           string
+		token          string
+		expectedStatus int
+	}{
+		{"no key", "/create", `{"secret":"s"}`, "", http.StatusUnauthorized},
+		{"unknown key", "/create", `{"secret":"s"}`, limited + "x", http.StatusUnauthorized},
+		{"no key for a request", "/request", "", "", http.StatusUnauthorized},
+		{"no key for a file", "/create/file?filename=a", "a", "", http.StatusUnauthorized},
+		{"valid key", "/create", `{"secret":"s","expiry":"1h"}`, limited, http.StatusCreated},
+		{"expiry over quota", "/create", `{"secret":"s","expiry":"1d"}`, limited,
+			http.StatusBadRequest},
+		{"request expiry over quota", "/request", `{"expiry":"1d"}`, limited,
+			http.StatusBadRequest},
+		{"secret over quota", "/create", `{"secret":"01234567890","expiry":"1h"}`, limited,
+			http.StatusRequestEntityTooLarge},
+		{"file over quota", "/create/file?filename=a&expiry=1h", strings.Repeat("a", 11),
+			limited, http.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := serve(http.MethodPost, tc.path, tc.body, tc.token)
+			if rr.Code != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d: %s", tc.expectedStatus, rr.Code, rr.Body)
+			}
+			if rr.Code == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected a WWW-Authenticate header")
+			}
+		})
+	}
+
+	t.Run("rate limited per key", func(t *testing.T) {
+		// The key's limit applies instead of the lower per-IP one.
+		for i := range 7 {
+			rr := serve(http.MethodPost, "/create", `{"secret":"s"}`, busy)
+			want := http.StatusCreated
+			if i == 6 {
+				want = http.StatusTooManyRequests
+			}
+			if rr.Code != want {
+				t.Errorf("request %d: expected status %d, got %d", i+1, want, rr.Code)
+			}
+		}
+	})
+
+	t.Run("anonymous reads", func(t *testing.T) {
+		var created domain.CreateRes
+		rr := serve(http.MethodPost, "/create", `{"secret":"s","expiry":"1h"}`, limited)
+		if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+			t.Fatalf("could not decode create response: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/read/"+created.ID, nil)
+		req.Header.Set("X-Passcode", created.Passcode)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		var config domain.ConfigRes
+		rr = serve(http.MethodGet, "/config", "", "")
+		if err := json.NewDecoder(rr.Body).Decode(&config); err != nil || !config.APIKeyRequired {
+			t.Errorf("expected the config to require an API key, got %+v %v", config, err)
+		}
+	})
+
+	t.Run("guessing keys is rate limited per IP", func(t *testing.T) {
+		for i := range 7 {
+			req := httptest.NewRequest(http.MethodPost, "/create",
+				strings.NewReader(`{"secret":"s"}`))
+			req.RemoteAddr = "198.51.100.1:1234"
+			req.Header.Set("Authorization", "Bearer "+limited+"x")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			want := http.StatusUnauthorized
+			if i >= 5 {
+				want = http.StatusTooManyRequests
+			}
+			if rr.Code != want {
+				t.Errorf("attempt %d: expected status %d, got %d", i+1, want, rr.Code)
+			}
+		}
+
+		// Other clients aren't held back.
+		if rr := serve(http.MethodPost, "/create", `{"secret":"s"}`, "x"); rr.Code !=
+			http.StatusUnauthorized {
+			t.Errorf("expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+}
+
+func TestNewRouter_Tracing(t *testing.T) {
+	utility.LowerCryptoParamsForTest(t)
+	rec := tracing.RecordSpansForTest(t)
+
+	repo := domain.NewMemoryRepository()
+	t.Cleanup(func() { _ = repo.Close() })
+	router := NewRouter(NewHandler(repo, DefaultHandlerConfig()), nil,
+		SecurityHeadersConfig{}, DefaultRateLimitConfig())
+
+	req := httptest.NewRequest(http.MethodPost, "/create",
+		strings.NewReader(`{"secret":"s","passphrase":"correct horse"}`))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var created domain.CreateRes
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatalf("could not decode create response: %v", err)
+	}
+
+	// The caller's trace is continued.
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req = httptest.NewRequest(http.MethodPost, "/read/"+created.ID, nil)
+	req.Header.Set("X-Passcode", created.Passcode)
+	req.Header.Set("X-Passphrase", "correct horse")
+	req.Header.Set("Traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	ended := rec.Ended()
+	for _, span := range ended {
+		for _, attr := range span.Attributes() {
+			v := attr.Value.Emit()
+			if strings.Contains(v, created.ID) || strings.Contains(v, created.Passcode) ||
+				strings.Contains(v, "correct horse") {
+				t.Errorf("expected no secret in %s, got %s=%s", span.Name(), attr.Key, v)
+			}
+		}
+	}
+	// child returns the span named name started under parent, or under no
+	// span if parent is nil.
+	child := func(parent sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+		t.Helper()
+		for _, span := range ended {
+			if span.Name() != name {
+				continue
+			}
+			if parent == nil || span.Parent().SpanID() == parent.SpanContext().SpanID() {
+				return span
+			}
+		}
+		t.Fatalf("expected a %q span under %v", name, parent)
+		return nil
+	}
+
+	// Each span is the child of the one before it.
+	const readRoute = "POST /read/{id:[0-9a-fA-F-]{36}}"
+	for root, chain := range map[string][]string{
+		"POST /create": {"HandleCreate", "encrypt", "kdf"},
+		readRoute:      {"HandleRead", "decrypt", "kdf"},
+	} {
+		span := child(nil, root)
+		for _, name := range chain {
+			span = child(span, name)
+		}
+	}
+
+	if child(nil, "POST /create").Parent().IsValid() {
+		t.Error("expected the create request to start a trace")
+	}
+	read := child(nil, readRoute)
+	if got := read.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("expected the caller's trace %s, got %s", traceID, got)
+	}
+	if read.SpanKind() != trace.SpanKindServer {
+		t.Errorf("expected a server span, got %v", read.SpanKind())
+	}
+	for _, attr := range read.Attributes() {
+		if attr.Key == "http.response.status_code" && attr.Value.AsInt64() != http.StatusOK {
+			t.Errorf("expected status %d, got %d", http.StatusOK, attr.Value.AsInt64())
+		}
+	}
+}
+
+func TestNewRouter_AuditLog(t *testing.T) {
+	utility.LowerCryptoParamsForTest(t)
+
+	repo := domain.NewMemoryRepository()
+	t.Cleanup(func() { _ = repo.Close() })
+	sink, err := audit.OpenFileSink(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatalf("OpenFileSink() error = %v", err)
+	}
+	auditLog, err := audit.Open(context.Background(), sink)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { _ = auditLog.Close() })
+	handler := NewHandler(repo, DefaultHandlerConfig())
+	handler.SetAuditLog(auditLog)
+	router := NewRouter(handler, nil, SecurityHeadersConfig{}, DefaultRateLimitConfig())
+
+	serve := func(method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.RemoteAddr = "198.51.100.23:4567"
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	create := func() domain.CreateRes {
+		t.Helper()
+		var created domain.CreateRes
+		rr := serve(http.MethodPost, "/create", `{"secret":"s"}`, nil)
+		if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+			t.Fatalf("could not decode create response: %v", err)
+		}
+		return created
+	}
+
+	read := create()
+	serve(http.MethodPost, "/read/"+read.ID, "", map[string]string{"X-Passcode": read.Passcode})
+	revoked := create()
+	serve(http.MethodDelete, "/secret/"+revoked.ID, "",
+		map[string]string{"X-Delete-Token": revoked.DeleteToken})
+	burned := create()
+	for range domain.MaxReadAttempts {
+		serve(http.MethodPost, "/read/"+burned.ID, "", map[string]string{"X-Passcode": "wrong"})
+	}
+
+	type event struct {
+		typ audit.EventType
+		id  string
+	}
+	var got []event
+	err = sink.Entries(context.Background(), 0, func(e audit.Entry) error {
+		got = append(got, event{e.Event, e.Secret})
+		if e.RequestID == "" || e.ClientIP != "198.51.100.0/24" {
+			t.Errorf("expected the request ID and client network on %+v", e)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Entries() error = %v", err)
+	}
+	want := []event{
+		{audit.EventCreated, audit.HashID(read.ID)},
+		{audit.EventRead, audit.HashID(read.ID)},
+		{audit.EventCreated, audit.HashID(revoked.ID)},
+		{audit.EventRevoked, audit.HashID(revoked.ID)},
+		{audit.EventCreated, audit.HashID(burned.ID)},
+		{audit.EventBurned, audit.HashID(burned.ID)},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected events %v, got %v", want, got)
+	}
+}
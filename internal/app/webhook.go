@@ -0,0 +1,351 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/smallwat3r/secretapi/internal/domain"
+	"github.com/smallwat3r/secretapi/internal/logging"
+
+	"github.com/google/uuid"
+)
+
+// WebhookEvent is the type of an event delivered to a secret's notify_url.
+type WebhookEvent string
+
+const (
+	// EventRead is sent each time a secret is read successfully.
+	EventRead WebhookEvent = "secret.read"
+	// EventBurned is sent when a secret is deleted after too many wrong
+	// passcodes.
+	EventBurned WebhookEvent = "secret.burned"
+	// EventExpired is sent when a secret expires before its last view.
+	EventExpired WebhookEvent = "secret.expired"
+)
+
+// Headers set on webhook deliveries. The signature is the hex encoded
+// HMAC-SHA256 of "<timestamp>.<body>", keyed with the secret's notify_secret.
+const (
+	WebhookEventHeader     = "X-SecretAPI-Event"
+	WebhookDeliveryHeader  = "X-SecretAPI-Delivery"
+	WebhookTimestampHeader = "X-SecretAPI-Timestamp"
+	WebhookSignatureHeader = "X-SecretAPI-Signature"
+)
+
+const (
+	// maxNotifyURLLength is the maximum length of a notify_url.
+	maxNotifyURLLength = 2048
+	// expiredBatchSize is how many expired secrets are fetched at once.
+	expiredBatchSize = 100
+)
+
+// WebhookPayload is the JSON body of a webhook delivery.
+type WebhookPayload struct {
+	Event          WebhookEvent `json:"event"`
+	ID             string       `json:"id"`
+	RemainingViews *int         `json:"remaining_views,omitempty"` // only for secret.read
+	OccurredAt     time.Time    `json:"occurred_at"`
+}
+
+// Notifier delivers events about secrets created with a notify_url.
+type Notifier interface {
+	// ValidateURL reports whether url can be used as a notify_url.
+	ValidateURL(url string) error
+	// Notify queues an event for delivery. It never blocks on the network.
+	Notify(hook *domain.Webhook, payload WebhookPayload)
+}
+
+// WebhookConfig holds configuration for webhook delivery.
+type WebhookConfig struct {
+	Workers      int           // concurrent deliveries
+	QueueSize    int           // events waiting for a worker before new ones are dropped
+	MaxAttempts  int           // delivery attempts per event, including the first
+	RetryBackoff time.Duration // wait before the first retry, doubled on each retry
+	Timeout      time.Duration // timeout of a single delivery attempt
+	PollInterval time.Duration // how often expired secrets are looked for
+	AllowPrivate bool          // allow plain HTTP and private addresses, for local testing
+}
+
+// DefaultWebhookConfig returns sensible defaults for webhook delivery.
+func DefaultWebhookConfig() WebhookConfig {
+	return WebhookConfig{
+		Workers:      4,
+		QueueSize:    1000,
+		MaxAttempts:  5,
+		RetryBackoff: 5 * time.Second,
+		Timeout:      10 * time.Second,
+		PollInterval: 30 * time.Second,
+	}
+}
+
+type delivery struct {
+	hook    domain.Webhook
+	payload WebhookPayload
+	body    []byte
+	id      string
+	attempt int
+}
+
+// WebhookNotifier delivers webhook events from a pool of background workers,
+// retrying failed deliveries with exponential backoff. Events still queued or
+// waiting for a retry when the notifier stops are lost.
+type WebhookNotifier struct {
+	repo   domain.SecretRepository
+	cfg    WebhookConfig
+	client *http.Client
+	queue  chan *delivery
+	wg     sync.WaitGroup
+}
+
+// NewWebhookNotifier returns a notifier that isn't delivering anything yet,
+// see Run.
+func NewWebhookNotifier(repo domain.SecretRepository, cfg WebhookConfig) *WebhookNotifier {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivate {
+		// Checking the resolved address when dialing, rather than the host
+		// when the secret is created, also covers DNS rebinding.
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublicAddr(addrPort.Addr()) {
+				return fmt.Errorf("webhook address %s is not public", addrPort.Addr())
+			}
+			return nil
+		}
+	}
+
+	return &WebhookNotifier{
+		repo: repo,
+		cfg:  cfg,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			Transport: &http.Transport{
+				Proxy:               nil,
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: cfg.Timeout,
+				MaxIdleConnsPerHost: 2,
+			},
+			// A redirect could point anywhere, receivers must answer directly.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		queue: make(chan *delivery, cfg.QueueSize),
+	}
+}
+
+// Run delivers events and reports expired secrets until ctx is cancelled,
+// then waits for in-flight deliveries to finish.
+func (n *WebhookNotifier) Run(ctx context.Context) {
+	for i := 0; i < n.cfg.Workers; i++ {
+		n.wg.Add(1)
+		go n.worker(ctx)
+	}
+
+	ticker := time.NewTicker(n.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			n.wg.Wait()
+			return
+		case <-ticker.C:
+			n.notifyExpired(ctx)
+		}
+	}
+}
+
+func (n *WebhookNotifier) ValidateURL(raw string) error {
+	if len(raw) > maxNotifyURLLength {
+		return errors.New("notify_url is too long")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return errors.New("notify_url must be an absolute URL")
+	}
+	if u.User != nil {
+		return errors.New("notify_url must not contain credentials")
+	}
+	if u.Scheme != "https" && !(n.cfg.AllowPrivate && u.Scheme == "http") {
+		return errors.New("notify_url must use https")
+	}
+	if addr, err := netip.ParseAddr(u.Hostname()); err == nil &&
+		!n.cfg.AllowPrivate && !isPublicAddr(addr) {
+		return errors.New("notify_url must not point to a private address")
+	}
+	return nil
+}
+
+func (n *WebhookNotifier) Notify(hook *domain.Webhook, payload WebhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("failed to encode webhook payload", logging.ID(payload.ID), logging.Err(err))
+		return
+	}
+	n.enqueue(&delivery{
+		hook:    *hook,
+		payload: payload,
+		body:    body,
+		id:      uuid.NewString(),
+	})
+}
+
+func (n *WebhookNotifier) enqueue(d *delivery) {
+	select {
+	case n.queue <- d:
+	default:
+		slog.Warn("webhook queue full, dropping event", logging.ID(d.payload.ID),
+			slog.String("event", string(d.payload.Event)))
+	}
+}
+
+func (n *WebhookNotifier) worker(ctx context.Context) {
+	defer n.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d := <-n.queue:
+			n.deliver(ctx, d)
+		}
+	}
+}
+
+// deliver makes one delivery attempt and schedules a retry if it failed with
+// an error worth retrying, unless ctx is cancelled by then.
+func (n *WebhookNotifier) deliver(ctx context.Context, d *delivery) {
+	d.attempt++
+	retry, err := n.send(ctx, d)
+	if err == nil {
+		slog.Info("webhook delivered", logging.ID(d.payload.ID),
+			slog.String("event", string(d.payload.Event)), slog.Int("attempt", d.attempt))
+		return
+	}
+	if !retry || d.attempt >= n.cfg.MaxAttempts {
+		slog.Warn("webhook delivery failed, giving up", logging.ID(d.payload.ID),
+			slog.String("event", string(d.payload.Event)), slog.Int("attempt", d.attempt),
+			logging.Err(err))
+		return
+	}
+
+	backoff := n.cfg.RetryBackoff << (d.attempt - 1)
+	slog.Warn("webhook delivery failed, retrying", logging.ID(d.payload.ID),
+		slog.String("event", string(d.payload.Event)), slog.Int("attempt", d.attempt),
+		slog.Duration("backoff", backoff), logging.Err(err))
+	time.AfterFunc(backoff, func() {
+		if ctx.Err() == nil {
+			n.enqueue(d)
+		}
+	})
+}
+
+// send posts a signed delivery and reports whether a failure is worth
+// retrying.
+func (n *WebhookNotifier) send(ctx context.Context, d *delivery) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.hook.URL,
+		bytes.NewReader(d.body))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "secretapi-webhook")
+	req.Header.Set(WebhookEventHeader, string(d.payload.Event))
+	req.Header.Set(WebhookDeliveryHeader, d.id)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(d.hook.Key, timestamp, d.body))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_ = resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+}
+
+// notifyExpired sends an expiry event for every secret with a webhook that
+// expired since the last poll.
+func (n *WebhookNotifier) notifyExpired(ctx context.Context) {
+	for {
+		ids, err := n.repo.DueWebhooks(ctx, time.Now(), expiredBatchSize)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to list expired webhooks", logging.Err(err))
+			return
+		}
+
+		pending := 0
+		for _, id := range ids {
+			// Clocks may disagree slightly with Redis about the expiry,
+			// leave the secret for the next poll if it's still there.
+			if _, err := n.repo.GetSecret(ctx, id); err == nil {
+				pending++
+				continue
+			}
+			hook, err := n.repo.TakeWebhook(ctx, id)
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to take webhook", logging.ID(id), logging.Err(err))
+				continue
+			}
+			// Another server instance got there first.
+			if hook == nil {
+				continue
+			}
+			n.Notify(hook, WebhookPayload{
+				Event:      EventExpired,
+				ID:         id,
+				OccurredAt: time.Now().UTC(),
+			})
+		}
+
+		if len(ids) < expiredBatchSize || pending == len(ids) {
+			return
+		}
+	}
+}
+
+// SignWebhook returns the signature header value of a webhook delivery, so
+// receivers can check it came from this server and wasn't tampered with.
+func SignWebhook(key []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// isPublicAddr reports whether addr is routable on the public internet.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() &&
+		!cgnatPrefix.Contains(addr)
+}
+
+// cgnatPrefix is the shared address space used by carrier-grade NAT, which
+// netip.Addr.IsPrivate doesn't cover.
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
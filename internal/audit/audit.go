@@ -0,0 +1,324 @@
+// Package audit keeps a tamper-evident record of when secrets were created,
+// read, burned, revoked or expired.
+//
+// The log is a chain of JSON entries, each holding the hash of the one
+// before it, so altering, removing or reordering an entry breaks every hash
+// after it. Entries never hold the content of a secret nor its ID, only a
+// hash of the ID.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/smallwat3r/secretapi/internal/logging"
+)
+
+// EventType is what happened to a secret.
+type EventType string
+
+const (
+	EventCreated EventType = "created"
+	EventRead    EventType = "read"
+	EventBurned  EventType = "burned"  // deleted after too many wrong passcodes
+	EventRevoked EventType = "revoked" // deleted with its delete token
+	EventExpired EventType = "expired" // expired before its last view
+)
+
+// genesisHash is the previous hash of the first entry of a log.
+const genesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// expiryInterval is how often the log looks for secrets that expired.
+const expiryInterval = time.Minute
+
+// ErrConflict is returned by Sink.Append when another writer appended an
+// entry first.
+var ErrConflict = errors.New("audit log was appended to concurrently")
+
+// Entry is one line of the audit log.
+type Entry struct {
+	Seq            uint64     `json:"seq"`
+	Time           time.Time  `json:"time"`
+	Event          EventType  `json:"event"`
+	Secret         string     `json:"secret"`                    // HashID of the secret's ID
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`      // created only
+	RemainingViews *int64     `json:"remaining_views,omitempty"` // read only
+	ClientIP       string     `json:"client_ip,omitempty"`       // network of the client, see IPBucket
+	RequestID      string     `json:"request_id,omitempty"`
+	Prev           string     `json:"prev"` // hash of the previous entry
+	Hash           string     `json:"hash"` // hash of this entry
+}
+
+// computeHash returns the hash chaining e to the entry before it: the
+// SHA-256 of the entry's JSON encoding without its hash.
+func (e Entry) computeHash() (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Event is an event to record. ID and ClientIP are only recorded hashed
+// and bucketed.
+type Event struct {
+	Type           EventType
+	ID             string
+	ExpiresAt      time.Time // created only
+	RemainingViews int64     // read only
+	ClientIP       string
+	RequestID      string
+}
+
+// Sink stores the entries of an audit log.
+type Sink interface {
+	// Entries calls fn with each entry after the one numbered after, in
+	// order, until fn returns an error.
+	Entries(ctx context.Context, after uint64, fn func(Entry) error) error
+	// Append appends an entry. It returns ErrConflict if the log doesn't end
+	// with the entry before it anymore.
+	Append(ctx context.Context, e Entry) error
+	Close() error
+}
+
+// exclusiveSink is a Sink that only the Log that opened it appends to, such
+// as a FileSink. The Log then never needs to read it again.
+type exclusiveSink interface {
+	Sink
+	exclusive()
+}
+
+// Log records events to a Sink. It also records the expiry of the secrets
+// created in the log that were neither read to the last view, burned nor
+// revoked by then.
+type Log struct {
+	sink   Sink
+	shared bool // other servers may append to sink
+	now    func() time.Time
+
+	mu      sync.Mutex
+	last    Entry
+	pending map[string]time.Time // hashed IDs of live secrets, to their expiry
+}
+
+// Open replays the log of sink, and returns a Log appending to it.
+func Open(ctx context.Context, sink Sink) (*Log, error) {
+	l := &Log{
+		sink:    sink,
+		now:     time.Now,
+		last:    Entry{Hash: genesisHash},
+		pending: make(map[string]time.Time),
+	}
+	_, exclusive := sink.(exclusiveSink)
+	l.shared = !exclusive
+	if err := l.catchUp(ctx); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Close closes the sink.
+func (l *Log) Close() error {
+	return l.sink.Close()
+}
+
+// Record appends an event to the log.
+func (l *Log) Record(ctx context.Context, ev Event) error {
+	e := Entry{
+		Event:     ev.Type,
+		Secret:    HashID(ev.ID),
+		ClientIP:  IPBucket(ev.ClientIP),
+		RequestID: ev.RequestID,
+	}
+	switch ev.Type {
+	case EventCreated:
+		expiresAt := ev.ExpiresAt.UTC()
+		e.ExpiresAt = &expiresAt
+	case EventRead:
+		remaining := ev.RemainingViews
+		e.RemainingViews = &remaining
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.append(ctx, e)
+}
+
+// Run records the expiry of secrets until ctx is done.
+func (l *Log) Run(ctx context.Context) {
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.recordExpired(ctx); err != nil {
+				slog.ErrorContext(ctx, "failed to record expired secrets", logging.Err(err))
+			}
+		}
+	}
+}
+
+// recordExpired records an expiry for every secret past its expiry.
+func (l *Log) recordExpired(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Another server may have recorded some of them already.
+	if l.shared {
+		if err := l.catchUp(ctx); err != nil {
+			return err
+		}
+	}
+	now := l.now()
+	for secret, expiresAt := range l.pending {
+		if now.Before(expiresAt) {
+			continue
+		}
+		// The expiry is when it happened, not when it was noticed.
+		err := l.append(ctx, Entry{Time: expiresAt, Event: EventExpired, Secret: secret})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// append chains e to the log and appends it, catching up with entries
+// appended by other servers first if needed. The caller must hold l.mu.
+func (l *Log) append(ctx context.Context, e Entry) error {
+	for {
+		e.Seq = l.last.Seq + 1
+		e.Prev = l.last.Hash
+		if e.Time.IsZero() || e.Event != EventExpired {
+			e.Time = l.now().UTC()
+		}
+		var err error
+		if e.Hash, err = e.computeHash(); err != nil {
+			return err
+		}
+
+		err = l.sink.Append(ctx, e)
+		if err == nil {
+			l.apply(e)
+			return nil
+		}
+		if !errors.Is(err, ErrConflict) {
+			return err
+		}
+		if err := l.catchUp(ctx); err != nil {
+			return err
+		}
+		if e.Event == EventExpired {
+			if _, ok := l.pending[e.Secret]; !ok {
+				return nil
+			}
+		}
+	}
+}
+
+// catchUp applies the entries appended since the last one seen, checking
+// they chain. The caller must hold l.mu, or own l.
+func (l *Log) catchUp(ctx context.Context) error {
+	return l.sink.Entries(ctx, l.last.Seq, func(e Entry) error {
+		if err := checkEntry(l.last, e); err != nil {
+			return err
+		}
+		l.apply(e)
+		return nil
+	})
+}
+
+// apply updates the head of the log and the pending expiries with e.
+func (l *Log) apply(e Entry) {
+	l.last = e
+	switch {
+	case e.Event == EventCreated && e.ExpiresAt != nil:
+		l.pending[e.Secret] = *e.ExpiresAt
+	case e.Event == EventRead && e.RemainingViews != nil && *e.RemainingViews > 0:
+	case e.Event != EventCreated:
+		delete(l.pending, e.Secret)
+	}
+}
+
+// checkEntry reports whether e is a valid successor of prev.
+func checkEntry(prev, e Entry) error {
+	if e.Seq != prev.Seq+1 {
+		return fmt.Errorf("entry %d follows entry %d", e.Seq, prev.Seq)
+	}
+	if e.Prev != prev.Hash {
+		return fmt.Errorf("entry %d doesn't chain to entry %d", e.Seq, prev.Seq)
+	}
+	hash, err := e.computeHash()
+	if err != nil {
+		return fmt.Errorf("entry %d: %w", e.Seq, err)
+	}
+	if hash != e.Hash {
+		return fmt.Errorf("entry %d was altered", e.Seq)
+	}
+	return nil
+}
+
+// VerifyResult describes a verified audit log.
+type VerifyResult struct {
+	Entries uint64
+	Head    string // hash of the last entry
+}
+
+// Verify checks that every entry of sink chains to the one before it and
+// wasn't altered. Recording the head of a verified log elsewhere, and
+// checking a later log still contains it, also catches a log rewritten from
+// scratch.
+func Verify(ctx context.Context, sink Sink) (VerifyResult, error) {
+	last := Entry{Hash: genesisHash}
+	err := sink.Entries(ctx, 0, func(e Entry) error {
+		if err := checkEntry(last, e); err != nil {
+			return err
+		}
+		last = e
+		return nil
+	})
+	return VerifyResult{Entries: last.Seq, Head: last.Hash}, err
+}
+
+// HashID returns the hex encoded SHA-256 of a secret ID. Its first 16
+// characters are the ID logged with LOG_SECRET_IDS=hash.
+func HashID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// IPBucket returns the network of a client address, the /24 of an IPv4 or
+// the /48 of an IPv6 address, which tells where a request came from without
+// identifying the client. It returns "" for an invalid address.
+func IPBucket(addr string) string {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		ap, err := netip.ParseAddrPort(addr)
+		if err != nil {
+			return ""
+		}
+		ip = ap.Addr()
+	}
+	ip = ip.Unmap()
+	bits := 48
+	if ip.Is4() {
+		bits = 24
+	}
+	prefix, err := ip.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.String()
+}
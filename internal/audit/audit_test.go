@@ -0,0 +1,290 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func openTestFileLog(t *testing.T, path string) *Log {
+	t.Helper()
+	sink, err := OpenFileSink(path)
+	if err != nil {
+		t.Fatalf("OpenFileSink() error = %v", err)
+	}
+	l, err := Open(context.Background(), sink)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	return l
+}
+
+func record(t *testing.T, l *Log, ev Event) {
+	t.Helper()
+	if err := l.Record(context.Background(), ev); err != nil {
+		t.Fatalf("Record(%s) error = %v", ev.Type, err)
+	}
+}
+
+func entries(t *testing.T, sink Sink) []Entry {
+	t.Helper()
+	var all []Entry
+	if err := sink.Entries(context.Background(), 0, func(e Entry) error {
+		all = append(all, e)
+		return nil
+	}); err != nil {
+		t.Fatalf("Entries() error = %v", err)
+	}
+	return all
+}
+
+func TestLog_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := openTestFileLog(t, path)
+	expiresAt := time.Now().Add(time.Hour)
+
+	record(t, l, Event{Type: EventCreated, ID: "secret-1", ExpiresAt: expiresAt,
+		ClientIP: "203.0.113.7:51234", RequestID: "req-1"})
+	record(t, l, Event{Type: EventRead, ID: "secret-1", RemainingViews: 0,
+		ClientIP: "2001:db8:1:2::7"})
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// A reopened log carries on with the chain.
+	l = openTestFileLog(t, path)
+	record(t, l, Event{Type: EventCreated, ID: "secret-2", ExpiresAt: expiresAt})
+	record(t, l, Event{Type: EventRevoked, ID: "secret-2"})
+
+	content, _ := os.ReadFile(path)
+	if bytes.Contains(content, []byte("secret-1")) || bytes.Contains(content, []byte("203.0.113.7")) {
+		t.Errorf("expected no secret ID nor client address in the log, got %s", content)
+	}
+
+	all := entries(t, l.sink)
+	if len(all) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(all))
+	}
+	first := all[0]
+	if first.Event != EventCreated || first.Secret != HashID("secret-1") ||
+		first.ClientIP != "203.0.113.0/24" || first.RequestID != "req-1" ||
+		first.ExpiresAt == nil || first.Prev != genesisHash {
+		t.Errorf("unexpected first entry %+v", first)
+	}
+	if all[1].ClientIP != "2001:db8:1::/48" || *all[1].RemainingViews != 0 {
+		t.Errorf("unexpected read entry %+v", all[1])
+	}
+
+	res, err := Verify(context.Background(), l.sink)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if res.Entries != 4 || res.Head != all[3].Hash {
+		t.Errorf("Verify() = %+v", res)
+	}
+}
+
+func TestVerify_Tampered(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	l := openTestFileLog(t, path)
+	for _, id := range []string{"a", "b", "c"} {
+		record(t, l, Event{Type: EventCreated, ID: id, ExpiresAt: time.Now().Add(time.Hour)})
+	}
+	content, _ := os.ReadFile(path)
+	lines := strings.SplitAfter(strings.TrimSuffix(string(content), "\n"), "\n")
+
+	tests := map[string]string{
+		"altered":        strings.Replace(string(content), `"created"`, `"revoked"`, 1),
+		"removed":        lines[0] + lines[2],
+		"reordered":      lines[1] + lines[0] + lines[2],
+		"truncated head": lines[1] + lines[2],
+		"extra field":    strings.Replace(string(content), `"seq":2,`, `"seq":2,"note":"x",`, 1),
+	}
+	for name, tampered := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(tampered), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			sink, err := OpenFileSink(path)
+			if err != nil {
+				t.Fatalf("OpenFileSink() error = %v", err)
+			}
+			defer sink.Close()
+			if _, err := Verify(context.Background(), sink); err == nil {
+				t.Error("expected Verify() to fail")
+			}
+			if _, err := Open(context.Background(), sink); err == nil {
+				t.Error("expected Open() to fail")
+			}
+		})
+	}
+}
+
+func TestLog_Expired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := openTestFileLog(t, path)
+	now := time.Now()
+	past := now.Add(-time.Minute)
+
+	record(t, l, Event{Type: EventCreated, ID: "expired", ExpiresAt: past})
+	record(t, l, Event{Type: EventCreated, ID: "read", ExpiresAt: past})
+	record(t, l, Event{Type: EventRead, ID: "read", RemainingViews: 0})
+	record(t, l, Event{Type: EventCreated, ID: "partly-read", ExpiresAt: past})
+	record(t, l, Event{Type: EventRead, ID: "partly-read", RemainingViews: 1})
+	record(t, l, Event{Type: EventCreated, ID: "burned", ExpiresAt: past})
+	record(t, l, Event{Type: EventBurned, ID: "burned"})
+	record(t, l, Event{Type: EventCreated, ID: "live", ExpiresAt: now.Add(time.Hour)})
+
+	if err := l.recordExpired(context.Background()); err != nil {
+		t.Fatalf("recordExpired() error = %v", err)
+	}
+	// Replaying the log finds nothing more to expire.
+	l = openTestFileLog(t, path)
+	if err := l.recordExpired(context.Background()); err != nil {
+		t.Fatalf("recordExpired() error = %v", err)
+	}
+
+	expired := make(map[string]Entry)
+	for _, e := range entries(t, l.sink) {
+		if e.Event == EventExpired {
+			if _, ok := expired[e.Secret]; ok {
+				t.Errorf("expected a single expiry of %s", e.Secret)
+			}
+			expired[e.Secret] = e
+		}
+	}
+	if len(expired) != 2 {
+		t.Errorf("expected 2 expired secrets, got %d", len(expired))
+	}
+	for _, id := range []string{"expired", "partly-read"} {
+		e, ok := expired[HashID(id)]
+		if !ok {
+			t.Errorf("expected %s to expire", id)
+			continue
+		}
+		if !e.Time.Equal(past.UTC()) {
+			t.Errorf("expected %s to expire at %v, got %v", id, past, e.Time)
+		}
+	}
+	if _, err := Verify(context.Background(), l.sink); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+}
+
+// countingSink counts the times a sink is read.
+type countingSink struct {
+	*FileSink
+	reads int
+}
+
+func (s *countingSink) Entries(ctx context.Context, after uint64, fn func(Entry) error) error {
+	s.reads++
+	return s.FileSink.Entries(ctx, after, fn)
+}
+
+func TestLog_ExpiredFileNotReread(t *testing.T) {
+	fs, err := OpenFileSink(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatalf("OpenFileSink() error = %v", err)
+	}
+	sink := &countingSink{FileSink: fs}
+	l, err := Open(context.Background(), sink)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	record(t, l, Event{Type: EventCreated, ID: "expired", ExpiresAt: time.Now()})
+	for range 2 {
+		if err := l.recordExpired(context.Background()); err != nil {
+			t.Fatalf("recordExpired() error = %v", err)
+		}
+	}
+	// Only Open reads the file, no one else appends to it.
+	if sink.reads != 1 {
+		t.Errorf("expected the file to be read once, got %d", sink.reads)
+	}
+	if all := entries(t, fs); len(all) != 2 || all[1].Event != EventExpired {
+		t.Errorf("expected the secret to expire once, got %+v", all)
+	}
+}
+
+func TestLog_RedisShared(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	ctx := context.Background()
+
+	// Two servers sharing one stream.
+	a, err := Open(ctx, NewRedisSink(rdb))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	b, err := Open(ctx, NewRedisSink(rdb))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	record(t, a, Event{Type: EventCreated, ID: "x", ExpiresAt: past})
+	record(t, b, Event{Type: EventCreated, ID: "y", ExpiresAt: time.Now().Add(time.Hour)})
+	record(t, a, Event{Type: EventRevoked, ID: "y"})
+	if err := b.recordExpired(ctx); err != nil {
+		t.Fatalf("recordExpired() error = %v", err)
+	}
+	if err := a.recordExpired(ctx); err != nil {
+		t.Fatalf("recordExpired() error = %v", err)
+	}
+
+	all := entries(t, NewRedisSink(rdb))
+	var events []EventType
+	for _, e := range all {
+		events = append(events, e.Event)
+	}
+	want := []EventType{EventCreated, EventCreated, EventRevoked, EventExpired}
+	if !slices.Equal(events, want) {
+		t.Errorf("expected events %v, got %v", want, events)
+	}
+	res, err := Verify(ctx, NewRedisSink(rdb))
+	if err != nil || res.Entries != 4 {
+		t.Errorf("Verify() = %+v, %v", res, err)
+	}
+
+	mr.Del(redisStreamKey)
+	for _, e := range append(all[:1], all[2:]...) {
+		if err := NewRedisSink(rdb).Append(ctx, e); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+	if _, err := Verify(ctx, NewRedisSink(rdb)); err == nil {
+		t.Error("expected Verify() to fail with an entry missing")
+	}
+}
+
+func TestIPBucket(t *testing.T) {
+	tests := map[string]string{
+		"203.0.113.7":          "203.0.113.0/24",
+		"203.0.113.7:443":      "203.0.113.0/24",
+		"::ffff:203.0.113.7":   "203.0.113.0/24",
+		"2001:db8:1:2::7":      "2001:db8:1::/48",
+		"[2001:db8:1:2::7]:80": "2001:db8:1::/48",
+		"":                     "",
+		"not an ip":            "",
+	}
+	for addr, want := range tests {
+		if got := IPBucket(addr); got != want {
+			t.Errorf("IPBucket(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
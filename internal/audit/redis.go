@@ -0,0 +1,82 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisStreamKey is the stream holding the audit log.
+const redisStreamKey = "audit:log"
+
+// redisPageSize is how many entries are read from the stream at once.
+const redisPageSize = 500
+
+// RedisSink appends the entries of an audit log to a Redis stream, which
+// several servers can share. Each entry is stored under the stream ID
+// "0-<seq>", so Redis refuses an entry numbered like one already appended.
+type RedisSink struct {
+	rdb redis.UniversalClient
+}
+
+// NewRedisSink returns a sink appending to the audit stream of rdb. Closing
+// it leaves rdb open.
+func NewRedisSink(rdb redis.UniversalClient) *RedisSink {
+	return &RedisSink{rdb: rdb}
+}
+
+func (s *RedisSink) Entries(ctx context.Context, after uint64, fn func(Entry) error) error {
+	start := streamID(after + 1)
+	for {
+		msgs, err := s.rdb.XRangeN(ctx, redisStreamKey, start, "+", redisPageSize).Result()
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			raw, _ := msg.Values["entry"].(string)
+			e, err := decodeEntry([]byte(raw))
+			if err != nil {
+				return fmt.Errorf("stream entry %s: %w", msg.ID, err)
+			}
+			if msg.ID != streamID(e.Seq) {
+				return fmt.Errorf("stream entry %s holds entry %d", msg.ID, e.Seq)
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		if len(msgs) < redisPageSize {
+			return nil
+		}
+		last, _ := strconv.ParseUint(strings.TrimPrefix(msgs[len(msgs)-1].ID, "0-"), 10, 64)
+		start = streamID(last + 1)
+	}
+}
+
+func (s *RedisSink) Append(ctx context.Context, e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	err = s.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: redisStreamKey,
+		ID:     streamID(e.Seq),
+		Values: []string{"entry", string(b)},
+	}).Err()
+	if err != nil && strings.Contains(err.Error(), "equal or smaller") {
+		return ErrConflict
+	}
+	return err
+}
+
+func (s *RedisSink) Close() error {
+	return nil
+}
+
+func streamID(seq uint64) string {
+	return "0-" + strconv.FormatUint(seq, 10)
+}
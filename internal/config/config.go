@@ -1,12 +1,20 @@
 package config
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/smallwat3r/secretapi/internal/domain"
+	"github.com/smallwat3r/secretapi/internal/utility"
 )
 
 // Config holds all application configuration.
@@ -19,6 +27,19 @@ type Config struct {
 	IdleTimeout       time.Duration
 	MaxHeaderBytes    int
 
+	// Logging settings
+	LogFormat    string     // "text" | "json"
+	LogLevel     slog.Level // LOG_LEVEL, e.g. "debug" or "warn"
+	LogSecretIDs string     // "hash" | "redact" | "plain"
+
+	// Storage settings
+	StorageBackend string // "redis" | "memory" | "bolt"
+	BoltPath       string // database file of the bolt backend
+
+	// Encryption at rest, the first key wraps new blobs and the others are
+	// only kept to unwrap older ones
+	MasterKeys []domain.MasterKey // MASTER_KEYS, or MASTER_KEYS_FILE
+
 	// Redis settings
 	RedisURL          string
 	RedisPoolSize     int
@@ -28,6 +49,12 @@ type Config struct {
 	RedisWriteTimeout time.Duration
 	RedisPoolTimeout  time.Duration
 
+	// Redis high availability, REDIS_URL then only supplies credentials, the
+	// database and TLS
+	RedisSentinelMaster string   // master name to resolve through Sentinel
+	RedisSentinelAddrs  []string // Sentinel nodes (host:port)
+	RedisClusterAddrs   []string // Cluster seed nodes (host:port)
+
 	// Shutdown settings
 	ShutdownTimeout time.Duration
 
@@ -36,10 +63,47 @@ type Config struct {
 	CanonicalHost    string // canonical hostname for HTTPS redirects (CANONICAL_HOST)
 	TrustedProxyCIDR string // CIDR from which proxy headers are trusted (TRUSTED_PROXY_CIDR)
 
+	// Secret limits
+	ExpiryOptions   []string      // accepted expiries, e.g. "15m", "1h" or "7d"
+	DefaultExpiry   string        // one of ExpiryOptions
+	MinExpiry       time.Duration // shortest expiry that isn't an option
+	MaxExpiry       time.Duration // longest expiry that isn't an option
+	MaxSecretSize   int           // bytes of plaintext
+	MaxFileSize     int64         // bytes of a file secret, 0 disables them
+	MaxReadAttempts int           // wrong passcodes before a secret is deleted
+
 	// UI settings
 	DefaultTheme string // "" | "light" | "dark"
+
+	// Webhook settings
+	WebhooksEnabled     bool // accept notify_url on create (WEBHOOKS_ENABLED=1)
+	WebhookAllowPrivate bool // allow http and private addresses (WEBHOOK_ALLOW_PRIVATE=1)
+
+	// Metrics settings
+	MetricsEnabled bool   // serve /metrics (METRICS_ENABLED=1)
+	MetricsAddr    string // serve /metrics on this address only, e.g. ":9090" (METRICS_ADDR)
+
+	// Audit log settings
+	AuditLog     string // "" (disabled) | "file" | "redis"
+	AuditLogFile string // file of the "file" audit log
+
+	// API key settings, creating secrets requires a key once a store is set
+	APIKeys     string // "" (disabled) | "file" | "redis"
+	APIKeysFile string // file of the "file" key store
+
+	// Tracing settings, traces are exported once an endpoint is set
+	TracingEndpoint  string  // OTLP/HTTP endpoint (OTEL_EXPORTER_OTLP_ENDPOINT)
+	TraceSampleRatio float64 // share of new traces sampled, 0 to 1 (TRACE_SAMPLE_RATIO)
 }
 
+// Upper bounds of the secret limits. Request bodies are read into memory, and
+// a passcode must stay hard to guess.
+const (
+	maxSecretSizeLimit   = 10 << 20 // 10 MB
+	maxFileSizeLimit     = 1 << 30  // 1 GB, files are streamed
+	maxReadAttemptsLimit = 100
+)
+
 // DefaultConfig returns a Config with sensible defaults.
 func DefaultConfig() Config {
 	return Config{
@@ -50,6 +114,13 @@ func DefaultConfig() Config {
 		IdleTimeout:       120 * time.Second,
 		MaxHeaderBytes:    1 << 20, // 1 MB
 
+		LogFormat:    "text",
+		LogLevel:     slog.LevelInfo,
+		LogSecretIDs: "hash",
+
+		StorageBackend: "redis",
+		BoltPath:       "secretapi.db",
+
 		RedisURL:          "redis://localhost:6379/0",
 		RedisPoolSize:     10,
 		RedisMinIdle:      2,
@@ -61,6 +132,19 @@ func DefaultConfig() Config {
 		ShutdownTimeout: 5 * time.Second,
 
 		RequireHTTPS: true, // secure default: enforce HTTPS
+
+		ExpiryOptions:   domain.ExpiryOptions,
+		DefaultExpiry:   domain.DefaultExpiry,
+		MinExpiry:       domain.MinExpiry,
+		MaxExpiry:       domain.MaxExpiry,
+		MaxSecretSize:   domain.MaxSecretSize,
+		MaxFileSize:     domain.MaxFileSize,
+		MaxReadAttempts: domain.MaxReadAttempts,
+
+		AuditLogFile: "audit.log",
+		APIKeysFile:  "api_keys.json",
+
+		TraceSampleRatio: 1,
 	}
 }
 
@@ -76,6 +160,61 @@ func Load() (Config, error) {
 		cfg.Port = port
 	}
 
+	// Logging settings
+	if format := os.Getenv("LOG_FORMAT"); format != "" {
+		if format != "text" && format != "json" {
+			return Config{}, fmt.Errorf("LOG_FORMAT must be 'text' or 'json', got %q", format)
+		}
+		cfg.LogFormat = format
+	}
+
+	if level := os.Getenv("LOG_LEVEL"); level != "" {
+		if err := cfg.LogLevel.UnmarshalText([]byte(level)); err != nil {
+			return Config{}, fmt.Errorf(
+				"LOG_LEVEL must be 'debug', 'info', 'warn' or 'error', got %q", level)
+		}
+	}
+
+	if ids := os.Getenv("LOG_SECRET_IDS"); ids != "" {
+		if ids != "hash" && ids != "redact" && ids != "plain" {
+			return Config{}, fmt.Errorf(
+				"LOG_SECRET_IDS must be 'hash', 'redact' or 'plain', got %q", ids)
+		}
+		cfg.LogSecretIDs = ids
+	}
+
+	// Storage settings
+	if backend := os.Getenv("STORAGE_BACKEND"); backend != "" {
+		if backend != "redis" && backend != "memory" && backend != "bolt" {
+			return Config{}, fmt.Errorf(
+				"STORAGE_BACKEND must be 'redis', 'memory' or 'bolt', got %q", backend)
+		}
+		cfg.StorageBackend = backend
+	}
+
+	if boltPath := os.Getenv("BOLT_PATH"); boltPath != "" {
+		cfg.BoltPath = boltPath
+	}
+
+	// Encryption at rest
+	keys, keysFile := os.Getenv("MASTER_KEYS"), os.Getenv("MASTER_KEYS_FILE")
+	if keys != "" && keysFile != "" {
+		return Config{}, errors.New("MASTER_KEYS and MASTER_KEYS_FILE can't be used together")
+	}
+	if keysFile != "" {
+		data, err := os.ReadFile(keysFile)
+		if err != nil {
+			return Config{}, fmt.Errorf("MASTER_KEYS_FILE: %w", err)
+		}
+		keys = string(data)
+	}
+	if keys != "" {
+		var err error
+		if cfg.MasterKeys, err = parseMasterKeys(keys); err != nil {
+			return Config{}, err
+		}
+	}
+
 	// Redis settings
 	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
 		cfg.RedisURL = redisURL
@@ -97,6 +236,27 @@ func Load() (Config, error) {
 		cfg.RedisMinIdle = idle
 	}
 
+	if master := os.Getenv("REDIS_SENTINEL_MASTER"); master != "" {
+		cfg.RedisSentinelMaster = master
+	}
+
+	if addrs := os.Getenv("REDIS_SENTINEL_ADDRS"); addrs != "" {
+		cfg.RedisSentinelAddrs = splitList(addrs)
+	}
+
+	if addrs := os.Getenv("REDIS_CLUSTER_ADDRS"); addrs != "" {
+		cfg.RedisClusterAddrs = splitList(addrs)
+	}
+
+	if (cfg.RedisSentinelMaster == "") != (len(cfg.RedisSentinelAddrs) == 0) {
+		return Config{}, errors.New(
+			"REDIS_SENTINEL_MASTER and REDIS_SENTINEL_ADDRS must be set together")
+	}
+	if cfg.RedisSentinelMaster != "" && len(cfg.RedisClusterAddrs) > 0 {
+		return Config{}, errors.New(
+			"REDIS_SENTINEL_MASTER and REDIS_CLUSTER_ADDRS can't be used together")
+	}
+
 	// Shutdown settings
 	if timeout := os.Getenv("SHUTDOWN_TIMEOUT"); timeout != "" {
 		dur, err := time.ParseDuration(timeout)
@@ -123,6 +283,74 @@ func Load() (Config, error) {
 		cfg.TrustedProxyCIDR = cidr
 	}
 
+	// Secret limits
+	if options := os.Getenv("EXPIRY_OPTIONS"); options != "" {
+		cfg.ExpiryOptions = splitList(options)
+		if len(cfg.ExpiryOptions) == 0 {
+			return Config{}, errors.New("EXPIRY_OPTIONS must list at least one expiry")
+		}
+		for _, opt := range cfg.ExpiryOptions {
+			if _, ok := utility.ParseExpiry(opt); !ok {
+				return Config{}, fmt.Errorf(
+					"EXPIRY_OPTIONS must contain durations such as 15m, 1h or 7d, got %q", opt)
+			}
+		}
+	}
+
+	if expiry := os.Getenv("DEFAULT_EXPIRY"); expiry != "" {
+		cfg.DefaultExpiry = expiry
+	}
+	if !slices.Contains(cfg.ExpiryOptions, cfg.DefaultExpiry) {
+		return Config{}, fmt.Errorf("DEFAULT_EXPIRY must be one of EXPIRY_OPTIONS (%s), got %q",
+			strings.Join(cfg.ExpiryOptions, ", "), cfg.DefaultExpiry)
+	}
+
+	if expiry := os.Getenv("MIN_EXPIRY"); expiry != "" {
+		d, ok := utility.ParseExpiry(expiry)
+		if !ok {
+			return Config{}, fmt.Errorf("MIN_EXPIRY must be a duration such as 5m or 1h, got %q", expiry)
+		}
+		cfg.MinExpiry = d
+	}
+
+	if expiry := os.Getenv("MAX_EXPIRY"); expiry != "" {
+		d, ok := utility.ParseExpiry(expiry)
+		if !ok {
+			return Config{}, fmt.Errorf("MAX_EXPIRY must be a duration such as 7d or 30d, got %q", expiry)
+		}
+		cfg.MaxExpiry = d
+	}
+	if cfg.MinExpiry > cfg.MaxExpiry {
+		return Config{}, errors.New("MIN_EXPIRY must not be greater than MAX_EXPIRY")
+	}
+
+	if size := os.Getenv("MAX_SECRET_SIZE"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n < 1 || n > maxSecretSizeLimit {
+			return Config{}, fmt.Errorf(
+				"MAX_SECRET_SIZE must be between 1 and %d bytes", maxSecretSizeLimit)
+		}
+		cfg.MaxSecretSize = n
+	}
+
+	if size := os.Getenv("MAX_FILE_SIZE"); size != "" {
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || n < 0 || n > maxFileSizeLimit {
+			return Config{}, fmt.Errorf(
+				"MAX_FILE_SIZE must be between 0 and %d bytes", maxFileSizeLimit)
+		}
+		cfg.MaxFileSize = n
+	}
+
+	if attempts := os.Getenv("MAX_READ_ATTEMPTS"); attempts != "" {
+		n, err := strconv.Atoi(attempts)
+		if err != nil || n < 1 || n > maxReadAttemptsLimit {
+			return Config{}, fmt.Errorf(
+				"MAX_READ_ATTEMPTS must be between 1 and %d", maxReadAttemptsLimit)
+		}
+		cfg.MaxReadAttempts = n
+	}
+
 	// UI settings
 	if theme := os.Getenv("DEFAULT_THEME"); theme != "" {
 		if theme != "light" && theme != "dark" {
@@ -131,9 +359,137 @@ func Load() (Config, error) {
 		cfg.DefaultTheme = theme
 	}
 
+	// Webhook settings
+	if enabled := os.Getenv("WEBHOOKS_ENABLED"); enabled == "1" || enabled == "true" {
+		cfg.WebhooksEnabled = true
+	}
+
+	if allow := os.Getenv("WEBHOOK_ALLOW_PRIVATE"); allow == "1" || allow == "true" {
+		cfg.WebhookAllowPrivate = true
+	}
+
+	// Metrics settings
+	if enabled := os.Getenv("METRICS_ENABLED"); enabled == "1" || enabled == "true" {
+		cfg.MetricsEnabled = true
+	}
+
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return Config{}, fmt.Errorf("METRICS_ADDR must be host:port or :port: %w", err)
+		}
+		cfg.MetricsAddr = addr
+		cfg.MetricsEnabled = true
+	}
+
+	// Audit log settings
+	if auditLog := os.Getenv("AUDIT_LOG"); auditLog != "" {
+		if auditLog != "file" && auditLog != "redis" {
+			return Config{}, fmt.Errorf("AUDIT_LOG must be 'file' or 'redis', got %q", auditLog)
+		}
+		if auditLog == "redis" && cfg.StorageBackend != "redis" {
+			return Config{}, errors.New("AUDIT_LOG=redis requires STORAGE_BACKEND=redis")
+		}
+		cfg.AuditLog = auditLog
+	}
+
+	if auditLogFile := os.Getenv("AUDIT_LOG_FILE"); auditLogFile != "" {
+		cfg.AuditLogFile = auditLogFile
+	}
+
+	// API key settings
+	if apiKeys := os.Getenv("API_KEYS"); apiKeys != "" {
+		if apiKeys != "file" && apiKeys != "redis" {
+			return Config{}, fmt.Errorf("API_KEYS must be 'file' or 'redis', got %q", apiKeys)
+		}
+		if apiKeys == "redis" && cfg.StorageBackend != "redis" {
+			return Config{}, errors.New("API_KEYS=redis requires STORAGE_BACKEND=redis")
+		}
+		cfg.APIKeys = apiKeys
+	}
+
+	if apiKeysFile := os.Getenv("API_KEYS_FILE"); apiKeysFile != "" {
+		cfg.APIKeysFile = apiKeysFile
+	}
+
+	// Tracing settings
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return Config{}, fmt.Errorf(
+				"OTEL_EXPORTER_OTLP_ENDPOINT must be an http or https URL, got %q", endpoint)
+		}
+		cfg.TracingEndpoint = endpoint
+	}
+
+	if ratio := os.Getenv("TRACE_SAMPLE_RATIO"); ratio != "" {
+		r, err := strconv.ParseFloat(ratio, 64)
+		if err != nil || r < 0 || r > 1 {
+			return Config{}, fmt.Errorf("TRACE_SAMPLE_RATIO must be between 0 and 1, got %q", ratio)
+		}
+		cfg.TraceSampleRatio = r
+	}
+
 	return cfg, nil
 }
 
+// parseMasterKeys parses a list of "id:key" entries separated by commas or
+// newlines, where key is 32 bytes in base64, e.g. from openssl rand -base64
+// 32. Lines starting with # are ignored.
+func parseMasterKeys(s string) ([]domain.MasterKey, error) {
+	var keys []domain.MasterKey
+	seen := make(map[string]bool)
+	for line := range strings.Lines(s) {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, entry := range splitList(line) {
+			id, encoded, _ := strings.Cut(entry, ":")
+			id = strings.TrimSpace(id)
+			if !validKeyID(id) {
+				return nil, fmt.Errorf(
+					"master key ids must be letters, digits, - or _, got %q", id)
+			}
+			if seen[id] {
+				return nil, fmt.Errorf("duplicate master key id %q", id)
+			}
+			seen[id] = true
+			key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+			if err != nil || len(key) != 32 {
+				return nil, fmt.Errorf("master key %q must be 32 bytes in base64", id)
+			}
+			keys = append(keys, domain.MasterKey{ID: id, Key: key})
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("master keys must list at least one id:key entry")
+	}
+	return keys, nil
+}
+
+func validKeyID(id string) bool {
+	if id == "" || len(id) > 32 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+			r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// splitList splits a comma-separated list, ignoring blank entries.
+func splitList(s string) []string {
+	var items []string
+	for item := range strings.SplitSeq(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // ListenAddr returns the address string for the HTTP server.
 func (c Config) ListenAddr() string {
 	return ":" + c.Port
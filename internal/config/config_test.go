@@ -1,7 +1,12 @@
 package config
 
 import (
+	"bytes"
+	"encoding/base64"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 )
@@ -314,3 +319,507 @@ func TestLoad_CanonicalHost(t *testing.T) {
 		t.Errorf("expected CanonicalHost %q, got %q", "secretapi.example.com", cfg.CanonicalHost)
 	}
 }
+
+func TestLoad_WebhooksDefault(t *testing.T) {
+	os.Unsetenv("WEBHOOKS_ENABLED")
+	os.Unsetenv("WEBHOOK_ALLOW_PRIVATE")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.WebhooksEnabled {
+		t.Error("expected webhooks to be disabled by default")
+	}
+	if cfg.WebhookAllowPrivate {
+		t.Error("expected private webhook addresses to be refused by default")
+	}
+}
+
+func TestLoad_Webhooks(t *testing.T) {
+	os.Setenv("WEBHOOKS_ENABLED", "1")
+	os.Setenv("WEBHOOK_ALLOW_PRIVATE", "true")
+	defer os.Unsetenv("WEBHOOKS_ENABLED")
+	defer os.Unsetenv("WEBHOOK_ALLOW_PRIVATE")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if !cfg.WebhooksEnabled {
+		t.Error("expected webhooks to be enabled")
+	}
+	if !cfg.WebhookAllowPrivate {
+		t.Error("expected private webhook addresses to be allowed")
+	}
+}
+
+func TestLoad_StorageBackendDefault(t *testing.T) {
+	os.Unsetenv("STORAGE_BACKEND")
+	os.Unsetenv("BOLT_PATH")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.StorageBackend != "redis" {
+		t.Errorf("expected redis storage backend by default, got %q", cfg.StorageBackend)
+	}
+	if cfg.BoltPath != "secretapi.db" {
+		t.Errorf("expected default bolt path, got %q", cfg.BoltPath)
+	}
+}
+
+func TestLoad_StorageBackend(t *testing.T) {
+	for _, backend := range []string{"redis", "memory", "bolt"} {
+		t.Run(backend, func(t *testing.T) {
+			os.Setenv("STORAGE_BACKEND", backend)
+			defer os.Unsetenv("STORAGE_BACKEND")
+
+			cfg, err := Load()
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if cfg.StorageBackend != backend {
+				t.Errorf("expected %q, got %q", backend, cfg.StorageBackend)
+			}
+		})
+	}
+}
+
+func TestLoad_StorageBackendInvalid(t *testing.T) {
+	os.Setenv("STORAGE_BACKEND", "postgres")
+	defer os.Unsetenv("STORAGE_BACKEND")
+
+	_, err := Load()
+	if err == nil {
+		t.Error("expected error for unknown storage backend")
+	}
+}
+
+func TestLoad_BoltPath(t *testing.T) {
+	os.Setenv("BOLT_PATH", "/data/secrets.db")
+	defer os.Unsetenv("BOLT_PATH")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.BoltPath != "/data/secrets.db" {
+		t.Errorf("expected custom bolt path, got %q", cfg.BoltPath)
+	}
+}
+
+func TestLoad_RedisSentinel(t *testing.T) {
+	os.Setenv("REDIS_SENTINEL_MASTER", "mymaster")
+	os.Setenv("REDIS_SENTINEL_ADDRS", "sentinel-1:26379, sentinel-2:26379,")
+	defer os.Unsetenv("REDIS_SENTINEL_MASTER")
+	defer os.Unsetenv("REDIS_SENTINEL_ADDRS")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.RedisSentinelMaster != "mymaster" {
+		t.Errorf("expected sentinel master 'mymaster', got %q", cfg.RedisSentinelMaster)
+	}
+	want := []string{"sentinel-1:26379", "sentinel-2:26379"}
+	if !slices.Equal(cfg.RedisSentinelAddrs, want) {
+		t.Errorf("expected sentinel addrs %v, got %v", want, cfg.RedisSentinelAddrs)
+	}
+}
+
+func TestLoad_RedisSentinelIncomplete(t *testing.T) {
+	os.Setenv("REDIS_SENTINEL_MASTER", "mymaster")
+	defer os.Unsetenv("REDIS_SENTINEL_MASTER")
+
+	_, err := Load()
+	if err == nil {
+		t.Error("expected error for sentinel master without sentinel addrs")
+	}
+}
+
+func TestLoad_RedisCluster(t *testing.T) {
+	os.Setenv("REDIS_CLUSTER_ADDRS", "node-1:6379,node-2:6379,node-3:6379")
+	defer os.Unsetenv("REDIS_CLUSTER_ADDRS")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if len(cfg.RedisClusterAddrs) != 3 {
+		t.Errorf("expected 3 cluster addrs, got %v", cfg.RedisClusterAddrs)
+	}
+}
+
+func TestLoad_RedisSentinelAndCluster(t *testing.T) {
+	os.Setenv("REDIS_SENTINEL_MASTER", "mymaster")
+	os.Setenv("REDIS_SENTINEL_ADDRS", "sentinel-1:26379")
+	os.Setenv("REDIS_CLUSTER_ADDRS", "node-1:6379")
+	defer os.Unsetenv("REDIS_SENTINEL_MASTER")
+	defer os.Unsetenv("REDIS_SENTINEL_ADDRS")
+	defer os.Unsetenv("REDIS_CLUSTER_ADDRS")
+
+	_, err := Load()
+	if err == nil {
+		t.Error("expected error for sentinel and cluster together")
+	}
+}
+
+func TestLoad_SecretLimitsDefault(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if !slices.Equal(cfg.ExpiryOptions, []string{"1h", "6h", "1d", "3d"}) {
+		t.Errorf("unexpected default expiry options %v", cfg.ExpiryOptions)
+	}
+	if cfg.DefaultExpiry != "1d" {
+		t.Errorf("expected default expiry '1d', got %q", cfg.DefaultExpiry)
+	}
+	if cfg.MinExpiry != 5*time.Minute || cfg.MaxExpiry != 7*24*time.Hour {
+		t.Errorf("expected expiries between 5m and 7d, got %v and %v", cfg.MinExpiry, cfg.MaxExpiry)
+	}
+	if cfg.MaxSecretSize != 64*1024 {
+		t.Errorf("expected max secret size of 64KB, got %d", cfg.MaxSecretSize)
+	}
+	if cfg.MaxReadAttempts != 3 {
+		t.Errorf("expected 3 max read attempts, got %d", cfg.MaxReadAttempts)
+	}
+	if cfg.MaxFileSize != 10<<20 {
+		t.Errorf("expected max file size of 10MB, got %d", cfg.MaxFileSize)
+	}
+}
+
+func TestLoad_SecretLimits(t *testing.T) {
+	os.Setenv("EXPIRY_OPTIONS", "15m, 1h, 1d, 7d")
+	os.Setenv("DEFAULT_EXPIRY", "1h")
+	os.Setenv("MAX_SECRET_SIZE", "1048576")
+	os.Setenv("MAX_READ_ATTEMPTS", "5")
+	os.Setenv("MIN_EXPIRY", "1m")
+	os.Setenv("MAX_FILE_SIZE", "0")
+	os.Setenv("MAX_EXPIRY", "P30D")
+	defer os.Unsetenv("EXPIRY_OPTIONS")
+	defer os.Unsetenv("DEFAULT_EXPIRY")
+	defer os.Unsetenv("MIN_EXPIRY")
+	defer os.Unsetenv("MAX_FILE_SIZE")
+	defer os.Unsetenv("MAX_EXPIRY")
+	defer os.Unsetenv("MAX_SECRET_SIZE")
+	defer os.Unsetenv("MAX_READ_ATTEMPTS")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if !slices.Equal(cfg.ExpiryOptions, []string{"15m", "1h", "1d", "7d"}) {
+		t.Errorf("unexpected expiry options %v", cfg.ExpiryOptions)
+	}
+	if cfg.DefaultExpiry != "1h" {
+		t.Errorf("expected default expiry '1h', got %q", cfg.DefaultExpiry)
+	}
+	if cfg.MinExpiry != time.Minute || cfg.MaxExpiry != 30*24*time.Hour {
+		t.Errorf("expected expiries between 1m and 30d, got %v and %v", cfg.MinExpiry, cfg.MaxExpiry)
+	}
+	if cfg.MaxSecretSize != 1<<20 {
+		t.Errorf("expected max secret size of 1MB, got %d", cfg.MaxSecretSize)
+	}
+	if cfg.MaxReadAttempts != 5 {
+		t.Errorf("expected 5 max read attempts, got %d", cfg.MaxReadAttempts)
+	}
+	if cfg.MaxFileSize != 0 {
+		t.Errorf("expected file secrets to be disabled, got %d", cfg.MaxFileSize)
+	}
+}
+
+func TestLoad_SecretLimitsInvalid(t *testing.T) {
+	testCases := []struct {
+		name string
+		env  map[string]string
+	}{
+		{"invalid expiry option", map[string]string{"EXPIRY_OPTIONS": "1h,forever"}},
+		{"empty expiry options", map[string]string{"EXPIRY_OPTIONS": ","}},
+		{"default expiry not an option", map[string]string{"DEFAULT_EXPIRY": "2h"}},
+		{"default expiry dropped from options", map[string]string{"EXPIRY_OPTIONS": "15m,1h"}},
+		{"invalid min expiry", map[string]string{"MIN_EXPIRY": "soon"}},
+		{"invalid max expiry", map[string]string{"MAX_EXPIRY": "P1M"}},
+		{"min expiry above max", map[string]string{"MIN_EXPIRY": "8d"}},
+		{"zero secret size", map[string]string{"MAX_SECRET_SIZE": "0"}},
+		{"huge secret size", map[string]string{"MAX_SECRET_SIZE": "1073741824"}},
+		{"invalid secret size", map[string]string{"MAX_SECRET_SIZE": "64KB"}},
+		{"negative file size", map[string]string{"MAX_FILE_SIZE": "-1"}},
+		{"huge file size", map[string]string{"MAX_FILE_SIZE": "10737418240"}},
+		{"zero read attempts", map[string]string{"MAX_READ_ATTEMPTS": "0"}},
+		{"too many read attempts", map[string]string{"MAX_READ_ATTEMPTS": "1000"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			if _, err := Load(); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestLoad_MasterKeys(t *testing.T) {
+	key1 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	key2 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+	os.Setenv("MASTER_KEYS", "2025-10:"+key2+", 2025-01:"+key1)
+	defer os.Unsetenv("MASTER_KEYS")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if len(cfg.MasterKeys) != 2 ||
+		cfg.MasterKeys[0].ID != "2025-10" || cfg.MasterKeys[1].ID != "2025-01" {
+		t.Fatalf("expected keys 2025-10 then 2025-01, got %v", cfg.MasterKeys)
+	}
+	if !bytes.Equal(cfg.MasterKeys[0].Key, bytes.Repeat([]byte{2}, 32)) {
+		t.Error("expected the first key to be decoded")
+	}
+}
+
+func TestLoad_MasterKeysFile(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	path := filepath.Join(t.TempDir(), "master-keys")
+	data := "# current key first\nk2:" + key + "\n\nk1:" + key + "\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("MASTER_KEYS_FILE", path)
+	defer os.Unsetenv("MASTER_KEYS_FILE")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if len(cfg.MasterKeys) != 2 || cfg.MasterKeys[0].ID != "k2" {
+		t.Errorf("expected keys k2 then k1, got %v", cfg.MasterKeys)
+	}
+}
+
+func TestLoad_MasterKeysInvalid(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	testCases := []struct {
+		name string
+		env  map[string]string
+	}{
+		{"missing key", map[string]string{"MASTER_KEYS": "k1"}},
+		{"short key", map[string]string{"MASTER_KEYS": "k1:c2hvcnQ="}},
+		{"invalid id", map[string]string{"MASTER_KEYS": "k$1:" + key}},
+		{"duplicate id", map[string]string{"MASTER_KEYS": "k1:" + key + ",k1:" + key}},
+		{"no entries", map[string]string{"MASTER_KEYS": ","}},
+		{"missing file", map[string]string{"MASTER_KEYS_FILE": "/nonexistent/master-keys"}},
+		{"both", map[string]string{"MASTER_KEYS": "k1:" + key, "MASTER_KEYS_FILE": "/etc/hosts"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			if _, err := Load(); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestLoad_Metrics(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.MetricsEnabled {
+		t.Error("expected metrics to be disabled by default")
+	}
+
+	os.Setenv("METRICS_ADDR", "127.0.0.1:9090")
+	defer os.Unsetenv("METRICS_ADDR")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.MetricsEnabled || cfg.MetricsAddr != "127.0.0.1:9090" {
+		t.Errorf("expected metrics on 127.0.0.1:9090, got %t %q", cfg.MetricsEnabled, cfg.MetricsAddr)
+	}
+
+	os.Setenv("METRICS_ADDR", "9090")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for METRICS_ADDR without a port separator")
+	}
+}
+
+func TestLoad_Logging(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.LogFormat != "text" || cfg.LogLevel != slog.LevelInfo || cfg.LogSecretIDs != "hash" {
+		t.Errorf("unexpected logging defaults %q %v %q", cfg.LogFormat, cfg.LogLevel, cfg.LogSecretIDs)
+	}
+
+	os.Setenv("LOG_FORMAT", "json")
+	os.Setenv("LOG_LEVEL", "debug")
+	os.Setenv("LOG_SECRET_IDS", "redact")
+	defer os.Unsetenv("LOG_FORMAT")
+	defer os.Unsetenv("LOG_LEVEL")
+	defer os.Unsetenv("LOG_SECRET_IDS")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.LogFormat != "json" || cfg.LogLevel != slog.LevelDebug || cfg.LogSecretIDs != "redact" {
+		t.Errorf("unexpected logging settings %q %v %q", cfg.LogFormat, cfg.LogLevel, cfg.LogSecretIDs)
+	}
+
+	for name, value := range map[string]string{
+		"LOG_FORMAT":     "xml",
+		"LOG_LEVEL":      "verbose",
+		"LOG_SECRET_IDS": "encrypt",
+	} {
+		t.Run(name, func(t *testing.T) {
+			prev := os.Getenv(name)
+			os.Setenv(name, value)
+			defer os.Setenv(name, prev)
+			if _, err := Load(); err == nil {
+				t.Errorf("expected error for %s=%s", name, value)
+			}
+		})
+	}
+}
+
+func TestLoad_Tracing(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.TracingEndpoint != "" || cfg.TraceSampleRatio != 1 {
+		t.Errorf("unexpected tracing defaults %q %v", cfg.TracingEndpoint, cfg.TraceSampleRatio)
+	}
+
+	os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318")
+	os.Setenv("TRACE_SAMPLE_RATIO", "0.25")
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	defer os.Unsetenv("TRACE_SAMPLE_RATIO")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.TracingEndpoint != "http://collector:4318" || cfg.TraceSampleRatio != 0.25 {
+		t.Errorf("unexpected tracing settings %q %v", cfg.TracingEndpoint, cfg.TraceSampleRatio)
+	}
+
+	for name, value := range map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT": "collector:4318",
+		"TRACE_SAMPLE_RATIO":          "1.5",
+	} {
+		t.Run(name, func(t *testing.T) {
+			prev := os.Getenv(name)
+			os.Setenv(name, value)
+			defer os.Setenv(name, prev)
+			if _, err := Load(); err == nil {
+				t.Errorf("expected error for %s=%s", name, value)
+			}
+		})
+	}
+}
+
+func TestLoad_AuditLog(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.AuditLog != "" || cfg.AuditLogFile != "audit.log" {
+		t.Errorf("unexpected audit log defaults %q %q", cfg.AuditLog, cfg.AuditLogFile)
+	}
+
+	os.Setenv("AUDIT_LOG", "file")
+	os.Setenv("AUDIT_LOG_FILE", "/var/log/secretapi/audit.log")
+	defer os.Unsetenv("AUDIT_LOG")
+	defer os.Unsetenv("AUDIT_LOG_FILE")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.AuditLog != "file" || cfg.AuditLogFile != "/var/log/secretapi/audit.log" {
+		t.Errorf("unexpected audit log settings %q %q", cfg.AuditLog, cfg.AuditLogFile)
+	}
+
+	os.Setenv("AUDIT_LOG", "redis")
+	if _, err := Load(); err != nil {
+		t.Errorf("Load() error = %v", err)
+	}
+
+	os.Setenv("STORAGE_BACKEND", "memory")
+	defer os.Unsetenv("STORAGE_BACKEND")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for AUDIT_LOG=redis without the redis backend")
+	}
+
+	os.Setenv("AUDIT_LOG", "syslog")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for AUDIT_LOG=syslog")
+	}
+}
+
+func TestLoad_APIKeys(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.APIKeys != "" || cfg.APIKeysFile != "api_keys.json" {
+		t.Errorf("unexpected api key defaults %q %q", cfg.APIKeys, cfg.APIKeysFile)
+	}
+
+	os.Setenv("API_KEYS", "file")
+	os.Setenv("API_KEYS_FILE", "/etc/secretapi/api_keys.json")
+	defer os.Unsetenv("API_KEYS")
+	defer os.Unsetenv("API_KEYS_FILE")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.APIKeys != "file" || cfg.APIKeysFile != "/etc/secretapi/api_keys.json" {
+		t.Errorf("unexpected api key settings %q %q", cfg.APIKeys, cfg.APIKeysFile)
+	}
+
+	os.Setenv("API_KEYS", "redis")
+	if _, err := Load(); err != nil {
+		t.Errorf("Load() error = %v", err)
+	}
+
+	os.Setenv("STORAGE_BACKEND", "bolt")
+	defer os.Unsetenv("STORAGE_BACKEND")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for API_KEYS=redis without the redis backend")
+	}
+
+	os.Setenv("API_KEYS", "ldap")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for API_KEYS=ldap")
+	}
+}
@@ -0,0 +1,553 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/smallwat3r/secretapi/internal/logging"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	secretsBucket  = []byte("secrets")
+	webhooksBucket = []byte("webhooks")
+
+	// chunksBucket holds a bucket per file secret, with its chunks keyed by
+	// their big-endian index, and their expiry under chunksExpiryKey.
+	chunksBucket    = []byte("chunks")
+	chunksExpiryKey = []byte("expires_at")
+)
+
+type boltSecret struct {
+	Blob      []byte    `json:"blob"`
+	Views     int64     `json:"views"`
+	Attempts  int64     `json:"attempts"`
+	TokenHash []byte    `json:"token_hash,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type boltWebhook struct {
+	Webhook
+	DueAt     time.Time `json:"due_at"`     // expiry of the secret
+	ExpiresAt time.Time `json:"expires_at"` // DueAt plus WebhookGracePeriod
+}
+
+// BoltRepository keeps secrets in an embedded bbolt database file, so a
+// single server can run without Redis and keep its secrets across restarts.
+// The file can only be opened by one process at a time.
+type BoltRepository struct {
+	db   *bolt.DB
+	now  func() time.Time
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewBoltRepository opens or creates the database at path. Close stops its
+// background sweeper and closes the database.
+func NewBoltRepository(path string) (*BoltRepository, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open bolt database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{secretsBucket, webhooksBucket, chunksBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("create bolt buckets: %w", err)
+	}
+
+	r := &BoltRepository{
+		db:   db,
+		now:  time.Now,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go r.sweepLoop()
+	return r, nil
+}
+
+func (r *BoltRepository) Close() error {
+	close(r.stop)
+	<-r.done
+	return r.db.Close()
+}
+
+func (r *BoltRepository) Ping(ctx context.Context) error {
+	return r.db.View(func(tx *bolt.Tx) error { return nil })
+}
+
+func (r *BoltRepository) StoreSecret(
+	ctx context.Context, id string, secret Secret, ttl time.Duration,
+) error {
+	expiresAt := r.now().Add(ttl)
+	return r.db.Update(func(tx *bolt.Tx) error {
+		err := putJSON(tx.Bucket(secretsBucket), id, boltSecret{
+			Blob:      secret.Blob,
+			Views:     int64(secret.MaxViews),
+			TokenHash: secret.TokenHash,
+			ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			return err
+		}
+		// The chunks of a file were appended during the upload, they now
+		// expire with the secret.
+		if c := tx.Bucket(chunksBucket).Bucket([]byte(id)); c != nil {
+			if err := putJSON(c, string(chunksExpiryKey), expiresAt); err != nil {
+				return err
+			}
+		}
+		if secret.Webhook == nil {
+			return nil
+		}
+		return putJSON(tx.Bucket(webhooksBucket), id, boltWebhook{
+			Webhook:   *secret.Webhook,
+			DueAt:     expiresAt,
+			ExpiresAt: expiresAt.Add(WebhookGracePeriod),
+		})
+	})
+}
+
+func (r *BoltRepository) GetSecret(ctx context.Context, id string) ([]byte, error) {
+	var blob []byte
+	err := r.db.View(func(tx *bolt.Tx) error {
+		s, err := r.lookup(tx, id)
+		if err != nil {
+			return err
+		}
+		blob = s.Blob
+		return nil
+	})
+	return blob, err
+}
+
+func (r *BoltRepository) ReplaceSecret(ctx context.Context, id string, old, blob []byte) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		s, err := r.lookup(tx, id)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(s.Blob, old) {
+			return ErrNotFound
+		}
+		s.Blob = blob
+		return putJSON(tx.Bucket(secretsBucket), id, s)
+	})
+}
+
+func (r *BoltRepository) DecrViewAndMaybeDelete(
+	ctx context.Context, id string, old []byte,
+) (int64, error) {
+	var remaining int64
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		s, err := r.lookup(tx, id)
+		if err != nil {
+			return err
+		}
+		// The secret read by the caller was replaced since, it's gone.
+		if !bytes.Equal(s.Blob, old) {
+			return ErrNotFound
+		}
+
+		s.Views--
+		if s.Views <= 0 {
+			return tx.Bucket(secretsBucket).Delete([]byte(id))
+		}
+		remaining = s.Views
+		return putJSON(tx.Bucket(secretsBucket), id, s)
+	})
+	return remaining, err
+}
+
+func (r *BoltRepository) IncrFailAndMaybeDelete(
+	ctx context.Context, id string, maxAttempts int,
+) (int64, error) {
+	var attempts int64
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		s, err := r.lookup(tx, id)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		s.Attempts++
+		attempts = s.Attempts
+		if s.Attempts >= int64(maxAttempts) {
+			if err := deleteChunks(tx, id); err != nil {
+				return err
+			}
+			return tx.Bucket(secretsBucket).Delete([]byte(id))
+		}
+		return putJSON(tx.Bucket(secretsBucket), id, s)
+	})
+	return attempts, err
+}
+
+func (r *BoltRepository) DeleteSecret(ctx context.Context, id string, tokenHash []byte) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		s, err := r.lookup(tx, id)
+		if err != nil {
+			return err
+		}
+		if len(s.TokenHash) == 0 || subtle.ConstantTimeCompare(s.TokenHash, tokenHash) != 1 {
+			return ErrInvalidToken
+		}
+
+		// A revoked secret isn't reported to its webhook.
+		if err := tx.Bucket(secretsBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		if err := deleteChunks(tx, id); err != nil {
+			return err
+		}
+		return tx.Bucket(webhooksBucket).Delete([]byte(id))
+	})
+}
+
+func (r *BoltRepository) GetStatus(
+	ctx context.Context, id string, tokenHash []byte,
+) (SecretStatus, error) {
+	var status SecretStatus
+	err := r.db.View(func(tx *bolt.Tx) error {
+		s, err := r.lookup(tx, id)
+		if err != nil {
+			return err
+		}
+		if len(s.TokenHash) == 0 || subtle.ConstantTimeCompare(s.TokenHash, tokenHash) != 1 {
+			return ErrInvalidToken
+		}
+
+		status = SecretStatus{
+			TTL:            s.ExpiresAt.Sub(r.now()),
+			RemainingViews: s.Views,
+			FailedAttempts: s.Attempts,
+		}
+		return nil
+	})
+	return status, err
+}
+
+func (r *BoltRepository) GetWebhook(ctx context.Context, id string) (*Webhook, error) {
+	var hook *Webhook
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		hook, err = r.webhook(tx, id)
+		return err
+	})
+	return hook, err
+}
+
+func (r *BoltRepository) TakeWebhook(ctx context.Context, id string) (*Webhook, error) {
+	var hook *Webhook
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if hook, err = r.webhook(tx, id); err != nil {
+			return err
+		}
+		return tx.Bucket(webhooksBucket).Delete([]byte(id))
+	})
+	return hook, err
+}
+
+func (r *BoltRepository) DueWebhooks(
+	ctx context.Context, before time.Time, limit int64,
+) ([]string, error) {
+	type due struct {
+		id    string
+		dueAt time.Time
+	}
+	var found []due
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(webhooksBucket).ForEach(func(k, v []byte) error {
+			var w boltWebhook
+			if err := json.Unmarshal(v, &w); err != nil {
+				return err
+			}
+			if !w.DueAt.After(before) {
+				found = append(found, due{id: string(k), dueAt: w.DueAt})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(found, func(a, b due) int { return a.dueAt.Compare(b.dueAt) })
+	if int64(len(found)) > limit {
+		found = found[:limit]
+	}
+	ids := make([]string, len(found))
+	for i, d := range found {
+		ids[i] = d.id
+	}
+	return ids, nil
+}
+
+func (r *BoltRepository) AppendChunk(
+	ctx context.Context, id string, chunk []byte, ttl time.Duration,
+) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		c, err := tx.Bucket(chunksBucket).CreateBucketIfNotExists([]byte(id))
+		if err != nil {
+			return err
+		}
+		seq, err := c.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := c.Put(chunkKey(int(seq-1)), chunk); err != nil {
+			return err
+		}
+		return putJSON(c, string(chunksExpiryKey), r.now().Add(ttl))
+	})
+}
+
+func (r *BoltRepository) GetChunk(ctx context.Context, id string, index int) ([]byte, error) {
+	var chunk []byte
+	err := r.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(chunksBucket).Bucket([]byte(id))
+		if c == nil || index < 0 {
+			return ErrNotFound
+		}
+		expired, err := r.chunksExpired(c)
+		if err != nil {
+			return err
+		}
+		data := c.Get(chunkKey(index))
+		if expired || data == nil {
+			return ErrNotFound
+		}
+		chunk = bytes.Clone(data)
+		return nil
+	})
+	return chunk, err
+}
+
+func (r *BoltRepository) DeleteChunks(ctx context.Context, id string) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return deleteChunks(tx, id)
+	})
+}
+
+func (r *BoltRepository) RewriteBlobs(ctx context.Context, rewrite BlobRewriter) (int, error) {
+	rewritten := 0
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		rewritten = 0
+		secrets := tx.Bucket(secretsBucket)
+		updates := make(map[string]*boltSecret)
+		err := secrets.ForEach(func(k, v []byte) error {
+			s, err := r.lookup(tx, string(k))
+			if errors.Is(err, ErrNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			updated, err := rewrite(string(k), s.Blob, false)
+			if err != nil {
+				return fmt.Errorf("rewrite id=%s: %w", k, err)
+			}
+			if updated != nil {
+				s.Blob = updated
+				updates[string(k)] = s
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// Buckets can't be changed while iterating over them.
+		for id, s := range updates {
+			if err := putJSON(secrets, id, s); err != nil {
+				return err
+			}
+			rewritten++
+		}
+
+		chunks := tx.Bucket(chunksBucket)
+		return chunks.ForEachBucket(func(id []byte) error {
+			c := chunks.Bucket(id)
+			expired, err := r.chunksExpired(c)
+			if err != nil || expired {
+				return err
+			}
+			updates := make(map[string][]byte)
+			err = c.ForEach(func(k, v []byte) error {
+				if bytes.Equal(k, chunksExpiryKey) {
+					return nil
+				}
+				updated, err := rewrite(string(id), bytes.Clone(v), true)
+				if err != nil {
+					return fmt.Errorf("rewrite chunk %d of id=%s: %w",
+						binary.BigEndian.Uint64(k), id, err)
+				}
+				if updated != nil {
+					updates[string(k)] = updated
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for k, chunk := range updates {
+				if err := c.Put([]byte(k), chunk); err != nil {
+					return err
+				}
+				rewritten++
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return rewritten, nil
+}
+
+// chunksExpired reports whether the chunks in bucket c have expired.
+func (r *BoltRepository) chunksExpired(c *bolt.Bucket) (bool, error) {
+	var expiresAt time.Time
+	if err := json.Unmarshal(c.Get(chunksExpiryKey), &expiresAt); err != nil {
+		return false, err
+	}
+	return !r.now().Before(expiresAt), nil
+}
+
+func deleteChunks(tx *bolt.Tx, id string) error {
+	err := tx.Bucket(chunksBucket).DeleteBucket([]byte(id))
+	if errors.Is(err, bolt.ErrBucketNotFound) {
+		return nil
+	}
+	return err
+}
+
+func chunkKey(index int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(index))
+}
+
+// lookup returns the secret stored under id, or ErrNotFound if there is none
+// or it has expired.
+func (r *BoltRepository) lookup(tx *bolt.Tx, id string) (*boltSecret, error) {
+	data := tx.Bucket(secretsBucket).Get([]byte(id))
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	var s boltSecret
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	if !r.now().Before(s.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return &s, nil
+}
+
+// webhook returns the webhook stored under id, or nil if there is none or it
+// is past its grace period.
+func (r *BoltRepository) webhook(tx *bolt.Tx, id string) (*Webhook, error) {
+	data := tx.Bucket(webhooksBucket).Get([]byte(id))
+	if data == nil {
+		return nil, nil
+	}
+	var w boltWebhook
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, err
+	}
+	if !r.now().Before(w.ExpiresAt) {
+		return nil, nil
+	}
+	return &w.Webhook, nil
+}
+
+func (r *BoltRepository) sweepLoop() {
+	defer close(r.done)
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if err := r.sweep(); err != nil {
+				slog.Error("failed to sweep expired secrets", logging.Err(err))
+			}
+		}
+	}
+}
+
+// sweep deletes expired secrets and chunks, and webhooks past their grace
+// period, so their ciphertext doesn't linger on disk.
+func (r *BoltRepository) sweep() error {
+	now := r.now()
+	return r.db.Update(func(tx *bolt.Tx) error {
+		var expired [][]byte
+		err := tx.Bucket(chunksBucket).ForEachBucket(func(k []byte) error {
+			done, err := r.chunksExpired(tx.Bucket(chunksBucket).Bucket(k))
+			if done {
+				expired = append(expired, bytes.Clone(k))
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := tx.Bucket(chunksBucket).DeleteBucket(k); err != nil {
+				return err
+			}
+		}
+
+		for _, name := range [][]byte{secretsBucket, webhooksBucket} {
+			b := tx.Bucket(name)
+			var expired [][]byte
+			err := b.ForEach(func(k, v []byte) error {
+				var rec struct {
+					ExpiresAt time.Time `json:"expires_at"`
+				}
+				if err := json.Unmarshal(v, &rec); err != nil {
+					return err
+				}
+				if !now.Before(rec.ExpiresAt) {
+					expired = append(expired, bytes.Clone(k))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, k := range expired {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func putJSON(b *bolt.Bucket, id string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(id), data)
+}
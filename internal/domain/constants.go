@@ -3,20 +3,61 @@ package domain
 import "time"
 
 const (
-	// MaxSecretSize is the maximum allowed size for a secret (64 KB).
+	// MaxSecretSize is the default maximum size for a secret (64 KB). It can
+	// be changed with MAX_SECRET_SIZE.
 	MaxSecretSize = 64 * 1024
 
-	// MaxRequestBodySize is the maximum allowed request body size.
-	// Set slightly larger than MaxSecretSize to account for JSON overhead.
-	MaxRequestBodySize = MaxSecretSize + 1024
+	// MaxFileSize is the default maximum size for a file secret (10 MB). It
+	// can be changed with MAX_FILE_SIZE.
+	MaxFileSize = 10 << 20
 
-	// MaxReadAttempts is the maximum number of incorrect passcode attempts
-	// before a secret is automatically deleted.
+	// MaxReadAttempts is the default maximum number of incorrect passcode
+	// attempts before a secret is automatically deleted. It can be changed
+	// with MAX_READ_ATTEMPTS.
 	MaxReadAttempts = 3
 
-	// DefaultExpiry is the default TTL for secrets when no expiry is specified.
-	DefaultExpiry = 24 * time.Hour
+	// MaxPassphraseSize is the maximum allowed size for the optional
+	// passphrase of a secret.
+	MaxPassphraseSize = 256
+
+	// MaxFields is the maximum number of fields of a structured secret, and
+	// MaxFieldNameSize the maximum size of their names.
+	MaxFields        = 32
+	MaxFieldNameSize = 64
+
+	// MaxViews is the maximum number of successful reads a secret can be
+	// created with.
+	MaxViews = 10
+
+	// DefaultExpiry is the expiry of secrets created without one, unless
+	// changed with DEFAULT_EXPIRY.
+	DefaultExpiry = "1d"
+
+	// MinExpiry and MaxExpiry are the default bounds of an expiry that isn't
+	// one of the expiry options. They can be changed with MIN_EXPIRY and
+	// MAX_EXPIRY.
+	MinExpiry = 5 * time.Minute
+	MaxExpiry = 7 * 24 * time.Hour
+
+	// WebhookGracePeriod is how long a webhook is kept after its secret
+	// expires, so the expiry event can still be delivered if no server was
+	// running at the time.
+	WebhookGracePeriod = 24 * time.Hour
 )
 
-// ExpiryOptions defines the valid expiry duration strings.
+// ExpiryOptions defines the default valid expiry duration strings. They can
+// be changed with EXPIRY_OPTIONS.
 var ExpiryOptions = []string{"1h", "6h", "1d", "3d"}
+
+// MaxCiphertextSize returns the maximum size of a client-side encrypted
+// secret: a "v2:" prefix followed by base64(nonce|ciphertext|tag) of a
+// plaintext of at most maxSecretSize bytes.
+func MaxCiphertextSize(maxSecretSize int) int {
+	return 3 + (maxSecretSize+12+16+2)/3*4
+}
+
+// MaxRequestBodySize returns the maximum size of a request body. It is
+// slightly larger than MaxCiphertextSize to account for JSON overhead.
+func MaxRequestBodySize(maxSecretSize int) int64 {
+	return int64(MaxCiphertextSize(maxSecretSize)) + 1024
+}
@@ -0,0 +1,243 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// envelopePrefix marks a blob wrapped with a master key. It is followed by
+// the key ID, "$", then the nonce and ciphertext.
+const envelopePrefix = "m1:"
+
+// MasterKey is a server key wrapping secrets at rest. Its ID is stored with
+// every blob it wraps, so older keys can still unwrap them after a rotation.
+type MasterKey struct {
+	ID  string
+	Key []byte // 32 bytes, AES-256-GCM
+}
+
+// EncryptedRepository wraps the blobs and chunks of another repository with
+// a master key, so that a copy of the storage, such as a Redis RDB file,
+// can't be attacked offline without it. Blobs stored before encryption at
+// rest was enabled are returned as they are until Rewrap wraps them.
+type EncryptedRepository struct {
+	SecretRepository
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+// NewEncryptedRepository wraps the blobs stored in repo with the first of
+// keys, and unwraps them with whichever of keys they name.
+func NewEncryptedRepository(repo SecretRepository, keys []MasterKey) (*EncryptedRepository, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no master key")
+	}
+	r := &EncryptedRepository{
+		SecretRepository: repo,
+		current:          keys[0].ID,
+		aeads:            make(map[string]cipher.AEAD, len(keys)),
+	}
+	for _, k := range keys {
+		if k.ID == "" || strings.ContainsAny(k.ID, "$:") {
+			return nil, fmt.Errorf("invalid master key id %q", k.ID)
+		}
+		if _, ok := r.aeads[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate master key id %q", k.ID)
+		}
+		if len(k.Key) != 32 {
+			return nil, fmt.Errorf("master key %q must be 32 bytes", k.ID)
+		}
+		block, err := aes.NewCipher(k.Key)
+		if err != nil {
+			return nil, err
+		}
+		if r.aeads[k.ID], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *EncryptedRepository) StoreSecret(
+	ctx context.Context, id string, secret Secret, ttl time.Duration,
+) error {
+	blob, err := r.wrap(id, secret.Blob, false)
+	if err != nil {
+		return err
+	}
+	secret.Blob = blob
+	return r.SecretRepository.StoreSecret(ctx, id, secret, ttl)
+}
+
+func (r *EncryptedRepository) GetSecret(ctx context.Context, id string) ([]byte, error) {
+	blob, err := r.SecretRepository.GetSecret(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return r.unwrap(id, blob, false)
+}
+
+// ReplaceSecret compares old to the unwrapped blob, then swaps the stored
+// blob only if it is still the one that was compared.
+func (r *EncryptedRepository) ReplaceSecret(
+	ctx context.Context, id string, old, blob []byte,
+) error {
+	stored, err := r.storedBlob(ctx, id, old)
+	if err != nil {
+		return err
+	}
+	wrapped, err := r.wrap(id, blob, false)
+	if err != nil {
+		return err
+	}
+	return r.SecretRepository.ReplaceSecret(ctx, id, stored, wrapped)
+}
+
+func (r *EncryptedRepository) DecrViewAndMaybeDelete(
+	ctx context.Context, id string, old []byte,
+) (int64, error) {
+	stored, err := r.storedBlob(ctx, id, old)
+	if err != nil {
+		return 0, err
+	}
+	return r.SecretRepository.DecrViewAndMaybeDelete(ctx, id, stored)
+}
+
+func (r *EncryptedRepository) AppendChunk(
+	ctx context.Context, id string, chunk []byte, ttl time.Duration,
+) error {
+	wrapped, err := r.wrap(id, chunk, true)
+	if err != nil {
+		return err
+	}
+	return r.SecretRepository.AppendChunk(ctx, id, wrapped, ttl)
+}
+
+func (r *EncryptedRepository) GetChunk(ctx context.Context, id string, index int) ([]byte, error) {
+	chunk, err := r.SecretRepository.GetChunk(ctx, id, index)
+	if err != nil {
+		return nil, err
+	}
+	return r.unwrap(id, chunk, true)
+}
+
+// RewriteBlobs passes unwrapped blobs to rewrite, and wraps what it returns.
+func (r *EncryptedRepository) RewriteBlobs(ctx context.Context, rewrite BlobRewriter) (int, error) {
+	return r.SecretRepository.RewriteBlobs(ctx, func(
+		id string, blob []byte, chunk bool,
+	) ([]byte, error) {
+		plain, err := r.unwrap(id, blob, chunk)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := rewrite(id, plain, chunk)
+		if err != nil || updated == nil {
+			return nil, err
+		}
+		return r.wrap(id, updated, chunk)
+	})
+}
+
+// Rewrap wraps every blob and chunk that isn't wrapped with the current
+// master key yet, and returns how many were. Once it has run, the other keys
+// are no longer needed.
+func (r *EncryptedRepository) Rewrap(ctx context.Context) (int, error) {
+	return r.SecretRepository.RewriteBlobs(ctx, func(
+		id string, blob []byte, chunk bool,
+	) ([]byte, error) {
+		if keyID, _, ok := cutEnvelope(blob); ok && keyID == r.current {
+			return nil, nil
+		}
+		plain, err := r.unwrap(id, blob, chunk)
+		if err != nil {
+			return nil, err
+		}
+		return r.wrap(id, plain, chunk)
+	})
+}
+
+// storedBlob returns the stored blob of a secret if it unwraps to old, or
+// ErrNotFound, as if the backend had compared them.
+func (r *EncryptedRepository) storedBlob(
+	ctx context.Context, id string, old []byte,
+) ([]byte, error) {
+	stored, err := r.SecretRepository.GetSecret(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := r.unwrap(id, stored, false)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(plain, old) {
+		return nil, ErrNotFound
+	}
+	return stored, nil
+}
+
+// wrap encrypts a blob with the current master key, bound to the id of its
+// secret.
+func (r *EncryptedRepository) wrap(id string, blob []byte, chunk bool) ([]byte, error) {
+	aead := r.aeads[r.current]
+	header := envelopePrefix + r.current + "$"
+	out := make([]byte, len(header), len(header)+aead.NonceSize()+len(blob)+aead.Overhead())
+	copy(out, header)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("nonce: %w", err)
+	}
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, blob, envelopeAAD(r.current, id, chunk)), nil
+}
+
+// unwrap decrypts a blob wrapped with any of the master keys. Blobs that
+// aren't wrapped are returned as they are.
+func (r *EncryptedRepository) unwrap(id string, blob []byte, chunk bool) ([]byte, error) {
+	keyID, data, ok := cutEnvelope(blob)
+	if !ok {
+		return blob, nil
+	}
+	aead, ok := r.aeads[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %q", keyID)
+	}
+	if len(data) < aead.NonceSize()+aead.Overhead() {
+		return nil, errors.New("wrapped blob too short")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, envelopeAAD(keyID, id, chunk))
+	if err != nil {
+		return nil, fmt.Errorf("unwrap with master key %q: %w", keyID, err)
+	}
+	return plain, nil
+}
+
+// cutEnvelope returns the key ID and data of a wrapped blob.
+func cutEnvelope(blob []byte) (keyID string, data []byte, ok bool) {
+	rest, ok := bytes.CutPrefix(blob, []byte(envelopePrefix))
+	if !ok {
+		return "", nil, false
+	}
+	id, data, ok := bytes.Cut(rest, []byte("$"))
+	if !ok {
+		return "", nil, false
+	}
+	return string(id), data, true
+}
+
+// envelopeAAD binds a wrapped blob to its key, its secret and what it holds,
+// so it can't be moved to another secret, or a chunk passed off as a blob.
+func envelopeAAD(keyID, id string, chunk bool) []byte {
+	kind := "blob"
+	if chunk {
+		kind = "chunk"
+	}
+	return []byte(envelopePrefix + keyID + "$" + id + "$" + kind)
+}
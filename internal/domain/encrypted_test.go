@@ -0,0 +1,142 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testMasterKey(id string, b byte) MasterKey {
+	return MasterKey{ID: id, Key: bytes.Repeat([]byte{b}, 32)}
+}
+
+func newTestEncryptedRepository(
+	t *testing.T, repo SecretRepository, keys ...MasterKey,
+) *EncryptedRepository {
+	t.Helper()
+	enc, err := NewEncryptedRepository(repo, keys)
+	if err != nil {
+		t.Fatalf("NewEncryptedRepository() error = %v", err)
+	}
+	return enc
+}
+
+func TestEncryptedRepository(t *testing.T) {
+	ctx := context.Background()
+	forEachBackend(t, func(t *testing.T, repo SecretRepository, _ func(time.Duration)) {
+		enc := newTestEncryptedRepository(t, repo, testMasterKey("k1", 1))
+		storeTestSecret(t, enc, "id", Secret{Blob: []byte("blob"), MaxViews: 2}, time.Hour)
+		if err := enc.AppendChunk(ctx, "id", []byte("chunk"), time.Hour); err != nil {
+			t.Fatalf("AppendChunk() error = %v", err)
+		}
+
+		stored, _ := repo.GetSecret(ctx, "id")
+		if !bytes.HasPrefix(stored, []byte("m1:k1$")) || bytes.Contains(stored, []byte("blob")) {
+			t.Errorf("expected a wrapped blob in storage, got %q", stored)
+		}
+		storedChunk, _ := repo.GetChunk(ctx, "id", 0)
+		if !bytes.HasPrefix(storedChunk, []byte("m1:k1$")) {
+			t.Errorf("expected a wrapped chunk in storage, got %q", storedChunk)
+		}
+
+		blob, err := enc.GetSecret(ctx, "id")
+		if err != nil || string(blob) != "blob" {
+			t.Fatalf("GetSecret() = %q, %v", blob, err)
+		}
+		if chunk, err := enc.GetChunk(ctx, "id", 0); err != nil || string(chunk) != "chunk" {
+			t.Errorf("GetChunk() = %q, %v", chunk, err)
+		}
+
+		if err := enc.ReplaceSecret(ctx, "id", []byte("other"), []byte("new")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound replacing another blob, got %v", err)
+		}
+		if err := enc.ReplaceSecret(ctx, "id", blob, []byte("new")); err != nil {
+			t.Fatalf("ReplaceSecret() error = %v", err)
+		}
+		if _, err := enc.DecrViewAndMaybeDelete(ctx, "id", blob); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound for a replaced blob, got %v", err)
+		}
+		if remaining, err := enc.DecrViewAndMaybeDelete(ctx, "id", []byte("new")); err != nil || remaining != 1 {
+			t.Errorf("DecrViewAndMaybeDelete() = %d, %v", remaining, err)
+		}
+	})
+}
+
+func TestEncryptedRepository_BoundToID(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository()
+	t.Cleanup(func() { _ = repo.Close() })
+	enc := newTestEncryptedRepository(t, repo, testMasterKey("k1", 1))
+
+	storeTestSecret(t, enc, "a", Secret{Blob: []byte("blob"), MaxViews: 1}, time.Hour)
+	stored, _ := repo.GetSecret(ctx, "a")
+	storeTestSecret(t, repo, "b", Secret{Blob: stored, MaxViews: 1}, time.Hour)
+
+	if _, err := enc.GetSecret(ctx, "b"); err == nil {
+		t.Error("expected a blob moved to another secret not to unwrap")
+	}
+}
+
+func TestEncryptedRepository_Rotation(t *testing.T) {
+	ctx := context.Background()
+	forEachBackend(t, func(t *testing.T, repo SecretRepository, _ func(time.Duration)) {
+		storeTestSecret(t, repo, "plain", Secret{Blob: []byte("legacy"), MaxViews: 1}, time.Hour)
+
+		old := newTestEncryptedRepository(t, repo, testMasterKey("k1", 1))
+		storeTestSecret(t, old, "id", Secret{Blob: []byte("blob"), MaxViews: 1}, time.Hour)
+		if err := old.AppendChunk(ctx, "id", []byte("chunk"), time.Hour); err != nil {
+			t.Fatalf("AppendChunk() error = %v", err)
+		}
+
+		rotated := newTestEncryptedRepository(t, repo, testMasterKey("k2", 2), testMasterKey("k1", 1))
+		for id, want := range map[string]string{"plain": "legacy", "id": "blob"} {
+			if blob, err := rotated.GetSecret(ctx, id); err != nil || string(blob) != want {
+				t.Errorf("GetSecret(%s) = %q, %v", id, blob, err)
+			}
+		}
+
+		n, err := rotated.Rewrap(ctx)
+		if err != nil {
+			t.Fatalf("Rewrap() error = %v", err)
+		}
+		if n != 3 {
+			t.Errorf("expected 3 rewrapped blobs, got %d", n)
+		}
+		if n, err := rotated.Rewrap(ctx); err != nil || n != 0 {
+			t.Errorf("second Rewrap() = %d, %v", n, err)
+		}
+
+		current := newTestEncryptedRepository(t, repo, testMasterKey("k2", 2))
+		for id, want := range map[string]string{"plain": "legacy", "id": "blob"} {
+			if blob, err := current.GetSecret(ctx, id); err != nil || string(blob) != want {
+				t.Errorf("GetSecret(%s) = %q, %v", id, blob, err)
+			}
+		}
+		if chunk, err := current.GetChunk(ctx, "id", 0); err != nil || string(chunk) != "chunk" {
+			t.Errorf("GetChunk() = %q, %v", chunk, err)
+		}
+		if _, err := old.GetSecret(ctx, "id"); err == nil {
+			t.Error("expected the retired key not to unwrap rewrapped blobs")
+		}
+	})
+}
+
+func TestNewEncryptedRepository_InvalidKeys(t *testing.T) {
+	repo := NewMemoryRepository()
+	t.Cleanup(func() { _ = repo.Close() })
+
+	tests := map[string][]MasterKey{
+		"none":         nil,
+		"empty id":     {testMasterKey("", 1)},
+		"id with $":    {testMasterKey("a$b", 1)},
+		"duplicate id": {testMasterKey("k1", 1), testMasterKey("k1", 2)},
+		"short key":    {{ID: "k1", Key: []byte("short")}},
+	}
+	for name, keys := range tests {
+		if _, err := NewEncryptedRepository(repo, keys); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Field is one named value of a structured secret, such as its username or
+// password.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Fields are the fields of a structured secret. They are encoded as a JSON
+// object that keeps the order the sender gave them in.
+type Fields []Field
+
+func (f Fields) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range f {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(field.Name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (f *Fields) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return errors.New("fields must be an object of strings")
+	}
+	fields := Fields{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var value *string
+		if err := dec.Decode(&value); err != nil || value == nil {
+			return errors.New("fields must be an object of strings")
+		}
+		fields = append(fields, Field{Name: tok.(string), Value: *value})
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	*f = fields
+	return nil
+}
+
+// Validate checks the number of fields and their names, which must be unique.
+func (f Fields) Validate() error {
+	if len(f) > MaxFields {
+		return fmt.Errorf("a secret can have at most %d fields", MaxFields)
+	}
+	seen := make(map[string]bool, len(f))
+	for _, field := range f {
+		switch {
+		case strings.TrimSpace(field.Name) == "":
+			return errors.New("field names can't be empty")
+		case len(field.Name) > MaxFieldNameSize:
+			return fmt.Errorf("field names can't exceed %d bytes", MaxFieldNameSize)
+		case seen[field.Name]:
+			return fmt.Errorf("duplicate field %q", field.Name)
+		}
+		seen[field.Name] = true
+	}
+	return nil
+}
+
+// Plain renders the fields one per line, as "name: value".
+func (f Fields) Plain() string {
+	var b strings.Builder
+	for _, field := range f {
+		fmt.Fprintf(&b, "%s: %s\n", field.Name, field.Value)
+	}
+	return b.String()
+}
+
+// Env renders the fields as shell variable assignments, one per line, with
+// their names turned into variable names, e.g. "api key" into API_KEY.
+// Values are single-quoted, so the output can be evaluated by a shell.
+func (f Fields) Env() string {
+	var b strings.Builder
+	for _, field := range f {
+		fmt.Fprintf(&b, "%s=%s\n", EnvName(field.Name), ShellQuote(field.Value))
+	}
+	return b.String()
+}
+
+// EnvName turns a field name into an environment variable name: upper case,
+// with anything but letters, digits and underscores replaced by underscores.
+func EnvName(name string) string {
+	env := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+	if env == "" || env[0] >= '0' && env[0] <= '9' {
+		env = "_" + env
+	}
+	return env
+}
+
+// ShellQuote quotes s for a POSIX shell.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,53 @@
+package domain
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFields_JSON(t *testing.T) {
+	in := `{"password":"hunter2","username":"admin","note":"line\nbreak"}`
+
+	var fields Fields
+	if err := json.Unmarshal([]byte(in), &fields); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := Fields{{"password", "hunter2"}, {"username", "admin"}, {"note", "line\nbreak"}}
+	if len(fields) != len(want) {
+		t.Fatalf("got %d fields, want %d", len(fields), len(want))
+	}
+	for i := range want {
+		if fields[i] != want[i] {
+			t.Errorf("field %d = %+v, want %+v", i, fields[i], want[i])
+		}
+	}
+
+	out, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(out) != in {
+		t.Errorf("Marshal() = %s, want %s", out, in)
+	}
+
+	for _, invalid := range []string{`[]`, `"a"`, `{"a":1}`, `{"a":null}`, `{"a":"b"`} {
+		if err := json.Unmarshal([]byte(invalid), &fields); err == nil {
+			t.Errorf("Unmarshal(%s) should fail", invalid)
+		}
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"username":     "USERNAME",
+		"api key":      "API_KEY",
+		"db-host.prod": "DB_HOST_PROD",
+		"2fa":          "_2FA",
+		"mot de passé": "MOT_DE_PASS_",
+	}
+	for name, want := range tests {
+		if got := EnvName(name); got != want {
+			t.Errorf("EnvName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
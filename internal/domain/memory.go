@@ -0,0 +1,377 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the embedded backends delete expired secrets.
+// Reads never return an expired secret in between, the sweep only reclaims
+// the storage.
+const sweepInterval = time.Minute
+
+type memorySecret struct {
+	blob      []byte
+	views     int64
+	attempts  int64
+	tokenHash []byte
+	expiresAt time.Time
+}
+
+type memoryChunks struct {
+	chunks    [][]byte
+	expiresAt time.Time
+}
+
+type memoryWebhook struct {
+	hook      Webhook
+	dueAt     time.Time // expiry of the secret
+	expiresAt time.Time // dueAt plus WebhookGracePeriod
+}
+
+// MemoryRepository keeps secrets in process memory. Secrets are lost on
+// restart and aren't shared between instances, so it suits a single server
+// that doesn't need them to survive a deploy.
+type MemoryRepository struct {
+	mu       sync.Mutex
+	secrets  map[string]*memorySecret
+	webhooks map[string]*memoryWebhook
+	chunks   map[string]*memoryChunks
+	now      func() time.Time
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewMemoryRepository returns an empty in-memory repository. Close stops its
+// background sweeper.
+func NewMemoryRepository() *MemoryRepository {
+	r := &MemoryRepository{
+		secrets:  make(map[string]*memorySecret),
+		webhooks: make(map[string]*memoryWebhook),
+		chunks:   make(map[string]*memoryChunks),
+		now:      time.Now,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go r.sweepLoop()
+	return r
+}
+
+func (r *MemoryRepository) Close() error {
+	close(r.stop)
+	<-r.done
+	return nil
+}
+
+func (r *MemoryRepository) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (r *MemoryRepository) StoreSecret(
+	ctx context.Context, id string, secret Secret, ttl time.Duration,
+) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	expiresAt := r.now().Add(ttl)
+	r.secrets[id] = &memorySecret{
+		blob:      bytes.Clone(secret.Blob),
+		views:     int64(secret.MaxViews),
+		tokenHash: bytes.Clone(secret.TokenHash),
+		expiresAt: expiresAt,
+	}
+	if c, ok := r.chunks[id]; ok {
+		c.expiresAt = expiresAt
+	}
+	if secret.Webhook != nil {
+		r.webhooks[id] = &memoryWebhook{
+			hook:      *secret.Webhook,
+			dueAt:     expiresAt,
+			expiresAt: expiresAt.Add(WebhookGracePeriod),
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) GetSecret(ctx context.Context, id string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.lookup(id)
+	if s == nil {
+		return nil, ErrNotFound
+	}
+	return bytes.Clone(s.blob), nil
+}
+
+func (r *MemoryRepository) ReplaceSecret(ctx context.Context, id string, old, blob []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.lookup(id)
+	if s == nil || !bytes.Equal(s.blob, old) {
+		return ErrNotFound
+	}
+	s.blob = bytes.Clone(blob)
+	return nil
+}
+
+func (r *MemoryRepository) DecrViewAndMaybeDelete(
+	ctx context.Context, id string, old []byte,
+) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.lookup(id)
+	if s == nil {
+		return 0, ErrNotFound
+	}
+	// The secret read by the caller was replaced since, it's gone.
+	if !bytes.Equal(s.blob, old) {
+		return 0, ErrNotFound
+	}
+
+	s.views--
+	if s.views <= 0 {
+		delete(r.secrets, id)
+		return 0, nil
+	}
+	return s.views, nil
+}
+
+func (r *MemoryRepository) IncrFailAndMaybeDelete(
+	ctx context.Context, id string, maxAttempts int,
+) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.lookup(id)
+	if s == nil {
+		return 0, nil
+	}
+
+	s.attempts++
+	if s.attempts >= int64(maxAttempts) {
+		delete(r.secrets, id)
+		delete(r.chunks, id)
+	}
+	return s.attempts, nil
+}
+
+func (r *MemoryRepository) DeleteSecret(ctx context.Context, id string, tokenHash []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.lookup(id)
+	if s == nil {
+		return ErrNotFound
+	}
+	if len(s.tokenHash) == 0 || subtle.ConstantTimeCompare(s.tokenHash, tokenHash) != 1 {
+		return ErrInvalidToken
+	}
+
+	// A revoked secret isn't reported to its webhook.
+	delete(r.secrets, id)
+	delete(r.webhooks, id)
+	delete(r.chunks, id)
+	return nil
+}
+
+func (r *MemoryRepository) GetStatus(
+	ctx context.Context, id string, tokenHash []byte,
+) (SecretStatus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.lookup(id)
+	if s == nil {
+		return SecretStatus{}, ErrNotFound
+	}
+	if len(s.tokenHash) == 0 || subtle.ConstantTimeCompare(s.tokenHash, tokenHash) != 1 {
+		return SecretStatus{}, ErrInvalidToken
+	}
+
+	return SecretStatus{
+		TTL:            s.expiresAt.Sub(r.now()),
+		RemainingViews: s.views,
+		FailedAttempts: s.attempts,
+	}, nil
+}
+
+func (r *MemoryRepository) GetWebhook(ctx context.Context, id string) (*Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w, ok := r.webhooks[id]
+	if !ok || !r.now().Before(w.expiresAt) {
+		return nil, nil
+	}
+	hook := w.hook
+	return &hook, nil
+}
+
+func (r *MemoryRepository) TakeWebhook(ctx context.Context, id string) (*Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w, ok := r.webhooks[id]
+	if !ok {
+		return nil, nil
+	}
+	delete(r.webhooks, id)
+	if !r.now().Before(w.expiresAt) {
+		return nil, nil
+	}
+	hook := w.hook
+	return &hook, nil
+}
+
+func (r *MemoryRepository) DueWebhooks(
+	ctx context.Context, before time.Time, limit int64,
+) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var ids []string
+	for id, w := range r.webhooks {
+		if !w.dueAt.After(before) {
+			ids = append(ids, id)
+		}
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		return r.webhooks[a].dueAt.Compare(r.webhooks[b].dueAt)
+	})
+	if int64(len(ids)) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+func (r *MemoryRepository) AppendChunk(
+	ctx context.Context, id string, chunk []byte, ttl time.Duration,
+) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.chunks[id]
+	if !ok {
+		c = &memoryChunks{}
+		r.chunks[id] = c
+	}
+	c.chunks = append(c.chunks, bytes.Clone(chunk))
+	c.expiresAt = r.now().Add(ttl)
+	return nil
+}
+
+func (r *MemoryRepository) GetChunk(ctx context.Context, id string, index int) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.chunks[id]
+	if !ok || !r.now().Before(c.expiresAt) || index < 0 || index >= len(c.chunks) {
+		return nil, ErrNotFound
+	}
+	return bytes.Clone(c.chunks[index]), nil
+}
+
+func (r *MemoryRepository) DeleteChunks(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.chunks, id)
+	return nil
+}
+
+func (r *MemoryRepository) RewriteBlobs(ctx context.Context, rewrite BlobRewriter) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rewritten := 0
+	for id := range r.secrets {
+		s := r.lookup(id)
+		if s == nil {
+			continue
+		}
+		updated, err := rewrite(id, bytes.Clone(s.blob), false)
+		if err != nil {
+			return rewritten, fmt.Errorf("rewrite id=%s: %w", id, err)
+		}
+		if updated != nil {
+			s.blob = bytes.Clone(updated)
+			rewritten++
+		}
+	}
+	for id, c := range r.chunks {
+		if !r.now().Before(c.expiresAt) {
+			continue
+		}
+		for i, chunk := range c.chunks {
+			updated, err := rewrite(id, bytes.Clone(chunk), true)
+			if err != nil {
+				return rewritten, fmt.Errorf("rewrite chunk %d of id=%s: %w", i, id, err)
+			}
+			if updated != nil {
+				c.chunks[i] = bytes.Clone(updated)
+				rewritten++
+			}
+		}
+	}
+	return rewritten, nil
+}
+
+// lookup returns the secret stored under id, or nil if there is none or it
+// has expired. The caller must hold r.mu.
+func (r *MemoryRepository) lookup(id string) *memorySecret {
+	s, ok := r.secrets[id]
+	if !ok {
+		return nil
+	}
+	if !r.now().Before(s.expiresAt) {
+		delete(r.secrets, id)
+		return nil
+	}
+	return s
+}
+
+func (r *MemoryRepository) sweepLoop() {
+	defer close(r.done)
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.sweep()
+		}
+	}
+}
+
+// sweep deletes expired secrets and chunks, and webhooks past their grace
+// period.
+func (r *MemoryRepository) sweep() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	for id, s := range r.secrets {
+		if !now.Before(s.expiresAt) {
+			delete(r.secrets, id)
+		}
+	}
+	for id, w := range r.webhooks {
+		if !now.Before(w.expiresAt) {
+			delete(r.webhooks, id)
+		}
+	}
+	for id, c := range r.chunks {
+		if !now.Before(c.expiresAt) {
+			delete(r.chunks, id)
+		}
+	}
+}
@@ -3,15 +3,42 @@ package domain
 import "time"
 
 type CreateReq struct {
-	Secret string `json:"secret"`
-	Expiry string `json:"expiry"` // one of: 1h, 6h, 1d, 3d
+	Secret     string     `json:"secret,omitempty"`
+	Ciphertext string     `json:"ciphertext,omitempty"` // client-side encrypted "v2:" blob
+	Fields     Fields     `json:"fields,omitempty"`     // instead of secret, e.g. username and password
+	Expiry     string     `json:"expiry,omitempty"`     // an expiry option or a duration, e.g. "2d12h"
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // instead of expiry
+	MaxViews   int        `json:"max_views,omitempty"`  // 1 to MaxViews, defaults to 1
+	NotifyURL  string     `json:"notify_url,omitempty"` // receives signed webhook events
+	Passphrase string     `json:"passphrase,omitempty"` // also required to read, shared separately
 }
 
 type CreateRes struct {
-	ID        string    `json:"id"`
-	Passcode  string    `json:"passcode"`
-	ExpiresAt time.Time `json:"expires_at"`
-	ReadURL   string    `json:"read_url"`
+	ID           string    `json:"id"`
+	Passcode     string    `json:"passcode,omitempty"` // empty for client-side encrypted secrets
+	ExpiresAt    time.Time `json:"expires_at"`
+	ReadURL      string    `json:"read_url"`
+	DeleteToken  string    `json:"delete_token"`            // revokes the secret via DELETE /secret/{id}
+	NotifySecret string    `json:"notify_secret,omitempty"` // signs webhook deliveries
+}
+
+type RequestReq struct {
+	Expiry    string     `json:"expiry,omitempty"`     // how long the link and its secret last
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // instead of expiry
+}
+
+type RequestRes struct {
+	ID          string    `json:"id"`
+	UploadURL   string    `json:"upload_url"` // sent to whoever will send the secret
+	ReadURL     string    `json:"read_url"`
+	Token       string    `json:"token"`        // reads the secret, as its passcode
+	DeleteToken string    `json:"delete_token"` // revokes or checks it via /secret/{id}
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type FulfillReq struct {
+	Secret string `json:"secret,omitempty"`
+	Fields Fields `json:"fields,omitempty"` // instead of secret
 }
 
 type ReadReq struct {
@@ -19,12 +46,29 @@ type ReadReq struct {
 }
 
 type ReadRes struct {
-	Secret            string `json:"secret,omitempty"`
-	RemainingAttempts *int   `json:"remaining_attempts,omitempty"`
+	Secret         string `json:"secret,omitempty"`
+	Ciphertext     string `json:"ciphertext,omitempty"`
+	Fields         Fields `json:"fields,omitempty"`
+	RemainingViews *int   `json:"remaining_views,omitempty"`
+}
+
+type StatusRes struct {
+	Exists         bool       `json:"exists"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	ExpiresIn      *int64     `json:"expires_in,omitempty"` // seconds
+	RemainingViews *int       `json:"remaining_views,omitempty"`
+	FailedAttempts *int       `json:"failed_attempts,omitempty"`
 }
 
 type ConfigRes struct {
-	MaxSecretSize int      `json:"max_secret_size"`
-	ExpiryOptions []string `json:"expiry_options"`
-	DefaultTheme  string   `json:"default_theme,omitempty"`
+	MaxSecretSize   int      `json:"max_secret_size"`
+	MaxFileSize     int64    `json:"max_file_size"` // 0 if file secrets are disabled
+	ExpiryOptions   []string `json:"expiry_options"`
+	DefaultExpiry   string   `json:"default_expiry"`
+	MinExpiry       string   `json:"min_expiry"` // bounds of any other expiry
+	MaxExpiry       string   `json:"max_expiry"`
+	MaxViews        int      `json:"max_views"`
+	MaxReadAttempts int      `json:"max_read_attempts"`
+	DefaultTheme    string   `json:"default_theme,omitempty"`
+	APIKeyRequired  bool     `json:"api_key_required,omitempty"` // to create secrets
 }
@@ -0,0 +1,127 @@
+package domain
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// ErrorCode identifies the kind of an error reported by the API, so clients
+// can tell errors apart without matching their messages.
+type ErrorCode string
+
+const (
+	CodeInvalidRequest      ErrorCode = "invalid_request"
+	CodeInvalidExpiry       ErrorCode = "invalid_expiry"
+	CodePasscodeRequired    ErrorCode = "passcode_required"
+	CodePassphraseRequired  ErrorCode = "passphrase_required"
+	CodeClientEncrypted     ErrorCode = "secret_client_encrypted"
+	CodeAPIKeyRequired      ErrorCode = "api_key_required"
+	CodeAPIKeyInvalid       ErrorCode = "api_key_invalid"
+	CodePasscodeInvalid     ErrorCode = "passcode_invalid"
+	CodeDeleteTokenRequired ErrorCode = "delete_token_required"
+	CodeDeleteTokenInvalid  ErrorCode = "delete_token_invalid"
+	CodeSecretNotFound      ErrorCode = "secret_not_found"
+	CodeSecretNotSent       ErrorCode = "secret_not_sent"
+	CodeLengthRequired      ErrorCode = "length_required"
+	CodeSecretTooLarge      ErrorCode = "secret_too_large"
+	CodeRateLimited         ErrorCode = "rate_limited"
+	CodeInternal            ErrorCode = "internal_error"
+)
+
+// errorKinds holds the status and title of the responses of each code.
+var errorKinds = map[ErrorCode]struct {
+	status int
+	title  string
+}{
+	CodeInvalidRequest:      {http.StatusBadRequest, "Invalid request"},
+	CodeInvalidExpiry:       {http.StatusBadRequest, "Invalid expiry"},
+	CodePasscodeRequired:    {http.StatusBadRequest, "Passcode required"},
+	CodePassphraseRequired:  {http.StatusBadRequest, "Passphrase required"},
+	CodeClientEncrypted:     {http.StatusBadRequest, "Secret encrypted client-side"},
+	CodeAPIKeyRequired:      {http.StatusUnauthorized, "API key required"},
+	CodeAPIKeyInvalid:       {http.StatusUnauthorized, "Invalid API key"},
+	CodePasscodeInvalid:     {http.StatusUnauthorized, "Invalid passcode"},
+	CodeDeleteTokenRequired: {http.StatusBadRequest, "Delete token required"},
+	CodeDeleteTokenInvalid:  {http.StatusForbidden, "Invalid delete token"},
+	CodeSecretNotFound:      {http.StatusNotFound, "Secret not found"},
+	CodeSecretNotSent:       {http.StatusConflict, "Secret not sent yet"},
+	CodeLengthRequired:      {http.StatusLengthRequired, "Length required"},
+	CodeSecretTooLarge:      {http.StatusRequestEntityTooLarge, "Secret too large"},
+	CodeRateLimited:         {http.StatusTooManyRequests, "Rate limit exceeded"},
+	CodeInternal:            {http.StatusInternalServerError, "Internal error"},
+}
+
+// ErrorCodes returns every code the API reports, sorted.
+func ErrorCodes() []ErrorCode {
+	codes := make([]ErrorCode, 0, len(errorKinds))
+	for code := range errorKinds {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	return codes
+}
+
+// Status returns the HTTP status of the errors of code.
+func (c ErrorCode) Status() int {
+	if k, ok := errorKinds[c]; ok {
+		return k.status
+	}
+	return http.StatusInternalServerError
+}
+
+// Title returns a short summary of the errors of code, which unlike their
+// details doesn't change from one occurrence to the next.
+func (c ErrorCode) Title() string {
+	if k, ok := errorKinds[c]; ok {
+		return k.title
+	}
+	return http.StatusText(http.StatusInternalServerError)
+}
+
+// Type returns the URI identifying the problem type of code.
+func (c ErrorCode) Type() string {
+	return "urn:secretapi:error:" + string(c)
+}
+
+// Error is an error to report to a client, with the code of its kind and a
+// message explaining this occurrence.
+type Error struct {
+	Code   ErrorCode
+	Detail string
+}
+
+// Errorf returns an Error of code with a formatted detail.
+func Errorf(code ErrorCode, format string, a ...any) *Error {
+	return &Error{Code: code, Detail: fmt.Sprintf(format, a...)}
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+// Problem is the body of an error response, an RFC 9457 problem details
+// object extended with the error code and, for some codes, what a client
+// can do about it.
+type Problem struct {
+	Type              string    `json:"type"`
+	Title             string    `json:"title"`
+	Status            int       `json:"status"`
+	Code              ErrorCode `json:"code"`
+	Detail            string    `json:"detail"`
+	Error             string    `json:"error"`                        // same as detail, for older clients
+	RemainingAttempts *int      `json:"remaining_attempts,omitempty"` // with passcode_invalid
+	RetryAfter        int       `json:"retry_after,omitempty"`        // seconds, with rate_limited
+}
+
+// NewProblem returns the problem details of an error of code.
+func NewProblem(code ErrorCode, detail string) Problem {
+	return Problem{
+		Type:   code.Type(),
+		Title:  code.Title(),
+		Status: code.Status(),
+		Code:   code,
+		Detail: detail,
+		Error:  detail,
+	}
+}
@@ -0,0 +1,514 @@
+package domain
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smallwat3r/secretapi/internal/logging"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// The scripts below run atomically on the Redis server, so concurrent
+// requests can't interleave between reading a secret's counters and acting on
+// them. Their keys are always secretKeys(id), in that order, optionally
+// followed by more.
+var (
+	// decrViewScript consumes a view if the secret still holds ARGV[1]. It
+	// returns the views left, or -1 if the secret is gone or was replaced.
+	// Secrets stored before views were tracked have no counter and are
+	// single view.
+	decrViewScript = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if not cur or cur ~= ARGV[1] then
+	return -1
+end
+local left = tonumber(redis.call('GET', KEYS[2]) or '1')
+if left <= 1 then
+	redis.call('DEL', KEYS[1], KEYS[2], KEYS[3], KEYS[4])
+	return 0
+end
+return redis.call('DECR', KEYS[2])
+`)
+
+	// replaceScript sets the secret to ARGV[2] if it still holds ARGV[1],
+	// keeping its TTL. It returns 1, or 0 if the secret is gone or was
+	// replaced.
+	replaceScript = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if not cur or cur ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2], 'KEEPTTL')
+return 1
+`)
+
+	// setChunkScript sets the chunk (KEYS[5]) at index ARGV[1] to ARGV[3] if
+	// it still holds ARGV[2]. It returns 1, or 0 if the chunk is gone or was
+	// replaced.
+	setChunkScript = redis.NewScript(`
+local cur = redis.call('LINDEX', KEYS[5], ARGV[1])
+if not cur or cur ~= ARGV[2] then
+	return 0
+end
+redis.call('LSET', KEYS[5], ARGV[1], ARGV[3])
+return 1
+`)
+
+	// incrFailScript counts a failed attempt, aligning the counter's TTL with
+	// the secret, and deletes the secret and its chunks (KEYS[5]) once
+	// ARGV[1] attempts are reached. It returns the attempt count, or 0 if the
+	// secret is gone.
+	incrFailScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+local attempts = redis.call('INCR', KEYS[3])
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl > 0 then
+	redis.call('PEXPIRE', KEYS[3], ttl)
+end
+if attempts >= tonumber(ARGV[1]) then
+	redis.call('DEL', KEYS[1], KEYS[2], KEYS[3], KEYS[4], KEYS[5])
+end
+return attempts
+`)
+
+	// deleteScript deletes a secret, its webhook (KEYS[5]) and its chunks
+	// (KEYS[6]) if the stored token hash equals ARGV[1]. It returns
+	// 1 once deleted, 0 for a wrong token and -1 if the secret is gone.
+	// Secrets stored before management tokens existed can't be revoked. The
+	// comparison isn't constant time, but it only leaks how much of a SHA-256
+	// hash matches.
+	deleteScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return -1
+end
+local stored = redis.call('GET', KEYS[4])
+if not stored or stored == '' or stored ~= ARGV[1] then
+	return 0
+end
+redis.call('DEL', KEYS[1], KEYS[2], KEYS[3], KEYS[4], KEYS[5], KEYS[6])
+return 1
+`)
+)
+
+type redisRepository struct {
+	rdb redis.UniversalClient
+}
+
+// NewRedisRepository returns a repository backed by a standalone Redis, a
+// Sentinel managed one or a Redis Cluster.
+func NewRedisRepository(rdb redis.UniversalClient) SecretRepository {
+	return &redisRepository{rdb: rdb}
+}
+
+func (r *redisRepository) Ping(ctx context.Context) error {
+	return r.rdb.Ping(ctx).Err()
+}
+
+func (r *redisRepository) StoreSecret(
+	ctx context.Context, id string, secret Secret, ttl time.Duration,
+) error {
+	var hook []byte
+	if secret.Webhook != nil {
+		var err error
+		if hook, err = json.Marshal(secret.Webhook); err != nil {
+			return err
+		}
+		// The due entry lives in another cluster slot than the secret, so it
+		// is added first: an entry without a secret is skipped by the expiry
+		// poller, while a secret without an entry would never report its
+		// expiry.
+		err = r.rdb.ZAdd(ctx, webhookDueKey, redis.Z{
+			Score:  float64(time.Now().Add(ttl).UnixMilli()),
+			Member: id,
+		}).Err()
+		if err != nil {
+			return err
+		}
+	}
+
+	key := redisKey(id)
+	_, err := r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, key, secret.Blob, ttl)
+		pipe.Set(ctx, viewsKey(id), secret.MaxViews, ttl)
+		if len(secret.TokenHash) > 0 {
+			pipe.Set(ctx, tokenKey(id), secret.TokenHash, ttl)
+		}
+		if hook != nil {
+			pipe.Set(ctx, webhookKey(id), hook, ttl+WebhookGracePeriod)
+		}
+		// The chunks of a file were appended during the upload, they now
+		// expire with the secret.
+		pipe.PExpire(ctx, chunksKey(id), ttl)
+		return nil
+	})
+	return err
+}
+
+func (r *redisRepository) GetSecret(ctx context.Context, id string) ([]byte, error) {
+	key := redisKey(id)
+	blob, err := r.rdb.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	return blob, err
+}
+
+func (r *redisRepository) ReplaceSecret(ctx context.Context, id string, old, blob []byte) error {
+	replaced, err := replaceScript.Run(ctx, r.rdb, secretKeys(id), old, blob).Int64()
+	if err != nil {
+		slog.ErrorContext(ctx, "ReplaceSecret failed", logging.ID(id), logging.Err(err))
+		return err
+	}
+	if replaced == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DecrViewAndMaybeDelete consumes one view of the secret if its current value
+// equals old, and returns the number of views left. The secret and all its
+// metadata are deleted once no views remain. It returns ErrNotFound if the
+// secret is already gone, so only as many callers as the secret has views
+// ever succeed.
+func (r *redisRepository) DecrViewAndMaybeDelete(
+	ctx context.Context, id string, old []byte,
+) (int64, error) {
+	remaining, err := decrViewScript.Run(ctx, r.rdb, secretKeys(id), old).Int64()
+	if err != nil {
+		slog.ErrorContext(ctx, "DecrViewAndMaybeDelete failed", logging.ID(id), logging.Err(err))
+		return 0, err
+	}
+	if remaining < 0 {
+		return 0, ErrNotFound
+	}
+	return remaining, nil
+}
+
+// IncrFailAndMaybeDelete counts a failed read attempt and returns the number
+// of attempts so far. The secret and all its metadata are deleted once
+// maxAttempts is reached. It returns 0 if the secret is already gone.
+func (r *redisRepository) IncrFailAndMaybeDelete(
+	ctx context.Context, id string, maxAttempts int,
+) (int64, error) {
+	keys := append(secretKeys(id), chunksKey(id))
+	attempts, err := incrFailScript.Run(ctx, r.rdb, keys, maxAttempts).Int64()
+	if err != nil {
+		slog.ErrorContext(ctx, "IncrFailAndMaybeDelete failed", logging.ID(id), logging.Err(err))
+		return 0, err
+	}
+	return attempts, nil
+}
+
+// DeleteSecret deletes the secret and all its metadata if tokenHash matches
+// the stored management token hash. It returns ErrNotFound if the secret is
+// gone and ErrInvalidToken if the token doesn't match.
+func (r *redisRepository) DeleteSecret(ctx context.Context, id string, tokenHash []byte) error {
+	keys := append(secretKeys(id), webhookKey(id), chunksKey(id))
+	res, err := deleteScript.Run(ctx, r.rdb, keys, tokenHash).Int64()
+	if err != nil {
+		slog.ErrorContext(ctx, "DeleteSecret failed", logging.ID(id), logging.Err(err))
+		return err
+	}
+	switch res {
+	case -1:
+		return ErrNotFound
+	case 0:
+		return ErrInvalidToken
+	}
+
+	// The webhook is already gone, a leftover entry is skipped by the expiry
+	// poller.
+	if err := r.rdb.ZRem(ctx, webhookDueKey, id).Err(); err != nil {
+		slog.ErrorContext(ctx, "failed to unschedule webhook", logging.ID(id), logging.Err(err))
+	}
+	return nil
+}
+
+// GetStatus returns the remaining TTL, views and failed attempts of a secret
+// if tokenHash matches the stored management token hash. It returns
+// ErrNotFound if the secret is gone and ErrInvalidToken if the token doesn't
+// match.
+func (r *redisRepository) GetStatus(
+	ctx context.Context, id string, tokenHash []byte,
+) (SecretStatus, error) {
+	pipe := r.rdb.Pipeline()
+	pttl := pipe.PTTL(ctx, redisKey(id))
+	tok := pipe.Get(ctx, tokenKey(id))
+	views := pipe.Get(ctx, viewsKey(id))
+	attempts := pipe.Get(ctx, attemptsKey(id))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return SecretStatus{}, err
+	}
+
+	// PTTL returns -2 when the key doesn't exist
+	ttl := pttl.Val()
+	if ttl == -2 {
+		return SecretStatus{}, ErrNotFound
+	}
+
+	stored, _ := tok.Bytes()
+	if len(stored) == 0 || subtle.ConstantTimeCompare(stored, tokenHash) != 1 {
+		return SecretStatus{}, ErrInvalidToken
+	}
+
+	status := SecretStatus{TTL: ttl, RemainingViews: 1}
+	if n, err := views.Int64(); err == nil {
+		status.RemainingViews = n
+	}
+	if n, err := attempts.Int64(); err == nil {
+		status.FailedAttempts = n
+	}
+	return status, nil
+}
+
+// GetWebhook returns the webhook registered for a secret, or nil if it has
+// none.
+func (r *redisRepository) GetWebhook(ctx context.Context, id string) (*Webhook, error) {
+	data, err := r.rdb.Get(ctx, webhookKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeWebhook(data)
+}
+
+// TakeWebhook removes the webhook registered for a secret and returns it, or
+// nil if it has none. Only one caller ever gets a given webhook, so each
+// final event is delivered once even with several server instances.
+func (r *redisRepository) TakeWebhook(ctx context.Context, id string) (*Webhook, error) {
+	data, err := r.rdb.GetDel(ctx, webhookKey(id)).Bytes()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	// The due entry lives in another cluster slot, so it can't be removed in
+	// the same transaction. GETDEL alone decides who gets the webhook.
+	if err := r.rdb.ZRem(ctx, webhookDueKey, id).Err(); err != nil {
+		slog.ErrorContext(ctx, "failed to unschedule webhook", logging.ID(id), logging.Err(err))
+	}
+	if data == nil {
+		return nil, nil
+	}
+	return decodeWebhook(data)
+}
+
+// DueWebhooks returns up to limit ids of secrets with a webhook that were due
+// to expire before the given time.
+func (r *redisRepository) DueWebhooks(
+	ctx context.Context, before time.Time, limit int64,
+) ([]string, error) {
+	return r.rdb.ZRangeByScore(ctx, webhookDueKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(before.UnixMilli(), 10),
+		Count: limit,
+	}).Result()
+}
+
+// AppendChunk appends an encrypted chunk to the content of a file secret.
+func (r *redisRepository) AppendChunk(
+	ctx context.Context, id string, chunk []byte, ttl time.Duration,
+) error {
+	_, err := r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, chunksKey(id), chunk)
+		pipe.PExpire(ctx, chunksKey(id), ttl)
+		return nil
+	})
+	return err
+}
+
+// GetChunk returns the index-th chunk of a file secret, or ErrNotFound.
+func (r *redisRepository) GetChunk(ctx context.Context, id string, index int) ([]byte, error) {
+	chunk, err := r.rdb.LIndex(ctx, chunksKey(id), int64(index)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	return chunk, err
+}
+
+// DeleteChunks deletes the content of a file secret.
+func (r *redisRepository) DeleteChunks(ctx context.Context, id string) error {
+	return r.rdb.Del(ctx, chunksKey(id)).Err()
+}
+
+// RewriteBlobs scans every master of a Redis Cluster, or the single Redis
+// otherwise. Blobs are swapped by scripts that check they still hold what
+// was passed to rewrite.
+func (r *redisRepository) RewriteBlobs(ctx context.Context, rewrite BlobRewriter) (int, error) {
+	cluster, ok := r.rdb.(*redis.ClusterClient)
+	if !ok {
+		return r.rewriteNode(ctx, r.rdb, rewrite)
+	}
+
+	var mu sync.Mutex
+	total := 0
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		n, err := r.rewriteNode(ctx, node, rewrite)
+		mu.Lock()
+		total += n
+		mu.Unlock()
+		return err
+	})
+	return total, err
+}
+
+// rewriteNode rewrites the blobs and chunks whose keys are found on node.
+func (r *redisRepository) rewriteNode(
+	ctx context.Context, node redis.UniversalClient, rewrite BlobRewriter,
+) (int, error) {
+	rewritten := 0
+	iter := node.Scan(ctx, 0, redisKey("*"), 1000).Iterator()
+	for iter.Next(ctx) {
+		id, ok := keyID(iter.Val())
+		if !ok {
+			continue
+		}
+		blob, err := r.rdb.Get(ctx, redisKey(id)).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue // expired since the scan
+		}
+		if err != nil {
+			return rewritten, err
+		}
+		updated, err := rewrite(id, blob, false)
+		if err != nil {
+			return rewritten, fmt.Errorf("rewrite id=%s: %w", id, err)
+		}
+		if updated == nil {
+			continue
+		}
+		done, err := replaceScript.Run(ctx, r.rdb, secretKeys(id), blob, updated).Int64()
+		if err != nil {
+			return rewritten, err
+		}
+		rewritten += int(done)
+	}
+	if err := iter.Err(); err != nil {
+		return rewritten, err
+	}
+
+	iter = node.Scan(ctx, 0, chunksKey("*"), 1000).Iterator()
+	for iter.Next(ctx) {
+		id, ok := keyID(iter.Val())
+		if !ok {
+			continue
+		}
+		keys := append(secretKeys(id), chunksKey(id))
+		for i := int64(0); ; i++ {
+			chunk, err := r.rdb.LIndex(ctx, chunksKey(id), i).Bytes()
+			if errors.Is(err, redis.Nil) {
+				break
+			}
+			if err != nil {
+				return rewritten, err
+			}
+			updated, err := rewrite(id, chunk, true)
+			if err != nil {
+				return rewritten, fmt.Errorf("rewrite chunk %d of id=%s: %w", i, id, err)
+			}
+			if updated == nil {
+				continue
+			}
+			done, err := setChunkScript.Run(ctx, r.rdb, keys, i, chunk, updated).Int64()
+			if err != nil {
+				return rewritten, err
+			}
+			rewritten += int(done)
+		}
+	}
+	return rewritten, iter.Err()
+}
+
+func decodeWebhook(data []byte) (*Webhook, error) {
+	var hook Webhook
+	if err := json.Unmarshal(data, &hook); err != nil {
+		return nil, err
+	}
+	return &hook, nil
+}
+
+// webhookDueKey is a sorted set of the ids of secrets with a webhook, scored
+// by expiry time in milliseconds.
+const webhookDueKey = "webhooks:due"
+
+// MigrateLegacyKeys renames the keys of secrets stored before keys had hash
+// tags, so they stay readable after an upgrade, and returns how many were
+// renamed. Older versions didn't support Redis Cluster, so there is nothing
+// to migrate there.
+func MigrateLegacyKeys(ctx context.Context, rdb redis.UniversalClient) (int, error) {
+	if _, ok := rdb.(*redis.ClusterClient); ok {
+		return 0, nil
+	}
+
+	renamed := 0
+	iter := rdb.Scan(ctx, 0, "secret:*", 1000).Iterator()
+	for iter.Next(ctx) {
+		old := iter.Val()
+		key, ok := taggedKey(old)
+		if !ok {
+			continue
+		}
+		// RENAMENX keeps the TTL, and never overwrites a newer key.
+		done, err := rdb.RenameNX(ctx, old, key).Result()
+		if err != nil && strings.Contains(err.Error(), "no such key") {
+			continue // expired since the scan
+		}
+		if err != nil {
+			return renamed, err
+		}
+		if done {
+			renamed++
+		}
+	}
+	return renamed, iter.Err()
+}
+
+// taggedKey returns the hash tagged equivalent of a legacy key.
+func taggedKey(legacy string) (string, bool) {
+	rest, ok := strings.CutPrefix(legacy, "secret:")
+	if !ok || strings.Contains(rest, "{") {
+		return "", false
+	}
+	for _, prefix := range []string{"attempts:", "views:", "token:", "webhook:"} {
+		if id, ok := strings.CutPrefix(rest, prefix); ok {
+			return "secret:" + prefix + "{" + id + "}", true
+		}
+	}
+	return redisKey(rest), true
+}
+
+// The keys of a secret share the {id} hash tag, so they live in the same
+// Redis Cluster slot and can be used together in a script or transaction.
+func redisKey(id string) string    { return "secret:{" + id + "}" }
+func attemptsKey(id string) string { return "secret:attempts:{" + id + "}" }
+func viewsKey(id string) string    { return "secret:views:{" + id + "}" }
+func tokenKey(id string) string    { return "secret:token:{" + id + "}" }
+func webhookKey(id string) string  { return "secret:webhook:{" + id + "}" }
+func chunksKey(id string) string   { return "secret:chunks:{" + id + "}" }
+
+// keyID returns the id in the hash tag of a key.
+func keyID(key string) (string, bool) {
+	_, rest, ok := strings.Cut(key, "{")
+	if !ok {
+		return "", false
+	}
+	id, _, ok := strings.Cut(rest, "}")
+	return id, ok && id != ""
+}
+
+// secretKeys returns the keys that belong to a secret and go along with its
+// last view. Its webhook and chunks are handled apart.
+func secretKeys(id string) []string {
+	return []string{redisKey(id), viewsKey(id), attemptsKey(id), tokenKey(id)}
+}
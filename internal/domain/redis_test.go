@@ -0,0 +1,216 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedisRepository(t *testing.T) (SecretRepository, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return NewRedisRepository(rdb), mr
+}
+
+func TestRedisRepository_DecrViewAndMaybeDelete(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("single view secret is deleted on first read", func(t *testing.T) {
+		repo, mr := newTestRedisRepository(t)
+		if err := repo.StoreSecret(ctx, "id", Secret{Blob: []byte("blob"), MaxViews: 1}, time.Hour); err != nil {
+			t.Fatalf("StoreSecret() error = %v", err)
+		}
+
+		remaining, err := repo.DecrViewAndMaybeDelete(ctx, "id", []byte("blob"))
+		if err != nil {
+			t.Fatalf("DecrViewAndMaybeDelete() error = %v", err)
+		}
+		if remaining != 0 {
+			t.Errorf("expected 0 remaining views, got %d", remaining)
+		}
+		if mr.Exists(redisKey("id")) || mr.Exists(viewsKey("id")) {
+			t.Error("expected secret and views counter to be deleted")
+		}
+	})
+
+	t.Run("multi view secret counts down then is deleted", func(t *testing.T) {
+		repo, mr := newTestRedisRepository(t)
+		if err := repo.StoreSecret(ctx, "id", Secret{Blob: []byte("blob"), MaxViews: 3}, time.Hour); err != nil {
+			t.Fatalf("StoreSecret() error = %v", err)
+		}
+		mr.Set(attemptsKey("id"), "1")
+
+		for _, want := range []int64{2, 1, 0} {
+			remaining, err := repo.DecrViewAndMaybeDelete(ctx, "id", []byte("blob"))
+			if err != nil {
+				t.Fatalf("DecrViewAndMaybeDelete() error = %v", err)
+			}
+			if remaining != want {
+				t.Errorf("expected %d remaining views, got %d", want, remaining)
+			}
+		}
+		for _, key := range secretKeys("id") {
+			if mr.Exists(key) {
+				t.Errorf("expected %s to be deleted", key)
+			}
+		}
+
+		_, err := repo.DecrViewAndMaybeDelete(ctx, "id", []byte("blob"))
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound once consumed, got %v", err)
+		}
+	})
+
+	t.Run("secret without views counter is single view", func(t *testing.T) {
+		repo, mr := newTestRedisRepository(t)
+		mr.Set(redisKey("id"), "blob")
+
+		remaining, err := repo.DecrViewAndMaybeDelete(ctx, "id", []byte("blob"))
+		if err != nil {
+			t.Fatalf("DecrViewAndMaybeDelete() error = %v", err)
+		}
+		if remaining != 0 || mr.Exists(redisKey("id")) {
+			t.Error("expected legacy secret to be deleted on first read")
+		}
+	})
+}
+
+func TestRedisRepository_IncrFailAndMaybeDelete(t *testing.T) {
+	ctx := context.Background()
+	repo, mr := newTestRedisRepository(t)
+	if err := repo.StoreSecret(ctx, "id", Secret{Blob: []byte("blob"), MaxViews: 2}, time.Hour); err != nil {
+		t.Fatalf("StoreSecret() error = %v", err)
+	}
+
+	for i := int64(1); i <= MaxReadAttempts; i++ {
+		attempts, err := repo.IncrFailAndMaybeDelete(ctx, "id", MaxReadAttempts)
+		if err != nil {
+			t.Fatalf("IncrFailAndMaybeDelete() error = %v", err)
+		}
+		if attempts != i {
+			t.Errorf("expected %d attempts, got %d", i, attempts)
+		}
+	}
+	for _, key := range secretKeys("id") {
+		if mr.Exists(key) {
+			t.Errorf("expected %s to be deleted after max attempts", key)
+		}
+	}
+}
+
+func TestRedisRepository_DeleteSecret(t *testing.T) {
+	ctx := context.Background()
+	tokenHash := []byte("token-hash")
+
+	t.Run("deletes the secret and its metadata with the right token", func(t *testing.T) {
+		repo, mr := newTestRedisRepository(t)
+		secret := Secret{Blob: []byte("blob"), MaxViews: 2, TokenHash: tokenHash}
+		if err := repo.StoreSecret(ctx, "id", secret, time.Hour); err != nil {
+			t.Fatalf("StoreSecret() error = %v", err)
+		}
+		mr.Set(attemptsKey("id"), "1")
+
+		if err := repo.DeleteSecret(ctx, "id", tokenHash); err != nil {
+			t.Fatalf("DeleteSecret() error = %v", err)
+		}
+		for _, key := range secretKeys("id") {
+			if mr.Exists(key) {
+				t.Errorf("expected %s to be deleted", key)
+			}
+		}
+	})
+
+	t.Run("rejects the wrong token", func(t *testing.T) {
+		repo, mr := newTestRedisRepository(t)
+		secret := Secret{Blob: []byte("blob"), MaxViews: 1, TokenHash: tokenHash}
+		if err := repo.StoreSecret(ctx, "id", secret, time.Hour); err != nil {
+			t.Fatalf("StoreSecret() error = %v", err)
+		}
+
+		err := repo.DeleteSecret(ctx, "id", []byte("other-hash"))
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("expected ErrInvalidToken, got %v", err)
+		}
+		if !mr.Exists(redisKey("id")) {
+			t.Error("expected secret to be kept")
+		}
+	})
+
+	t.Run("secret without token can't be revoked", func(t *testing.T) {
+		repo, mr := newTestRedisRepository(t)
+		mr.Set(redisKey("id"), "blob")
+
+		err := repo.DeleteSecret(ctx, "id", nil)
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("expected ErrInvalidToken, got %v", err)
+		}
+	})
+
+	t.Run("returns ErrNotFound when the secret is gone", func(t *testing.T) {
+		repo, _ := newTestRedisRepository(t)
+		err := repo.DeleteSecret(ctx, "id", tokenHash)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+}
+
+func TestRedisKeys_ShareHashTag(t *testing.T) {
+	// Redis Cluster only runs scripts and transactions on keys of one slot.
+	for _, key := range append(secretKeys("abc"), webhookKey("abc")) {
+		if !strings.Contains(key, "{abc}") {
+			t.Errorf("expected %s to carry the {abc} hash tag", key)
+		}
+	}
+}
+
+func TestMigrateLegacyKeys(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	mr.Set("secret:legacy", "blob")
+	mr.SetTTL("secret:legacy", time.Hour)
+	mr.Set("secret:views:legacy", "2")
+	mr.SetTTL("secret:views:legacy", time.Hour)
+	mr.Set("secret:attempts:legacy", "1")
+	mr.Set(redisKey("current"), "other")
+	mr.Set("ratelimit:1.2.3.4", "1")
+
+	renamed, err := MigrateLegacyKeys(ctx, rdb)
+	if err != nil {
+		t.Fatalf("MigrateLegacyKeys() error = %v", err)
+	}
+	if renamed != 3 {
+		t.Errorf("expected 3 renamed keys, got %d", renamed)
+	}
+
+	repo := NewRedisRepository(rdb)
+	blob, err := repo.GetSecret(ctx, "legacy")
+	if err != nil || string(blob) != "blob" {
+		t.Fatalf("expected migrated secret to be readable, got %q, %v", blob, err)
+	}
+	if ttl := mr.TTL(redisKey("legacy")); ttl != time.Hour {
+		t.Errorf("expected the TTL to be kept, got %v", ttl)
+	}
+	remaining, err := repo.DecrViewAndMaybeDelete(ctx, "legacy", blob)
+	if err != nil || remaining != 1 {
+		t.Errorf("expected the view count to be kept, got %d, %v", remaining, err)
+	}
+	if !mr.Exists(redisKey("current")) || !mr.Exists("ratelimit:1.2.3.4") {
+		t.Error("expected other keys to be left alone")
+	}
+
+	renamed, err = MigrateLegacyKeys(ctx, rdb)
+	if err != nil || renamed != 0 {
+		t.Errorf("expected a second run to be a no-op, got %d, %v", renamed, err)
+	}
+}
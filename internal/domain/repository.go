@@ -1,151 +1,79 @@
 package domain
 
 import (
-	"bytes"
 	"context"
 	"errors"
-	"log"
 	"time"
-
-	"github.com/redis/go-redis/v9"
 )
 
-const maxWatchRetries = 3
-
-type SecretRepository interface {
-	StoreSecret(ctx context.Context, id string, secret []byte, ttl time.Duration) error
-	GetSecret(ctx context.Context, id string) ([]byte, error)
-	DelIfMatch(ctx context.Context, id string, old []byte) error
-	IncrFailAndMaybeDelete(ctx context.Context, id string) (int64, error)
-	DeleteAttempts(ctx context.Context, id string) error
-	Ping(ctx context.Context) error
-}
-
-type redisRepository struct {
-	rdb *redis.Client
-}
-
-func NewRedisRepository(rdb *redis.Client) SecretRepository {
-	return &redisRepository{rdb: rdb}
-}
+var (
+	// ErrNotFound is returned when a secret doesn't exist, either because it
+	// was never created or because it was read, revoked, burned or expired.
+	ErrNotFound = errors.New("secret not found")
 
-func (r *redisRepository) Ping(ctx context.Context) error {
-	return r.rdb.Ping(ctx).Err()
-}
-
-func (r *redisRepository) StoreSecret(
-	ctx context.Context, id string, secret []byte, ttl time.Duration,
-) error {
-	key := redisKey(id)
-	return r.rdb.Set(ctx, key, secret, ttl).Err()
-}
+	// ErrInvalidToken is returned when a management token does not match the
+	// one stored for a secret.
+	ErrInvalidToken = errors.New("invalid management token")
+)
 
-func (r *redisRepository) GetSecret(ctx context.Context, id string) ([]byte, error) {
-	key := redisKey(id)
-	return r.rdb.Get(ctx, key).Bytes()
+// Secret is an encrypted secret along with the metadata stored next to it.
+type Secret struct {
+	Blob      []byte
+	MaxViews  int
+	TokenHash []byte // SHA-256 of the sender's management token
+	Webhook   *Webhook
 }
 
-func (r *redisRepository) DeleteAttempts(ctx context.Context, id string) error {
-	key := attemptsKey(id)
-	return r.rdb.Del(ctx, key).Err()
+// Webhook is where events about a secret are delivered. It is stored apart
+// from the secret so that it outlives it long enough to report its expiry.
+type Webhook struct {
+	URL string `json:"url"`
+	Key []byte `json:"key"` // HMAC-SHA256 signing key, shared with the sender
 }
 
-// DelIfMatch deletes the key if its current value equals old.
-func (r *redisRepository) DelIfMatch(ctx context.Context, id string, old []byte) error {
-	key := redisKey(id)
-
-	var err error
-	for i := 0; i < maxWatchRetries; i++ {
-		err = r.rdb.Watch(ctx, func(tx *redis.Tx) error {
-			cur, err := tx.Get(ctx, key).Bytes()
-			if errors.Is(err, redis.Nil) {
-				return nil // key already gone
-			}
-			if err != nil {
-				return err
-			}
-			if !bytes.Equal(cur, old) {
-				return redis.TxFailedErr // value changed, abort
-			}
-			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
-				pipe.Del(ctx, key)
-				return nil
-			})
-			return err
-		}, key)
-
-		if !errors.Is(err, redis.TxFailedErr) {
-			break
-		}
-	}
-
-	if err != nil && !errors.Is(err, redis.TxFailedErr) {
-		log.Printf("DelIfMatch failed for id=%s: %v", id, err)
-		return err
-	}
-	return nil
+// SecretStatus describes a stored secret without revealing its content.
+type SecretStatus struct {
+	TTL            time.Duration
+	RemainingViews int64
+	FailedAttempts int64
 }
 
-func (r *redisRepository) IncrFailAndMaybeDelete(ctx context.Context, id string) (int64, error) {
-	key := redisKey(id)
-	att := attemptsKey(id)
-	var cnt *redis.IntCmd
-
-	var err error
-	for i := 0; i < maxWatchRetries; i++ {
-		err = r.rdb.Watch(ctx, func(tx *redis.Tx) error {
-			exists, err := tx.Exists(ctx, key).Result()
-			if err != nil {
-				return err
-			}
-			if exists == 0 {
-				return nil // secret gone
-			}
-
-			ttl, err := tx.PTTL(ctx, key).Result()
-			if err != nil {
-				return err
-			}
-
-			// INCR attempts and align TTL
-			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
-				cnt = pipe.Incr(ctx, att)
-				if ttl > 0 {
-					pipe.PExpire(ctx, att, ttl)
-				}
-				return nil
-			})
-			if err != nil {
-				return err
-			}
-
-			if cnt.Val() >= MaxReadAttempts {
-				// delete secret and attempts counter
-				_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
-					pipe.Del(ctx, key, att)
-					return nil
-				})
-				return err
-			}
-			return nil
-		}, key, att)
-
-		if !errors.Is(err, redis.TxFailedErr) {
-			break
-		}
-		cnt = nil // reset for retry
-	}
-
-	if err != nil && !errors.Is(err, redis.TxFailedErr) {
-		log.Printf("IncrFailAndMaybeDelete failed for id=%s: %v", id, err)
-		return 0, err
-	}
-
-	if cnt == nil {
-		return 0, nil
-	}
-	return cnt.Val(), nil
+// BlobRewriter returns the new content of the blob of the secret id, or of
+// one of its chunks, or nil to keep it.
+type BlobRewriter func(id string, blob []byte, chunk bool) ([]byte, error)
+
+// SecretRepository stores secrets. Implementations must make every method
+// atomic, so that a secret can never be read more times than it allows, even
+// by concurrent readers.
+//
+// The content of a file secret is stored apart as chunks, appended before
+// the secret itself is stored. They are deleted along with a burned or
+// revoked secret, but outlive its last view until DeleteChunks, so the last
+// reader can still stream them. Either way they expire with the secret.
+//
+// ReplaceSecret swaps the blob of a secret if it still holds old, keeping its
+// expiry, views and management token. It returns ErrNotFound otherwise, so a
+// secret request can only be fulfilled once.
+//
+// RewriteBlobs passes the blob of every live secret, and every chunk of a
+// file secret, to rewrite, and stores what it returns in their place without
+// touching anything else. A nil result leaves the blob as is, and so does a
+// concurrent change to it. It returns how many blobs were rewritten, and is
+// meant for maintenance such as rotating the master key.
+type SecretRepository interface {
+	StoreSecret(ctx context.Context, id string, secret Secret, ttl time.Duration) error
+	GetSecret(ctx context.Context, id string) ([]byte, error)
+	ReplaceSecret(ctx context.Context, id string, old, blob []byte) error
+	DecrViewAndMaybeDelete(ctx context.Context, id string, old []byte) (int64, error)
+	IncrFailAndMaybeDelete(ctx context.Context, id string, maxAttempts int) (int64, error)
+	DeleteSecret(ctx context.Context, id string, tokenHash []byte) error
+	GetStatus(ctx context.Context, id string, tokenHash []byte) (SecretStatus, error)
+	GetWebhook(ctx context.Context, id string) (*Webhook, error)
+	TakeWebhook(ctx context.Context, id string) (*Webhook, error)
+	DueWebhooks(ctx context.Context, before time.Time, limit int64) ([]string, error)
+	AppendChunk(ctx context.Context, id string, chunk []byte, ttl time.Duration) error
+	GetChunk(ctx context.Context, id string, index int) ([]byte, error)
+	DeleteChunks(ctx context.Context, id string) error
+	RewriteBlobs(ctx context.Context, rewrite BlobRewriter) (int, error)
+	Ping(ctx context.Context) error
 }
-
-func redisKey(id string) string    { return "secret:" + id }
-func attemptsKey(id string) string { return "secret:attempts:" + id }
@@ -0,0 +1,536 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// testClock is a settable clock for the embedded backends.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// testBackends opens a fresh repository of each backend, along with a
+// function moving its clock forward.
+var testBackends = []struct {
+	name string
+	open func(t *testing.T) (SecretRepository, func(time.Duration))
+}{
+	{"redis", func(t *testing.T) (SecretRepository, func(time.Duration)) {
+		repo, mr := newTestRedisRepository(t)
+		return repo, mr.FastForward
+	}},
+	{"memory", func(t *testing.T) (SecretRepository, func(time.Duration)) {
+		repo := NewMemoryRepository()
+		t.Cleanup(func() { _ = repo.Close() })
+		clock := &testClock{now: time.Now()}
+		repo.now = clock.Now
+		return repo, clock.Advance
+	}},
+	{"bolt", func(t *testing.T) (SecretRepository, func(time.Duration)) {
+		repo, err := NewBoltRepository(filepath.Join(t.TempDir(), "secretapi.db"))
+		if err != nil {
+			t.Fatalf("NewBoltRepository() error = %v", err)
+		}
+		t.Cleanup(func() { _ = repo.Close() })
+		clock := &testClock{now: time.Now()}
+		repo.now = clock.Now
+		return repo, clock.Advance
+	}},
+}
+
+func forEachBackend(t *testing.T, test func(t *testing.T, repo SecretRepository, advance func(time.Duration))) {
+	t.Helper()
+	for _, backend := range testBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			repo, advance := backend.open(t)
+			test(t, repo, advance)
+		})
+	}
+}
+
+func storeTestSecret(t *testing.T, repo SecretRepository, id string, secret Secret, ttl time.Duration) {
+	t.Helper()
+	if err := repo.StoreSecret(context.Background(), id, secret, ttl); err != nil {
+		t.Fatalf("StoreSecret() error = %v", err)
+	}
+}
+
+func TestRepository_Views(t *testing.T) {
+	ctx := context.Background()
+	forEachBackend(t, func(t *testing.T, repo SecretRepository, _ func(time.Duration)) {
+		storeTestSecret(t, repo, "id", Secret{Blob: []byte("blob"), MaxViews: 3}, time.Hour)
+
+		for _, want := range []int64{2, 1, 0} {
+			blob, err := repo.GetSecret(ctx, "id")
+			if err != nil || string(blob) != "blob" {
+				t.Fatalf("GetSecret() = %q, %v", blob, err)
+			}
+			remaining, err := repo.DecrViewAndMaybeDelete(ctx, "id", blob)
+			if err != nil {
+				t.Fatalf("DecrViewAndMaybeDelete() error = %v", err)
+			}
+			if remaining != want {
+				t.Errorf("expected %d remaining views, got %d", want, remaining)
+			}
+		}
+
+		if _, err := repo.GetSecret(ctx, "id"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound once consumed, got %v", err)
+		}
+		if _, err := repo.DecrViewAndMaybeDelete(ctx, "id", []byte("blob")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound once consumed, got %v", err)
+		}
+	})
+}
+
+func TestRepository_ConcurrentReaders(t *testing.T) {
+	ctx := context.Background()
+	const readers = 50
+
+	for _, maxViews := range []int{1, 3} {
+		t.Run(fmt.Sprintf("%d views", maxViews), func(t *testing.T) {
+			forEachBackend(t, func(t *testing.T, repo SecretRepository, _ func(time.Duration)) {
+				storeTestSecret(t, repo, "id", Secret{Blob: []byte("blob"), MaxViews: maxViews}, time.Hour)
+
+				var (
+					wg      sync.WaitGroup
+					start   = make(chan struct{})
+					winners atomic.Int32
+				)
+				for range readers {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						<-start
+						_, err := repo.DecrViewAndMaybeDelete(ctx, "id", []byte("blob"))
+						switch {
+						case err == nil:
+							winners.Add(1)
+						case !errors.Is(err, ErrNotFound):
+							t.Errorf("DecrViewAndMaybeDelete() error = %v", err)
+						}
+					}()
+				}
+				close(start)
+				wg.Wait()
+
+				if got := winners.Load(); got != int32(maxViews) {
+					t.Errorf("expected exactly %d of %d readers to consume a view, got %d",
+						maxViews, readers, got)
+				}
+			})
+		})
+	}
+}
+
+func TestRepository_ReplacedSecret(t *testing.T) {
+	ctx := context.Background()
+	forEachBackend(t, func(t *testing.T, repo SecretRepository, _ func(time.Duration)) {
+		storeTestSecret(t, repo, "id", Secret{Blob: []byte("new"), MaxViews: 1}, time.Hour)
+
+		_, err := repo.DecrViewAndMaybeDelete(ctx, "id", []byte("old"))
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound for a replaced secret, got %v", err)
+		}
+		if _, err := repo.GetSecret(ctx, "id"); err != nil {
+			t.Errorf("expected the new secret to be kept, got %v", err)
+		}
+	})
+}
+
+func TestRepository_ReplaceSecret(t *testing.T) {
+	ctx := context.Background()
+	tokenHash := []byte("token-hash")
+	forEachBackend(t, func(t *testing.T, repo SecretRepository, advance func(time.Duration)) {
+		storeTestSecret(t, repo, "id",
+			Secret{Blob: []byte("request"), MaxViews: 2, TokenHash: tokenHash}, time.Hour)
+
+		if err := repo.ReplaceSecret(ctx, "id", []byte("other"), []byte("sealed")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound for a stale blob, got %v", err)
+		}
+		if err := repo.ReplaceSecret(ctx, "id", []byte("request"), []byte("sealed")); err != nil {
+			t.Fatalf("ReplaceSecret() error = %v", err)
+		}
+		if err := repo.ReplaceSecret(ctx, "id", []byte("request"), []byte("again")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected a second replace to fail, got %v", err)
+		}
+		if err := repo.ReplaceSecret(ctx, "missing", nil, []byte("sealed")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound for a missing secret, got %v", err)
+		}
+
+		blob, err := repo.GetSecret(ctx, "id")
+		if err != nil || string(blob) != "sealed" {
+			t.Fatalf("GetSecret() = %q, %v, want the new blob", blob, err)
+		}
+		status, err := repo.GetStatus(ctx, "id", tokenHash)
+		if err != nil {
+			t.Fatalf("GetStatus() error = %v", err)
+		}
+		if status.RemainingViews != 2 || status.TTL <= 0 || status.TTL > time.Hour {
+			t.Errorf("expected the views and TTL to be kept, got %+v", status)
+		}
+
+		advance(2 * time.Hour)
+		if _, err := repo.GetSecret(ctx, "id"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound after expiry, got %v", err)
+		}
+	})
+}
+
+func TestRepository_IncrFailAndMaybeDelete(t *testing.T) {
+	ctx := context.Background()
+	forEachBackend(t, func(t *testing.T, repo SecretRepository, _ func(time.Duration)) {
+		storeTestSecret(t, repo, "id", Secret{Blob: []byte("blob"), MaxViews: 2}, time.Hour)
+
+		for i := int64(1); i <= MaxReadAttempts; i++ {
+			attempts, err := repo.IncrFailAndMaybeDelete(ctx, "id", MaxReadAttempts)
+			if err != nil {
+				t.Fatalf("IncrFailAndMaybeDelete() error = %v", err)
+			}
+			if attempts != i {
+				t.Errorf("expected %d attempts, got %d", i, attempts)
+			}
+		}
+
+		if _, err := repo.GetSecret(ctx, "id"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound after max attempts, got %v", err)
+		}
+		attempts, err := repo.IncrFailAndMaybeDelete(ctx, "id", MaxReadAttempts)
+		if err != nil || attempts != 0 {
+			t.Errorf("IncrFailAndMaybeDelete() on a gone secret = %d, %v, want 0, nil",
+				attempts, err)
+		}
+	})
+}
+
+func TestRepository_DeleteSecret(t *testing.T) {
+	ctx := context.Background()
+	tokenHash := []byte("token-hash")
+	forEachBackend(t, func(t *testing.T, repo SecretRepository, _ func(time.Duration)) {
+		storeTestSecret(t, repo, "id", Secret{Blob: []byte("blob"), MaxViews: 1, TokenHash: tokenHash}, time.Hour)
+		storeTestSecret(t, repo, "legacy", Secret{Blob: []byte("blob"), MaxViews: 1}, time.Hour)
+
+		if err := repo.DeleteSecret(ctx, "id", []byte("other-hash")); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("expected ErrInvalidToken, got %v", err)
+		}
+		if err := repo.DeleteSecret(ctx, "legacy", nil); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("expected ErrInvalidToken for a secret without token, got %v", err)
+		}
+		if _, err := repo.GetSecret(ctx, "id"); err != nil {
+			t.Errorf("expected secret to be kept, got %v", err)
+		}
+
+		if err := repo.DeleteSecret(ctx, "id", tokenHash); err != nil {
+			t.Fatalf("DeleteSecret() error = %v", err)
+		}
+		if _, err := repo.GetSecret(ctx, "id"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound after revoke, got %v", err)
+		}
+		if err := repo.DeleteSecret(ctx, "id", tokenHash); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+}
+
+func TestRepository_GetStatus(t *testing.T) {
+	ctx := context.Background()
+	tokenHash := []byte("token-hash")
+	forEachBackend(t, func(t *testing.T, repo SecretRepository, advance func(time.Duration)) {
+		storeTestSecret(t, repo, "id", Secret{Blob: []byte("blob"), MaxViews: 3, TokenHash: tokenHash}, time.Hour)
+		if _, err := repo.IncrFailAndMaybeDelete(ctx, "id", MaxReadAttempts); err != nil {
+			t.Fatalf("IncrFailAndMaybeDelete() error = %v", err)
+		}
+		if _, err := repo.DecrViewAndMaybeDelete(ctx, "id", []byte("blob")); err != nil {
+			t.Fatalf("DecrViewAndMaybeDelete() error = %v", err)
+		}
+
+		status, err := repo.GetStatus(ctx, "id", tokenHash)
+		if err != nil {
+			t.Fatalf("GetStatus() error = %v", err)
+		}
+		if status.RemainingViews != 2 {
+			t.Errorf("expected 2 remaining views, got %d", status.RemainingViews)
+		}
+		if status.FailedAttempts != 1 {
+			t.Errorf("expected 1 failed attempt, got %d", status.FailedAttempts)
+		}
+		if status.TTL <= 0 || status.TTL > time.Hour {
+			t.Errorf("expected TTL within an hour, got %v", status.TTL)
+		}
+
+		if _, err := repo.GetStatus(ctx, "id", []byte("other-hash")); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("expected ErrInvalidToken, got %v", err)
+		}
+
+		advance(2 * time.Hour)
+		if _, err := repo.GetStatus(ctx, "id", tokenHash); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound after expiry, got %v", err)
+		}
+	})
+}
+
+func TestRepository_Expiry(t *testing.T) {
+	ctx := context.Background()
+	forEachBackend(t, func(t *testing.T, repo SecretRepository, advance func(time.Duration)) {
+		storeTestSecret(t, repo, "id", Secret{Blob: []byte("blob"), MaxViews: 1}, time.Hour)
+
+		advance(59 * time.Minute)
+		if _, err := repo.GetSecret(ctx, "id"); err != nil {
+			t.Fatalf("expected secret before expiry, got %v", err)
+		}
+
+		advance(2 * time.Minute)
+		if _, err := repo.GetSecret(ctx, "id"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound after expiry, got %v", err)
+		}
+		if _, err := repo.DecrViewAndMaybeDelete(ctx, "id", []byte("blob")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound after expiry, got %v", err)
+		}
+	})
+}
+
+func TestRepository_Webhooks(t *testing.T) {
+	ctx := context.Background()
+	hook := &Webhook{URL: "https://hooks.example.com", Key: []byte("key")}
+
+	t.Run("stored apart from the secret and taken once", func(t *testing.T) {
+		forEachBackend(t, func(t *testing.T, repo SecretRepository, _ func(time.Duration)) {
+			storeTestSecret(t, repo, "id", Secret{Blob: []byte("blob"), MaxViews: 1, Webhook: hook}, time.Hour)
+			if _, err := repo.DecrViewAndMaybeDelete(ctx, "id", []byte("blob")); err != nil {
+				t.Fatalf("DecrViewAndMaybeDelete() error = %v", err)
+			}
+
+			got, err := repo.GetWebhook(ctx, "id")
+			if err != nil || got == nil || got.URL != hook.URL {
+				t.Fatalf("GetWebhook() = %v, %v, want the stored webhook", got, err)
+			}
+			got, err = repo.TakeWebhook(ctx, "id")
+			if err != nil || got == nil || string(got.Key) != "key" {
+				t.Fatalf("TakeWebhook() = %v, %v, want the stored webhook", got, err)
+			}
+			got, err = repo.TakeWebhook(ctx, "id")
+			if err != nil || got != nil {
+				t.Errorf("second TakeWebhook() = %v, %v, want nil", got, err)
+			}
+			if got, _ := repo.GetWebhook(ctx, "id"); got != nil {
+				t.Errorf("expected no webhook once taken, got %v", got)
+			}
+		})
+	})
+
+	t.Run("due once the secret expires", func(t *testing.T) {
+		forEachBackend(t, func(t *testing.T, repo SecretRepository, _ func(time.Duration)) {
+			storeTestSecret(t, repo, "soon", Secret{Blob: []byte("a"), Webhook: hook}, time.Minute)
+			storeTestSecret(t, repo, "later", Secret{Blob: []byte("b"), Webhook: hook}, time.Hour)
+			storeTestSecret(t, repo, "none", Secret{Blob: []byte("c")}, time.Minute)
+
+			ids, err := repo.DueWebhooks(ctx, time.Now().Add(2*time.Minute), 10)
+			if err != nil {
+				t.Fatalf("DueWebhooks() error = %v", err)
+			}
+			if len(ids) != 1 || ids[0] != "soon" {
+				t.Errorf("expected only the expired secret to be due, got %v", ids)
+			}
+
+			if _, err := repo.TakeWebhook(ctx, "soon"); err != nil {
+				t.Fatalf("TakeWebhook() error = %v", err)
+			}
+			ids, _ = repo.DueWebhooks(ctx, time.Now().Add(2*time.Minute), 10)
+			if len(ids) != 0 {
+				t.Errorf("expected no due webhook once taken, got %v", ids)
+			}
+		})
+	})
+
+	t.Run("outlives the secret", func(t *testing.T) {
+		forEachBackend(t, func(t *testing.T, repo SecretRepository, advance func(time.Duration)) {
+			storeTestSecret(t, repo, "id", Secret{Blob: []byte("blob"), Webhook: hook}, time.Minute)
+
+			advance(time.Hour)
+			if got, err := repo.TakeWebhook(ctx, "id"); err != nil || got == nil {
+				t.Errorf("TakeWebhook() after expiry = %v, %v, want the webhook", got, err)
+			}
+		})
+	})
+
+	t.Run("dropped when the secret is revoked", func(t *testing.T) {
+		forEachBackend(t, func(t *testing.T, repo SecretRepository, _ func(time.Duration)) {
+			tokenHash := []byte("token-hash")
+			storeTestSecret(t, repo, "id", Secret{Blob: []byte("blob"), TokenHash: tokenHash, Webhook: hook}, time.Hour)
+			if err := repo.DeleteSecret(ctx, "id", tokenHash); err != nil {
+				t.Fatalf("DeleteSecret() error = %v", err)
+			}
+			if got, _ := repo.GetWebhook(ctx, "id"); got != nil {
+				t.Errorf("expected webhook to be deleted, got %v", got)
+			}
+			ids, _ := repo.DueWebhooks(ctx, time.Now().Add(2*time.Hour), 10)
+			if len(ids) != 0 {
+				t.Errorf("expected no due webhook after revoke, got %v", ids)
+			}
+		})
+	})
+}
+
+func TestRepository_Chunks(t *testing.T) {
+	ctx := context.Background()
+	tokenHash := []byte("token-hash")
+
+	// appendTestChunks stores a two chunk file secret.
+	appendTestChunks := func(t *testing.T, repo SecretRepository, id string, ttl time.Duration) {
+		t.Helper()
+		for _, chunk := range []string{"first", "second"} {
+			if err := repo.AppendChunk(ctx, id, []byte(chunk), ttl); err != nil {
+				t.Fatalf("AppendChunk() error = %v", err)
+			}
+		}
+		secret := Secret{Blob: []byte("blob"), MaxViews: 1, TokenHash: tokenHash}
+		storeTestSecret(t, repo, id, secret, ttl)
+	}
+	chunksGone := func(t *testing.T, repo SecretRepository, id string) {
+		t.Helper()
+		if _, err := repo.GetChunk(ctx, id, 0); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound for the chunks, got %v", err)
+		}
+	}
+
+	forEachBackend(t, func(t *testing.T, repo SecretRepository, advance func(time.Duration)) {
+		t.Run("outlive the last view", func(t *testing.T) {
+			appendTestChunks(t, repo, "read", time.Hour)
+			if _, err := repo.DecrViewAndMaybeDelete(ctx, "read", []byte("blob")); err != nil {
+				t.Fatalf("DecrViewAndMaybeDelete() error = %v", err)
+			}
+			for i, want := range []string{"first", "second"} {
+				chunk, err := repo.GetChunk(ctx, "read", i)
+				if err != nil || string(chunk) != want {
+					t.Errorf("GetChunk(%d) = %q, %v, want %q", i, chunk, err, want)
+				}
+			}
+			if _, err := repo.GetChunk(ctx, "read", 2); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected ErrNotFound past the last chunk, got %v", err)
+			}
+			if err := repo.DeleteChunks(ctx, "read"); err != nil {
+				t.Fatalf("DeleteChunks() error = %v", err)
+			}
+			chunksGone(t, repo, "read")
+		})
+
+		t.Run("burned with the secret", func(t *testing.T) {
+			appendTestChunks(t, repo, "burned", time.Hour)
+			if _, err := repo.IncrFailAndMaybeDelete(ctx, "burned", 1); err != nil {
+				t.Fatalf("IncrFailAndMaybeDelete() error = %v", err)
+			}
+			chunksGone(t, repo, "burned")
+		})
+
+		t.Run("revoked with the secret", func(t *testing.T) {
+			appendTestChunks(t, repo, "revoked", time.Hour)
+			if err := repo.DeleteSecret(ctx, "revoked", tokenHash); err != nil {
+				t.Fatalf("DeleteSecret() error = %v", err)
+			}
+			chunksGone(t, repo, "revoked")
+		})
+
+		t.Run("expire with the secret", func(t *testing.T) {
+			if err := repo.AppendChunk(ctx, "expiring", []byte("chunk"), time.Minute); err != nil {
+				t.Fatalf("AppendChunk() error = %v", err)
+			}
+			storeTestSecret(t, repo, "expiring", Secret{Blob: []byte("blob"), MaxViews: 1}, time.Hour)
+
+			advance(30 * time.Minute)
+			if _, err := repo.GetChunk(ctx, "expiring", 0); err != nil {
+				t.Errorf("expected chunks to expire with the secret, got %v", err)
+			}
+			advance(time.Hour)
+			chunksGone(t, repo, "expiring")
+		})
+	})
+}
+
+func TestEmbeddedRepositories_Sweep(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("memory", func(t *testing.T) {
+		repo := NewMemoryRepository()
+		defer repo.Close()
+		clock := &testClock{now: time.Now()}
+		repo.now = clock.Now
+
+		_ = repo.AppendChunk(ctx, "id", []byte("chunk"), time.Minute)
+		storeTestSecret(t, repo, "id", Secret{Blob: []byte("blob"), MaxViews: 1}, time.Minute)
+		clock.Advance(time.Hour)
+		repo.sweep()
+
+		if len(repo.secrets) != 0 {
+			t.Errorf("expected expired secret to be swept, %d left", len(repo.secrets))
+		}
+		if len(repo.chunks) != 0 {
+			t.Errorf("expected expired chunks to be swept, %d left", len(repo.chunks))
+		}
+	})
+
+	t.Run("bolt", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "secretapi.db")
+		repo, err := NewBoltRepository(path)
+		if err != nil {
+			t.Fatalf("NewBoltRepository() error = %v", err)
+		}
+		clock := &testClock{now: time.Now()}
+		repo.now = clock.Now
+
+		_ = repo.AppendChunk(ctx, "expired", []byte("chunk"), time.Minute)
+		storeTestSecret(t, repo, "expired", Secret{Blob: []byte("a"), MaxViews: 1}, time.Minute)
+		storeTestSecret(t, repo, "live", Secret{Blob: []byte("b"), MaxViews: 1}, 2*time.Hour)
+		clock.Advance(time.Hour)
+		if err := repo.sweep(); err != nil {
+			t.Fatalf("sweep() error = %v", err)
+		}
+		if err := repo.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+
+		// Secrets survive a restart, expired ones don't come back.
+		repo, err = NewBoltRepository(path)
+		if err != nil {
+			t.Fatalf("NewBoltRepository() error = %v", err)
+		}
+		defer repo.Close()
+		repo.now = clock.Now
+		if _, err := repo.GetSecret(ctx, "live"); err != nil {
+			t.Errorf("expected live secret after reopening, got %v", err)
+		}
+		err = repo.db.View(func(tx *bolt.Tx) error {
+			if tx.Bucket(secretsBucket).Get([]byte("expired")) != nil {
+				t.Error("expected expired secret to be swept from disk")
+			}
+			if tx.Bucket(chunksBucket).Bucket([]byte("expired")) != nil {
+				t.Error("expected expired chunks to be swept from disk")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("View() error = %v", err)
+		}
+	})
+}
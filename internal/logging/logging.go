@@ -0,0 +1,87 @@
+// Package logging configures the server's structured logger. Records are
+// written as text or JSON, and carry the attributes attached to their
+// context with With, such as the ID and route of the request being served.
+package logging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+)
+
+// Options configure the logger.
+type Options struct {
+	Format    string     // "text" | "json"
+	Level     slog.Level // records below it are dropped
+	SecretIDs string     // "hash" | "redact" | "plain"
+}
+
+// secretIDs is how ID renders secret IDs, set by New.
+var secretIDs = "hash"
+
+// New returns a logger writing to w. It also sets how secret IDs are logged
+// by every logger, as they are rendered by ID.
+func New(w io.Writer, opts Options) *slog.Logger {
+	if opts.SecretIDs != "" {
+		secretIDs = opts.SecretIDs
+	}
+	handlerOpts := &slog.HandlerOptions{Level: opts.Level}
+	var h slog.Handler
+	if opts.Format == "json" {
+		h = slog.NewJSONHandler(w, handlerOpts)
+	} else {
+		h = slog.NewTextHandler(w, handlerOpts)
+	}
+	return slog.New(contextHandler{h})
+}
+
+type ctxKey struct{}
+
+// With returns a copy of ctx whose log records carry attrs, after those
+// already attached to ctx.
+func With(ctx context.Context, attrs ...slog.Attr) context.Context {
+	prev, _ := ctx.Value(ctxKey{}).([]slog.Attr)
+	return context.WithValue(ctx, ctxKey{}, append(prev[:len(prev):len(prev)], attrs...))
+}
+
+// contextHandler adds the attributes attached to the context of a record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(ctxKey{}).([]slog.Attr); ok {
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// ID returns the attribute logging a secret ID. By default it is the start
+// of the ID's SHA-256 hash, which still correlates the events of a secret
+// without revealing its ID, and so its read URL.
+func ID(id string) slog.Attr {
+	switch secretIDs {
+	case "plain":
+		return slog.String("id", id)
+	case "redact":
+		return slog.String("id", "[redacted]")
+	default:
+		sum := sha256.Sum256([]byte(id))
+		return slog.String("id", hex.EncodeToString(sum[:8]))
+	}
+}
+
+// Err returns the attribute logging an error.
+func Err(err error) slog.Attr {
+	return slog.Any("err", err)
+}
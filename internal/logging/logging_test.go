@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNew_JSONWithContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, Options{Format: "json", Level: slog.LevelInfo})
+
+	ctx := With(context.Background(), slog.String("request_id", "req-1"))
+	ctx = With(ctx, slog.String("route", "/read/{id}"))
+	logger.InfoContext(ctx, "secret read", slog.Int("remaining_views", 0))
+	logger.DebugContext(ctx, "dropped")
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected a single JSON record, got %q: %v", buf.String(), err)
+	}
+	want := map[string]any{
+		"msg": "secret read", "level": "INFO", "request_id": "req-1",
+		"route": "/read/{id}", "remaining_views": float64(0),
+	}
+	for k, v := range want {
+		if record[k] != v {
+			t.Errorf("%s = %v, want %v", k, record[k], v)
+		}
+	}
+}
+
+func TestWith_DoesNotShareAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, Options{})
+
+	parent := With(context.Background(), slog.String("a", "1"))
+	With(parent, slog.String("b", "2"))
+	logger.InfoContext(parent, "parent")
+
+	if strings.Contains(buf.String(), "b=2") {
+		t.Errorf("expected the parent context to be left alone, got %q", buf.String())
+	}
+}
+
+func TestID(t *testing.T) {
+	const id = "d47ef7c1-4a3b-412f-b6ab-5c25b2b68d33"
+	t.Cleanup(func() { secretIDs = "hash" })
+
+	New(&bytes.Buffer{}, Options{SecretIDs: "hash"})
+	hashed := ID(id).Value.String()
+	if len(hashed) != 16 || strings.Contains(id, hashed) {
+		t.Errorf("expected a 16 character hash, got %q", hashed)
+	}
+	if ID(id).Value.String() != hashed {
+		t.Error("expected the hash to be stable")
+	}
+
+	New(&bytes.Buffer{}, Options{SecretIDs: "redact"})
+	if got := ID(id).Value.String(); got != "[redacted]" {
+		t.Errorf("expected a redacted id, got %q", got)
+	}
+
+	New(&bytes.Buffer{}, Options{SecretIDs: "plain"})
+	if got := ID(id).Value.String(); got != id {
+		t.Errorf("expected the plain id, got %q", got)
+	}
+}
@@ -0,0 +1,88 @@
+// Package metrics holds the Prometheus metrics of the server. They are
+// registered on Registry, which also collects the Go runtime and process
+// metrics, and served by Handler.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "secretapi"
+
+// Registry is where every metric of the server is registered.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	// SecretsCreated counts new secrets by expiry bucket: the shortest
+	// expiry option at least as long as their expiry, or "longer".
+	SecretsCreated = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "secrets_created_total",
+		Help:      "Secrets created, by expiry bucket.",
+	}, []string{"expiry"})
+
+	// SecretsRead counts views of secrets, each read of a multi-view secret
+	// included.
+	SecretsRead = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "secrets_read_total",
+		Help:      "Secrets successfully read.",
+	})
+
+	// FailedAttempts counts reads with a wrong passcode or passphrase.
+	FailedAttempts = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "failed_attempts_total",
+		Help:      "Read attempts with a wrong passcode or passphrase.",
+	})
+
+	// SecretsBurned counts secrets deleted after too many failed attempts.
+	SecretsBurned = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "secrets_burned_total",
+		Help:      "Secrets deleted after reaching the maximum read attempts.",
+	})
+
+	// RateLimited counts requests rejected by the rate limiter, by method.
+	RateLimited = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests rejected by the rate limiter, by HTTP method.",
+	}, []string{"method"})
+
+	// KeyDerivationDuration observes Argon2id key derivations.
+	KeyDerivationDuration = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "key_derivation_duration_seconds",
+		Help:      "Time spent deriving keys with Argon2id.",
+		Buckets:   []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+	})
+
+	// RedisDuration observes Redis commands, by command name, or "pipeline"
+	// for pipelines and transactions.
+	RedisDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "redis_command_duration_seconds",
+		Help:      "Latency of Redis commands, by command.",
+		Buckets:   []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 1},
+	}, []string{"command"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestHandler(t *testing.T) {
+	SecretsRead.Inc()
+
+	rr := httptest.NewRecorder()
+	Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, rr.Code)
+	}
+	for _, name := range []string{"secretapi_secrets_read_total", "go_goroutines"} {
+		if !strings.Contains(rr.Body.String(), name) {
+			t.Errorf("expected %s in the metrics", name)
+		}
+	}
+}
+
+func TestRedisHook(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	rdb.AddHook(RedisHook{})
+
+	ctx := context.Background()
+	if err := rdb.Set(ctx, "key", "value", 0).Err(); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if _, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Get(ctx, "key")
+		return nil
+	}); err != nil {
+		t.Fatalf("TxPipelined() error = %v", err)
+	}
+
+	families, err := Registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	observed := make(map[string]uint64)
+	for _, f := range families {
+		if f.GetName() != "secretapi_redis_command_duration_seconds" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			observed[m.GetLabel()[0].GetValue()] = m.GetHistogram().GetSampleCount()
+		}
+	}
+	for _, command := range []string{"set", "pipeline"} {
+		if observed[command] == 0 {
+			t.Errorf("expected %s to be observed, got %v", command, observed)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RedisHook records a span for each command of a Redis client. Add it with
+// AddHook. Only the command's name is recorded, as its arguments hold the
+// keys, which carry secret IDs, and the blobs.
+type RedisHook struct{}
+
+func (RedisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (RedisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		ctx, span := startRedisSpan(ctx, cmd.Name())
+		defer span.End()
+		err := next(ctx, cmd)
+		endRedisSpan(span, err)
+		return err
+	}
+}
+
+func (RedisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		ctx, span := startRedisSpan(ctx, "pipeline")
+		defer span.End()
+		span.SetAttributes(attribute.Int("db.operation.batch.size", len(cmds)))
+		err := next(ctx, cmds)
+		endRedisSpan(span, err)
+		return err
+	}
+}
+
+func startRedisSpan(ctx context.Context, op string) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, "redis "+op, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system.name", "redis"),
+			attribute.String("db.operation.name", op),
+		))
+}
+
+// endRedisSpan records err on span, unless it only means a key was missing.
+func endRedisSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, redis.Nil) {
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+var _ redis.Hook = RedisHook{}
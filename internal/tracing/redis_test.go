@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestRedisHook(t *testing.T) {
+	rec := RecordSpansForTest(t)
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	rdb.AddHook(RedisHook{})
+
+	ctx, parent := Tracer().Start(context.Background(), "parent")
+	if err := rdb.Set(ctx, "secret:{id}", "blob", 0).Err(); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := rdb.Get(ctx, "missing").Err(); err != redis.Nil {
+		t.Fatalf("expected redis.Nil, got %v", err)
+	}
+	if _, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Incr(ctx, "a")
+		pipe.Incr(ctx, "b")
+		return nil
+	}); err != nil {
+		t.Fatalf("TxPipelined() error = %v", err)
+	}
+	parent.End()
+
+	names := make(map[string]bool)
+	for _, span := range rec.Ended() {
+		names[span.Name()] = true
+		if span.Name() == "parent" {
+			continue
+		}
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("expected %s to be a child of the caller's span", span.Name())
+		}
+		if span.SpanKind() != trace.SpanKindClient {
+			t.Errorf("expected %s to be a client span", span.Name())
+		}
+		if span.Status().Code == codes.Error {
+			t.Errorf("expected %s not to be an error", span.Name())
+		}
+		for _, attr := range span.Attributes() {
+			if v := attr.Value.Emit(); strings.Contains(v, "id") || strings.Contains(v, "blob") {
+				t.Errorf("expected no key or value in %s, got %s=%s", span.Name(), attr.Key, v)
+			}
+		}
+	}
+	for _, name := range []string{"redis set", "redis get", "redis pipeline"} {
+		if !names[name] {
+			t.Errorf("expected a %q span, got %v", name, names)
+		}
+	}
+}
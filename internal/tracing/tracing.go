@@ -0,0 +1,75 @@
+// Package tracing exports the traces of the server with OpenTelemetry.
+//
+// Spans are named after what the server does, not after the secret it does it
+// to: secret IDs, passcodes, passphrases and tokens are never recorded as
+// attributes, and request paths are replaced by the route they matched.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/smallwat3r/secretapi"
+
+// Options configures the export of traces.
+type Options struct {
+	Endpoint    string  // base URL of an OTLP/HTTP collector, e.g. http://localhost:4318
+	SampleRatio float64 // share of new traces sampled, 0 to 1
+}
+
+// Tracer returns the tracer of the server. Its spans are dropped until Setup
+// or a test installs a tracer provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Setup exports spans to the OTLP endpoint of opts and installs the tracer
+// provider and W3C trace context propagation globally. The returned function
+// flushes the spans left and stops the export.
+//
+// Traces started upstream are continued whatever the sample ratio, so a
+// caller sampling a request sees the spans of the server for it.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	// Like OTEL_EXPORTER_OTLP_ENDPOINT, the endpoint is the base URL of the
+	// collector, and http:// URLs are exported to without TLS.
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(
+		strings.TrimSuffix(opts.Endpoint, "/")+"/v1/traces",
+	))
+	if err != nil {
+		return nil, fmt.Errorf("otlp exporter: %w", err)
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName("secretapi")),
+		// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence.
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		_ = exporter.Shutdown(ctx)
+		return nil, fmt.Errorf("otel resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagator)
+	return tp.Shutdown, nil
+}
+
+// propagator reads and writes the W3C trace context and baggage headers.
+var propagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{}, propagation.Baggage{},
+)
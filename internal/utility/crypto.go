@@ -1,27 +1,49 @@
 package utility
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
 	"math/big"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/smallwat3r/secretapi/internal/metrics"
+	"github.com/smallwat3r/secretapi/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/argon2"
 )
 
 const (
 	saltLen           = 16
 	nonceLen          = 12 // GCM standard
+	tagLen            = 16 // GCM standard
 	keyLen            = 32 // AES-256
 	passcodeWordCount = 3  // number of words in generated passcode
+	tokenLen          = 32 // management token entropy in bytes
 )
 
+// PasscodeBlobPrefix marks blobs encrypted by the server with a key derived
+// from the passcode. "v2:" was already taken by client-side blobs.
+const PasscodeBlobPrefix = "v3:"
+
+// passcodeBlobAlgorithm names the key derivation and cipher of "v3:" blobs.
+const passcodeBlobAlgorithm = "argon2id-aes256gcm"
+
+// ClientBlobPrefix marks blobs encrypted by the client with a random key that
+// never reaches the server (zero-knowledge mode).
+const ClientBlobPrefix = "v2:"
+
 // CryptoConfig holds configuration parameters for cryptographic operations.
 type CryptoConfig struct {
 	ArgonTime    uint32
@@ -81,16 +103,46 @@ func GeneratePasscode() (string, error) {
 	return strings.Join(words, "-"), nil
 }
 
-func deriveKey(passcode string, salt []byte) []byte {
-	cfg := getCryptoConfig()
-	return argon2.IDKey(
-		[]byte(passcode),
-		salt,
-		cfg.ArgonTime,
-		cfg.ArgonMemory,
-		cfg.ArgonThreads,
-		keyLen,
-	)
+// GenerateToken returns a random URL-safe token, used to manage a secret
+// after it has been created.
+func GenerateToken() (string, error) {
+	b := make([]byte, tokenLen)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", fmt.Errorf("token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the SHA-256 hash of a token. Only hashes are stored, so
+// a leaked database doesn't grant control over live secrets. Tokens are
+// random with full entropy, so a fast hash is sufficient.
+func HashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+func deriveKey(
+	ctx context.Context, passcode, passphrase string, salt []byte, p kdfParams,
+) []byte {
+	_, span := tracing.Tracer().Start(ctx, "kdf", trace.WithAttributes(
+		attribute.String("kdf.algorithm", "argon2id"),
+		attribute.Int("kdf.time", int(p.Time)),
+		attribute.Int("kdf.memory", int(p.Memory)),
+		attribute.Int("kdf.threads", int(p.Threads)),
+	))
+	defer span.End()
+
+	secret := []byte(passcode)
+	if p.Passphrase {
+		// Generated passcodes never contain a NUL, so the two can't be
+		// shifted into one another.
+		secret = append(append(secret, 0), passphrase...)
+	}
+	defer zeroBytes(secret)
+	start := time.Now()
+	key := argon2.IDKey(secret, salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	metrics.KeyDerivationDuration.Observe(time.Since(start).Seconds())
+	return key
 }
 
 // zeroBytes overwrites a byte slice with zeros to clear sensitive data from memory.
@@ -100,45 +152,109 @@ func zeroBytes(b []byte) {
 	}
 }
 
-func Encrypt(plaintext []byte, passcode string) ([]byte, error) {
+// Encrypt encrypts plaintext with a key derived from passcode and returns a
+// "v3:" blob. The blob records the parameters its key was derived with, so
+// it stays readable when CryptoConfig is tuned, and id is authenticated as
+// additional data so the blob can't be moved to another secret. A non-empty
+// passphrase is mixed into the key along with the passcode, and then needed
+// to decrypt the blob too.
+//
+// The blob has the form "v3:argon2id-aes256gcm$t=1,m=65536,p=4,l=32$" followed
+// by base64(salt|nonce|ciphertext). With a passphrase, ",pp=1" is appended to
+// the parameters.
+func Encrypt(
+	ctx context.Context, plaintext []byte, passcode, passphrase, id string,
+) ([]byte, error) {
+	return encrypt(ctx, plaintext, currentKDFParams(passphrase), passcode, passphrase, id)
+}
+
+// EncryptFields is Encrypt for the JSON encoded fields of a structured
+// secret. The blob's header records it with ",f=1", so it is authenticated
+// along with the ciphertext and a reader knows how to render the plaintext.
+func EncryptFields(
+	ctx context.Context, plaintext []byte, passcode, passphrase, id string,
+) ([]byte, error) {
+	params := currentKDFParams(passphrase)
+	params.Fields = true
+	return encrypt(ctx, plaintext, params, passcode, passphrase, id)
+}
+
+func encrypt(
+	ctx context.Context, plaintext []byte, params kdfParams, passcode, passphrase, id string,
+) ([]byte, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "encrypt")
+	defer span.End()
+
 	salt := make([]byte, saltLen)
 	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
 		return nil, fmt.Errorf("salt: %w", err)
 	}
-	key := deriveKey(passcode, salt)
+	key := deriveKey(ctx, passcode, passphrase, salt, params)
 	defer zeroBytes(key) // Clear key from memory after use
 
-	block, err := aes.NewCipher(key)
+	gcm, err := newGCM(key)
 	if err != nil {
-		return nil, fmt.Errorf("cipher: %w", err)
-	}
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, fmt.Errorf("gcm: %w", err)
+		return nil, err
 	}
 	nonce := make([]byte, nonceLen)
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, fmt.Errorf("nonce: %w", err)
 	}
 
-	ct := gcm.Seal(nil, nonce, plaintext, nil)
-
-	// store as base64(salt|nonce|ciphertext) with a "v1:" prefix.
-	raw := make([]byte, 0, len(salt)+len(nonce)+len(ct))
+	header := passcodeBlobHeader(PasscodeBlobPrefix, params)
+	raw := make([]byte, 0, saltLen+nonceLen+len(plaintext)+tagLen)
 	raw = append(raw, salt...)
 	raw = append(raw, nonce...)
-	raw = append(raw, ct...)
+	raw = gcm.Seal(raw, nonce, plaintext, passcodeBlobAAD(header, id))
 
-	out := "v1:" + base64.StdEncoding.EncodeToString(raw)
+	out := header + "$" + base64.StdEncoding.EncodeToString(raw)
 	return []byte(out), nil
 }
 
-func Decrypt(blob []byte, passcode string) ([]byte, error) {
+// Decrypt decrypts a blob produced by Encrypt for the secret id. The
+// passphrase is ignored unless the blob was encrypted with one. Blobs in the
+// older "v1:" format carry neither their parameters nor the id, they are
+// decrypted with the current CryptoConfig. A blob produced by Seal is
+// decrypted with the requester's token as its passcode.
+func Decrypt(ctx context.Context, blob []byte, passcode, passphrase, id string) ([]byte, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "decrypt")
+	defer span.End()
+
 	s := string(blob)
-	if !strings.HasPrefix(s, "v1:") {
+	if IsSealed(blob) {
+		return openSealed(blob, passcode, id)
+	}
+
+	var (
+		params kdfParams
+		aad    []byte
+		b64    string
+	)
+	switch {
+	case strings.HasPrefix(s, PasscodeBlobPrefix):
+		header, data, ok := cutLast(s, "$")
+		if !ok {
+			return nil, errors.New("missing header")
+		}
+		var err error
+		if params, err = parsePasscodeBlobHeader(PasscodeBlobPrefix, header); err != nil {
+			return nil, err
+		}
+		aad = passcodeBlobAAD(header, id)
+		b64 = data
+	case strings.HasPrefix(s, "v1:"):
+		cfg := getCryptoConfig()
+		params = kdfParams{
+			Time:    cfg.ArgonTime,
+			Memory:  cfg.ArgonMemory,
+			Threads: cfg.ArgonThreads,
+			KeyLen:  keyLen,
+		}
+		b64 = strings.TrimPrefix(s, "v1:")
+	default:
 		return nil, errors.New("unsupported format")
 	}
-	b64 := strings.TrimPrefix(s, "v1:")
+
 	raw, err := base64.StdEncoding.DecodeString(b64)
 	if err != nil {
 		return nil, fmt.Errorf("b64: %w", err)
@@ -151,20 +267,277 @@ func Decrypt(blob []byte, passcode string) ([]byte, error) {
 	nonce := raw[saltLen : saltLen+nonceLen]
 	ct := raw[saltLen+nonceLen:]
 
-	key := deriveKey(passcode, salt)
+	key := deriveKey(ctx, passcode, passphrase, salt, params)
 	defer zeroBytes(key) // Clear key from memory after use
 
-	block, err := aes.NewCipher(key)
+	gcm, err := newGCM(key)
 	if err != nil {
-		return nil, fmt.Errorf("cipher: %w", err)
+		return nil, err
 	}
-	gcm, err := cipher.NewGCM(block)
+	pt, err := gcm.Open(nil, nonce, ct, aad)
 	if err != nil {
-		return nil, fmt.Errorf("gcm: %w", err)
+		return nil, errors.New("auth failed")
+	}
+	return pt, nil
+}
+
+// RequiresPassphrase reports whether blob was encrypted with a passphrase on
+// top of its passcode.
+func RequiresPassphrase(blob []byte) bool {
+	params, ok := blobParams(blob)
+	return ok && params.Passphrase
+}
+
+// HasFields reports whether blob holds the fields of a structured secret.
+func HasFields(blob []byte) bool {
+	if IsSealed(blob) {
+		header, _, _ := cutLast(string(blob), "$")
+		return strings.HasSuffix(header, "$f=1")
+	}
+	params, ok := blobParams(blob)
+	return ok && params.Fields
+}
+
+// blobParams returns the parameters recorded in the header of a blob whose
+// key derives from a passcode.
+func blobParams(blob []byte) (kdfParams, bool) {
+	s := string(blob)
+	for _, prefix := range []string{PasscodeBlobPrefix, FileBlobPrefix} {
+		if strings.HasPrefix(s, prefix) {
+			header, _, _ := cutLast(s, "$")
+			params, err := parsePasscodeBlobHeader(prefix, header)
+			return params, err == nil
+		}
+	}
+	return kdfParams{}, false
+}
+
+// kdfParams are the Argon2id parameters a passcode blob was encrypted with.
+type kdfParams struct {
+	Time       uint32
+	Memory     uint32
+	Threads    uint8
+	KeyLen     uint32
+	Passphrase bool // the key also derives from a passphrase
+	Fields     bool // the plaintext holds the fields of a structured secret
+}
+
+// currentKDFParams returns the parameters new blobs are encrypted with.
+func currentKDFParams(passphrase string) kdfParams {
+	cfg := getCryptoConfig()
+	return kdfParams{
+		Time:       cfg.ArgonTime,
+		Memory:     cfg.ArgonMemory,
+		Threads:    cfg.ArgonThreads,
+		KeyLen:     keyLen,
+		Passphrase: passphrase != "",
 	}
-	pt, err := gcm.Open(nil, nonce, ct, nil)
+}
+
+// Upper bounds on the parameters accepted from a blob header, so a corrupt
+// or forged blob can't make a read allocate unbounded memory.
+const (
+	maxArgonTime    = 16
+	maxArgonMemory  = 1024 * 1024 // 1 GB
+	maxArgonThreads = 64
+)
+
+// passcodeBlobHeader returns the header of a blob whose key derives from a
+// passcode, for "v3:" secrets and "f3:" files alike.
+func passcodeBlobHeader(prefix string, p kdfParams) string {
+	header := fmt.Sprintf("%s%s$t=%d,m=%d,p=%d,l=%d",
+		prefix, passcodeBlobAlgorithm, p.Time, p.Memory, p.Threads, p.KeyLen)
+	if p.Passphrase {
+		header += ",pp=1"
+	}
+	if p.Fields {
+		header += ",f=1"
+	}
+	return header
+}
+
+func parsePasscodeBlobHeader(prefix, header string) (kdfParams, error) {
+	alg, params, ok := strings.Cut(strings.TrimPrefix(header, prefix), "$")
+	if !ok {
+		return kdfParams{}, errors.New("missing parameters")
+	}
+	if alg != passcodeBlobAlgorithm {
+		return kdfParams{}, fmt.Errorf("unsupported algorithm %q", alg)
+	}
+
+	var p kdfParams
+	seen := make(map[string]bool)
+	for field := range strings.SplitSeq(params, ",") {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok || seen[name] {
+			return kdfParams{}, fmt.Errorf("invalid parameter %q", field)
+		}
+		seen[name] = true
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return kdfParams{}, fmt.Errorf("invalid parameter %q", field)
+		}
+		switch name {
+		case "t":
+			p.Time = uint32(n)
+		case "m":
+			p.Memory = uint32(n)
+		case "p":
+			if n > maxArgonThreads {
+				return kdfParams{}, fmt.Errorf("invalid parameter %q", field)
+			}
+			p.Threads = uint8(n)
+		case "l":
+			p.KeyLen = uint32(n)
+		case "pp":
+			if n != 1 {
+				return kdfParams{}, fmt.Errorf("invalid parameter %q", field)
+			}
+			p.Passphrase = true
+		case "f":
+			if n != 1 {
+				return kdfParams{}, fmt.Errorf("invalid parameter %q", field)
+			}
+			p.Fields = true
+		default:
+			return kdfParams{}, fmt.Errorf("unknown parameter %q", name)
+		}
+	}
+
+	switch {
+	case !seen["t"] || !seen["m"] || !seen["p"] || !seen["l"]:
+		return kdfParams{}, errors.New("missing parameters")
+	case p.Time < 1 || p.Time > maxArgonTime:
+		return kdfParams{}, errors.New("invalid argon2 time")
+	case p.Memory < 8*uint32(p.Threads) || p.Memory > maxArgonMemory:
+		return kdfParams{}, errors.New("invalid argon2 memory")
+	case p.Threads < 1:
+		return kdfParams{}, errors.New("invalid argon2 threads")
+	case p.KeyLen != keyLen:
+		return kdfParams{}, errors.New("invalid key length")
+	}
+	return p, nil
+}
+
+// passcodeBlobAAD binds a blob to its header and to the id of its secret.
+func passcodeBlobAAD(header, id string) []byte {
+	return []byte(header + "$" + id)
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// GenerateClientKey returns a random AES-256 key for client-side encryption.
+func GenerateClientKey() ([]byte, error) {
+	key := make([]byte, keyLen)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("key: %w", err)
+	}
+	return key, nil
+}
+
+// EncodeClientKey encodes a client key for use in a URL fragment.
+func EncodeClientKey(key []byte) string {
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
+// DecodeClientKey decodes a client key taken from a URL fragment.
+func DecodeClientKey(s string) ([]byte, error) {
+	key, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("b64: %w", err)
+	}
+	if len(key) != keyLen {
+		return nil, errors.New("invalid key length")
+	}
+	return key, nil
+}
+
+// EncryptWithKey encrypts plaintext with a raw AES-256 key and returns a
+// "v2:" blob of the form base64(nonce|ciphertext). No key derivation is
+// involved: the key is random and travels in the URL fragment.
+func EncryptWithKey(plaintext, key []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceLen)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("nonce: %w", err)
+	}
+
+	raw := make([]byte, 0, nonceLen+len(plaintext)+tagLen)
+	raw = append(raw, nonce...)
+	raw = gcm.Seal(raw, nonce, plaintext, nil)
+
+	out := ClientBlobPrefix + base64.StdEncoding.EncodeToString(raw)
+	return []byte(out), nil
+}
+
+// DecryptWithKey decrypts a "v2:" blob produced by EncryptWithKey.
+func DecryptWithKey(blob, key []byte) ([]byte, error) {
+	raw, err := decodeClientBlob(blob)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	pt, err := gcm.Open(nil, raw[:nonceLen], raw[nonceLen:], nil)
 	if err != nil {
 		return nil, errors.New("auth failed")
 	}
 	return pt, nil
 }
+
+// IsClientEncrypted reports whether blob was encrypted client-side.
+func IsClientEncrypted(blob []byte) bool {
+	return strings.HasPrefix(string(blob), ClientBlobPrefix)
+}
+
+// ValidateClientBlob checks that blob is a well-formed "v2:" blob whose
+// plaintext would not exceed maxPlaintext bytes. The server cannot decrypt
+// it, so this is only a structural check.
+func ValidateClientBlob(blob []byte, maxPlaintext int) error {
+	raw, err := decodeClientBlob(blob)
+	if err != nil {
+		return err
+	}
+	if len(raw)-nonceLen-tagLen > maxPlaintext {
+		return errors.New("blob too large")
+	}
+	return nil
+}
+
+func decodeClientBlob(blob []byte) ([]byte, error) {
+	s := string(blob)
+	if !strings.HasPrefix(s, ClientBlobPrefix) {
+		return nil, errors.New("unsupported format")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, ClientBlobPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("b64: %w", err)
+	}
+	if len(raw) < nonceLen+tagLen {
+		return nil, errors.New("blob too short")
+	}
+	return raw, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("gcm: %w", err)
+	}
+	return gcm, nil
+}
@@ -2,6 +2,8 @@ package utility
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"strings"
 	"testing"
 )
@@ -12,7 +14,7 @@ func TestEncryptDecrypt(t *testing.T) {
 	passcode := "abacus-abdomen-abdominal"
 	plaintext := []byte("this is a very secret message")
 
-	encrypted, err := Encrypt(plaintext, passcode)
+	encrypted, err := Encrypt(context.Background(), plaintext, passcode, "", "test-id")
 	if err != nil {
 		t.Fatalf("Encrypt() error = %v", err)
 	}
@@ -21,7 +23,7 @@ func TestEncryptDecrypt(t *testing.T) {
 		t.Fatal("Encrypt() returned empty byte slice")
 	}
 
-	decrypted, err := Decrypt(encrypted, passcode)
+	decrypted, err := Decrypt(context.Background(), encrypted, passcode, "", "test-id")
 	if err != nil {
 		t.Fatalf("Decrypt() error = %v", err)
 	}
@@ -38,12 +40,12 @@ func TestDecrypt_WrongPasscode(t *testing.T) {
 	wrongPasscode := "abide-abiding-ability"
 	plaintext := []byte("this is a very secret message")
 
-	encrypted, err := Encrypt(plaintext, passcode)
+	encrypted, err := Encrypt(context.Background(), plaintext, passcode, "", "test-id")
 	if err != nil {
 		t.Fatalf("Encrypt() error = %v", err)
 	}
 
-	_, err = Decrypt(encrypted, wrongPasscode)
+	_, err = Decrypt(context.Background(), encrypted, wrongPasscode, "", "test-id")
 	if err == nil {
 		t.Error("Decrypt() with wrong passcode should return an error")
 	}
@@ -58,11 +60,19 @@ func TestDecrypt_InvalidBlob(t *testing.T) {
 		{"no prefix", []byte("invalidblob")},
 		{"short blob", []byte("v1:short")},
 		{"bad base64", []byte("v1:!@#$%^")},
+		{"v3 without header", []byte("v3:AAAA")},
+		{"unknown algorithm", []byte("v3:scrypt$t=1,m=1024,p=4,l=32$AAAA")},
+		{"missing parameter", []byte("v3:argon2id-aes256gcm$t=1,m=1024,p=4$AAAA")},
+		{"unknown parameter", []byte("v3:argon2id-aes256gcm$t=1,m=1024,p=4,l=32,x=1$AAAA")},
+		{"huge memory", []byte("v3:argon2id-aes256gcm$t=1,m=4294967295,p=4,l=32$AAAA")},
+		{"zero threads", []byte("v3:argon2id-aes256gcm$t=1,m=1024,p=0,l=32$AAAA")},
+		{"wrong key length", []byte("v3:argon2id-aes256gcm$t=1,m=1024,p=4,l=16$AAAA")},
+		{"invalid passphrase flag", []byte("v3:argon2id-aes256gcm$t=1,m=1024,p=4,l=32,pp=2$AAAA")},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if _, err := Decrypt(tt.blob, passcode); err == nil {
+			if _, err := Decrypt(context.Background(), tt.blob, passcode, "", "test-id"); err == nil {
 				t.Errorf("Decrypt() with blob '%s' should fail", tt.blob)
 			}
 		})
@@ -129,12 +139,12 @@ func TestEncrypt_EmptyPlaintext(t *testing.T) {
 	passcode := "abacus-abdomen-abdominal"
 	plaintext := []byte("")
 
-	encrypted, err := Encrypt(plaintext, passcode)
+	encrypted, err := Encrypt(context.Background(), plaintext, passcode, "", "test-id")
 	if err != nil {
 		t.Fatalf("Encrypt() with empty plaintext error = %v", err)
 	}
 
-	decrypted, err := Decrypt(encrypted, passcode)
+	decrypted, err := Decrypt(context.Background(), encrypted, passcode, "", "test-id")
 	if err != nil {
 		t.Fatalf("Decrypt() error = %v", err)
 	}
@@ -151,12 +161,12 @@ func TestEncrypt_LargePlaintext(t *testing.T) {
 	// 64KB of data
 	plaintext := bytes.Repeat([]byte("a"), 64*1024)
 
-	encrypted, err := Encrypt(plaintext, passcode)
+	encrypted, err := Encrypt(context.Background(), plaintext, passcode, "", "test-id")
 	if err != nil {
 		t.Fatalf("Encrypt() with large plaintext error = %v", err)
 	}
 
-	decrypted, err := Decrypt(encrypted, passcode)
+	decrypted, err := Decrypt(context.Background(), encrypted, passcode, "", "test-id")
 	if err != nil {
 		t.Fatalf("Decrypt() error = %v", err)
 	}
@@ -173,12 +183,12 @@ func TestEncrypt_BinaryData(t *testing.T) {
 	// Binary data with null bytes and special characters
 	plaintext := []byte{0x00, 0x01, 0x02, 0xFF, 0xFE, 0x00, 0x7F}
 
-	encrypted, err := Encrypt(plaintext, passcode)
+	encrypted, err := Encrypt(context.Background(), plaintext, passcode, "", "test-id")
 	if err != nil {
 		t.Fatalf("Encrypt() with binary data error = %v", err)
 	}
 
-	decrypted, err := Decrypt(encrypted, passcode)
+	decrypted, err := Decrypt(context.Background(), encrypted, passcode, "", "test-id")
 	if err != nil {
 		t.Fatalf("Decrypt() error = %v", err)
 	}
@@ -194,12 +204,12 @@ func TestEncrypt_SameInputDifferentOutput(t *testing.T) {
 	passcode := "abacus-abdomen-abdominal"
 	plaintext := []byte("test message")
 
-	encrypted1, err := Encrypt(plaintext, passcode)
+	encrypted1, err := Encrypt(context.Background(), plaintext, passcode, "", "test-id")
 	if err != nil {
 		t.Fatalf("Encrypt() error = %v", err)
 	}
 
-	encrypted2, err := Encrypt(plaintext, passcode)
+	encrypted2, err := Encrypt(context.Background(), plaintext, passcode, "", "test-id")
 	if err != nil {
 		t.Fatalf("Encrypt() error = %v", err)
 	}
@@ -216,14 +226,257 @@ func TestEncrypt_OutputFormat(t *testing.T) {
 	passcode := "abacus-abdomen-abdominal"
 	plaintext := []byte("test")
 
-	encrypted, err := Encrypt(plaintext, passcode)
+	encrypted, err := Encrypt(context.Background(), plaintext, passcode, "", "test-id")
 	if err != nil {
 		t.Fatalf("Encrypt() error = %v", err)
 	}
 
-	// Check output starts with version prefix
-	if !strings.HasPrefix(string(encrypted), "v1:") {
-		t.Errorf("encrypted should start with 'v1:', got: %s",
-			string(encrypted[:10]))
+	// Check output starts with the header of its parameters
+	want := "v3:argon2id-aes256gcm$t=1,m=1024,p=4,l=32$"
+	if !strings.HasPrefix(string(encrypted), want) {
+		t.Errorf("encrypted should start with %q, got: %s", want, encrypted)
+	}
+}
+
+func TestDecrypt_RecordedParams(t *testing.T) {
+	LowerCryptoParamsForTest(t)
+
+	passcode := "abacus-abdomen-abdominal"
+	encrypted, err := Encrypt(context.Background(), []byte("test"), passcode, "", "test-id")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	// Blobs stay readable after the parameters are tuned.
+	cfg := TestCryptoConfig()
+	cfg.ArgonMemory *= 2
+	cfg.ArgonTime++
+	setCryptoConfig(cfg)
+
+	decrypted, err := Decrypt(context.Background(), encrypted, passcode, "", "test-id")
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if string(decrypted) != "test" {
+		t.Errorf("Decrypt() got = %s, want test", decrypted)
+	}
+}
+
+func TestDecrypt_BoundToID(t *testing.T) {
+	LowerCryptoParamsForTest(t)
+
+	passcode := "abacus-abdomen-abdominal"
+	encrypted, err := Encrypt(context.Background(), []byte("test"), passcode, "", "test-id")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	if _, err := Decrypt(context.Background(), encrypted, passcode, "", "other-id"); err == nil {
+		t.Error("Decrypt() of a blob copied to another id should fail")
+	}
+
+	// The header is authenticated too, even though the key derived from
+	// tampered parameters would differ anyway.
+	tampered := strings.Replace(string(encrypted), "t=1,", "t=2,", 1)
+	if _, err := Decrypt(context.Background(), []byte(tampered), passcode, "", "test-id"); err == nil {
+		t.Error("Decrypt() with a tampered header should fail")
+	}
+}
+
+func TestEncryptDecrypt_Passphrase(t *testing.T) {
+	LowerCryptoParamsForTest(t)
+
+	passcode := "abacus-abdomen-abdominal"
+	passphrase := "correct horse battery staple"
+	encrypted, err := Encrypt(context.Background(), []byte("test"), passcode, passphrase, "test-id")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if !RequiresPassphrase(encrypted) {
+		t.Error("RequiresPassphrase() = false for a blob encrypted with a passphrase")
+	}
+
+	decrypted, err := Decrypt(context.Background(), encrypted, passcode, passphrase, "test-id")
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if string(decrypted) != "test" {
+		t.Errorf("Decrypt() got = %s, want test", decrypted)
+	}
+
+	for _, tc := range []struct{ name, passcode, passphrase string }{
+		{"missing passphrase", passcode, ""},
+		{"wrong passphrase", passcode, "wrong horse"},
+		{"wrong passcode", "abide-abiding-ability", passphrase},
+		{"passphrase moved into passcode", passcode + "\x00" + passphrase, ""},
+	} {
+		_, err := Decrypt(context.Background(), encrypted, tc.passcode, tc.passphrase, "test-id")
+		if err == nil {
+			t.Errorf("Decrypt() with %s should fail", tc.name)
+		}
+	}
+
+	// Without a passphrase, one given on read is ignored.
+	plain, err := Encrypt(context.Background(), []byte("test"), passcode, "", "test-id")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if RequiresPassphrase(plain) {
+		t.Error("RequiresPassphrase() = true for a blob encrypted without a passphrase")
+	}
+	if _, err := Decrypt(context.Background(), plain, passcode, "unused", "test-id"); err != nil {
+		t.Errorf("Decrypt() error = %v", err)
+	}
+}
+
+func TestEncryptFields(t *testing.T) {
+	LowerCryptoParamsForTest(t)
+
+	passcode := "abacus-abdomen-abdominal"
+	fields := []byte(`{"username":"admin","password":"hunter2"}`)
+	encrypted, err := EncryptFields(context.Background(), fields, passcode, "correct horse", "test-id")
+	if err != nil {
+		t.Fatalf("EncryptFields() error = %v", err)
+	}
+	if !strings.Contains(string(encrypted), ",pp=1,f=1$") {
+		t.Errorf("expected the header to record the fields, got %q", encrypted)
+	}
+	if !HasFields(encrypted) || !RequiresPassphrase(encrypted) {
+		t.Error("expected the blob to hold fields and require a passphrase")
+	}
+
+	decrypted, err := Decrypt(context.Background(), encrypted, passcode, "correct horse", "test-id")
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if string(decrypted) != string(fields) {
+		t.Errorf("Decrypt() got = %s, want %s", decrypted, fields)
+	}
+
+	// The flag is authenticated, a blob can't be passed off as plain text.
+	stripped := []byte(strings.Replace(string(encrypted), ",f=1", "", 1))
+	if HasFields(stripped) {
+		t.Error("HasFields() = true without the flag")
+	}
+	_, err = Decrypt(context.Background(), stripped, passcode, "correct horse", "test-id")
+	if err == nil {
+		t.Error("Decrypt() should fail once the flag is stripped")
+	}
+
+	plain, err := Encrypt(context.Background(), []byte("test"), passcode, "", "test-id")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if HasFields(plain) {
+		t.Error("HasFields() = true for a plain text secret")
+	}
+}
+
+func TestDecrypt_V1(t *testing.T) {
+	LowerCryptoParamsForTest(t)
+
+	// Blob in the original format: "v1:" + base64(salt|nonce|ciphertext),
+	// keyed with the current parameters and without additional data.
+	passcode := "abacus-abdomen-abdominal"
+	salt := make([]byte, saltLen)
+	nonce := make([]byte, nonceLen)
+	params := kdfParams{Time: 1, Memory: 1024, Threads: 4, KeyLen: keyLen}
+	key := deriveKey(context.Background(), passcode, "", salt, params)
+	gcm, err := newGCM(key)
+	if err != nil {
+		t.Fatalf("newGCM() error = %v", err)
+	}
+	raw := append(append(salt, nonce...), gcm.Seal(nil, nonce, []byte("legacy"), nil)...)
+	blob := []byte("v1:" + base64.StdEncoding.EncodeToString(raw))
+
+	decrypted, err := Decrypt(context.Background(), blob, passcode, "", "any-id")
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if string(decrypted) != "legacy" {
+		t.Errorf("Decrypt() got = %s, want legacy", decrypted)
+	}
+}
+
+func TestEncryptWithKey_RoundTrip(t *testing.T) {
+	key, err := GenerateClientKey()
+	if err != nil {
+		t.Fatalf("GenerateClientKey() error = %v", err)
+	}
+	plaintext := []byte("zero-knowledge secret")
+
+	blob, err := EncryptWithKey(plaintext, key)
+	if err != nil {
+		t.Fatalf("EncryptWithKey() error = %v", err)
+	}
+	if !IsClientEncrypted(blob) {
+		t.Errorf("expected blob to start with %q, got %s", ClientBlobPrefix, blob)
+	}
+	if err := ValidateClientBlob(blob, len(plaintext)); err != nil {
+		t.Errorf("ValidateClientBlob() error = %v", err)
+	}
+
+	decrypted, err := DecryptWithKey(blob, key)
+	if err != nil {
+		t.Fatalf("DecryptWithKey() error = %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("DecryptWithKey() got = %s, want %s", decrypted, plaintext)
+	}
+
+	otherKey, _ := GenerateClientKey()
+	if _, err := DecryptWithKey(blob, otherKey); err == nil {
+		t.Error("DecryptWithKey() with wrong key should return an error")
+	}
+}
+
+func TestValidateClientBlob(t *testing.T) {
+	key, _ := GenerateClientKey()
+	blob, err := EncryptWithKey([]byte("12345"), key)
+	if err != nil {
+		t.Fatalf("EncryptWithKey() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		blob    []byte
+		max     int
+		wantErr bool
+	}{
+		{"valid", blob, 5, false},
+		{"plaintext too large", blob, 4, true},
+		{"server-side blob", []byte("v1:AAAA"), 5, true},
+		{"bad base64", []byte("v2:!@#$%^"), 5, true},
+		{"short blob", []byte("v2:AAAA"), 5, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateClientBlob(tt.blob, tt.max)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateClientBlob() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClientKeyEncoding(t *testing.T) {
+	key, _ := GenerateClientKey()
+
+	encoded := EncodeClientKey(key)
+	if strings.ContainsAny(encoded, "+/=") {
+		t.Errorf("encoded key is not URL safe: %s", encoded)
+	}
+
+	decoded, err := DecodeClientKey(encoded)
+	if err != nil {
+		t.Fatalf("DecodeClientKey() error = %v", err)
+	}
+	if !bytes.Equal(decoded, key) {
+		t.Error("DecodeClientKey() did not return the original key")
+	}
+
+	if _, err := DecodeClientKey("c2hvcnQ"); err == nil {
+		t.Error("DecodeClientKey() with short key should return an error")
 	}
 }
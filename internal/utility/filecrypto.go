@@ -0,0 +1,202 @@
+package utility
+
+import (
+	"bufio"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// FileBlobPrefix marks the blob of a file secret. The file itself is stored
+// apart as encrypted chunks, the blob holds the parameters of its key and its
+// encrypted metadata.
+const FileBlobPrefix = "f3:"
+
+// FileChunkSize is the size of the plaintext of each chunk of a file, so at
+// most one chunk of a file is held in memory while it is encrypted or
+// decrypted.
+const FileChunkSize = 64 * 1024
+
+// FileMeta describes a file secret. It is encrypted along with the file, as
+// the name of a file can be as revealing as its content.
+type FileMeta struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"` // MIME type given by the sender
+	Size   int64  `json:"size"`
+	Chunks int    `json:"chunks"`
+}
+
+// Every file has its own key, so nonces are derived from the position of a
+// chunk rather than drawn at random. Their first byte tells the last chunk
+// and the metadata apart, so a file can't be truncated, reordered or have its
+// metadata swapped with a chunk without failing to decrypt.
+const (
+	nonceChunk byte = iota
+	nonceLastChunk
+	nonceMeta
+)
+
+// FileCipher encrypts and decrypts the chunks of one file secret.
+type FileCipher struct {
+	gcm    cipher.AEAD
+	header string
+	salt   []byte
+	aad    []byte
+}
+
+// NewFileCipher derives the key of a new file secret from passcode and
+// passphrase, with the same parameters as Encrypt. Chunks are bound to id
+// like "v3:" blobs.
+func NewFileCipher(ctx context.Context, passcode, passphrase, id string) (*FileCipher, error) {
+	params := currentKDFParams(passphrase)
+	salt := make([]byte, saltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, fmt.Errorf("salt: %w", err)
+	}
+	return newFileCipher(ctx, passcode, passphrase, id, salt, params)
+}
+
+func newFileCipher(
+	ctx context.Context, passcode, passphrase, id string, salt []byte, params kdfParams,
+) (*FileCipher, error) {
+	key := deriveKey(ctx, passcode, passphrase, salt, params)
+	defer zeroBytes(key) // Clear key from memory after use
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := passcodeBlobHeader(FileBlobPrefix, params)
+	return &FileCipher{
+		gcm:    gcm,
+		header: header,
+		salt:   salt,
+		aad:    passcodeBlobAAD(header, id),
+	}, nil
+}
+
+// OpenFile derives the key of a file secret from its blob and decrypts the
+// file's metadata. A wrong passcode or passphrase fails here, before any
+// chunk is read.
+func OpenFile(
+	ctx context.Context, blob []byte, passcode, passphrase, id string,
+) (*FileCipher, FileMeta, error) {
+	header, data, ok := cutLast(string(blob), "$")
+	if !ok || !strings.HasPrefix(header, FileBlobPrefix) {
+		return nil, FileMeta{}, errors.New("unsupported format")
+	}
+	params, err := parsePasscodeBlobHeader(FileBlobPrefix, header)
+	if err != nil {
+		return nil, FileMeta{}, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, FileMeta{}, fmt.Errorf("b64: %w", err)
+	}
+	if len(raw) < saltLen+tagLen {
+		return nil, FileMeta{}, errors.New("blob too short")
+	}
+
+	c, err := newFileCipher(ctx, passcode, passphrase, id, raw[:saltLen], params)
+	if err != nil {
+		return nil, FileMeta{}, err
+	}
+	pt, err := c.gcm.Open(nil, fileNonce(nonceMeta, 0), raw[saltLen:], c.aad)
+	if err != nil {
+		return nil, FileMeta{}, errors.New("auth failed")
+	}
+	var meta FileMeta
+	if err := json.Unmarshal(pt, &meta); err != nil {
+		return nil, FileMeta{}, fmt.Errorf("metadata: %w", err)
+	}
+	if meta.Chunks < 1 || meta.Size < 0 {
+		return nil, FileMeta{}, errors.New("invalid metadata")
+	}
+	return c, meta, nil
+}
+
+// Blob returns the blob of the file secret, holding its encrypted metadata.
+// It has the form "f3:argon2id-aes256gcm$<parameters>$" followed by
+// base64(salt|ciphertext).
+func (c *FileCipher) Blob(meta FileMeta) ([]byte, error) {
+	pt, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, 0, saltLen+len(pt)+tagLen)
+	raw = append(raw, c.salt...)
+	raw = c.gcm.Seal(raw, fileNonce(nonceMeta, 0), pt, c.aad)
+	return []byte(c.header + "$" + base64.StdEncoding.EncodeToString(raw)), nil
+}
+
+// SealStream reads r to the end and passes each encrypted chunk to put, in
+// order. It returns the size of the file and its number of chunks. An empty
+// file still has one, empty, chunk.
+func (c *FileCipher) SealStream(
+	r io.Reader, put func(chunk []byte) error,
+) (size int64, chunks int, err error) {
+	br := bufio.NewReaderSize(r, FileChunkSize)
+	buf := make([]byte, FileChunkSize)
+	defer zeroBytes(buf)
+
+	for index := 0; ; index++ {
+		n, err := io.ReadFull(br, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, 0, err
+		}
+		// Look ahead to tell whether this is the last chunk.
+		_, err = br.Peek(1)
+		last := err == io.EOF
+		if err != nil && !last {
+			return 0, 0, err
+		}
+
+		if err := put(c.SealChunk(index, buf[:n], last)); err != nil {
+			return 0, 0, err
+		}
+		size += int64(n)
+		if last {
+			return size, index + 1, nil
+		}
+	}
+}
+
+// SealChunk encrypts the index-th chunk of the file.
+func (c *FileCipher) SealChunk(index int, plaintext []byte, last bool) []byte {
+	return c.gcm.Seal(nil, chunkNonce(index, last), plaintext, c.aad)
+}
+
+// OpenChunk decrypts the index-th chunk of the file.
+func (c *FileCipher) OpenChunk(index int, chunk []byte, last bool) ([]byte, error) {
+	pt, err := c.gcm.Open(nil, chunkNonce(index, last), chunk, c.aad)
+	if err != nil {
+		return nil, errors.New("auth failed")
+	}
+	return pt, nil
+}
+
+// IsFile reports whether blob is the blob of a file secret.
+func IsFile(blob []byte) bool {
+	return strings.HasPrefix(string(blob), FileBlobPrefix)
+}
+
+func chunkNonce(index int, last bool) []byte {
+	if last {
+		return fileNonce(nonceLastChunk, uint64(index))
+	}
+	return fileNonce(nonceChunk, uint64(index))
+}
+
+func fileNonce(kind byte, index uint64) []byte {
+	nonce := make([]byte, nonceLen)
+	nonce[0] = kind
+	binary.BigEndian.PutUint64(nonce[nonceLen-8:], index)
+	return nonce
+}
@@ -0,0 +1,175 @@
+package utility
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// sealFile encrypts data as a file secret and returns its blob and chunks.
+func sealFile(t *testing.T, data []byte, passphrase, id string) ([]byte, [][]byte) {
+	t.Helper()
+	c, err := NewFileCipher(context.Background(), "abacus-abdomen-abdominal", passphrase, id)
+	if err != nil {
+		t.Fatalf("NewFileCipher() error = %v", err)
+	}
+	var chunks [][]byte
+	size, n, err := c.SealStream(bytes.NewReader(data), func(chunk []byte) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SealStream() error = %v", err)
+	}
+	if size != int64(len(data)) || n != len(chunks) {
+		t.Fatalf("SealStream() = %d bytes in %d chunks, want %d bytes in %d chunks",
+			size, n, len(data), len(chunks))
+	}
+	blob, err := c.Blob(FileMeta{Name: "id_ed25519", Type: "text/plain", Size: size, Chunks: n})
+	if err != nil {
+		t.Fatalf("Blob() error = %v", err)
+	}
+	return blob, chunks
+}
+
+// openFile decrypts the chunks of a file secret.
+func openFile(blob []byte, chunks [][]byte, passphrase, id string) ([]byte, FileMeta, error) {
+	c, meta, err := OpenFile(context.Background(), blob, "abacus-abdomen-abdominal", passphrase, id)
+	if err != nil {
+		return nil, FileMeta{}, err
+	}
+	if meta.Chunks != len(chunks) {
+		return nil, FileMeta{}, fmt.Errorf("got %d chunks, want %d", len(chunks), meta.Chunks)
+	}
+	var out []byte
+	for i, chunk := range chunks {
+		pt, err := c.OpenChunk(i, chunk, i == meta.Chunks-1)
+		if err != nil {
+			return nil, FileMeta{}, err
+		}
+		out = append(out, pt...)
+	}
+	return out, meta, nil
+}
+
+func TestFileCipher_RoundTrip(t *testing.T) {
+	LowerCryptoParamsForTest(t)
+
+	sizes := []int{0, 1, FileChunkSize - 1, FileChunkSize, FileChunkSize + 1, 3*FileChunkSize + 7}
+	for _, size := range sizes {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			data := make([]byte, size)
+			_, _ = rand.Read(data)
+
+			blob, chunks := sealFile(t, data, "", "test-id")
+			if !IsFile(blob) || !strings.HasPrefix(string(blob), "f3:argon2id-aes256gcm$") {
+				t.Fatalf("unexpected blob %q", blob)
+			}
+			if want := max(1, (size+FileChunkSize-1)/FileChunkSize); len(chunks) != want {
+				t.Errorf("expected %d chunks, got %d", want, len(chunks))
+			}
+			if bytes.Contains(blob, []byte("id_ed25519")) {
+				t.Error("file name must be encrypted")
+			}
+
+			got, meta, err := openFile(blob, chunks, "", "test-id")
+			if err != nil {
+				t.Fatalf("openFile() error = %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Error("decrypted file doesn't match")
+			}
+			if meta.Name != "id_ed25519" || meta.Type != "text/plain" || meta.Size != int64(size) {
+				t.Errorf("unexpected metadata %+v", meta)
+			}
+		})
+	}
+}
+
+func TestFileCipher_Tampering(t *testing.T) {
+	LowerCryptoParamsForTest(t)
+
+	data := bytes.Repeat([]byte("k"), 3*FileChunkSize)
+	blob, chunks := sealFile(t, data, "", "test-id")
+
+	t.Run("wrong passcode", func(t *testing.T) {
+		if _, _, err := OpenFile(context.Background(), blob, "wrong-pass", "", "test-id"); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("other id", func(t *testing.T) {
+		if _, _, err := openFile(blob, chunks, "", "other-id"); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		c, _, err := OpenFile(context.Background(), blob, "abacus-abdomen-abdominal", "", "test-id")
+		if err != nil {
+			t.Fatalf("OpenFile() error = %v", err)
+		}
+		// The second chunk wasn't sealed as the last one.
+		if _, err := c.OpenChunk(1, chunks[1], true); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("reordered", func(t *testing.T) {
+		swapped := [][]byte{chunks[1], chunks[0], chunks[2]}
+		if _, _, err := openFile(blob, swapped, "", "test-id"); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("chunk from another file", func(t *testing.T) {
+		_, other := sealFile(t, data, "", "test-id")
+		mixed := [][]byte{chunks[0], other[1], chunks[2]}
+		if _, _, err := openFile(blob, mixed, "", "test-id"); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestFileCipher_Passphrase(t *testing.T) {
+	LowerCryptoParamsForTest(t)
+
+	blob, chunks := sealFile(t, []byte("kubeconfig"), "correct horse", "test-id")
+	if !RequiresPassphrase(blob) {
+		t.Error("expected the blob to require a passphrase")
+	}
+	if _, _, err := openFile(blob, chunks, "", "test-id"); err == nil {
+		t.Error("expected an error without the passphrase")
+	}
+	got, _, err := openFile(blob, chunks, "correct horse", "test-id")
+	if err != nil || string(got) != "kubeconfig" {
+		t.Errorf("openFile() = %q, %v", got, err)
+	}
+}
+
+func TestFileCipher_SealStreamErrors(t *testing.T) {
+	LowerCryptoParamsForTest(t)
+
+	c, err := NewFileCipher(context.Background(), "abacus-abdomen-abdominal", "", "test-id")
+	if err != nil {
+		t.Fatalf("NewFileCipher() error = %v", err)
+	}
+	errRead := errors.New("connection reset")
+	r := io.MultiReader(bytes.NewReader(make([]byte, FileChunkSize+1)), iotest.ErrReader(errRead))
+	_, _, err = c.SealStream(r, func([]byte) error { return nil })
+	if !errors.Is(err, errRead) {
+		t.Errorf("expected the read error, got %v", err)
+	}
+
+	errPut := errors.New("storage unavailable")
+	_, _, err = c.SealStream(strings.NewReader("s"), func([]byte) error { return errPut })
+	if !errors.Is(err, errPut) {
+		t.Errorf("expected the storage error, got %v", err)
+	}
+}
@@ -2,37 +2,160 @@ package utility
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/smallwat3r/secretapi/internal/domain"
+	"github.com/smallwat3r/secretapi/internal/logging"
 )
 
+const day = 24 * time.Hour
+
+// ParseExpiry parses an expiry into a positive duration. It accepts Go
+// durations ("30m", "1h30m"), optionally preceded by a number of days ("7d",
+// "2d12h"), and ISO-8601 durations made of weeks, days, hours, minutes and
+// seconds ("PT30M", "P2DT12H"). Months and years have no fixed length and are
+// refused.
 func ParseExpiry(s string) (time.Duration, bool) {
-	switch s {
-	case "1h":
-		return time.Hour, true
-	case "6h":
-		return 6 * time.Hour, true
-	case "1d":
-		return 24 * time.Hour, true
-	case "3d":
-		return 72 * time.Hour, true
-	default:
+	var (
+		d  time.Duration
+		ok bool
+	)
+	if strings.HasPrefix(s, "P") {
+		d, ok = parseISODuration(s)
+	} else {
+		d, ok = parseDayDuration(s)
+	}
+	if !ok || d <= 0 {
 		return 0, false
 	}
+	return d, true
+}
+
+func parseDayDuration(s string) (time.Duration, bool) {
+	var d time.Duration
+	if i := strings.IndexByte(s, 'd'); i >= 0 {
+		n, ok := parseCount(s[:i])
+		if !ok {
+			return 0, false
+		}
+		d = time.Duration(n) * day
+		if s = s[i+1:]; s == "" {
+			return d, true
+		}
+	}
+	rest, err := time.ParseDuration(s)
+	if err != nil || rest < 0 {
+		return 0, false
+	}
+	return d + rest, true
+}
+
+func parseISODuration(s string) (time.Duration, bool) {
+	date, clock, hasTime := strings.Cut(strings.TrimPrefix(s, "P"), "T")
+	if date == "" && clock == "" || hasTime && clock == "" {
+		return 0, false
+	}
+	total, ok := sumISOUnits(date, map[byte]time.Duration{'W': 7 * day, 'D': day}, "WD")
+	if !ok {
+		return 0, false
+	}
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+	d, ok := sumISOUnits(clock, units, "HMS")
+	return total + d, ok
+}
+
+// sumISOUnits adds up the "<n><unit>" components of s, which must appear in
+// the given order, each at most once.
+func sumISOUnits(s string, units map[byte]time.Duration, order string) (time.Duration, bool) {
+	var total time.Duration
+	for s != "" {
+		i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+		if i <= 0 {
+			return 0, false
+		}
+		n, ok := parseCount(s[:i])
+		j := strings.IndexByte(order, s[i])
+		if !ok || j < 0 {
+			return 0, false
+		}
+		total += time.Duration(n) * units[s[i]]
+		order, s = order[j+1:], s[i+1:]
+	}
+	return total, true
+}
+
+// parseCount parses a plain non-negative number of units, small enough that
+// a duration of that many days doesn't overflow.
+func parseCount(s string) (int, bool) {
+	if s == "" || strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n > 100_000 {
+		return 0, false
+	}
+	return n, true
+}
+
+// FormatExpiry formats a duration the way ParseExpiry reads it, with whole
+// days written "<n>d", e.g. "15m", "1h30m" or "2d12h".
+func FormatExpiry(d time.Duration) string {
+	var b strings.Builder
+	if days := d / day; days > 0 {
+		fmt.Fprintf(&b, "%dd", days)
+		d -= days * day
+	}
+	if d > 0 || b.Len() == 0 {
+		s := d.String()
+		// Drop the zero units time.Duration.String always includes.
+		if strings.HasSuffix(s, "m0s") {
+			s = strings.TrimSuffix(s, "0s")
+		}
+		if strings.HasSuffix(s, "h0m") {
+			s = strings.TrimSuffix(s, "0m")
+		}
+		b.WriteString(s)
+	}
+	return b.String()
 }
 
 func WriteJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(v); err != nil {
-		log.Printf("failed to encode JSON response: %v", err)
+		slog.Error("failed to encode JSON response", logging.Err(err))
+	}
+}
+
+// HttpError replies with the problem details of an error of code.
+func HttpError(w http.ResponseWriter, code domain.ErrorCode, detail string) {
+	WriteProblem(w, domain.NewProblem(code, detail))
+}
+
+// WriteError replies with err, using its code when it is a domain.Error and
+// code otherwise.
+func WriteError(w http.ResponseWriter, err error, code domain.ErrorCode) {
+	var e *domain.Error
+	if errors.As(err, &e) {
+		code = e.Code
 	}
+	HttpError(w, code, err.Error())
 }
 
-func HttpError(w http.ResponseWriter, code int, msg string) {
-	WriteJSON(w, code, map[string]string{"error": msg})
+// WriteProblem replies with p as an application/problem+json body.
+func WriteProblem(w http.ResponseWriter, p domain.Problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		slog.Error("failed to encode problem response", logging.Err(err))
+	}
 }
 
 func Getenv(key, def string) string {
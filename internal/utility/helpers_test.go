@@ -1,11 +1,16 @@
 package utility
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
+
+	"github.com/smallwat3r/secretapi/internal/domain"
 )
 
 func TestParseExpiry(t *testing.T) {
@@ -18,10 +23,36 @@ func TestParseExpiry(t *testing.T) {
 		{"6h", 6 * time.Hour, true},
 		{"1d", 24 * time.Hour, true},
 		{"3d", 72 * time.Hour, true},
+		{"7d", 7 * 24 * time.Hour, true},
+		{"15m", 15 * time.Minute, true},
+		{"24h", 24 * time.Hour, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"2d12h", 60 * time.Hour, true},
+		{"1d30m", 24*time.Hour + 30*time.Minute, true},
+		{"PT30M", 30 * time.Minute, true},
+		{"P2DT12H", 60 * time.Hour, true},
+		{"P1W", 7 * 24 * time.Hour, true},
+		{"PT1H30M15S", 90*time.Minute + 15*time.Second, true},
+		{"P", 0, false},
+		{"PT", 0, false},
+		{"P1DT", 0, false},
+		{"P1M", 0, false}, // months have no fixed length
+		{"P1Y", 0, false},
+		{"PT30M1H", 0, false}, // out of order
+		{"PT1H1H", 0, false},
+		{"P0D", 0, false},
+		{"P-1D", 0, false},
+		{"1d-1h", 0, false},
+		{"1d1d", 0, false},
+		{"999999999d", 0, false},
 		{"", 0, false},
-		{"1m", 0, false},
+		{"d", 0, false},
+		{"0d", 0, false},
+		{"-1d", 0, false},
+		{"+1d", 0, false},
+		{"0h", 0, false},
+		{"-1h", 0, false},
 		{"1y", 0, false},
-		{"24h", 0, false},
 		{"invalid", 0, false},
 		{"1H", 0, false}, // case sensitive
 	}
@@ -41,6 +72,35 @@ func TestParseExpiry(t *testing.T) {
 	}
 }
 
+func TestFormatExpiry(t *testing.T) {
+	testCases := []struct {
+		input    time.Duration
+		expected string
+	}{
+		{5 * time.Minute, "5m"},
+		{time.Hour, "1h"},
+		{90 * time.Minute, "1h30m"},
+		{90 * time.Second, "1m30s"},
+		{24 * time.Hour, "1d"},
+		{60 * time.Hour, "2d12h"},
+		{7*24*time.Hour + time.Minute, "7d1m"},
+		{0, "0s"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expected, func(t *testing.T) {
+			if got := FormatExpiry(tc.input); got != tc.expected {
+				t.Errorf("FormatExpiry(%v) = %q, want %q", tc.input, got, tc.expected)
+			}
+			if tc.input > 0 {
+				if d, ok := ParseExpiry(tc.expected); !ok || d != tc.input {
+					t.Errorf("ParseExpiry(%q) = %v, %v, want %v", tc.expected, d, ok, tc.input)
+				}
+			}
+		})
+	}
+}
+
 func TestWriteJSON(t *testing.T) {
 	t.Run("sets correct headers and status", func(t *testing.T) {
 		rr := httptest.NewRecorder()
@@ -83,19 +143,49 @@ func TestWriteJSON(t *testing.T) {
 
 func TestHttpError(t *testing.T) {
 	rr := httptest.NewRecorder()
-	HttpError(rr, http.StatusBadRequest, "something went wrong")
+	HttpError(rr, domain.CodeSecretNotFound, "not found or expired")
 
-	if rr.Code != http.StatusBadRequest {
-		t.Errorf("expected status %d, got %d", http.StatusBadRequest, rr.Code)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("expected Content-Type application/problem+json, got %q", ct)
 	}
 
-	expected := `{"error":"something went wrong"}`
+	expected := `{"type":"urn:secretapi:error:secret_not_found","title":"Secret not found",` +
+		`"status":404,"code":"secret_not_found","detail":"not found or expired",` +
+		`"error":"not found or expired"}`
 	got := rr.Body.String()
 	if got != expected+"\n" {
 		t.Errorf("expected body %q, got %q", expected+"\n", got)
 	}
 }
 
+func TestWriteError(t *testing.T) {
+	testCases := []struct {
+		err  error
+		code domain.ErrorCode
+	}{
+		{errors.New("max_views must be a number"), domain.CodeInvalidRequest},
+		{domain.Errorf(domain.CodeInvalidExpiry, "expiry is too long"), domain.CodeInvalidExpiry},
+		{fmt.Errorf("check: %w", domain.Errorf(domain.CodeSecretTooLarge, "too large")),
+			domain.CodeSecretTooLarge},
+	}
+
+	for _, tc := range testCases {
+		rr := httptest.NewRecorder()
+		WriteError(rr, tc.err, domain.CodeInvalidRequest)
+
+		var p domain.Problem
+		if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+			t.Fatalf("could not decode problem: %v", err)
+		}
+		if p.Code != tc.code || rr.Code != tc.code.Status() || p.Detail != tc.err.Error() {
+			t.Errorf("WriteError(%v) = %d %+v, want code %s", tc.err, rr.Code, p, tc.code)
+		}
+	}
+}
+
 func TestGetenv(t *testing.T) {
 	t.Run("returns environment variable when set", func(t *testing.T) {
 		key := "TEST_GETENV_VAR"
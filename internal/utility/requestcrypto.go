@@ -0,0 +1,175 @@
+package utility
+
+import (
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RequestBlobPrefix marks the blob of a secret request waiting to be
+// fulfilled. It holds the X25519 public key the secret will be sealed to,
+// whose private key is the requester's token.
+const RequestBlobPrefix = "q3:"
+
+// SealedBlobPrefix marks the blob of a fulfilled secret request.
+const SealedBlobPrefix = "r3:"
+
+// sealedBlobAlgorithm names the key agreement and cipher of "r3:" blobs.
+const sealedBlobAlgorithm = "x25519-aes256gcm"
+
+// sealedBlobInfo separates the keys of sealed blobs from any other use of
+// the same shared secret.
+const sealedBlobInfo = "secretapi request v3"
+
+// NewSecretRequest generates the key pair of a new secret request. It
+// returns the requester's token, which is the private key, and the blob to
+// store until the request is fulfilled, of the form "q3:x25519$" followed by
+// base64(public key). Only the token can open what is sealed to the blob.
+func NewSecretRequest() (token string, blob []byte, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", nil, fmt.Errorf("key: %w", err)
+	}
+	token = base64.RawURLEncoding.EncodeToString(key.Bytes())
+	blob = []byte(RequestBlobPrefix + "x25519$" +
+		base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()))
+	return token, blob, nil
+}
+
+// IsRequest reports whether blob is a secret request waiting to be
+// fulfilled.
+func IsRequest(blob []byte) bool {
+	return strings.HasPrefix(string(blob), RequestBlobPrefix)
+}
+
+// Seal encrypts plaintext to the public key of the request blob, so only the
+// requester's token can decrypt it, and returns an "r3:" blob bound to id.
+// A fresh key pair is generated for every blob and its public half stored
+// along with the ciphertext. With fields, the header records that the
+// plaintext holds the fields of a structured secret, like EncryptFields.
+//
+// The blob has the form "r3:x25519-aes256gcm$" followed by
+// base64(ephemeral public key|nonce|ciphertext), with "f=1$" before the
+// data for fields.
+func Seal(request, plaintext []byte, fields bool, id string) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(string(request), RequestBlobPrefix+"x25519$")
+	if !ok {
+		return nil, errors.New("unsupported format")
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("b64: %w", err)
+	}
+	recipient, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("public key: %w", err)
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("key: %w", err)
+	}
+
+	key, err := sealedBlobKey(ephemeral, recipient, ephemeral.PublicKey(), recipient)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroBytes(key) // Clear key from memory after use
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceLen)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("nonce: %w", err)
+	}
+
+	header := SealedBlobPrefix + sealedBlobAlgorithm
+	if fields {
+		header += "$f=1"
+	}
+	ephemeralPub := ephemeral.PublicKey().Bytes()
+	out := make([]byte, 0, len(ephemeralPub)+nonceLen+len(plaintext)+tagLen)
+	out = append(out, ephemeralPub...)
+	out = append(out, nonce...)
+	out = gcm.Seal(out, nonce, plaintext, passcodeBlobAAD(header, id))
+	return []byte(header + "$" + base64.StdEncoding.EncodeToString(out)), nil
+}
+
+// openSealed decrypts an "r3:" blob with the requester's token.
+func openSealed(blob []byte, token, id string) ([]byte, error) {
+	header, data, ok := cutLast(string(blob), "$")
+	if !ok || !isSealedHeader(header) {
+		return nil, errors.New("unsupported format")
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("b64: %w", err)
+	}
+	const pubLen = 32
+	if len(raw) < pubLen+nonceLen+tagLen {
+		return nil, errors.New("blob too short")
+	}
+
+	privBytes, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.New("auth failed")
+	}
+	defer zeroBytes(privBytes)
+	priv, err := ecdh.X25519().NewPrivateKey(privBytes)
+	if err != nil {
+		return nil, errors.New("auth failed")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(raw[:pubLen])
+	if err != nil {
+		return nil, fmt.Errorf("public key: %w", err)
+	}
+
+	key, err := sealedBlobKey(priv, ephemeral, ephemeral, priv.PublicKey())
+	if err != nil {
+		return nil, errors.New("auth failed")
+	}
+	defer zeroBytes(key) // Clear key from memory after use
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := raw[pubLen : pubLen+nonceLen]
+	pt, err := gcm.Open(nil, nonce, raw[pubLen+nonceLen:], passcodeBlobAAD(header, id))
+	if err != nil {
+		return nil, errors.New("auth failed")
+	}
+	return pt, nil
+}
+
+// sealedBlobKey derives the AES key of a sealed blob from the X25519 shared
+// secret of priv and peer, salted with the ephemeral and recipient public
+// keys so the key is bound to both.
+func sealedBlobKey(
+	priv *ecdh.PrivateKey, peer, ephemeral, recipient *ecdh.PublicKey,
+) ([]byte, error) {
+	shared, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroBytes(shared)
+	salt := append(ephemeral.Bytes(), recipient.Bytes()...)
+	return hkdf.Key(sha256.New, shared, salt, sealedBlobInfo, keyLen)
+}
+
+// IsSealed reports whether blob is a fulfilled secret request.
+func IsSealed(blob []byte) bool {
+	return strings.HasPrefix(string(blob), SealedBlobPrefix)
+}
+
+func isSealedHeader(header string) bool {
+	base := SealedBlobPrefix + sealedBlobAlgorithm
+	return header == base || header == base+"$f=1"
+}
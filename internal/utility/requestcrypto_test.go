@@ -0,0 +1,78 @@
+package utility
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSeal_RoundTrip(t *testing.T) {
+	token, request, err := NewSecretRequest()
+	if err != nil {
+		t.Fatalf("NewSecretRequest() error = %v", err)
+	}
+	if !IsRequest(request) || !strings.HasPrefix(string(request), "q3:x25519$") {
+		t.Fatalf("unexpected request blob %q", request)
+	}
+	if strings.Contains(string(request), token) {
+		t.Fatal("the request blob must not hold the token")
+	}
+
+	sealed, err := Seal(request, []byte("sk_live_123"), false, "test-id")
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if !IsSealed(sealed) || IsRequest(sealed) || HasFields(sealed) {
+		t.Errorf("unexpected sealed blob %q", sealed)
+	}
+	if !strings.HasPrefix(string(sealed), "r3:x25519-aes256gcm$") {
+		t.Errorf("unexpected sealed blob %q", sealed)
+	}
+
+	got, err := Decrypt(context.Background(), sealed, token, "", "test-id")
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if string(got) != "sk_live_123" {
+		t.Errorf("Decrypt() = %q, want sk_live_123", got)
+	}
+
+	otherToken, _, _ := NewSecretRequest()
+	for _, tc := range []struct{ name, token, id string }{
+		{"other token", otherToken, "test-id"},
+		{"malformed token", "not-a-token", "test-id"},
+		{"empty token", "", "test-id"},
+		{"other id", token, "other-id"},
+	} {
+		if _, err := Decrypt(context.Background(), sealed, tc.token, "", tc.id); err == nil {
+			t.Errorf("Decrypt() with %s should fail", tc.name)
+		}
+	}
+}
+
+func TestSeal_Fields(t *testing.T) {
+	token, request, _ := NewSecretRequest()
+	sealed, err := Seal(request, []byte(`{"username":"admin"}`), true, "test-id")
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if !HasFields(sealed) {
+		t.Error("HasFields() = false for sealed fields")
+	}
+	if _, err := Decrypt(context.Background(), sealed, token, "", "test-id"); err != nil {
+		t.Errorf("Decrypt() error = %v", err)
+	}
+
+	stripped := []byte(strings.Replace(string(sealed), "$f=1", "", 1))
+	if _, err := Decrypt(context.Background(), stripped, token, "", "test-id"); err == nil {
+		t.Error("Decrypt() should fail once the flag is stripped")
+	}
+}
+
+func TestSeal_InvalidRequest(t *testing.T) {
+	for _, blob := range []string{"v3:whatever", "q3:x25519$!!!", "q3:x25519$AAAA"} {
+		if _, err := Seal([]byte(blob), []byte("s"), false, "test-id"); err == nil {
+			t.Errorf("Seal(%q) should fail", blob)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Package client is a Go client for SecretAPI. It creates secrets, reads
+// them through their links, and revokes or checks them with their delete
+// tokens.
+//
+//	c := client.New("https://secret.smallwat3r.com")
+//	created, err := c.Create(ctx, client.CreateParams{Secret: "hunter2", Expiry: "1h"})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// apiPrefix is the path of the version of the API the client speaks.
+const apiPrefix = "/api/v1"
+
+// DefaultTimeout bounds the requests of a client created without its own
+// HTTP client.
+const DefaultTimeout = 30 * time.Second
+
+// ErrUnavailable is returned once a request failed with a gateway error on
+// every attempt of the retry policy.
+var ErrUnavailable = errors.New("server unavailable")
+
+// RetryPolicy controls how requests answered with a gateway error are sent
+// again, which gives a server that scales to zero time to wake up.
+type RetryPolicy struct {
+	// Attempts is how many times a request is sent at most. Less than 2
+	// disables retries.
+	Attempts int
+	// Delay is how long to wait before each retry.
+	Delay time.Duration
+	// OnRetry, if set, is called before each retry with its number,
+	// starting from 1, and the status of the failed attempt.
+	OnRetry func(retry, status int)
+}
+
+// DefaultRetryPolicy sends a request up to 5 times, a second apart.
+var DefaultRetryPolicy = RetryPolicy{Attempts: 5, Delay: time.Second}
+
+// Client sends requests to a SecretAPI server. It is safe for concurrent
+// use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	retry      RetryPolicy
+	apiKey     string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sends requests with hc instead of a client with
+// DefaultTimeout.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithRetryPolicy retries requests according to p instead of
+// DefaultRetryPolicy.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) { c.retry = p }
+}
+
+// WithAPIKey authenticates the requests creating secrets with key, for
+// servers requiring API keys. It is only sent to the client's own server,
+// never to the server of a link.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// New returns a client of the server at baseURL, such as
+// "https://secret.smallwat3r.com".
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: DefaultTimeout},
+		retry:      DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// apiURL returns the URL of an endpoint of the client's server.
+func (c *Client) apiURL(endpoint string) string {
+	return c.baseURL + apiPrefix + endpoint
+}
+
+// link is a parsed secret or secret request link.
+type link struct {
+	url *url.URL
+	id  string
+	key string // fragment, the key of a zero-knowledge secret
+}
+
+// parseLink parses a link to a secret, or to a secret request. A bare ID
+// refers to the client's own server.
+func (c *Client) parseLink(rawLink, page string) (link, error) {
+	if !strings.Contains(rawLink, "/") {
+		rawLink = c.baseURL + "/" + page + "/" + rawLink
+	}
+	u, err := url.Parse(rawLink)
+	if err != nil {
+		return link{}, fmt.Errorf("parse link: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return link{}, fmt.Errorf("parse link: %q isn't an absolute URL", rawLink)
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	// The production domain redirects to https, which would turn POSTs
+	// into GETs.
+	if u.Scheme == "http" && strings.Contains(u.Host, "smallwat3r.com") {
+		u.Scheme = "https"
+	}
+	l := link{url: u, id: path.Base(u.Path), key: u.Fragment}
+	u.Fragment = ""
+	return l, nil
+}
+
+// endpoint returns the URL of an endpoint of the server of l.
+func (l link) endpoint(endpoint string) string {
+	u := url.URL{Scheme: l.url.Scheme, Host: l.url.Host, Path: apiPrefix + endpoint}
+	return u.String()
+}
+
+// newJSONRequest returns a POST request to target with v as its JSON body.
+func newJSONRequest(ctx context.Context, target string, v any) (*http.Request, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("encode request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// authorize adds the client's API key to a request to its own server.
+func (c *Client) authorize(req *http.Request) {
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+}
+
+// do sends req, retrying on gateway errors, and returns the response if it
+// has the status want. Any other response is returned as an *Error. The
+// caller closes the body of the response.
+func (c *Client) do(req *http.Request, want int) (*http.Response, error) {
+	attempts := max(c.retry.Attempts, 1)
+	for attempt := 1; ; attempt++ {
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == want {
+			return resp, nil
+		}
+		if resp.StatusCode != http.StatusBadGateway {
+			defer resp.Body.Close()
+			return nil, newError(resp)
+		}
+		discard(resp)
+		// A body that can't be sent again rules out retrying.
+		if attempt == attempts || req.Body != nil && req.GetBody == nil {
+			return nil, fmt.Errorf("%w after %d attempt(s)", ErrUnavailable, attempt)
+		}
+
+		if c.retry.OnRetry != nil {
+			c.retry.OnRetry(attempt, resp.StatusCode)
+		}
+		if err := sleep(req.Context(), c.retry.Delay); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// decode decodes the JSON body of resp into v, and closes it.
+func decode(resp *http.Response, v any) error {
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// discard closes the body of a response whose content doesn't matter.
+func discard(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+}
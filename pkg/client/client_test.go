@@ -0,0 +1,310 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallwat3r/secretapi/internal/app"
+	"github.com/smallwat3r/secretapi/internal/domain"
+	"github.com/smallwat3r/secretapi/internal/utility"
+)
+
+// newTestServer returns a client of a server storing secrets in memory.
+func newTestServer(t *testing.T) *Client {
+	t.Helper()
+	utility.LowerCryptoParamsForTest(t)
+	repo := domain.NewMemoryRepository()
+	t.Cleanup(func() { _ = repo.Close() })
+	router := app.NewRouter(app.NewHandler(repo, app.DefaultHandlerConfig()), nil,
+		app.SecurityHeadersConfig{}, app.DefaultRateLimitConfig())
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return New(server.URL, WithHTTPClient(server.Client()))
+}
+
+func TestClient_Secrets(t *testing.T) {
+	c := newTestServer(t)
+	ctx := context.Background()
+
+	cfg, err := c.Config(ctx)
+	if err != nil {
+		t.Fatalf("Config() error = %v", err)
+	}
+	if cfg.MaxViews != domain.MaxViews {
+		t.Errorf("expected max views %d, got %d", domain.MaxViews, cfg.MaxViews)
+	}
+
+	created, err := c.Create(ctx, CreateParams{Secret: "hunter2", Expiry: "1h", MaxViews: 2})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	secret, err := c.Read(ctx, created.ReadURL, created.Passcode, "")
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if secret.Text != "hunter2" || secret.RemainingViews != 1 {
+		t.Errorf("unexpected secret %+v", secret)
+	}
+
+	status, err := c.Status(ctx, created.ID, created.DeleteToken)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if !status.Exists || *status.RemainingViews != 1 {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if err := c.Revoke(ctx, created.ReadURL, created.DeleteToken); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	_, err = c.Read(ctx, created.ReadURL, created.Passcode, "")
+	if !IsCode(err, CodeSecretNotFound) {
+		t.Errorf("expected a revoked secret to be not found, got %v", err)
+	}
+
+	fields := Fields{{Name: "username", Value: "admin"}, {Name: "password", Value: "hunter2"}}
+	created, err = c.Create(ctx, CreateParams{Fields: fields, Passphrase: "open sesame"})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	_, err = c.Read(ctx, created.ReadURL, created.Passcode, "")
+	if !IsCode(err, CodePassphraseRequired) {
+		t.Errorf("expected the passphrase to be required, got %v", err)
+	}
+	secret, err = c.Read(ctx, created.ReadURL, created.Passcode, "open sesame")
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(secret.Fields) != 2 || secret.Fields[1] != fields[1] {
+		t.Errorf("unexpected fields %+v", secret.Fields)
+	}
+}
+
+func TestClient_ZeroKnowledge(t *testing.T) {
+	c := newTestServer(t)
+	ctx := context.Background()
+
+	created, err := c.Create(ctx, CreateParams{Secret: "hunter2", ZeroKnowledge: true})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if created.Passcode != "" || !strings.Contains(created.ReadURL, "#") {
+		t.Fatalf("expected the key in the link, got %+v", created)
+	}
+	secret, err := c.Read(ctx, created.ReadURL, "", "")
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if secret.Text != "hunter2" {
+		t.Errorf("expected the decrypted secret, got %q", secret.Text)
+	}
+}
+
+func TestClient_File(t *testing.T) {
+	c := newTestServer(t)
+	ctx := context.Background()
+
+	created, err := c.CreateFile(ctx, FileParams{
+		Name:    "notes.txt",
+		Content: strings.NewReader("hello"),
+	})
+	if err != nil {
+		t.Fatalf("CreateFile() error = %v", err)
+	}
+	secret, err := c.Read(ctx, created.ReadURL, created.Passcode, "")
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if secret.File == nil {
+		t.Fatalf("expected a file, got %+v", secret)
+	}
+	defer secret.File.Close()
+	content, err := io.ReadAll(secret.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.File.Name != "notes.txt" || string(content) != "hello" {
+		t.Errorf("unexpected file %q: %q", secret.File.Name, content)
+	}
+
+	_, err = c.CreateFile(ctx, FileParams{Name: "notes.txt", Content: io.MultiReader()})
+	if err == nil {
+		t.Error("expected an error for content of unknown size")
+	}
+}
+
+func TestClient_Request(t *testing.T) {
+	c := newTestServer(t)
+	ctx := context.Background()
+
+	request, err := c.Request(ctx, RequestParams{Expiry: "1h"})
+	if err != nil {
+		t.Fatalf("Request() error = %v", err)
+	}
+	_, err = c.Read(ctx, request.ReadURL, request.Token, "")
+	if !IsCode(err, CodeSecretNotSent) {
+		t.Errorf("expected the secret not to be sent yet, got %v", err)
+	}
+	if err := c.Send(ctx, request.UploadURL, "hunter2", nil); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	secret, err := c.Read(ctx, request.ReadURL, request.Token, "")
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if secret.Text != "hunter2" {
+		t.Errorf("expected the sent secret, got %q", secret.Text)
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	c := newTestServer(t)
+	ctx := context.Background()
+
+	created, err := c.Create(ctx, CreateParams{Secret: "hunter2"})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	_, err = c.Read(ctx, created.ReadURL, "wrong-pass-code", "")
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("expected an *Error, got %v", err)
+	}
+	if e.StatusCode != http.StatusUnauthorized || e.Code != CodePasscodeInvalid ||
+		e.RemainingAttempts == nil || *e.RemainingAttempts != domain.MaxReadAttempts-1 {
+		t.Errorf("unexpected error %+v", e)
+	}
+
+	_, err = c.Create(ctx, CreateParams{Secret: "hunter2", Expiry: "1y"})
+	if !IsCode(err, CodeInvalidExpiry) {
+		t.Errorf("expected an invalid expiry, got %v", err)
+	}
+	if err := c.Revoke(ctx, created.ID, "wrong-token"); !IsCode(err, CodeDeleteTokenInvalid) {
+		t.Errorf("expected an invalid delete token, got %v", err)
+	}
+	if _, err := c.Read(ctx, "not a link/", "passcode", ""); err == nil {
+		t.Error("expected an error for an invalid link")
+	}
+}
+
+func TestClient_Retry(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !bytes.Contains(body, []byte("hunter2")) {
+			t.Errorf("expected every attempt to carry the secret, got %q", body)
+		}
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id":"test-id"}`))
+	}))
+	defer server.Close()
+
+	var retries []int
+	c := New(server.URL, WithRetryPolicy(RetryPolicy{
+		Attempts: 3,
+		Delay:    time.Millisecond,
+		OnRetry:  func(retry, status int) { retries = append(retries, retry) },
+	}))
+	created, err := c.Create(context.Background(), CreateParams{Secret: "hunter2"})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if created.ID != "test-id" || len(retries) != 2 {
+		t.Errorf("expected 2 retries before success, got %v and %+v", retries, created)
+	}
+
+	attempts.Store(-10)
+	_, err = c.Create(context.Background(), CreateParams{Secret: "hunter2"})
+	if !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected ErrUnavailable, got %v", err)
+	}
+
+	attempts.Store(-10)
+	ctx, cancel := context.WithCancel(context.Background())
+	c = New(server.URL, WithRetryPolicy(RetryPolicy{
+		Attempts: 3,
+		Delay:    time.Hour,
+		OnRetry:  func(int, int) { cancel() },
+	}))
+	if _, err := c.Create(ctx, CreateParams{Secret: "hunter2"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the context to stop retries, got %v", err)
+	}
+}
+
+func TestError_NotAProblem(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream timed out", http.StatusGatewayTimeout)
+	}))
+	defer server.Close()
+
+	_, err := New(server.URL).Config(context.Background())
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("expected an *Error, got %v", err)
+	}
+	if e.Code != "" || e.StatusCode != http.StatusGatewayTimeout ||
+		e.Detail != "upstream timed out" {
+		t.Errorf("unexpected error %+v", e)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	codes := map[ErrorCode]bool{
+		CodeInvalidRequest: true, CodeInvalidExpiry: true, CodePasscodeRequired: true,
+		CodePassphraseRequired: true, CodeClientEncrypted: true, CodeAPIKeyRequired: true,
+		CodeAPIKeyInvalid: true, CodePasscodeInvalid: true, CodeDeleteTokenRequired: true,
+		CodeDeleteTokenInvalid: true, CodeSecretNotFound: true, CodeSecretNotSent: true,
+		CodeLengthRequired: true, CodeSecretTooLarge: true, CodeRateLimited: true,
+		CodeInternal: true,
+	}
+	serverCodes := domain.ErrorCodes()
+	for _, code := range serverCodes {
+		if !codes[ErrorCode(code)] {
+			t.Errorf("the server reports %s, which the client has no constant for", code)
+		}
+	}
+	if len(codes) != len(serverCodes) {
+		t.Errorf("expected %d codes, the client has %d", len(serverCodes), len(codes))
+	}
+}
+
+// The client encrypts zero-knowledge secrets on its own, in the format the
+// server validates.
+func TestZeroKnowledgeFormat(t *testing.T) {
+	key, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := encryptWithKey([]byte("hunter2"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := utility.ValidateClientBlob(blob, len("hunter2")); err != nil {
+		t.Errorf("expected the server to accept the blob, got %v", err)
+	}
+	plaintext, err := utility.DecryptWithKey(blob, key)
+	if err != nil || string(plaintext) != "hunter2" {
+		t.Errorf("expected the secret back, got %q, %v", plaintext, err)
+	}
+
+	blob, _ = utility.EncryptWithKey([]byte("hunter2"), key)
+	decoded, err := decodeKey(utility.EncodeClientKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err = decryptWithKey(blob, decoded)
+	if err != nil || string(plaintext) != "hunter2" {
+		t.Errorf("expected the secret back, got %q, %v", plaintext, err)
+	}
+}
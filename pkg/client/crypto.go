@@ -0,0 +1,93 @@
+package client
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Zero-knowledge secrets are encrypted with AES-256-GCM under a random key,
+// into "v2:" blobs of the form base64(nonce|ciphertext), the format the web
+// UI uses too.
+const (
+	blobPrefix = "v2:"
+	keyLen     = 32
+	nonceLen   = 12
+	tagLen     = 16
+)
+
+// generateKey returns a random key for a zero-knowledge secret.
+func generateKey() ([]byte, error) {
+	key := make([]byte, keyLen)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// encodeKey encodes a key for the fragment of a link.
+func encodeKey(key []byte) string {
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
+// decodeKey decodes a key taken from the fragment of a link.
+func decodeKey(s string) ([]byte, error) {
+	key, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("b64: %w", err)
+	}
+	if len(key) != keyLen {
+		return nil, errors.New("invalid key length")
+	}
+	return key, nil
+}
+
+// encryptWithKey encrypts plaintext into a "v2:" blob.
+func encryptWithKey(plaintext, key []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceLen, nonceLen+len(plaintext)+tagLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("nonce: %w", err)
+	}
+	raw := gcm.Seal(nonce, nonce, plaintext, nil)
+	return []byte(blobPrefix + base64.StdEncoding.EncodeToString(raw)), nil
+}
+
+// decryptWithKey decrypts a "v2:" blob.
+func decryptWithKey(blob, key []byte) ([]byte, error) {
+	s, ok := strings.CutPrefix(string(blob), blobPrefix)
+	if !ok {
+		return nil, errors.New("unsupported format")
+	}
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("b64: %w", err)
+	}
+	if len(raw) < nonceLen+tagLen {
+		return nil, errors.New("blob too short")
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, raw[:nonceLen], raw[nonceLen:], nil)
+	if err != nil {
+		return nil, errors.New("auth failed")
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
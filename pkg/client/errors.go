@@ -0,0 +1,97 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrorCode identifies the kind of an error reported by the server.
+type ErrorCode string
+
+// The codes of the errors reported by the server.
+const (
+	CodeInvalidRequest      ErrorCode = "invalid_request"
+	CodeInvalidExpiry       ErrorCode = "invalid_expiry"
+	CodePasscodeRequired    ErrorCode = "passcode_required"
+	CodePassphraseRequired  ErrorCode = "passphrase_required"
+	CodeClientEncrypted     ErrorCode = "secret_client_encrypted"
+	CodeAPIKeyRequired      ErrorCode = "api_key_required"
+	CodeAPIKeyInvalid       ErrorCode = "api_key_invalid"
+	CodePasscodeInvalid     ErrorCode = "passcode_invalid"
+	CodeDeleteTokenRequired ErrorCode = "delete_token_required"
+	CodeDeleteTokenInvalid  ErrorCode = "delete_token_invalid"
+	CodeSecretNotFound      ErrorCode = "secret_not_found"
+	CodeSecretNotSent       ErrorCode = "secret_not_sent"
+	CodeLengthRequired      ErrorCode = "length_required"
+	CodeSecretTooLarge      ErrorCode = "secret_too_large"
+	CodeRateLimited         ErrorCode = "rate_limited"
+	CodeInternal            ErrorCode = "internal_error"
+)
+
+// problem is the body of an error response, the problem details of RFC
+// 9457 extended with the error code.
+type problem struct {
+	Code              ErrorCode `json:"code"`
+	Detail            string    `json:"detail"`
+	Error             string    `json:"error"` // detail, from servers predating codes
+	RemainingAttempts *int      `json:"remaining_attempts"`
+	RetryAfter        int       `json:"retry_after"` // seconds
+}
+
+// maxErrorBody is how much of an error response is read.
+const maxErrorBody = 64 << 10
+
+// Error is an error response of the server.
+type Error struct {
+	StatusCode int
+	// Code is empty for servers predating error codes, and for responses
+	// that didn't come from SecretAPI, such as those of a proxy.
+	Code   ErrorCode
+	Detail string
+	// RemainingAttempts is how many more wrong passcodes delete the secret,
+	// with CodePasscodeInvalid.
+	RemainingAttempts *int
+	// RetryAfter is how long to wait before retrying, with CodeRateLimited.
+	RetryAfter time.Duration
+}
+
+// newError returns the error of an unexpected response.
+func newError(resp *http.Response) *Error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	e := &Error{StatusCode: resp.StatusCode}
+
+	var p problem
+	if err := json.Unmarshal(body, &p); err != nil || p.Code == "" && p.Error == "" {
+		e.Detail = strings.TrimSpace(string(body))
+		if e.Detail == "" {
+			e.Detail = http.StatusText(resp.StatusCode)
+		}
+		return e
+	}
+	e.Code = p.Code
+	e.Detail = p.Detail
+	if e.Detail == "" {
+		e.Detail = p.Error
+	}
+	e.RemainingAttempts = p.RemainingAttempts
+	e.RetryAfter = time.Duration(p.RetryAfter) * time.Second
+	return e
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("status %d: %s", e.StatusCode, e.Detail)
+	}
+	return fmt.Sprintf("%s (%s)", e.Detail, e.Code)
+}
+
+// IsCode reports whether err is an *Error of the given code.
+func IsCode(err error, code ErrorCode) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// Field is one named value of a structured secret, such as its username or
+// password.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Fields are the fields of a structured secret. They are encoded as a JSON
+// object that keeps their order.
+type Fields []Field
+
+func (f Fields) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range f {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(field.Name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (f *Fields) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return errors.New("fields must be an object of strings")
+	}
+	fields := Fields{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var value *string
+		if err := dec.Decode(&value); err != nil || value == nil {
+			return errors.New("fields must be an object of strings")
+		}
+		fields = append(fields, Field{Name: tok.(string), Value: *value})
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	*f = fields
+	return nil
+}
@@ -0,0 +1,467 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Config is the limits and options of a server.
+type Config struct {
+	MaxSecretSize   int      `json:"max_secret_size"`
+	MaxFileSize     int64    `json:"max_file_size"` // 0 if file secrets are disabled
+	ExpiryOptions   []string `json:"expiry_options"`
+	DefaultExpiry   string   `json:"default_expiry"`
+	MinExpiry       string   `json:"min_expiry"` // bounds of any other expiry
+	MaxExpiry       string   `json:"max_expiry"`
+	MaxViews        int      `json:"max_views"`
+	MaxReadAttempts int      `json:"max_read_attempts"`
+	DefaultTheme    string   `json:"default_theme,omitempty"`
+	APIKeyRequired  bool     `json:"api_key_required,omitempty"` // to create secrets
+}
+
+// Created is a secret created by Create or CreateFile.
+type Created struct {
+	ID           string    `json:"id"`
+	Passcode     string    `json:"passcode,omitempty"` // empty for zero-knowledge secrets
+	ExpiresAt    time.Time `json:"expires_at"`
+	ReadURL      string    `json:"read_url"`
+	DeleteToken  string    `json:"delete_token"`            // revokes the secret, see Revoke
+	NotifySecret string    `json:"notify_secret,omitempty"` // signs webhook deliveries
+}
+
+// SecretRequest is a link through which someone else sends a secret,
+// created by Request.
+type SecretRequest struct {
+	ID          string    `json:"id"`
+	UploadURL   string    `json:"upload_url"` // sent to whoever will send the secret
+	ReadURL     string    `json:"read_url"`
+	Token       string    `json:"token"`        // reads the secret, as its passcode
+	DeleteToken string    `json:"delete_token"` // revokes the request, see Revoke
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// Status tells whether a secret is still waiting to be read. Only Exists is
+// set once it was read, revoked or expired.
+type Status struct {
+	Exists         bool       `json:"exists"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	ExpiresIn      *int64     `json:"expires_in,omitempty"` // seconds
+	RemainingViews *int       `json:"remaining_views,omitempty"`
+	FailedAttempts *int       `json:"failed_attempts,omitempty"`
+}
+
+// createReq, requestReq, fulfillReq and readRes are the bodies of requests
+// and responses the client doesn't expose.
+type createReq struct {
+	Secret     string     `json:"secret,omitempty"`
+	Ciphertext string     `json:"ciphertext,omitempty"`
+	Fields     Fields     `json:"fields,omitempty"`
+	Expiry     string     `json:"expiry,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	MaxViews   int        `json:"max_views,omitempty"`
+	NotifyURL  string     `json:"notify_url,omitempty"`
+	Passphrase string     `json:"passphrase,omitempty"`
+}
+
+type requestReq struct {
+	Expiry    string     `json:"expiry,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type fulfillReq struct {
+	Secret string `json:"secret,omitempty"`
+	Fields Fields `json:"fields,omitempty"`
+}
+
+type readRes struct {
+	Secret         string `json:"secret,omitempty"`
+	Ciphertext     string `json:"ciphertext,omitempty"`
+	Fields         Fields `json:"fields,omitempty"`
+	RemainingViews *int   `json:"remaining_views,omitempty"`
+}
+
+// CreateParams describes a new secret, made of either Secret or Fields.
+type CreateParams struct {
+	Secret string
+	Fields Fields
+	// Expiry is one of the server's expiry options, or a duration within
+	// its range such as "30m" or "2d12h". Empty means the server's default.
+	Expiry string
+	// ExpiresAt sets an absolute expiry instead of Expiry.
+	ExpiresAt time.Time
+	// MaxViews is how many times the secret can be read, 1 by default.
+	MaxViews int
+	// NotifyURL receives signed webhook events, on servers with webhooks
+	// enabled.
+	NotifyURL string
+	// Passphrase is also required to read the secret. It is shared apart
+	// from the link.
+	Passphrase string
+	// ZeroKnowledge encrypts Secret locally with a random key, which the
+	// server never sees: it is only kept in the fragment of the ReadURL.
+	// It can't be used with Fields or Passphrase.
+	ZeroKnowledge bool
+}
+
+// FileParams describes a new file secret.
+type FileParams struct {
+	// Name is the name of the file, required.
+	Name    string
+	Content io.Reader
+	// Size is the length of Content. It can be left out when Content is an
+	// *os.File, or has a Len method like *bytes.Reader.
+	Size int64
+	// Type is the content type of the file, guessed from Name if empty.
+	Type string
+	// Expiry, ExpiresAt, MaxViews, NotifyURL and Passphrase are as for
+	// CreateParams.
+	Expiry     string
+	ExpiresAt  time.Time
+	MaxViews   int
+	NotifyURL  string
+	Passphrase string
+}
+
+// RequestParams describes a new secret request.
+type RequestParams struct {
+	// Expiry is how long the link and its secret last, as for CreateParams,
+	// or ExpiresAt when they expire.
+	Expiry    string
+	ExpiresAt time.Time
+}
+
+// Secret is a secret read by Read.
+type Secret struct {
+	// Text is the secret, unless it is made of Fields or is a File.
+	Text   string
+	Fields Fields
+	// File is set for a file secret. The caller reads its content and
+	// closes it.
+	File *File
+	// RemainingViews is how many more times the secret can be read.
+	RemainingViews int
+}
+
+// File is the content of a file secret.
+type File struct {
+	Name string
+	Type string
+	Size int64
+	io.ReadCloser
+}
+
+// Config returns the limits and options of the client's server.
+func (c *Client) Config(ctx context.Context) (*Config, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.apiURL("/config"), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := decode(resp, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Create creates a secret on the client's server.
+func (c *Client) Create(ctx context.Context, p CreateParams) (*Created, error) {
+	body := createReq{
+		Secret:     p.Secret,
+		Fields:     p.Fields,
+		Expiry:     p.Expiry,
+		MaxViews:   p.MaxViews,
+		NotifyURL:  p.NotifyURL,
+		Passphrase: p.Passphrase,
+	}
+	if !p.ExpiresAt.IsZero() {
+		body.ExpiresAt = &p.ExpiresAt
+	}
+
+	var key []byte
+	if p.ZeroKnowledge {
+		if p.Fields != nil || p.Passphrase != "" {
+			return nil, errors.New("zero-knowledge secrets can't have fields or a passphrase")
+		}
+		var err error
+		if key, err = generateKey(); err != nil {
+			return nil, fmt.Errorf("generate key: %w", err)
+		}
+		blob, err := encryptWithKey([]byte(p.Secret), key)
+		if err != nil {
+			return nil, fmt.Errorf("encrypt secret: %w", err)
+		}
+		body.Secret, body.Ciphertext = "", string(blob)
+	}
+
+	req, err := newJSONRequest(ctx, c.apiURL("/create"), body)
+	if err != nil {
+		return nil, err
+	}
+	created, err := c.create(req)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		created.ReadURL += "#" + encodeKey(key)
+	}
+	return created, nil
+}
+
+// CreateFile creates a file secret on the client's server. The file is
+// streamed to the server, which encrypts it. Requests are only retried when
+// Content is an io.Seeker, so that it can be sent again.
+func (c *Client) CreateFile(ctx context.Context, p FileParams) (*Created, error) {
+	if p.Name == "" {
+		return nil, errors.New("a file name is required")
+	}
+	query := url.Values{"filename": {p.Name}}
+	if !p.ExpiresAt.IsZero() {
+		query.Set("expires_at", p.ExpiresAt.Format(time.RFC3339))
+	} else if p.Expiry != "" {
+		query.Set("expiry", p.Expiry)
+	}
+	if p.MaxViews != 0 {
+		query.Set("max_views", strconv.Itoa(p.MaxViews))
+	}
+	if p.NotifyURL != "" {
+		query.Set("notify_url", p.NotifyURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		c.apiURL("/create/file?"+query.Encode()), io.NopCloser(p.Content))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = p.Size
+	if req.ContentLength == 0 {
+		if req.ContentLength, err = contentSize(p.Content); err != nil {
+			return nil, err
+		}
+	}
+	if req.ContentLength == 0 {
+		req.Body = http.NoBody
+	}
+	if s, ok := p.Content.(io.Seeker); ok {
+		start, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		req.GetBody = func() (io.ReadCloser, error) {
+			if _, err := s.Seek(start, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return io.NopCloser(p.Content), nil
+		}
+	}
+
+	contentType := p.Type
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(p.Name))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+	if p.Passphrase != "" {
+		req.Header.Set("X-Passphrase", p.Passphrase)
+	}
+	return c.create(req)
+}
+
+// contentSize returns the size of the content of a file, if it can tell.
+func contentSize(r io.Reader) (int64, error) {
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len()), nil
+	case interface{ Stat() (fs.FileInfo, error) }:
+		info, err := r.Stat()
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	}
+	return 0, errors.New("the size of the file is required")
+}
+
+// create sends a request creating a secret.
+func (c *Client) create(req *http.Request) (*Created, error) {
+	c.authorize(req)
+	resp, err := c.do(req, http.StatusCreated)
+	if err != nil {
+		return nil, err
+	}
+	var created Created
+	if err := decode(resp, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// Request creates a secret request on the client's server: a link through
+// which someone else sends a secret that only its token can read.
+func (c *Client) Request(ctx context.Context, p RequestParams) (*SecretRequest, error) {
+	body := requestReq{Expiry: p.Expiry}
+	if !p.ExpiresAt.IsZero() {
+		body.ExpiresAt = &p.ExpiresAt
+	}
+	req, err := newJSONRequest(ctx, c.apiURL("/request"), body)
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req)
+	resp, err := c.do(req, http.StatusCreated)
+	if err != nil {
+		return nil, err
+	}
+	var request SecretRequest
+	if err := decode(resp, &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// Send sends a secret, or fields, through the upload link of a secret
+// request.
+func (c *Client) Send(ctx context.Context, uploadLink, secret string, fields Fields) error {
+	l, err := c.parseLink(uploadLink, "request")
+	if err != nil {
+		return err
+	}
+	req, err := newJSONRequest(ctx, l.endpoint("/request/"+l.id),
+		fulfillReq{Secret: secret, Fields: fields})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	discard(resp)
+	return nil
+}
+
+// Read reads a secret through its link, using up one of its views. The
+// passcode, and the passphrase if the sender set one, are needed unless the
+// link carries the key of a zero-knowledge secret. The link may also be the
+// ID of a secret on the client's server.
+func (c *Client) Read(ctx context.Context, link, passcode, passphrase string) (*Secret, error) {
+	l, err := c.parseLink(link, "read")
+	if err != nil {
+		return nil, err
+	}
+	var key []byte
+	if l.key != "" {
+		if key, err = decodeKey(l.key); err != nil {
+			return nil, fmt.Errorf("invalid key in link: %w", err)
+		}
+	} else if passcode == "" {
+		return nil, errors.New("a passcode is required unless the link has a #key")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.endpoint("/read/"+l.id), nil)
+	if err != nil {
+		return nil, err
+	}
+	// The key of a zero-knowledge secret never leaves the client.
+	if key == nil {
+		req.Header.Set("X-Passcode", passcode)
+		if passphrase != "" {
+			req.Header.Set("X-Passphrase", passphrase)
+		}
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.do(req, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	disposition, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+	if disposition == "attachment" {
+		remaining, _ := strconv.Atoi(resp.Header.Get("X-Remaining-Views"))
+		return &Secret{
+			File: &File{
+				Name:       params["filename"],
+				Type:       resp.Header.Get("Content-Type"),
+				Size:       resp.ContentLength,
+				ReadCloser: resp.Body,
+			},
+			RemainingViews: remaining,
+		}, nil
+	}
+
+	var res readRes
+	if err := decode(resp, &res); err != nil {
+		return nil, err
+	}
+	secret := &Secret{Text: res.Secret, Fields: res.Fields}
+	if res.RemainingViews != nil {
+		secret.RemainingViews = *res.RemainingViews
+	}
+	if key != nil {
+		plaintext, err := decryptWithKey([]byte(res.Ciphertext), key)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt secret: %w", err)
+		}
+		secret.Text = string(plaintext)
+	}
+	return secret, nil
+}
+
+// Revoke deletes a secret, or a secret request, before it is read, with the
+// delete token it was created with. The link may also be the
+// ID of a secret on the client's server.
+func (c *Client) Revoke(ctx context.Context, link, token string) error {
+	l, err := c.parseLink(link, "read")
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete,
+		l.endpoint("/secret/"+l.id), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Delete-Token", token)
+	resp, err := c.do(req, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	discard(resp)
+	return nil
+}
+
+// Status reports whether a secret is still waiting to be read, without
+// using up a view, with the same link and token as Revoke.
+func (c *Client) Status(ctx context.Context, link, token string) (*Status, error) {
+	l, err := c.parseLink(link, "read")
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		l.endpoint("/secret/"+l.id+"/status"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Delete-Token", token)
+	resp, err := c.do(req, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	var status Status
+	if err := decode(resp, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
@@ -2,6 +2,7 @@ import { h } from 'preact';
 import { Router } from 'preact-router';
 import { Create } from './pages/Create';
 import { Read } from './pages/Read';
+import { Request } from './pages/Request';
 import { About } from './pages/About';
 import { Layout } from './components/Layout';
 import { useConfig } from './hooks/useConfig';
@@ -16,6 +17,7 @@ function App() {
       <Router>
         <Create path="/" />
         <Read path="/read/:id" />
+        <Request path="/request/:id" />
         <About path="/about" />
       </Router>
     </Layout>
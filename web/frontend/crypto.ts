@@ -0,0 +1,70 @@
+// Client-side encryption for zero-knowledge secrets. The key is generated in
+// the browser and only ever travels in the URL fragment, which browsers never
+// send to the server. Blobs use the same "v2:" format as the CLI:
+// "v2:" + base64(nonce | ciphertext | tag), AES-256-GCM.
+
+const BLOB_PREFIX = 'v2:';
+const NONCE_LENGTH = 12;
+
+function toBase64(bytes: Uint8Array): string {
+  let binary = '';
+  bytes.forEach((b) => (binary += String.fromCharCode(b)));
+  return btoa(binary);
+}
+
+function fromBase64(value: string) {
+  return Uint8Array.from(atob(value), (c) => c.charCodeAt(0));
+}
+
+function toBase64Url(bytes: Uint8Array): string {
+  return toBase64(bytes).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+}
+
+function fromBase64Url(value: string) {
+  const padded = value.replace(/-/g, '+').replace(/_/g, '/');
+  return fromBase64(padded + '='.repeat((4 - (padded.length % 4)) % 4));
+}
+
+export interface EncryptedSecret {
+  ciphertext: string;
+  key: string; // base64url, to be appended to the read URL as a fragment
+}
+
+export async function encryptSecret(secret: string): Promise<EncryptedSecret> {
+  const rawKey = crypto.getRandomValues(new Uint8Array(32));
+  const nonce = crypto.getRandomValues(new Uint8Array(NONCE_LENGTH));
+  const key = await crypto.subtle.importKey('raw', rawKey, 'AES-GCM', false, ['encrypt']);
+  const sealed = new Uint8Array(
+    await crypto.subtle.encrypt(
+      { name: 'AES-GCM', iv: nonce },
+      key,
+      new TextEncoder().encode(secret)
+    )
+  );
+
+  const raw = new Uint8Array(nonce.length + sealed.length);
+  raw.set(nonce);
+  raw.set(sealed, nonce.length);
+
+  return { ciphertext: BLOB_PREFIX + toBase64(raw), key: toBase64Url(rawKey) };
+}
+
+export async function decryptSecret(ciphertext: string, encodedKey: string): Promise<string> {
+  if (!ciphertext.startsWith(BLOB_PREFIX)) {
+    throw new Error('unsupported format');
+  }
+  const raw = fromBase64(ciphertext.slice(BLOB_PREFIX.length));
+  const key = await crypto.subtle.importKey(
+    'raw',
+    fromBase64Url(encodedKey),
+    'AES-GCM',
+    false,
+    ['decrypt']
+  );
+  const plaintext = await crypto.subtle.decrypt(
+    { name: 'AES-GCM', iv: raw.slice(0, NONCE_LENGTH) },
+    key,
+    raw.slice(NONCE_LENGTH)
+  );
+  return new TextDecoder().decode(plaintext);
+}
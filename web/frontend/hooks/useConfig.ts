@@ -3,14 +3,20 @@ import { ConfigResponse } from '../types';
 
 const DEFAULT_CONFIG: ConfigResponse = {
   max_secret_size: 64 * 1024,
+  max_file_size: 10 * 1024 * 1024,
   expiry_options: ['1h', '6h', '1d', '3d'],
+  default_expiry: '1d',
+  min_expiry: '5m',
+  max_expiry: '7d',
+  max_views: 10,
+  max_read_attempts: 3,
 };
 
 export function useConfig(): ConfigResponse {
   const [config, setConfig] = useState<ConfigResponse>(DEFAULT_CONFIG);
 
   useEffect(() => {
-    fetch('/config')
+    fetch('/api/v1/config')
       .then((res) => res.json())
       .then((data: ConfigResponse) => setConfig(data))
       .catch(() => {
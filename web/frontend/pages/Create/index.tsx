@@ -4,21 +4,39 @@ import { CopyableDiv } from '../../components/CopyableDiv';
 import styles from './Create.module.css';
 import { useCancellableFetch } from '../../hooks/useCancellableFetch';
 import { useConfig } from '../../hooks/useConfig';
+import { encryptSecret } from '../../crypto';
 import { ApiErrorResponse, CreateResponse, Expiry } from '../../types';
 
+// Select value standing for a duration typed in by the user
+const CUSTOM_EXPIRY = 'custom';
+
 export function Create() {
   const config = useConfig();
   const [secret, setSecret] = useState<string>('');
-  const [expiry, setExpiry] = useState<Expiry>('1d');
+  const [file, setFile] = useState<File | null>(null);
+  const [selectedExpiry, setSelectedExpiry] = useState<Expiry>(config.default_expiry);
+  const [customExpiry, setCustomExpiry] = useState<string>('');
+  const [maxViews, setMaxViews] = useState<number>(1);
+  const [zeroKnowledge, setZeroKnowledge] = useState<boolean>(false);
+  const [passphrase, setPassphrase] = useState<string>('');
+  const [hasPassphrase, setHasPassphrase] = useState<boolean>(false);
   const [result, setResult] = useState<CreateResponse | null>(null);
   const [loading, setLoading] = useState<boolean>(false);
   const [error, setError] = useState<string | null>(null);
+  const [revoked, setRevoked] = useState<boolean>(false);
   const cancellableFetch = useCancellableFetch();
 
+  // Select the server's default expiry once its config has loaded
+  useEffect(() => {
+    setSelectedExpiry(config.default_expiry);
+  }, [config.default_expiry]);
+
   // Clear sensitive data from memory on page hide/unload
   useEffect(() => {
     const clearSensitiveData = () => {
       setSecret('');
+      setFile(null);
+      setPassphrase('');
       setResult(null);
     };
 
@@ -36,8 +54,10 @@ export function Create() {
   }, []);
 
   const secretByteLength = useMemo(() => new Blob([secret]).size, [secret]);
-  const isSecretTooLong = secretByteLength > config.max_secret_size;
-  const isSecretEmpty = secret.trim() === '';
+  const isSecretTooLong = file
+    ? file.size > config.max_file_size
+    : secretByteLength > config.max_secret_size;
+  const isSecretEmpty = !file && secret.trim() === '';
 
   const handleSubmit = async (e: JSX.TargetedEvent<HTMLFormElement, Event>) => {
     e.preventDefault();
@@ -49,28 +69,92 @@ export function Create() {
       return;
     }
     if (isSecretTooLong) {
-      setError(`Secret exceeds ${formatBytes(config.max_secret_size)} limit.`);
+      setError(
+        file
+          ? `File exceeds ${formatBytes(config.max_file_size)} limit.`
+          : `Secret exceeds ${formatBytes(config.max_secret_size)} limit.`
+      );
       return;
     }
 
     setLoading(true);
     setResult(null);
     setError(null);
+    setRevoked(false);
 
     try {
-      const response = await cancellableFetch('/create', {
-        method: 'POST',
-        headers: { 'Content-Type': 'application/json' },
-        body: JSON.stringify({ secret, expiry }),
-      });
+      const expiry = selectedExpiry === CUSTOM_EXPIRY ? customExpiry.trim() : selectedExpiry;
+      // In zero-knowledge mode only the ciphertext leaves the browser, the key
+      // is appended to the read URL as a fragment.
+      const encrypted = zeroKnowledge && !file ? await encryptSecret(secret) : null;
+      let response: Response;
+      if (file) {
+        // Files are sent as is and encrypted by the server as they stream in
+        const query = new URLSearchParams({
+          filename: file.name,
+          expiry,
+          max_views: String(maxViews),
+        });
+        response = await cancellableFetch(`/api/v1/create/file?${query}`, {
+          method: 'POST',
+          headers: {
+            'Content-Type': file.type || 'application/octet-stream',
+            ...(passphrase && { 'X-Passphrase': passphrase }),
+          },
+          body: file,
+        });
+      } else {
+        const body = encrypted
+          ? { ciphertext: encrypted.ciphertext, expiry, max_views: maxViews }
+          : { secret, expiry, max_views: maxViews, passphrase: passphrase || undefined };
+        response = await cancellableFetch('/api/v1/create', {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify(body),
+        });
+      }
 
       if (response.ok) {
         const data: CreateResponse = await response.json();
-        setSecret(''); // Clear secret from memory after successful submission
+        if (encrypted) {
+          data.read_url = `${data.read_url}#${encrypted.key}`;
+        }
+        // Clear secret and passphrase from memory after successful submission
+        setHasPassphrase(!encrypted && passphrase !== '');
+        setSecret('');
+        setFile(null);
+        setPassphrase('');
         setResult(data);
       } else {
         const errorData: ApiErrorResponse = await response.json();
-        setError(errorData.error || 'An unknown error occurred.');
+        setError(errorData.detail || 'An unknown error occurred.');
+      }
+    } catch (err: unknown) {
+      if (err instanceof Error && err.name !== 'AbortError') {
+        setError('An unexpected error occurred. Please try again.');
+      }
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  const handleRevoke = async () => {
+    if (!result || loading) return;
+
+    setLoading(true);
+    setError(null);
+
+    try {
+      const response = await cancellableFetch(`/api/v1/secret/${result.id}`, {
+        method: 'DELETE',
+        headers: { 'X-Delete-Token': result.delete_token },
+      });
+
+      if (response.ok) {
+        setRevoked(true);
+      } else {
+        const errorData: ApiErrorResponse = await response.json();
+        setError(errorData.detail || 'An unknown error occurred.');
       }
     } catch (err: unknown) {
       if (err instanceof Error && err.name !== 'AbortError') {
@@ -83,14 +167,16 @@ export function Create() {
 
   const formatBytes = (bytes: number): string => {
     if (bytes < 1024) return `${bytes} B`;
-    return `${(bytes / 1024).toFixed(1)} KB`;
+    if (bytes < 1024 * 1024) return `${(bytes / 1024).toFixed(1)} KB`;
+    return `${(bytes / (1024 * 1024)).toFixed(1)} MB`;
   };
 
   const formatExpiryLabel = (expiry: string): string => {
-    const match = expiry.match(/^(\d+)([hd])$/);
+    const match = expiry.match(/^(\d+)([mhd])$/);
     if (!match) return expiry;
     const [, num, unit] = match;
     const n = parseInt(num, 10);
+    if (unit === 'm') return n === 1 ? '1 minute' : `${n} minutes`;
     if (unit === 'h') return n === 1 ? '1 hour' : `${n} hours`;
     if (unit === 'd') return n === 1 ? '1 day' : `${n} days`;
     return expiry;
@@ -98,24 +184,64 @@ export function Create() {
 
   if (result) {
     const expiresAt = new Date(result.expires_at).toUTCString();
-    const messageTemplate = `I've shared a secret with you.
+    const deletionNote =
+      maxViews > 1
+        ? `The secret will be deleted after ${maxViews} reads.`
+        : 'The secret will be deleted after reading.';
+    const passphraseNote = hasPassphrase
+      ? "\nYou'll also need the passphrase, which I'll send you separately.\n"
+      : '';
+    const messageTemplate = result.passcode
+      ? `I've shared a secret with you.
 
 URL: ${result.read_url}
 Passcode: ${result.passcode}
+${passphraseNote}
+Expires: ${expiresAt}
+You have ${config.max_read_attempts} attempts to enter the correct passcode. ${deletionNote}`
+      : `I've shared a secret with you.
+
+URL: ${result.read_url}
 
 Expires: ${expiresAt}
-You have 3 attempts to enter the correct passcode. The secret will be deleted after reading.`;
+The link contains the decryption key. ${deletionNote}`;
 
     return (
       <div class={`${styles.result} ${styles.pageWrapper}`}>
         <p class={styles.resultInfo}>
-          Share the Read URL and passcode with the recipient, or use the message template below.
+          {result.passcode
+            ? 'Share the Read URL and passcode with the recipient, or use the message template below.'
+            : 'Share the Read URL with the recipient, or use the message template below. It contains the decryption key, which never reached the server.'}
         </p>
         <CopyableDiv value={result.read_url} header="Read URL" />
-        <CopyableDiv value={result.passcode} header="Passcode" />
+        {result.passcode && <CopyableDiv value={result.passcode} header="Passcode" />}
+        {hasPassphrase && (
+          <p class={styles.resultInfo}>
+            The recipient also needs your passphrase. Send it over another channel, such as a
+            phone call, not alongside the link.
+          </p>
+        )}
         <p>Expires At</p>
         <div>{expiresAt}</div>
         <CopyableDiv value={messageTemplate} header="Message Template" />
+        <CopyableDiv value={result.delete_token} header="Delete Token (keep private)" />
+        {revoked ? (
+          <p class={styles.resultInfo}>The secret has been revoked and can no longer be read.</p>
+        ) : (
+          <button
+            type="button"
+            class={styles.revokeButton}
+            onClick={handleRevoke}
+            disabled={loading}
+          >
+            {loading ? 'Loading...' : 'Revoke Secret'}
+          </button>
+        )}
+        {error && (
+          <div class={`${styles.errorMessage} error`} role="alert" aria-live="polite">
+            {error}
+          </div>
+        )}
       </div>
     );
   }
@@ -125,23 +251,37 @@ You have 3 attempts to enter the correct passcode. The secret will be deleted af
       <label class={styles.fieldLabel} for="secret-input">
         Secret
       </label>
-      <textarea
-        id="secret-input"
-        value={secret}
-        onInput={(e: JSX.TargetedEvent<HTMLTextAreaElement, Event>) =>
-          setSecret(e.currentTarget.value)
-        }
-        placeholder="Enter your secret"
-        class={isSecretTooLong ? styles.textareaError : ''}
-      />
+      {!file && (
+        <textarea
+          id="secret-input"
+          value={secret}
+          onInput={(e: JSX.TargetedEvent<HTMLTextAreaElement, Event>) =>
+            setSecret(e.currentTarget.value)
+          }
+          placeholder="Enter your secret"
+          class={isSecretTooLong ? styles.textareaError : ''}
+        />
+      )}
+      {config.max_file_size > 0 && (
+        <input
+          type="file"
+          aria-label="File"
+          class={styles.fileInput}
+          onChange={(e: JSX.TargetedEvent<HTMLInputElement, Event>) =>
+            setFile(e.currentTarget.files?.[0] ?? null)
+          }
+        />
+      )}
       <div class={`${styles.charCount} ${isSecretTooLong ? styles.charCountError : ''}`}>
-        {formatBytes(secretByteLength)} / {formatBytes(config.max_secret_size)}
+        {file
+          ? `${formatBytes(file.size)} / ${formatBytes(config.max_file_size)}`
+          : `${formatBytes(secretByteLength)} / ${formatBytes(config.max_secret_size)}`}
       </div>
       <p class={styles.expiryLabel}>Expiry</p>
       <select
-        value={expiry}
+        value={selectedExpiry}
         onChange={(e: JSX.TargetedEvent<HTMLSelectElement, Event>) =>
-          setExpiry(e.currentTarget.value as Expiry)
+          setSelectedExpiry(e.currentTarget.value as Expiry)
         }
       >
         {config.expiry_options.map((opt) => (
@@ -149,7 +289,63 @@ You have 3 attempts to enter the correct passcode. The secret will be deleted af
             {formatExpiryLabel(opt)}
           </option>
         ))}
+        <option value={CUSTOM_EXPIRY}>Custom...</option>
       </select>
+      {selectedExpiry === CUSTOM_EXPIRY && (
+        <input
+          type="text"
+          aria-label="Custom expiry"
+          value={customExpiry}
+          onInput={(e: JSX.TargetedEvent<HTMLInputElement, Event>) =>
+            setCustomExpiry(e.currentTarget.value)
+          }
+          placeholder={`A duration such as 30m or 2d12h, from ${config.min_expiry} to ${config.max_expiry}`}
+          required
+        />
+      )}
+      <p class={styles.expiryLabel}>Views</p>
+      <select
+        value={maxViews}
+        onChange={(e: JSX.TargetedEvent<HTMLSelectElement, Event>) =>
+          setMaxViews(parseInt(e.currentTarget.value, 10))
+        }
+      >
+        {Array.from({ length: config.max_views }, (_, i) => i + 1).map((n) => (
+          <option key={n} value={n}>
+            {n === 1 ? '1 view' : `${n} views`}
+          </option>
+        ))}
+      </select>
+      {!file && (
+        <label class={styles.checkboxLabel}>
+          <input
+            type="checkbox"
+            checked={zeroKnowledge}
+            onChange={(e: JSX.TargetedEvent<HTMLInputElement, Event>) =>
+              setZeroKnowledge(e.currentTarget.checked)
+            }
+          />
+          Encrypt in my browser (the server never sees the secret or its key)
+        </label>
+      )}
+      {(!zeroKnowledge || file) && (
+        <>
+          <label class={styles.fieldLabel} for="passphrase-input">
+            Passphrase (optional)
+          </label>
+          <input
+            id="passphrase-input"
+            type="password"
+            value={passphrase}
+            onInput={(e: JSX.TargetedEvent<HTMLInputElement, Event>) =>
+              setPassphrase(e.currentTarget.value)
+            }
+            placeholder="Also required to read, share it separately"
+            maxLength={256}
+            autocomplete="new-password"
+          />
+        </>
+      )}
       <button type="submit" disabled={loading || isSecretTooLong}>
         {loading ? 'Loading...' : 'Create Secret'}
       </button>
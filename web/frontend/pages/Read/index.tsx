@@ -3,7 +3,9 @@ import { useState, useEffect, useRef } from 'preact/hooks';
 import styles from './Read.module.css';
 import { useCancellableFetch } from '../../hooks/useCancellableFetch';
 import { CopyButton } from '../../components/CopyButton';
+import { CopyableDiv } from '../../components/CopyableDiv';
 import { ApiErrorResponse, ReadResponse } from '../../types';
+import { decryptSecret } from '../../crypto';
 
 interface ReadProps {
   id: string;
@@ -11,13 +13,39 @@ interface ReadProps {
 
 const AUTO_CLEAR_SECONDS = 300; // 5 minutes
 
+interface SharedFile {
+  name: string;
+  url: string; // object URL of the decrypted file
+}
+
+// fileName returns the file name given by a Content-Disposition header.
+function fileName(disposition: string): string {
+  const encoded = disposition.match(/filename\*=UTF-8''([^;]+)/i);
+  if (encoded) {
+    try {
+      return decodeURIComponent(encoded[1]);
+    } catch {
+      // Fall back to the plain file name
+    }
+  }
+  const plain = disposition.match(/filename="?([^";]+)"?/i);
+  return plain ? plain[1] : 'secret';
+}
+
 export function Read(props: ReadProps) {
   const [passcode, setPasscode] = useState<string>('');
+  const [passphrase, setPassphrase] = useState<string>('');
   const [secret, setSecret] = useState<string | null>(null);
+  const [file, setFile] = useState<SharedFile | null>(null);
+  const [fields, setFields] = useState<Record<string, string> | null>(null);
+  const [remainingViews, setRemainingViews] = useState<number>(0);
   const [loading, setLoading] = useState<boolean>(false);
   const [error, setError] = useState<string | null>(null);
   const [secondsRemaining, setSecondsRemaining] = useState<number>(AUTO_CLEAR_SECONDS);
   const id = props.id;
+  // A fragment holds the key of a secret encrypted in the sender's browser.
+  // Browsers never send it to the server.
+  const [key] = useState<string>(() => window.location.hash.slice(1));
   const cancellableFetch = useCancellableFetch();
   const timerRef = useRef<number | null>(null);
 
@@ -25,7 +53,10 @@ export function Read(props: ReadProps) {
   useEffect(() => {
     const clearSecret = () => {
       setSecret(null);
+      setFile(null);
+      setFields(null);
       setPasscode('');
+      setPassphrase('');
     };
 
     // Handle page hide (works with bfcache, back/forward navigation)
@@ -44,9 +75,15 @@ export function Read(props: ReadProps) {
     };
   }, []);
 
+  // Release the decrypted file once it's cleared
+  useEffect(() => {
+    if (!file) return;
+    return () => URL.revokeObjectURL(file.url);
+  }, [file]);
+
   // Auto-clear secret from memory after timeout
   useEffect(() => {
-    if (!secret) return;
+    if (!secret && !file && !fields) return;
 
     setSecondsRemaining(AUTO_CLEAR_SECONDS);
 
@@ -54,6 +91,8 @@ export function Read(props: ReadProps) {
       setSecondsRemaining((prev) => {
         if (prev <= 1) {
           setSecret(null);
+          setFile(null);
+          setFields(null);
           if (timerRef.current) {
             clearInterval(timerRef.current);
             timerRef.current = null;
@@ -70,7 +109,7 @@ export function Read(props: ReadProps) {
         timerRef.current = null;
       }
     };
-  }, [secret]);
+  }, [secret, file, fields]);
 
   const handleSubmit = async (e: JSX.TargetedEvent<HTMLFormElement, Event>) => {
     e.preventDefault();
@@ -80,26 +119,52 @@ export function Read(props: ReadProps) {
     setError(null);
 
     try {
-      const response = await cancellableFetch(`/read/${id}`, {
+      const response = await cancellableFetch(`/api/v1/read/${id}`, {
         method: 'POST',
-        headers: { 'X-Passcode': passcode },
+        headers: key
+          ? {}
+          : { 'X-Passcode': passcode, ...(passphrase && { 'X-Passphrase': passphrase }) },
       });
 
-      if (response.ok) {
+      const disposition = response.headers.get('Content-Disposition');
+      if (response.ok && disposition?.startsWith('attachment')) {
+        const blob = await response.blob();
+        setRemainingViews(parseInt(response.headers.get('X-Remaining-Views') ?? '0', 10));
+        setFile({ name: fileName(disposition), url: URL.createObjectURL(blob) });
+        setPasscode('');
+        setPassphrase('');
+      } else if (response.ok) {
         const data: ReadResponse = await response.json();
-        setSecret(data.secret);
-        setPasscode(''); // Clear passcode from memory
+        setRemainingViews(data.remaining_views ?? 0);
+        if (key && data.ciphertext) {
+          // Drop the key from the address bar and history once used
+          window.history.replaceState(null, '', window.location.pathname);
+          try {
+            setSecret(await decryptSecret(data.ciphertext, key));
+          } catch {
+            setError('Could not decrypt the secret. The link may be incomplete.');
+          }
+        } else if (data.fields) {
+          setFields(data.fields);
+        } else {
+          setSecret(data.secret ?? null);
+        }
+        // Clear passcode and passphrase from memory
+        setPasscode('');
+        setPassphrase('');
       } else {
         const errorData: ApiErrorResponse = await response.json();
 
-        if (errorData.remaining_attempts !== undefined) {
+        if (errorData.code === 'passcode_invalid' && errorData.remaining_attempts !== undefined) {
           if (errorData.remaining_attempts > 0) {
-            setError(`Invalid passcode. ${errorData.remaining_attempts} attempts remaining.`);
+            setError(
+              `Invalid passcode or passphrase. ${errorData.remaining_attempts} attempts remaining.`,
+            );
           } else {
             setError('No attempts remaining. Secret deleted.');
           }
         } else {
-          setError(errorData.error || 'An unknown error occurred.');
+          setError(errorData.detail || 'An unknown error occurred.');
         }
       }
     } catch (err: unknown) {
@@ -123,14 +188,50 @@ export function Read(props: ReadProps) {
         <div class={styles.secret}>{secret}</div>
         <div class={styles.warning}>
           <strong>Save this secret now.</strong> For security, it will be cleared from this page in{' '}
-          <strong>{formatTime(secondsRemaining)}</strong>. The secret has been deleted from the
-          server and cannot be retrieved again.
+          <strong>{formatTime(secondsRemaining)}</strong>.{' '}
+          {remainingViews > 0
+            ? `It can be viewed ${remainingViews} more ${remainingViews === 1 ? 'time' : 'times'} with this link.`
+            : 'The secret has been deleted from the server and cannot be retrieved again.'}
         </div>
         <CopyButton textToCopy={secret} />
       </div>
     );
   }
 
+  if (fields) {
+    return (
+      <div class={`${styles.result} ${styles.pageWrapper}`}>
+        {Object.entries(fields).map(([name, value]) => (
+          <CopyableDiv key={name} value={value} header={name} />
+        ))}
+        <div class={styles.warning}>
+          <strong>Save this secret now.</strong> For security, it will be cleared from this page in{' '}
+          <strong>{formatTime(secondsRemaining)}</strong>.{' '}
+          {remainingViews > 0
+            ? `It can be viewed ${remainingViews} more ${remainingViews === 1 ? 'time' : 'times'} with this link.`
+            : 'The secret has been deleted from the server and cannot be retrieved again.'}
+        </div>
+      </div>
+    );
+  }
+
+  if (file) {
+    return (
+      <div class={`${styles.result} ${styles.pageWrapper}`}>
+        <a class={styles.download} href={file.url} download={file.name}>
+          Download {file.name}
+        </a>
+        <div class={styles.warning}>
+          <strong>Save this file now.</strong> For security, it will be cleared from this page in{' '}
+          <strong>{formatTime(secondsRemaining)}</strong>.{' '}
+          {remainingViews > 0
+            ? `It can be downloaded ${remainingViews} more ${remainingViews === 1 ? 'time' : 'times'} with this link.`
+            : 'The file has been deleted from the server and cannot be retrieved again.'}
+        </div>
+      </div>
+    );
+  }
+
   // Show message if secret was auto-cleared
   if (secondsRemaining === 0) {
     return (
@@ -143,6 +244,25 @@ export function Read(props: ReadProps) {
     );
   }
 
+  if (key) {
+    return (
+      <form class={styles.pageWrapper} onSubmit={handleSubmit}>
+        <p class={styles.info}>
+          This secret was encrypted in the sender&apos;s browser. It will be decrypted on this
+          device and deleted from the server once revealed.
+        </p>
+        <button type="submit" disabled={loading}>
+          {loading ? 'Loading...' : 'Reveal Secret'}
+        </button>
+        {error && (
+          <div class={`${styles.errorMessage} error`} role="alert" aria-live="polite">
+            {error}
+          </div>
+        )}
+      </form>
+    );
+  }
+
   return (
     <form class={styles.pageWrapper} onSubmit={handleSubmit}>
       <input
@@ -154,6 +274,15 @@ export function Read(props: ReadProps) {
         required
         autocomplete="off"
       />
+      <input
+        type="password"
+        value={passphrase}
+        onInput={(e: JSX.TargetedEvent<HTMLInputElement, Event>) =>
+          setPassphrase(e.currentTarget.value)
+        }
+        placeholder="Passphrase (if the sender set one)"
+        autocomplete="off"
+      />
       <button type="submit" disabled={loading}>
         {loading ? 'Loading...' : 'Read Secret'}
       </button>
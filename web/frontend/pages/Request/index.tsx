@@ -0,0 +1,111 @@
+import { h, JSX } from 'preact';
+import { useState, useMemo, useEffect } from 'preact/hooks';
+import styles from './Request.module.css';
+import { useCancellableFetch } from '../../hooks/useCancellableFetch';
+import { useConfig } from '../../hooks/useConfig';
+import { ApiErrorResponse } from '../../types';
+
+interface RequestProps {
+  id: string;
+}
+
+export function Request(props: RequestProps) {
+  const config = useConfig();
+  const [secret, setSecret] = useState<string>('');
+  const [sent, setSent] = useState<boolean>(false);
+  const [loading, setLoading] = useState<boolean>(false);
+  const [error, setError] = useState<string | null>(null);
+  const cancellableFetch = useCancellableFetch();
+  const id = props.id;
+
+  // Clear the secret from memory on page hide/unload
+  useEffect(() => {
+    const clearSecret = () => setSecret('');
+
+    window.addEventListener('pagehide', clearSecret);
+    window.addEventListener('beforeunload', clearSecret);
+
+    return () => {
+      window.removeEventListener('pagehide', clearSecret);
+      window.removeEventListener('beforeunload', clearSecret);
+      clearSecret();
+    };
+  }, []);
+
+  const secretByteLength = useMemo(() => new Blob([secret]).size, [secret]);
+  const isSecretTooLong = secretByteLength > config.max_secret_size;
+
+  const handleSubmit = async (e: JSX.TargetedEvent<HTMLFormElement, Event>) => {
+    e.preventDefault();
+    if (loading) return;
+
+    if (secret.trim() === '') {
+      setError('Secret cannot be empty.');
+      return;
+    }
+
+    setLoading(true);
+    setError(null);
+
+    try {
+      const response = await cancellableFetch(`/api/v1/request/${id}`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ secret }),
+      });
+
+      if (response.ok) {
+        setSecret('');
+        setSent(true);
+      } else {
+        const errorData: ApiErrorResponse = await response.json();
+        setError(errorData.detail || 'An unknown error occurred.');
+      }
+    } catch (err: unknown) {
+      if (err instanceof Error && err.name !== 'AbortError') {
+        setError('An unexpected error occurred. Please try again.');
+      }
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  if (sent) {
+    return (
+      <div class={styles.pageWrapper}>
+        <p class={styles.info}>
+          Your secret was sent. Only the person who requested it can read it, and this link can&apos;t
+          be used again.
+        </p>
+      </div>
+    );
+  }
+
+  return (
+    <form class={`${styles.form} ${styles.pageWrapper}`} onSubmit={handleSubmit}>
+      <p class={styles.info}>
+        Someone asked you to send them a secret. It will be encrypted so that only they can read
+        it. This link can only be used once.
+      </p>
+      <textarea
+        aria-label="Secret"
+        value={secret}
+        onInput={(e: JSX.TargetedEvent<HTMLTextAreaElement, Event>) =>
+          setSecret(e.currentTarget.value)
+        }
+        placeholder="Enter the secret to send"
+      />
+      <div class={`${styles.charCount} ${isSecretTooLong ? styles.charCountError : ''}`}>
+        {secretByteLength} / {config.max_secret_size} bytes
+      </div>
+      <button type="submit" disabled={loading || isSecretTooLong}>
+        {loading ? 'Loading...' : 'Send Secret'}
+      </button>
+      {error && (
+        <div class={`${styles.errorMessage} error`} role="alert" aria-live="polite">
+          {error}
+        </div>
+      )}
+    </form>
+  );
+}
@@ -1,22 +1,40 @@
+// RFC 9457 problem details, with a code identifying the error
 export interface ApiErrorResponse {
-  error?: string;
-  remaining_attempts?: number;
+  type?: string;
+  title?: string;
+  status?: number;
+  code?: string; // e.g. secret_not_found or passcode_invalid
+  detail?: string;
+  remaining_attempts?: number; // with passcode_invalid
+  retry_after?: number; // seconds, with rate_limited
 }
 
 export interface CreateResponse {
+  id: string;
   read_url: string;
-  passcode: string;
+  passcode?: string; // absent for client-side encrypted secrets
   expires_at: string;
+  delete_token: string;
 }
 
 export interface ReadResponse {
-  secret: string;
+  secret?: string;
+  ciphertext?: string; // set for client-side encrypted secrets
+  fields?: Record<string, string>; // set for structured secrets, instead of secret
+  remaining_views?: number;
 }
 
 export interface ConfigResponse {
   max_secret_size: number;
+  max_file_size: number; // 0 if file secrets are disabled
   expiry_options: string[];
+  default_expiry: string;
+  min_expiry: string; // bounds of a custom expiry
+  max_expiry: string;
+  max_views: number;
+  max_read_attempts: number;
   default_theme?: 'light' | 'dark';
+  api_key_required?: boolean; // to create secrets
 }
 
 export type Expiry = string;